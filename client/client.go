@@ -0,0 +1,391 @@
+// Package client is a Go client for the DFS coordinator.
+//
+// A Client wraps a single gRPC connection that is shared by all of its
+// calls and safe for concurrent use:
+//
+//	c, err := client.New("localhost:50053")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	w, err := c.Create(ctx, "/reports/q3.csv", nil)
+//	if err != nil {
+//		return err
+//	}
+//	if _, err := io.Copy(w, src); err != nil {
+//		return err
+//	}
+//	if err := w.Close(); err != nil {
+//		return err
+//	}
+//
+//	r, err := c.Open(ctx, "/reports/q3.csv")
+//	...
+//
+// Files are named by path, which starts with "/", or by file ID. Errors for
+// a named file are *fs.PathError values that match fs.ErrNotExist,
+// fs.ErrExist and fs.ErrPermission where that applies; status.Code still
+// reports the gRPC code of the underlying failure.
+package client
+
+import (
+	"context"
+	"io/fs"
+	"math/rand"
+	"strings"
+	"time"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	"dfs/internal/pki"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// snapshotRoot is where the coordinator exposes read-only snapshots. Files
+// below it share their IDs with the live files they were taken from, so they
+// can only be read by path.
+const snapshotRoot = "/.snapshots"
+
+// Client talks to a DFS coordinator.
+type Client struct {
+	conn  *grpc.ClientConn
+	coord pbcoord.CoordinatorClient
+	opts  options
+}
+
+type options struct {
+	dialOptions    []grpc.DialOption
+	tls            pki.Files
+	token          string
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithDialOptions adds options for the gRPC connection. Without a
+// credentials option the connection is not encrypted.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// WithTLS connects to the coordinator over mutual TLS, verifying it against
+// the cluster CA certificate in caFile and presenting the client certificate
+// and key in certFile and keyFile. The files are PEM encoded, as issued by
+// dfs certs.
+func WithTLS(caFile, certFile, keyFile string) Option {
+	return func(o *options) {
+		o.tls = pki.Files{CA: caFile, Cert: certFile, Key: keyFile}
+	}
+}
+
+// WithToken makes every call with an API token, as issued by dfs token, for
+// coordinators that require authentication. Unless the connection uses TLS
+// the token is sent in the clear.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// ContextWithToken returns a context whose calls are made with token instead
+// of the one the client was created with, for servers that act on behalf of
+// their own users.
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	return auth.ContextWithToken(ctx, token)
+}
+
+// WithRetry sets how often a call that failed because the coordinator was
+// unavailable or overloaded is attempted in total, and the bounds of the
+// exponential backoff between attempts. maxAttempts of 1 disables retries.
+func WithRetry(maxAttempts int, initialBackoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxAttempts = max(maxAttempts, 1)
+		o.initialBackoff = initialBackoff
+		o.maxBackoff = max(maxBackoff, initialBackoff)
+	}
+}
+
+// New returns a client for the coordinator at addr. The connection is made
+// lazily, so New does not fail if the coordinator is down.
+func New(addr string, opts ...Option) (*Client, error) {
+	o := options{
+		dialOptions:    []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		maxAttempts:    4,
+		initialBackoff: 100 * time.Millisecond,
+		maxBackoff:     5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tls.Enabled() {
+		creds, err := pki.DialOption(o.tls, pki.RoleCoordinator)
+		if err != nil {
+			return nil, err
+		}
+		o.dialOptions = append(o.dialOptions, creds)
+	}
+	o.dialOptions = append(o.dialOptions, grpc.WithPerRPCCredentials(&auth.Credentials{Token: o.token, Insecure: !o.tls.Enabled()}))
+	conn, err := grpc.NewClient(addr, o.dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, coord: pbcoord.NewCoordinatorClient(conn), opts: o}, nil
+}
+
+// Close closes the connection. Readers and writers still open fail.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// FileInfo describes a file.
+type FileInfo struct {
+	ID          string
+	Name        string
+	Path        string
+	Size        int64
+	VersionID   string
+	ContentType string
+	Metadata    map[string]string
+	Tags        []string
+	// Hex-encoded digests of the contents. They are empty if the file was
+	// changed by a partial write since it was uploaded.
+	SHA256    string
+	MD5       string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Owner, Group and the permission bits of Mode are empty for files
+	// created while the coordinator did not require authentication.
+	Owner string
+	Group string
+	Mode  fs.FileMode
+	// Generation increases with every change to the file, attributes
+	// included.
+	Generation int64
+}
+
+func fileInfo(f *pbcoord.FileInfo) *FileInfo {
+	created, _ := time.Parse(time.RFC3339, f.CreatedAt)
+	updated, _ := time.Parse(time.RFC3339, f.UpdatedAt)
+	return &FileInfo{
+		ID:          f.FileId,
+		Name:        f.FileName,
+		Path:        f.Path,
+		Size:        f.FileSize,
+		VersionID:   f.VersionId,
+		ContentType: f.ContentType,
+		Metadata:    f.UserMetadata,
+		Tags:        f.Tags,
+		SHA256:      f.Sha256,
+		MD5:         f.Md5,
+		CreatedAt:   created,
+		UpdatedAt:   updated,
+		Owner:       f.Owner,
+		Group:       f.Group,
+		Mode:        fs.FileMode(f.Mode) & fs.ModePerm,
+		Generation:  f.Generation,
+	}
+}
+
+// Stat returns the attributes of the named file.
+func (c *Client) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	fileID, path := splitName(name)
+	var resp *pbcoord.StatFileResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		resp, err = c.coord.StatFile(ctx, &pbcoord.StatFileRequest{FileId: fileID, Path: path})
+		return err
+	})
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fileInfo(resp.File), nil
+}
+
+// ListOptions narrows down the files List returns. Every condition that is
+// set has to hold.
+type ListOptions struct {
+	Tags     []string
+	Metadata map[string]string
+	// ContentType matches files of this type, or of any subtype if it ends
+	// in "/", as in "image/".
+	ContentType string
+}
+
+// List returns the files whose path starts with prefix. opts may be nil.
+func (c *Client) List(ctx context.Context, prefix string, opts *ListOptions) ([]*FileInfo, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	var resp *pbcoord.ListFilesResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		resp, err = c.coord.ListFiles(ctx, &pbcoord.ListFilesRequest{
+			PathPrefix:   prefix,
+			Tags:         opts.Tags,
+			UserMetadata: opts.Metadata,
+			ContentType:  opts.ContentType,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, pathError("list", prefix, err)
+	}
+	files := make([]*FileInfo, len(resp.Files))
+	for i, f := range resp.Files {
+		files[i] = fileInfo(f)
+	}
+	return files, nil
+}
+
+// Remove moves the named file to the trash, from where it can be restored
+// until the coordinator's retention period runs out.
+func (c *Client) Remove(ctx context.Context, name string) error {
+	return c.remove(ctx, name, false)
+}
+
+// RemovePermanently deletes the named file and its data right away.
+func (c *Client) RemovePermanently(ctx context.Context, name string) error {
+	return c.remove(ctx, name, true)
+}
+
+func (c *Client) remove(ctx context.Context, name string, permanent bool) error {
+	fileID, path := splitName(name)
+	err := c.retry(ctx, func() error {
+		_, err := c.coord.DeleteFile(ctx, &pbcoord.DeleteFileRequest{FileId: fileID, Path: path, Permanent: permanent})
+		return err
+	})
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	return nil
+}
+
+// Rename moves the named file to the path to. A different file already at
+// to is moved to the trash if overwrite is set; otherwise Rename fails with
+// an error matching fs.ErrExist.
+func (c *Client) Rename(ctx context.Context, name, to string, overwrite bool) (*FileInfo, error) {
+	fileID, path := splitName(name)
+	var resp *pbcoord.MoveFileResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		resp, err = c.coord.MoveFile(ctx, &pbcoord.MoveFileRequest{
+			FileId:          fileID,
+			Path:            path,
+			DestinationPath: to,
+			Overwrite:       overwrite,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, pathError("rename", name, err)
+	}
+	return fileInfo(resp.File), nil
+}
+
+// Chmod changes the permission bits of the named file, or of the directory
+// if name ends in "/". Only the owner and admins may change them.
+func (c *Client) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	return c.setPermissions(ctx, "chmod", name, &pbcoord.SetPermissionsRequest{Mode: uint32(mode.Perm()), SetMode: true})
+}
+
+// Chown changes the owner and group of the named file, or of the directory
+// if name ends in "/". An empty owner or group is left as it is. Only admins
+// may change the owner, and owners may only pick a group they belong to.
+func (c *Client) Chown(ctx context.Context, name, owner, group string) error {
+	return c.setPermissions(ctx, "chown", name, &pbcoord.SetPermissionsRequest{Owner: owner, Group: group})
+}
+
+func (c *Client) setPermissions(ctx context.Context, op, name string, req *pbcoord.SetPermissionsRequest) error {
+	if strings.HasSuffix(name, "/") {
+		req.Path = name
+	} else {
+		req.FileId, req.FilePath = splitName(name)
+	}
+	err := c.retry(ctx, func() error {
+		_, err := c.coord.SetPermissions(ctx, req)
+		return err
+	})
+	if err != nil {
+		return pathError(op, name, err)
+	}
+	return nil
+}
+
+// splitName tells whether name is a path or a file ID.
+func splitName(name string) (fileID, path string) {
+	if strings.HasPrefix(name, "/") {
+		return "", name
+	}
+	return name, ""
+}
+
+// retry calls f until it succeeds, fails with an error that is not worth
+// retrying, or has been attempted as often as the client allows. Between
+// attempts it waits for an exponentially growing, jittered backoff.
+func (c *Client) retry(ctx context.Context, f func() error) error {
+	backoff := c.opts.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= c.opts.maxAttempts || !retryable(err) {
+			return err
+		}
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff = min(2*backoff, c.opts.maxBackoff)
+	}
+}
+
+// retryable reports whether err means the call did not take effect and may
+// succeed if made again.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// pathError wraps the error of an RPC on the named file.
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: &rpcError{err}}
+}
+
+// rpcError is the error of a failed RPC. It prints as the status message
+// and matches the io/fs error that corresponds to its code.
+type rpcError struct {
+	err error
+}
+
+func (e *rpcError) Error() string { return status.Convert(e.err).Message() }
+
+func (e *rpcError) Unwrap() error { return e.err }
+
+func (e *rpcError) Is(target error) bool {
+	switch status.Code(e.err) {
+	case codes.NotFound:
+		return target == fs.ErrNotExist
+	case codes.AlreadyExists:
+		return target == fs.ErrExist
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return target == fs.ErrPermission
+	case codes.Canceled:
+		return target == context.Canceled
+	case codes.DeadlineExceeded:
+		return target == context.DeadlineExceeded
+	}
+	return false
+}
@@ -0,0 +1,498 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCoordinator serves files from memory and records the calls it gets.
+type fakeCoordinator struct {
+	pbcoord.UnimplementedCoordinatorServer
+
+	mu    sync.Mutex
+	files map[string][]byte
+	// size, if set, is the size StatFile reports instead of the real one.
+	size map[string]int64
+	// piece is how many bytes each download message carries.
+	piece int
+	// errs are returned by the next calls, one per call, before any of
+	// them is served.
+	errs []error
+	// breakAfter makes the next download stream fail with Unavailable
+	// after that many messages.
+	breakAfter int
+
+	calls     map[string]int
+	uploads   [][]*pbcoord.UploadFileRequest
+	deletes   []*pbcoord.DeleteFileRequest
+	setPerms  []*pbcoord.SetPermissionsRequest
+	downloads []*pbcoord.DownloadFileRequest
+}
+
+func (f *fakeCoordinator) call(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[name]++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (f *fakeCoordinator) StatFile(ctx context.Context, req *pbcoord.StatFileRequest) (*pbcoord.StatFileResponse, error) {
+	if err := f.call("StatFile"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := req.GetPath()
+	if p == "" {
+		p = req.GetFileId()
+	}
+	data, ok := f.files[p]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no file %s", p)
+	}
+	size, ok := f.size[p]
+	if !ok {
+		size = int64(len(data))
+	}
+	return &pbcoord.StatFileResponse{File: &pbcoord.FileInfo{FileId: p, Path: p, FileSize: size, VersionId: "v1"}}, nil
+}
+
+func (f *fakeCoordinator) DownloadFile(req *pbcoord.DownloadFileRequest, stream pbcoord.Coordinator_DownloadFileServer) error {
+	if err := f.call("DownloadFile"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.downloads = append(f.downloads, req)
+	data := f.files[req.GetFileId()]
+	breakAfter := f.breakAfter
+	f.breakAfter = 0
+	f.mu.Unlock()
+	data = data[min(req.GetOffset(), int64(len(data))):]
+	if req.GetLength() > 0 {
+		data = data[:min(req.GetLength(), int64(len(data)))]
+	}
+	for sent := 0; len(data) > 0; sent++ {
+		if breakAfter > 0 && sent == breakAfter {
+			return status.Errorf(codes.Unavailable, "connection lost")
+		}
+		n := min(f.piece, len(data))
+		if err := stream.Send(&pbcoord.DownloadFileResponse{ChunkData: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (f *fakeCoordinator) UploadFile(stream pbcoord.Coordinator_UploadFileServer) error {
+	if err := f.call("UploadFile"); err != nil {
+		return err
+	}
+	var msgs []*pbcoord.UploadFileRequest
+	var data []byte
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, req)
+		data = append(data, req.ChunkData...)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads = append(f.uploads, msgs)
+	f.files[msgs[0].GetPath()] = data
+	return stream.SendAndClose(&pbcoord.UploadFileResponse{FileId: msgs[0].GetPath(), VersionId: "v1"})
+}
+
+func (f *fakeCoordinator) DeleteFile(ctx context.Context, req *pbcoord.DeleteFileRequest) (*pbcoord.DeleteFileResponse, error) {
+	if err := f.call("DeleteFile"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletes = append(f.deletes, req)
+	return &pbcoord.DeleteFileResponse{Success: true}, nil
+}
+
+func (f *fakeCoordinator) SetPermissions(ctx context.Context, req *pbcoord.SetPermissionsRequest) (*pbcoord.SetPermissionsResponse, error) {
+	if err := f.call("SetPermissions"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setPerms = append(f.setPerms, req)
+	return &pbcoord.SetPermissionsResponse{}, nil
+}
+
+func (f *fakeCoordinator) MoveFile(ctx context.Context, req *pbcoord.MoveFileRequest) (*pbcoord.MoveFileResponse, error) {
+	if err := f.call("MoveFile"); err != nil {
+		return nil, err
+	}
+	return &pbcoord.MoveFileResponse{File: &pbcoord.FileInfo{Path: req.GetDestinationPath()}}, nil
+}
+
+// startFake serves a fake coordinator and returns it with a client that
+// retries quickly.
+func startFake(t *testing.T, opts ...Option) (*fakeCoordinator, *Client) {
+	t.Helper()
+	f := &fakeCoordinator{files: map[string][]byte{}, size: map[string]int64{}, piece: 4, calls: map[string]int{}}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pbcoord.RegisterCoordinatorServer(s, f)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	opts = append([]Option{WithRetry(4, time.Millisecond, 4*time.Millisecond)}, opts...)
+	c, err := New(lis.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return f, c
+}
+
+func TestRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantCode  codes.Code
+	}{
+		{"success", nil, 1, codes.OK},
+		{"recovers", []error{unavailable, status.Error(codes.ResourceExhausted, "busy")}, 3, codes.OK},
+		{"gives up", []error{unavailable, unavailable, unavailable, unavailable, unavailable}, 4, codes.Unavailable},
+		{"not retryable", []error{status.Error(codes.Internal, "broken")}, 1, codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, c := startFake(t)
+			f.files["/a"] = []byte("a")
+			f.errs = tt.errs
+			_, err := c.Stat(context.Background(), "/a")
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("Stat = %v, want code %v", err, tt.wantCode)
+			}
+			if f.calls["StatFile"] != tt.wantCalls {
+				t.Errorf("StatFile called %d times, want %d", f.calls["StatFile"], tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	f, c := startFake(t, WithRetry(3, 40*time.Millisecond, 40*time.Millisecond))
+	unavailable := status.Error(codes.Unavailable, "down")
+	f.errs = []error{unavailable, unavailable, unavailable}
+	start := time.Now()
+	if _, err := c.Stat(context.Background(), "/a"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Stat = %v", err)
+	}
+	// Two waits of at least half the backoff each.
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("three attempts took %v, want at least 40ms of backoff", elapsed)
+	}
+
+	// Cancelling the context ends the waiting.
+	f.errs = []error{unavailable, unavailable, unavailable}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Stat(ctx, "/a"); err == nil {
+		t.Fatal("Stat succeeded with a cancelled context")
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want error
+	}{
+		{codes.NotFound, fs.ErrNotExist},
+		{codes.AlreadyExists, fs.ErrExist},
+		{codes.PermissionDenied, fs.ErrPermission},
+		{codes.Unauthenticated, fs.ErrPermission},
+		{codes.DeadlineExceeded, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		f, c := startFake(t)
+		f.errs = []error{status.Error(tt.code, "the message")}
+		_, err := c.Rename(context.Background(), "/a", "/b", false)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%v: Rename = %v, want an error matching %v", tt.code, err, tt.want)
+		}
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Op != "rename" || pathErr.Path != "/a" {
+			t.Errorf("%v: Rename = %#v, want a *fs.PathError for rename /a", tt.code, err)
+		}
+		if err.Error() != "rename /a: the message" {
+			t.Errorf("%v: error prints as %q", tt.code, err)
+		}
+		if status.Code(err) != tt.code {
+			t.Errorf("%v: the status is lost", tt.code)
+		}
+	}
+	if errors.Is(pathError("stat", "/a", status.Error(codes.Internal, "x")), fs.ErrNotExist) {
+		t.Error("Internal matches fs.ErrNotExist")
+	}
+}
+
+func TestWriterBlocks(t *testing.T) {
+	f, c := startFake(t)
+	data := bytes.Repeat([]byte("0123456789"), (2*uploadBlockSize+1000)/10)
+	w, err := c.Create(context.Background(), "/big.bin", &CreateOptions{ContentType: "application/x-test", Tags: []string{"t"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes of odd sizes that straddle the block boundaries.
+	for rest := data; len(rest) > 0; {
+		n := min(7000, len(rest))
+		if k, err := w.Write(rest[:n]); err != nil || k != n {
+			t.Fatalf("Write = %d, %v", k, err)
+		}
+		rest = rest[n:]
+	}
+	if w.Result() != nil {
+		t.Error("Result is set before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Result() == nil || w.Result().FileID != "/big.bin" {
+		t.Errorf("Result = %+v", w.Result())
+	}
+	msgs := f.uploads[0]
+	var sizes []int
+	for _, m := range msgs {
+		sizes = append(sizes, len(m.ChunkData))
+	}
+	if len(sizes) != 3 || sizes[0] != uploadBlockSize || sizes[1] != uploadBlockSize || sizes[2] != len(data)-2*uploadBlockSize {
+		t.Errorf("upload messages carry %v bytes", sizes)
+	}
+	if m := msgs[0]; m.Path != "/big.bin" || m.FileName != "big.bin" || m.ContentType != "application/x-test" || len(m.Tags) != 1 {
+		t.Errorf("first message = %v", m)
+	}
+	if msgs[1].Path != "" || msgs[2].Path != "" {
+		t.Error("later messages repeat the attributes")
+	}
+	if !bytes.Equal(f.files["/big.bin"], data) {
+		t.Error("uploaded data differs")
+	}
+	if _, err := w.Write([]byte("x")); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Write after Close = %v", err)
+	}
+	if err := w.Close(); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestWriterEmptyFile(t *testing.T) {
+	f, c := startFake(t)
+	w, err := c.Create(context.Background(), "/empty", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The one message names the file.
+	if msgs := f.uploads[0]; len(msgs) != 1 || msgs[0].Path != "/empty" || len(msgs[0].ChunkData) != 0 {
+		t.Fatalf("empty upload sent %v", msgs)
+	}
+	if data, ok := f.files["/empty"]; !ok || len(data) != 0 {
+		t.Fatalf("stored %q, %v", data, ok)
+	}
+
+	for _, name := range []string{"relative", "/dir/"} {
+		if _, err := c.Create(context.Background(), name, nil); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Create(%q) = %v, want fs.ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	f, c := startFake(t)
+	f.files["/f"] = []byte("0123456789abcdefghij")
+	r, err := c.Open(context.Background(), "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	read := func(n int) string {
+		t.Helper()
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+
+	// The first message holds "0123"; the rest of it stays buffered.
+	if got := read(2); got != "01" {
+		t.Fatalf("read %q", got)
+	}
+	if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(3); got != "345" {
+		t.Fatalf("read %q after skipping within the buffer", got)
+	}
+	if n := f.calls["DownloadFile"]; n != 1 {
+		t.Fatalf("%d streams for reads and a short seek, want 1", n)
+	}
+
+	if pos, err := r.Seek(-4, io.SeekEnd); err != nil || pos != 16 {
+		t.Fatalf("Seek from the end = %d, %v", pos, err)
+	}
+	if got := read(4); got != "ghij" {
+		t.Fatalf("read %q after seeking to the end", got)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("Read at the end = %d, %v", n, err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(4); got != "0123" {
+		t.Fatalf("read %q after seeking back", got)
+	}
+	if n := f.calls["DownloadFile"]; n != 3 {
+		t.Fatalf("%d streams after two far seeks, want 3", n)
+	}
+	if off := f.downloads[1].Offset; off != 16 {
+		t.Errorf("stream after seeking to the end starts at %d", off)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative offset succeeded")
+	}
+}
+
+func TestReaderResumesBrokenStream(t *testing.T) {
+	f, c := startFake(t)
+	f.files["/f"] = []byte("0123456789abcdefghij")
+	f.breakAfter = 2
+	r, err := c.Open(context.Background(), "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "0123456789abcdefghij" {
+		t.Fatalf("ReadAll = %q, %v", data, err)
+	}
+	if len(f.downloads) != 2 || f.downloads[1].Offset != 8 {
+		t.Fatalf("downloads after a broken stream: %v", f.downloads)
+	}
+}
+
+func TestReaderReadAt(t *testing.T) {
+	f, c := startFake(t)
+	f.files["/f"] = []byte("0123456789abcdefghij")
+	r, err := c.Open(context.Background(), "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		off     int64
+		n       int
+		want    string
+		wantErr error
+	}{
+		{5, 7, "56789ab", nil},
+		{15, 10, "fghij", io.EOF},
+		{20, 1, "", io.EOF},
+	}
+	for _, tt := range tests {
+		buf := make([]byte, tt.n)
+		n, err := r.ReadAt(buf, tt.off)
+		if string(buf[:n]) != tt.want || err != tt.wantErr {
+			t.Errorf("ReadAt(%d, %d) = %q, %v; want %q, %v", tt.n, tt.off, buf[:n], err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := r.ReadAt(make([]byte, 1), -1); err == nil {
+		t.Error("ReadAt at a negative offset succeeded")
+	}
+	// ReadAt leaves the offset of Read alone.
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "012" {
+		t.Errorf("Read after ReadAt = %q, %v", buf, err)
+	}
+	r.Close()
+	if _, err := r.ReadAt(buf, 0); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("ReadAt after Close = %v", err)
+	}
+}
+
+func TestReaderShortFile(t *testing.T) {
+	f, c := startFake(t)
+	f.files["/f"] = []byte("0123456789")
+	f.size["/f"] = 16
+	r, err := c.Open(context.Background(), "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadAll of a file shorter than its size = %v, want io.ErrUnexpectedEOF", err)
+	}
+	buf := make([]byte, 8)
+	n, err := r.ReadAt(buf, 4)
+	if n != 6 || err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadAt past the real end = %d, %v; want 6, io.ErrUnexpectedEOF", n, err)
+	}
+}
+
+func TestChangesByPath(t *testing.T) {
+	f, c := startFake(t)
+	ctx := context.Background()
+	if err := c.Remove(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemovePermanently(ctx, "f123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Chmod(ctx, "/a", 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Chown(ctx, "/dir/", "", "staff"); err != nil {
+		t.Fatal(err)
+	}
+	// The server resolves paths itself, in the same step as the change,
+	// so the client does not look the files up first.
+	if n := f.calls["StatFile"]; n != 0 {
+		t.Errorf("%d StatFile calls, want none", n)
+	}
+	if d := f.deletes[0]; d.Path != "/a" || d.FileId != "" || d.Permanent {
+		t.Errorf("Remove sent %v", d)
+	}
+	if d := f.deletes[1]; d.Path != "" || d.FileId != "f123" || !d.Permanent {
+		t.Errorf("RemovePermanently sent %v", d)
+	}
+	if p := f.setPerms[0]; p.FilePath != "/a" || p.FileId != "" || p.Path != "" || p.Mode != 0o600 || !p.SetMode {
+		t.Errorf("Chmod sent %v", p)
+	}
+	if p := f.setPerms[1]; p.Path != "/dir/" || p.FilePath != "" || p.Group != "staff" {
+		t.Errorf("Chown of a directory sent %v", p)
+	}
+}
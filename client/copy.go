@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+
+	pbcoord "dfs/internal/pb/coordinator"
+)
+
+// Copy makes a copy of the named file at path to. The copy shares its data
+// with the original, so no data is transferred. Like Create, it replaces a
+// file at to or becomes a new version of it.
+func (c *Client) Copy(ctx context.Context, name, to string) (*FileInfo, error) {
+	fileID, path := splitName(name)
+	var resp *pbcoord.CopyFileResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		resp, err = c.coord.CopyFile(ctx, &pbcoord.CopyFileRequest{
+			SourceFileId:    fileID,
+			SourcePath:      path,
+			DestinationPath: to,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, pathError("copy", name, err)
+	}
+	return c.Stat(ctx, resp.FileId)
+}
+
+// Concat makes a file at path to out of the contents of the named files, one
+// after the other. Like Copy, it shares data with the sources. opts sets the
+// attributes of the new file and may be nil.
+func (c *Client) Concat(ctx context.Context, to string, names []string, opts *CreateOptions) (*FileInfo, error) {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	fileIDs := make([]string, len(names))
+	for i, name := range names {
+		fileID, path := splitName(name)
+		if path != "" {
+			info, err := c.Stat(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			fileID = info.ID
+		}
+		fileIDs[i] = fileID
+	}
+	var resp *pbcoord.ConcatFilesResponse
+	err := c.retry(ctx, func() error {
+		var err error
+		resp, err = c.coord.ConcatFiles(ctx, &pbcoord.ConcatFilesRequest{
+			SourceFileIds:   fileIDs,
+			DestinationPath: to,
+			ContentType:     opts.ContentType,
+			UserMetadata:    opts.Metadata,
+			Tags:            opts.Tags,
+			Mode:            uint32(opts.Mode.Perm()),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, pathError("concat", to, err)
+	}
+	return c.Stat(ctx, resp.FileId)
+}
@@ -0,0 +1,250 @@
+// Package dfsfs presents the DFS namespace as an io/fs file system, so that
+// fs.WalkDir, http.FS, template.ParseFS and the like work on DFS contents.
+//
+// The DFS keeps a flat namespace of file paths; directories are implied by
+// the paths of the files below them and cannot be empty. If a path names a
+// file and is also a prefix of other paths, the file hides the directory.
+// Files are loaded lazily: opening one only fetches its attributes, and the
+// contents are streamed as they are read.
+package dfsfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"dfs/client"
+)
+
+// FS is a read-only view of the files below a DFS path. It implements
+// fs.FS, fs.ReadDirFS, fs.ReadFileFS, fs.StatFS and fs.SubFS.
+type FS struct {
+	ctx  context.Context
+	c    *client.Client
+	root string
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.SubFS      = (*FS)(nil)
+)
+
+// New returns a file system of the files below root, which is "/" for the
+// whole namespace. ctx applies to every call made through it.
+func New(ctx context.Context, c *client.Client, root string) *FS {
+	return &FS{ctx: ctx, c: c, root: "/" + strings.Trim(root, "/")}
+}
+
+// dfsPath returns the DFS path for a valid fs name.
+func (f *FS) dfsPath(name string) string {
+	if name == "." {
+		return f.root
+	}
+	return path.Join(f.root, name)
+}
+
+// Open opens the named file or directory. Files implement io.Seeker and
+// io.ReaderAt as well as fs.File.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		r, err := f.c.Open(f.ctx, f.dfsPath(name))
+		if err == nil {
+			return &file{r}, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, relabel(err, "open", name)
+		}
+	}
+	entries, err := f.readDir("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &dir{name: name, entries: entries}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		info, err := f.c.Stat(f.ctx, f.dfsPath(name))
+		if err == nil {
+			return fileInfo{info}, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, relabel(err, "stat", name)
+		}
+	}
+	if _, err := f.readDir("stat", name); err != nil {
+		return nil, err
+	}
+	return dirInfo(path.Base(name)), nil
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	r, err := f.c.Open(f.ctx, f.dfsPath(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && name != "." {
+			if _, dirErr := f.readDir("readfile", name); dirErr == nil {
+				return nil, &fs.PathError{Op: "readfile", Path: name, Err: errIsDir}
+			}
+		}
+		return nil, relabel(err, "readfile", name)
+	}
+	defer r.Close()
+	data := make([]byte, r.Info().Size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, relabel(err, "readfile", name)
+	}
+	return data, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		_, err := f.c.Stat(f.ctx, f.dfsPath(name))
+		if err == nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, relabel(err, "readdir", name)
+		}
+	}
+	return f.readDir("readdir", name)
+}
+
+// Sub returns the file system below dir.
+func (f *FS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	return New(f.ctx, f.c, f.dfsPath(dir)), nil
+}
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// readDir lists the directory name, sorted by name. Only the root may be
+// empty; any other directory without files does not exist.
+func (f *FS) readDir(op, name string) ([]fs.DirEntry, error) {
+	prefix := f.dfsPath(name)
+	if prefix != "/" {
+		prefix += "/"
+	}
+	files, err := f.c.List(f.ctx, prefix, nil)
+	if err != nil {
+		return nil, relabel(err, op, name)
+	}
+
+	children := map[string]fs.DirEntry{}
+	for _, info := range files {
+		rel := strings.TrimPrefix(info.Path, prefix)
+		child, _, isDir := strings.Cut(rel, "/")
+		if !fs.ValidPath(rel) {
+			// Paths such as "/a//b" have no io/fs name.
+			continue
+		}
+		if !isDir {
+			children[child] = fs.FileInfoToDirEntry(fileInfo{info})
+		} else if _, ok := children[child]; !ok {
+			children[child] = fs.FileInfoToDirEntry(dirInfo(child))
+		}
+	}
+	if len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, e := range children {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+// relabel reports err, which names a DFS path, under the fs name instead.
+func relabel(err error, op, name string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// file is an open DFS file.
+type file struct {
+	*client.Reader
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return fileInfo{f.Info()}, nil
+}
+
+// fileInfo adapts the attributes of a DFS file. Sys returns the
+// *client.FileInfo.
+type fileInfo struct {
+	info *client.FileInfo
+}
+
+func (fi fileInfo) Name() string       { return path.Base(fi.info.Path) }
+func (fi fileInfo) Size() int64        { return fi.info.Size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi fileInfo) ModTime() time.Time { return fi.info.UpdatedAt }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() any           { return fi.info }
+
+// dirInfo describes an implied directory by its name.
+type dirInfo string
+
+func (di dirInfo) Name() string       { return string(di) }
+func (di dirInfo) Size() int64        { return 0 }
+func (di dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (di dirInfo) ModTime() time.Time { return time.Time{} }
+func (di dirInfo) IsDir() bool        { return true }
+func (di dirInfo) Sys() any           { return nil }
+
+// dir is an open directory. Its entries are listed when it is opened.
+type dir struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return dirInfo(path.Base(d.name)), nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
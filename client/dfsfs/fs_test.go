@@ -0,0 +1,125 @@
+package dfsfs_test
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"testing"
+	"testing/fstest"
+
+	"dfs/client"
+	"dfs/client/dfsfs"
+	"dfs/internal/chunk"
+	"dfs/internal/coordinator"
+	"dfs/internal/metadataservice"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+	pbstorage "dfs/internal/pb/storagenode"
+	"dfs/internal/storagenode"
+
+	"google.golang.org/grpc"
+)
+
+// serve starts a gRPC server with the services register adds and returns
+// its address.
+func serve(t *testing.T, register func(s *grpc.Server)) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// startCluster runs a metadata service, three storage nodes and a
+// coordinator in the test process and returns a client of the coordinator.
+func startCluster(t *testing.T) *client.Client {
+	t.Helper()
+	store, err := metadataservice.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataAddr := serve(t, func(s *grpc.Server) {
+		pbmeta.RegisterMetadataServiceServer(s, metadataservice.NewServer(store))
+	})
+
+	var storageAddrs []string
+	for i := 0; i < 3; i++ {
+		chunks, err := chunk.NewDiskStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		storageAddrs = append(storageAddrs, serve(t, func(s *grpc.Server) {
+			pbstorage.RegisterStorageNodeServer(s, storagenode.NewServer(chunks))
+		}))
+	}
+
+	coord, err := coordinator.NewServer(coordinator.Config{
+		MetadataAddrs:     []string{metadataAddr},
+		StorageAddrs:      storageAddrs,
+		CacheSize:         -1,
+		RetentionInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New(serve(t, func(s *grpc.Server) {
+		pbcoord.RegisterCoordinatorServer(s, coord)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func upload(t *testing.T, c *client.Client, name, contents string) {
+	t.Helper()
+	w, err := c.Create(context.Background(), name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("uploading %s: %v", name, err)
+	}
+}
+
+func TestFS(t *testing.T) {
+	c := startCluster(t)
+	files := map[string]string{
+		"/top.txt":               "top",
+		"/docs/readme.md":        "# readme",
+		"/docs/guide/intro.txt":  "introduction",
+		"/docs/guide/empty.txt":  "",
+		"/other/data.bin":        string(make([]byte, 4096)),
+		"/other/deep/a/b/c.json": `{"c": true}`,
+	}
+	for name, contents := range files {
+		upload(t, c, name, contents)
+	}
+
+	fsys := dfsfs.New(context.Background(), c, "/")
+	err := fstest.TestFS(fsys, "top.txt", "docs/readme.md", "docs/guide/intro.txt",
+		"docs/guide/empty.txt", "other/data.bin", "other/deep/a/b/c.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := fs.Sub(fsys, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "readme.md", "guide/intro.txt", "guide/empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(dfsfs.New(context.Background(), c, "/docs/guide"), "intro.txt", "empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+
+	pbcoord "dfs/internal/pb/coordinator"
+)
+
+// Reader reads a file as it was when it was opened: it keeps reading the
+// version that was current then, even if the file is changed in the
+// meantime. It implements io.ReadSeekCloser and io.ReaderAt.
+//
+// Read streams the file from the current offset and keeps the stream open
+// for the next Read; a Seek outside the data already received restarts it
+// at the new offset. ReadAt makes a separate ranged request for each call
+// and may be called concurrently, unlike Read and Seek.
+type Reader struct {
+	c    *Client
+	ctx  context.Context
+	name string
+	info *FileInfo
+	// fileID or path name the pinned version of the file for
+	// DownloadFile.
+	fileID string
+	path   string
+
+	offset int64
+	// stream delivers the file from offset+len(buf) on; buf holds data
+	// already received but not yet read.
+	stream pbcoord.Coordinator_DownloadFileClient
+	cancel context.CancelFunc
+	buf    []byte
+	closed bool
+}
+
+// Open opens the named file for reading. ctx applies to every read.
+func (c *Client) Open(ctx context.Context, name string) (*Reader, error) {
+	info, err := c.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{c: c, ctx: ctx, name: name, info: info, fileID: info.ID}
+	if strings.HasPrefix(name, snapshotRoot+"/") {
+		r.fileID, r.path = "", name
+	}
+	return r, nil
+}
+
+// Info returns the attributes of the file as of when it was opened.
+func (r *Reader) Info() *FileInfo {
+	return r.info
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, &fs.PathError{Op: "read", Path: r.name, Err: fs.ErrClosed}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.offset >= r.info.Size {
+		return 0, io.EOF
+	}
+	for len(r.buf) == 0 {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+	return n, nil
+}
+
+// fill receives the next piece of the file into buf, opening a stream at
+// the current offset if there is none. A stream that breaks off is reopened
+// where it stopped.
+func (r *Reader) fill() error {
+	err := r.c.retry(r.ctx, func() error {
+		if r.stream == nil {
+			ctx, cancel := context.WithCancel(r.ctx)
+			stream, err := r.c.coord.DownloadFile(ctx, &pbcoord.DownloadFileRequest{
+				FileId:    r.fileID,
+				Path:      r.path,
+				VersionId: r.info.VersionID,
+				Offset:    r.offset,
+			})
+			if err != nil {
+				cancel()
+				return err
+			}
+			r.stream, r.cancel = stream, cancel
+		}
+		resp, err := r.stream.Recv()
+		if err != nil {
+			r.closeStream()
+			return err
+		}
+		r.buf = resp.ChunkData
+		return nil
+	})
+	if err == io.EOF {
+		// The file is shorter than its size said.
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return pathError("read", r.name, err)
+	}
+	return nil
+}
+
+func (r *Reader) closeStream() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.stream, r.cancel, r.buf = nil, nil, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, &fs.PathError{Op: "seek", Path: r.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.info.Size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: r.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: r.name, Err: errors.New("negative offset")}
+	}
+
+	// Skipping ahead within what has been received keeps the stream;
+	// anything else needs a new one.
+	if d := offset - r.offset; r.stream != nil && d >= 0 && d <= int64(len(r.buf)) {
+		r.buf = r.buf[d:]
+	} else if d != 0 {
+		r.closeStream()
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if r.closed {
+		return 0, &fs.PathError{Op: "read", Path: r.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: r.name, Err: errors.New("negative offset")}
+	}
+	if off >= r.info.Size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), r.info.Size-off)
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+	var n int64
+	err := r.c.retry(ctx, func() error {
+		stream, err := r.c.coord.DownloadFile(ctx, &pbcoord.DownloadFileRequest{
+			FileId:    r.fileID,
+			Path:      r.path,
+			VersionId: r.info.VersionID,
+			Offset:    off + n,
+			Length:    want - n,
+		})
+		if err != nil {
+			return err
+		}
+		for n < want {
+			resp, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			n += int64(copy(p[n:want], resp.ChunkData))
+		}
+		return nil
+	})
+	switch {
+	case err == io.EOF:
+		return int(n), io.ErrUnexpectedEOF
+	case err != nil:
+		return int(n), pathError("read", r.name, err)
+	case n < int64(len(p)):
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+// Close ends the stream of a Reader that was not read to the end.
+func (r *Reader) Close() error {
+	if r.closed {
+		return &fs.PathError{Op: "close", Path: r.name, Err: fs.ErrClosed}
+	}
+	r.closed = true
+	r.closeStream()
+	return nil
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+
+	pbcoord "dfs/internal/pb/coordinator"
+)
+
+// uploadBlockSize is how much data each upload message carries.
+const uploadBlockSize = 64 * 1024
+
+// CreateOptions sets the attributes of a file written with Create. When a
+// new version of an existing file is written, attributes left unset keep
+// their current values.
+type CreateOptions struct {
+	// ContentType is detected from the name and contents if empty.
+	ContentType string
+	Metadata    map[string]string
+	Tags        []string
+	// Mode holds the permission bits of a new file; zero selects 0644. A
+	// file that replaces another keeps its permissions.
+	Mode fs.FileMode
+	// IfNoneMatch makes the upload fail with an error matching fs.ErrExist
+	// if there is a file at the path when it starts or completes.
+	IfNoneMatch bool
+	// IfMatch, if set, makes the upload fail with codes.FailedPrecondition
+	// unless the file at the path is the file IfMatch describes, at the
+	// same Generation, from start to end.
+	IfMatch *FileInfo
+}
+
+// UploadResult identifies the file version a Writer created.
+type UploadResult struct {
+	FileID    string
+	VersionID string
+	// Hex-encoded digests of the uploaded contents.
+	SHA256 string
+	MD5    string
+}
+
+// Writer uploads a file as it is written, in blocks of uploadBlockSize. The
+// file, or the new version of it, only appears once Close succeeds; Abort
+// or cancelling the context passed to Create throws the upload away.
+//
+// Starting the upload is retried like any other call, but an upload that
+// fails after data was sent is not, since the data is not kept.
+type Writer struct {
+	c      *Client
+	name   string
+	stream pbcoord.Coordinator_UploadFileClient
+	cancel context.CancelFunc
+	// first carries the file's name and attributes and is sent with the
+	// first block.
+	first  *pbcoord.UploadFileRequest
+	buf    []byte
+	err    error
+	result *UploadResult
+	closed bool
+}
+
+// Create starts writing the file at path name, which replaces any file at
+// that path or, if versioning is enabled for it, becomes its new version.
+// The coordinator moves a replaced file to the trash. opts may be nil.
+func (c *Client) Create(ctx context.Context, name string, opts *CreateOptions) (*Writer, error) {
+	if !strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	streamCtx, cancel := context.WithCancel(ctx)
+	var stream pbcoord.Coordinator_UploadFileClient
+	err := c.retry(streamCtx, func() error {
+		var err error
+		stream, err = c.coord.UploadFile(streamCtx)
+		return err
+	})
+	if err != nil {
+		cancel()
+		return nil, pathError("create", name, err)
+	}
+	w := &Writer{
+		c:      c,
+		name:   name,
+		stream: stream,
+		cancel: cancel,
+		first: &pbcoord.UploadFileRequest{
+			FileName:     path.Base(name),
+			Path:         name,
+			ContentType:  opts.ContentType,
+			UserMetadata: opts.Metadata,
+			Tags:         opts.Tags,
+			Mode:         uint32(opts.Mode.Perm()),
+			IfNoneMatch:  opts.IfNoneMatch,
+		},
+		buf: make([]byte, 0, uploadBlockSize),
+	}
+	if opts.IfMatch != nil {
+		w.first.IfMatchFileId = opts.IfMatch.ID
+		w.first.IfMatch = opts.IfMatch.Generation
+	}
+	return w, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: fs.ErrClosed}
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(w.buf)+len(p) >= uploadBlockSize {
+		k := uploadBlockSize - len(w.buf)
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		if err := w.send(); err != nil {
+			return n - len(p) - k, err
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return n, nil
+}
+
+// send sends what is buffered as the next upload message.
+func (w *Writer) send() error {
+	req := &pbcoord.UploadFileRequest{}
+	if w.first != nil {
+		req, w.first = w.first, nil
+	}
+	req.ChunkData = w.buf
+	if err := w.stream.Send(req); err != nil {
+		// A failed send means the server ended the call; its status is
+		// returned by CloseAndRecv.
+		_, err = w.stream.CloseAndRecv()
+		w.fail(err)
+		return w.err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *Writer) fail(err error) {
+	w.cancel()
+	w.err = pathError("write", w.name, err)
+}
+
+// Close sends the rest of the data and completes the upload.
+func (w *Writer) Close() error {
+	if w.closed {
+		return &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	// An empty file still needs the first message to name it.
+	if len(w.buf) > 0 || w.first != nil {
+		if err := w.send(); err != nil {
+			return err
+		}
+	}
+	resp, err := w.stream.CloseAndRecv()
+	w.cancel()
+	if err != nil {
+		w.err = pathError("close", w.name, err)
+		return w.err
+	}
+	w.result = &UploadResult{
+		FileID:    resp.FileId,
+		VersionID: resp.VersionId,
+		SHA256:    resp.Sha256,
+		MD5:       resp.Md5,
+	}
+	return nil
+}
+
+// Abort throws the upload away. It does nothing after Close.
+func (w *Writer) Abort() {
+	if !w.closed {
+		w.closed = true
+		w.cancel()
+	}
+}
+
+// Result returns what the upload created, or nil unless Close succeeded.
+func (w *Writer) Result() *UploadResult {
+	return w.result
+}
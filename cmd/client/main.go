@@ -3,26 +3,37 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"dfs/internal/auth"
 	pbcoord "dfs/internal/pb/coordinator"
+	"dfs/internal/pki"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
-	options := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	tlsFiles, err := pki.FilesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	creds, err := pki.DialOption(tlsFiles, pki.RoleCoordinator)
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
 	}
 
-	conn, err := grpc.NewClient("localhost:50053", options...)
+	token := grpc.WithPerRPCCredentials(&auth.Credentials{Token: os.Getenv("DFS_TOKEN"), Insecure: !tlsFiles.Enabled()})
+
+	conn, err := grpc.NewClient("localhost:50053", creds, token)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
@@ -33,17 +44,47 @@ func main() {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
-		fmt.Print("Enter command (upload/download/delete/exit): ")
+		fmt.Print("Enter command (upload/append/download/copy/concat/delete/ls/stat/attrs/trash/undelete/purge/versions/restore/versioning/snapshot/snapshots/dropsnapshot/exit): ")
 		command, _ := reader.ReadString('\n')
 		command = strings.TrimSpace(command)
 
 		switch command {
 		case "upload":
 			uploadFile(client, reader)
+		case "append":
+			appendFile(client, reader)
 		case "download":
 			downloadFile(client, reader)
+		case "copy":
+			copyFile(client, reader)
+		case "concat":
+			concatFiles(client, reader)
 		case "delete":
 			deleteFile(client, reader)
+		case "ls":
+			listFiles(client, reader)
+		case "stat":
+			statFile(client, reader)
+		case "attrs":
+			updateAttributes(client, reader)
+		case "snapshot":
+			createSnapshot(client, reader)
+		case "snapshots":
+			listSnapshots(client, reader)
+		case "dropsnapshot":
+			dropSnapshot(client, reader)
+		case "trash":
+			listTrash(client, reader)
+		case "undelete":
+			undeleteFile(client, reader)
+		case "purge":
+			purgeTrash(client, reader)
+		case "versions":
+			listVersions(client, reader)
+		case "restore":
+			restoreVersion(client, reader)
+		case "versioning":
+			setVersioning(client, reader)
 		case "exit":
 			return
 		default:
@@ -69,6 +110,7 @@ func uploadFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 	defer file.Close()
 
 	fileName := filepath.Base(filePath)
+	contentType, metadata, tags := parseAttributes(prompt(reader, "Enter attributes (optional, e.g. type=text/plain tag=release key=value): "))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
@@ -91,8 +133,11 @@ func uploadFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 		}
 
 		err = stream.Send(&pbcoord.UploadFileRequest{
-			FileName:  fileName,
-			ChunkData: buffer[:n],
+			FileName:     fileName,
+			ChunkData:    buffer[:n],
+			ContentType:  contentType,
+			UserMetadata: metadata,
+			Tags:         tags,
 		})
 		if err != nil {
 			log.Printf("Failed to send chunk: %v", err)
@@ -106,11 +151,61 @@ func uploadFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 		return
 	}
 
-	fmt.Printf("File uploaded successfully. File ID: %s\n", resp.FileId)
+	fmt.Printf("File uploaded successfully. File ID: %s, SHA-256: %s\n", resp.FileId, resp.Sha256)
+}
+
+func appendFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	fileID := prompt(reader, "Enter file ID to append to: ")
+	filePath := prompt(reader, "Enter path of the data to append: ")
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("Failed to open file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	stream, err := client.Append(ctx)
+	if err != nil {
+		log.Printf("Failed to start append: %v", err)
+		return
+	}
+
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buffer)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to read file: %v", err)
+			return
+		}
+
+		err = stream.Send(&pbcoord.AppendRequest{
+			FileId: fileID,
+			Data:   buffer[:n],
+		})
+		if err != nil {
+			log.Printf("Failed to send data: %v", err)
+			return
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Printf("Failed to complete append: %v", err)
+		return
+	}
+
+	fmt.Printf("Appended to file %s, now %d bytes (version %s)\n", fileID, resp.FileSize, resp.VersionId)
 }
 
 func downloadFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
-	fmt.Print("Enter file ID: ")
+	fmt.Print("Enter file ID or path: ")
 	fileID, err := reader.ReadString('\n')
 	if err != nil {
 		log.Printf("Failed to read file ID: %v", err)
@@ -137,16 +232,22 @@ func downloadFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 		downloadPath = defaultDownloadDir
 	}
 
+	versionID := prompt(reader, "Enter version ID (press Enter for current): ")
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	stream, err := client.DownloadFile(ctx, &pbcoord.DownloadFileRequest{FileId: fileID})
+	req := &pbcoord.DownloadFileRequest{FileId: fileID, VersionId: versionID}
+	if strings.HasPrefix(fileID, "/") {
+		req = &pbcoord.DownloadFileRequest{Path: fileID, VersionId: versionID}
+	}
+	stream, err := client.DownloadFile(ctx, req)
 	if err != nil {
 		log.Printf("Failed to start download: %v", err)
 		return
 	}
 
-	var fileName string
+	var fileName, wantSHA256 string
 	var fileData []byte
 	for {
 		resp, err := stream.Recv()
@@ -159,10 +260,19 @@ func downloadFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 		}
 		if fileName == "" {
 			fileName = resp.FileName
+			wantSHA256 = resp.Sha256
 		}
 		fileData = append(fileData, resp.ChunkData...)
 	}
 
+	if wantSHA256 != "" {
+		sum := sha256.Sum256(fileData)
+		if got := hex.EncodeToString(sum[:]); got != wantSHA256 {
+			log.Printf("Downloaded data does not match the file: SHA-256 is %s, expected %s", got, wantSHA256)
+			return
+		}
+	}
+
 	fullPath := filepath.Join(downloadPath, fileName)
 	err = os.MkdirAll(filepath.Dir(fullPath), 0755)
 	if err != nil {
@@ -179,6 +289,39 @@ func downloadFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 	fmt.Printf("File downloaded successfully: %s\n", fullPath)
 }
 
+func copyFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	source := prompt(reader, "Enter file ID or path to copy: ")
+	dest := prompt(reader, "Enter destination path: ")
+
+	req := &pbcoord.CopyFileRequest{DestinationPath: dest}
+	if strings.HasPrefix(source, "/") {
+		req.SourcePath = source
+	} else {
+		req.SourceFileId = source
+	}
+	resp, err := client.CopyFile(context.Background(), req)
+	if err != nil {
+		log.Printf("Failed to copy file: %v", err)
+		return
+	}
+	fmt.Printf("File copied successfully. File ID: %s\n", resp.FileId)
+}
+
+func concatFiles(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	sources := strings.Fields(prompt(reader, "Enter file IDs to concatenate, separated by spaces: "))
+	dest := prompt(reader, "Enter destination path: ")
+
+	resp, err := client.ConcatFiles(context.Background(), &pbcoord.ConcatFilesRequest{
+		SourceFileIds:   sources,
+		DestinationPath: dest,
+	})
+	if err != nil {
+		log.Printf("Failed to concatenate files: %v", err)
+		return
+	}
+	fmt.Printf("Files concatenated successfully. File ID: %s, Size: %d bytes\n", resp.FileId, resp.FileSize)
+}
+
 func deleteFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 	fmt.Print("Enter file ID: ")
 	fileID, err := reader.ReadString('\n')
@@ -198,8 +341,272 @@ func deleteFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
 	}
 
 	if resp.Success {
-		fmt.Println("File deleted successfully")
+		fmt.Println("File moved to the trash")
 	} else {
 		fmt.Println("Failed to delete file")
 	}
 }
+
+func listVersions(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	fileID := prompt(reader, "Enter file ID: ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := client.ListVersions(ctx, &pbcoord.ListVersionsRequest{FileId: fileID})
+	if err != nil {
+		log.Printf("Failed to list versions: %v", err)
+		return
+	}
+
+	for _, v := range resp.Versions {
+		marker := " "
+		if v.Current {
+			marker = "*"
+		}
+		fmt.Printf("%s %s  %10d bytes  created %s\n", marker, v.VersionId, v.FileSize, v.CreatedAt)
+	}
+}
+
+func restoreVersion(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	fileID := prompt(reader, "Enter file ID: ")
+	versionID := prompt(reader, "Enter version ID: ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := client.RestoreVersion(ctx, &pbcoord.RestoreVersionRequest{FileId: fileID, VersionId: versionID})
+	if err != nil {
+		log.Printf("Failed to restore version: %v", err)
+		return
+	}
+	fmt.Println("Version restored successfully")
+}
+
+func setVersioning(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	req := &pbcoord.SetVersioningRequest{}
+	req.FileId = prompt(reader, "Enter file ID (press Enter to configure a directory): ")
+	if req.FileId == "" {
+		req.Path = prompt(reader, "Enter directory path: ")
+	}
+	switch prompt(reader, "Enable versioning? (yes/no/inherit): ") {
+	case "yes":
+		req.Enabled = true
+		fmt.Sscan(prompt(reader, "Versions to keep (0 for no limit): "), &req.KeepVersions)
+		fmt.Sscan(prompt(reader, "Days to keep old versions (0 for no limit): "), &req.KeepDays)
+	case "inherit":
+		req.Inherit = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := client.SetVersioning(ctx, req); err != nil {
+		log.Printf("Failed to set versioning: %v", err)
+		return
+	}
+	fmt.Println("Versioning policy updated")
+}
+
+func listFiles(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	prefix := prompt(reader, "Enter path prefix (press Enter for all): ")
+	contentType, metadata, tags := parseAttributes(prompt(reader, "Enter filters (optional, e.g. tag=release type=image/ key=value): "))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := client.ListFiles(ctx, &pbcoord.ListFilesRequest{
+		PathPrefix:   prefix,
+		Tags:         tags,
+		UserMetadata: metadata,
+		ContentType:  contentType,
+	})
+	if err != nil {
+		log.Printf("Failed to list files: %v", err)
+		return
+	}
+
+	for _, f := range resp.Files {
+		fmt.Printf("%s  %10d bytes  %s\n", f.FileId, f.FileSize, f.Path)
+	}
+}
+
+func statFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	file := prompt(reader, "Enter file ID or path: ")
+
+	req := &pbcoord.StatFileRequest{}
+	if strings.HasPrefix(file, "/") {
+		req.Path = file
+	} else {
+		req.FileId = file
+	}
+	resp, err := client.StatFile(context.Background(), req)
+	if err != nil {
+		log.Printf("Failed to stat file: %v", err)
+		return
+	}
+	printFileInfo(resp.File)
+}
+
+func updateAttributes(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	fileID := prompt(reader, "Enter file ID: ")
+	contentType, set, addTags := parseAttributes(prompt(reader, "Enter attributes to set (e.g. type=text/plain tag=release key=value): "))
+	_, unset, removeTags := parseAttributes(prompt(reader, "Enter attributes to remove (e.g. tag=draft key=): "))
+
+	req := &pbcoord.UpdateFileAttributesRequest{
+		FileId:          fileID,
+		ContentType:     contentType,
+		SetUserMetadata: set,
+		AddTags:         addTags,
+		RemoveTags:      removeTags,
+	}
+	for k := range unset {
+		req.DeleteUserMetadata = append(req.DeleteUserMetadata, k)
+	}
+	resp, err := client.UpdateFileAttributes(context.Background(), req)
+	if err != nil {
+		log.Printf("Failed to update attributes: %v", err)
+		return
+	}
+	printFileInfo(resp.File)
+}
+
+func printFileInfo(f *pbcoord.FileInfo) {
+	fmt.Printf("File ID:      %s\n", f.FileId)
+	fmt.Printf("Path:         %s\n", f.Path)
+	fmt.Printf("Size:         %d bytes\n", f.FileSize)
+	fmt.Printf("Content type: %s\n", f.ContentType)
+	fmt.Printf("Version:      %s\n", f.VersionId)
+	fmt.Printf("SHA-256:      %s\n", f.Sha256)
+	fmt.Printf("MD5:          %s\n", f.Md5)
+	fmt.Printf("Created:      %s\n", f.CreatedAt)
+	fmt.Printf("Updated:      %s\n", f.UpdatedAt)
+	fmt.Printf("Tags:         %s\n", strings.Join(f.Tags, ", "))
+	keys := make([]string, 0, len(f.UserMetadata))
+	for k := range f.UserMetadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %s = %s\n", k, f.UserMetadata[k])
+	}
+}
+
+// parseAttributes splits space-separated "type=...", "tag=..." and
+// "key=value" words into a content type, user metadata and tags.
+func parseAttributes(line string) (string, map[string]string, []string) {
+	var contentType string
+	var metadata map[string]string
+	var tags []string
+	for _, word := range strings.Fields(line) {
+		k, v, _ := strings.Cut(word, "=")
+		switch k {
+		case "type":
+			contentType = v
+		case "tag":
+			tags = append(tags, v)
+		default:
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[k] = v
+		}
+	}
+	return contentType, metadata, tags
+}
+
+func createSnapshot(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	dir := prompt(reader, "Enter directory to snapshot: ")
+	id := prompt(reader, "Enter snapshot name (press Enter to generate one): ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := client.CreateSnapshot(ctx, &pbcoord.CreateSnapshotRequest{Path: dir, Id: id})
+	if err != nil {
+		log.Printf("Failed to create snapshot: %v", err)
+		return
+	}
+	fmt.Printf("Snapshot %s created with %d files; browse it under /.snapshots/%s\n", resp.Snapshot.Id, resp.Snapshot.FileCount, resp.Snapshot.Id)
+}
+
+func listSnapshots(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := client.ListSnapshots(ctx, &pbcoord.ListSnapshotsRequest{})
+	if err != nil {
+		log.Printf("Failed to list snapshots: %v", err)
+		return
+	}
+
+	for _, snap := range resp.Snapshots {
+		fmt.Printf("%s  %s  %d files, %d bytes, created %s\n", snap.Id, snap.Path, snap.FileCount, snap.TotalSize, snap.CreatedAt)
+	}
+}
+
+func dropSnapshot(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	id := prompt(reader, "Enter snapshot ID: ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err := client.DeleteSnapshot(ctx, &pbcoord.DeleteSnapshotRequest{Id: id}); err != nil {
+		log.Printf("Failed to delete snapshot: %v", err)
+		return
+	}
+	fmt.Println("Snapshot deleted")
+}
+
+func listTrash(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	prefix := prompt(reader, "Enter path prefix (press Enter for all): ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := client.ListTrash(ctx, &pbcoord.ListTrashRequest{PathPrefix: prefix})
+	if err != nil {
+		log.Printf("Failed to list trash: %v", err)
+		return
+	}
+
+	for _, e := range resp.Entries {
+		fmt.Printf("%s  %s  deleted %s, purged after %s\n", e.FileId, e.Path, e.DeletedAt, e.PurgeAt)
+	}
+}
+
+func undeleteFile(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	fileID := prompt(reader, "Enter file ID: ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := client.Restore(ctx, &pbcoord.RestoreRequest{FileId: fileID}); err != nil {
+		log.Printf("Failed to restore file: %v", err)
+		return
+	}
+	fmt.Println("File restored successfully")
+}
+
+func purgeTrash(client pbcoord.CoordinatorClient, reader *bufio.Reader) {
+	fileID := prompt(reader, "Enter file ID (press Enter to empty the whole trash): ")
+	if fileID == "" && prompt(reader, "Permanently delete everything in the trash? (yes/no): ") != "yes" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	resp, err := client.PurgeTrash(ctx, &pbcoord.PurgeTrashRequest{FileId: fileID})
+	if err != nil {
+		log.Printf("Failed to purge trash: %v", err)
+		return
+	}
+	fmt.Printf("Purged %d files\n", resp.Purged)
+}
+
+func prompt(reader *bufio.Reader, text string) string {
+	fmt.Print(text)
+	line, _ := reader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
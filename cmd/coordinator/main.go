@@ -1,12 +1,16 @@
 package main
 
 import (
+	"dfs/internal/auth"
 	"dfs/internal/coordinator"
 	pbcoord "dfs/internal/pb/coordinator"
+	"dfs/internal/pki"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 )
@@ -14,10 +18,11 @@ import (
 func main() {
     log.Println("Starting Coordinator")
 
-    metadataAddr := os.Getenv("DFS_METADATA_ADDR")
-    if metadataAddr == "" {
-        metadataAddr = "metadataservice:50052"
+    metadataAddrsStr := os.Getenv("DFS_METADATA_ADDR")
+    if metadataAddrsStr == "" {
+        metadataAddrsStr = "metadataservice:50052"
     }
+    metadataAddrs := strings.Split(metadataAddrsStr, ",")
 
     storageAddrsStr := os.Getenv("DFS_STORAGE_ADDRS")
     if storageAddrsStr == "" {
@@ -25,7 +30,63 @@ func main() {
     }
     storageAddrs := strings.Split(storageAddrsStr, ",")
 
-    server, err := coordinator.NewServer(metadataAddr, storageAddrs)
+    cacheSize := 0
+    if v := os.Getenv("DFS_COORDINATOR_CACHE_SIZE"); v != "" {
+        var err error
+        cacheSize, err = strconv.Atoi(v)
+        if err != nil {
+            log.Fatalf("Invalid DFS_COORDINATOR_CACHE_SIZE: %v", err)
+        }
+    }
+
+    var retentionInterval time.Duration
+    if v := os.Getenv("DFS_COORDINATOR_RETENTION_INTERVAL"); v != "" {
+        var err error
+        retentionInterval, err = time.ParseDuration(v)
+        if err != nil {
+            log.Fatalf("Invalid DFS_COORDINATOR_RETENTION_INTERVAL: %v", err)
+        }
+    }
+
+    var trashRetention time.Duration
+    if v := os.Getenv("DFS_COORDINATOR_TRASH_RETENTION"); v != "" {
+        var err error
+        trashRetention, err = time.ParseDuration(v)
+        if err != nil {
+            log.Fatalf("Invalid DFS_COORDINATOR_TRASH_RETENTION: %v", err)
+        }
+    }
+
+    tlsFiles, err := pki.FilesFromEnv()
+    if err != nil {
+        log.Fatalf("Invalid TLS configuration: %v", err)
+    }
+    if !tlsFiles.Enabled() {
+        log.Println("DFS_TLS_* is not set; connections are not encrypted or authenticated")
+    }
+
+    // Clients authenticate with API tokens signed with the secret in
+    // DFS_AUTH_SECRET_FILE, which dfs token creates.
+    var verifier *auth.Verifier
+    if file := os.Getenv("DFS_AUTH_SECRET_FILE"); file != "" {
+        secret, err := auth.ReadSecret(file)
+        if err != nil {
+            log.Fatalf("Invalid DFS_AUTH_SECRET_FILE: %v", err)
+        }
+        verifier = auth.NewVerifier(secret)
+    } else {
+        log.Println("DFS_AUTH_SECRET_FILE is not set; clients are not authenticated and file permissions are not enforced")
+    }
+
+    server, err := coordinator.NewServer(coordinator.Config{
+        MetadataAddrs:     metadataAddrs,
+        StorageAddrs:      storageAddrs,
+        CacheSize:         cacheSize,
+        RetentionInterval: retentionInterval,
+        TrashRetention:    trashRetention,
+        TLS:               tlsFiles,
+        Authenticate:      verifier != nil,
+    })
     if err != nil {
         log.Fatalf("Failed to create coordinator server: %v", err)
     }
@@ -40,7 +101,14 @@ func main() {
         log.Fatalf("Failed to listen: %v", err)
     }
 
-    s := grpc.NewServer()
+    opts, err := pki.ServerOptions(tlsFiles, pki.RoleClient, pki.RoleAdmin)
+    if err != nil {
+        log.Fatalf("Failed to set up TLS: %v", err)
+    }
+    if verifier != nil {
+        opts = append(opts, verifier.ServerOptions()...)
+    }
+    s := grpc.NewServer(opts...)
     pbcoord.RegisterCoordinatorServer(s, server)
 
     log.Printf("Coordinator is listening on :%s", port)
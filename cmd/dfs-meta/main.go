@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"dfs/internal/metadataservice"
+	pb "dfs/internal/pb/metadata"
+	"dfs/internal/pki"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const usage = `usage: dfs-meta <command> [flags]
+
+commands:
+  import   copy every record from a JSON metadata directory into a bolt database or WAL
+  members  list, add or remove members of a replicated metadata service
+  fsck     check a stopped metadata store for corruption and inconsistencies
+  watch    stream namespace change events as JSON lines
+
+members and watch use the admin certificate named by DFS_TLS_CA,
+DFS_TLS_CERT and DFS_TLS_KEY when the metadata service requires TLS.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "import":
+		runImport(os.Args[2:])
+	case "members":
+		runMembers(os.Args[2:])
+	case "fsck":
+		runFsck(os.Args[2:])
+	case "watch":
+		runWatch(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	from := fs.String("from", "/tmp/dfs-metadata", "JSON metadata directory to read")
+	engine := fs.String("engine", metadataservice.EngineBolt, "engine to import into: bolt or wal")
+	to := fs.String("to", "", "bolt database file or WAL directory to write (default: metadata.db in the JSON directory, or its wal subdirectory)")
+	fs.Parse(args)
+
+	src, err := metadataservice.NewDiskStore(*from)
+	if err != nil {
+		log.Fatalf("Failed to open JSON store: %v", err)
+	}
+
+	var dst metadataservice.Store
+	// finish makes the imported store durable and closes it.
+	var finish func() error
+	switch *engine {
+	case metadataservice.EngineBolt:
+		if *to == "" {
+			*to = filepath.Join(*from, metadataservice.BoltFileName)
+		}
+		bolt, err := metadataservice.NewBoltStore(*to)
+		if err != nil {
+			log.Fatalf("Failed to open bolt store: %v", err)
+		}
+		dst, finish = bolt, bolt.Close
+	case metadataservice.EngineWAL:
+		// The WAL store writes snapshot.json, which the JSON store would
+		// take for a file record, so it must not share the directory.
+		if *to == "" {
+			*to = filepath.Join(*from, "wal")
+		}
+		if sameDir(*to, *from) {
+			log.Fatalf("The WAL directory must differ from the JSON directory %s", *from)
+		}
+		wal, err := metadataservice.NewWALStore(*to, 0)
+		if err != nil {
+			log.Fatalf("Failed to open WAL store: %v", err)
+		}
+		dst = wal
+		finish = func() error {
+			err := wal.Snapshot()
+			if closeErr := wal.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+	default:
+		log.Fatalf("Cannot import into engine %q", *engine)
+	}
+
+	records, dirs, snaps, err := importStore(src, dst)
+	if finishErr := finish(); err == nil && finishErr != nil {
+		err = fmt.Errorf("failed to write %s: %w", *to, finishErr)
+	}
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+	log.Printf("Imported %d records, %d directories and %d snapshots from %s into %s", records, dirs, snaps, *from, *to)
+}
+
+// importStore copies every file record, directory and snapshot from src
+// into dst and returns how many of each it copied.
+func importStore(src, dst metadataservice.Store) (records, dirs, snaps int, err error) {
+	files, err := src.List()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to list JSON metadata: %w", err)
+	}
+	for _, metadata := range files {
+		if err := dst.Save(metadata); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to import file %s: %w", metadata.FileID, err)
+		}
+	}
+	directories, err := src.ListDirectories()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to list directory settings: %w", err)
+	}
+	for _, dir := range directories {
+		if err := dst.SaveDirectory(dir); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to import directory %s: %w", dir.Path, err)
+		}
+	}
+	snapshots, err := src.ListSnapshots()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, snap := range snapshots {
+		if err := dst.SaveSnapshot(snap); err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to import snapshot %s: %w", snap.ID, err)
+		}
+	}
+	return len(files), len(directories), len(snapshots), nil
+}
+
+// sameDir reports whether a and b name the same directory.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	if absA == absB {
+		return true
+	}
+	infoA, errA := os.Stat(absA)
+	infoB, errB := os.Stat(absB)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+func runMembers(args []string) {
+	fs := flag.NewFlagSet("members", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:50052", "gRPC address of the leader")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dfs-meta members [-addr host:port] list | add <id> <raft-addr> | remove <id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	conn, err := dial(*addr)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewMetadataServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	switch {
+	case fs.Arg(0) == "list" || fs.NArg() == 0:
+		resp, err := client.ListMembers(ctx, &pb.ListMembersRequest{})
+		if err != nil {
+			log.Fatalf("Failed to list members: %v", err)
+		}
+		for _, m := range resp.Members {
+			role := "follower"
+			if m.Leader {
+				role = "leader"
+			}
+			if !m.Voter {
+				role += " (non-voter)"
+			}
+			fmt.Printf("%s\t%s\t%s\n", m.Id, m.RaftAddr, role)
+		}
+	case fs.Arg(0) == "add" && fs.NArg() == 3:
+		if _, err := client.AddMember(ctx, &pb.AddMemberRequest{Id: fs.Arg(1), RaftAddr: fs.Arg(2)}); err != nil {
+			log.Fatalf("Failed to add member: %v", err)
+		}
+		log.Printf("Added member %s", fs.Arg(1))
+	case fs.Arg(0) == "remove" && fs.NArg() == 2:
+		if _, err := client.RemoveMember(ctx, &pb.RemoveMemberRequest{Id: fs.Arg(1)}); err != nil {
+			log.Fatalf("Failed to remove member: %v", err)
+		}
+		log.Printf("Removed member %s", fs.Arg(1))
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func runFsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	dir := fs.String("dir", "/tmp/dfs-metadata", "metadata directory to check")
+	engine := fs.String("engine", metadataservice.EngineJSON, "engine the directory was written by: json, bolt or wal")
+	fs.Parse(args)
+
+	report, err := metadataservice.Fsck(*engine, *dir)
+	if err != nil {
+		log.Fatalf("fsck failed: %v", err)
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d records checked, %d problems found\n", report.Records, len(report.Problems))
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:50052", "gRPC address of the metadata service")
+	prefix := fs.String("prefix", "", "only show events for paths with this prefix")
+	after := fs.Uint64("after", 0, "resume after this sequence number (default: new events only)")
+	fs.Parse(args)
+
+	conn, err := dial(*addr)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	stream, err := pb.NewMetadataServiceClient(conn).Watch(context.Background(), &pb.WatchRequest{
+		AfterSequence: *after,
+		PathPrefix:    *prefix,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start watch: %v", err)
+	}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("Watch failed: %v", err)
+		}
+		line, err := protojson.Marshal(event)
+		if err != nil {
+			log.Fatalf("Failed to encode event: %v", err)
+		}
+		fmt.Println(string(line))
+	}
+}
+
+// dial connects to the metadata service, with the certificate from the
+// DFS_TLS_* variables if they are set.
+func dial(addr string) (*grpc.ClientConn, error) {
+	files, err := pki.FilesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	creds, err := pki.DialOption(files, pki.RoleMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.NewClient(addr, creds)
+}
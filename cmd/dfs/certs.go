@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dfs/internal/pki"
+)
+
+const certsUsage = `usage: dfs certs <command> [flags]
+
+commands:
+  init   create a cluster CA in ca.pem and ca-key.pem
+  issue  issue a certificate signed by the CA, as <name>.pem and <name>-key.pem
+  dev    create a CA if there is none and issue every certificate a local or
+         docker-compose cluster needs
+
+Services read their certificate from $DFS_TLS_CA, $DFS_TLS_CERT and
+$DFS_TLS_KEY. Each certificate has a role, and services only accept peers
+with the roles that may call them: storage nodes accept the coordinator, the
+metadata service accepts the coordinator and admin, and the coordinator
+accepts client and admin.
+`
+
+// devCerts are the certificates of the docker-compose cluster, named after
+// its services.
+var devCerts = []pki.Request{
+	{Name: "metadataservice", Role: pki.RoleMetadata},
+	{Name: "storagenode1", Role: pki.RoleStorageNode},
+	{Name: "storagenode2", Role: pki.RoleStorageNode},
+	{Name: "storagenode3", Role: pki.RoleStorageNode},
+	{Name: "coordinator", Role: pki.RoleCoordinator},
+	{Name: "s3gateway", Role: pki.RoleClient},
+	{Name: "httpgateway", Role: pki.RoleClient},
+	{Name: "client", Role: pki.RoleClient},
+	{Name: "admin", Role: pki.RoleAdmin},
+}
+
+func runCerts(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, certsUsage)
+		os.Exit(exitUsage)
+	}
+	switch args[0] {
+	case "init":
+		return runCertsInit(args[1:])
+	case "issue":
+		return runCertsIssue(args[1:])
+	case "dev":
+		return runCertsDev(args[1:])
+	}
+	fmt.Fprint(os.Stderr, certsUsage)
+	os.Exit(exitUsage)
+	return nil
+}
+
+func certsFlags(name, args string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("certs "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: dfs certs %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "certs", "directory of the CA and certificates")
+	return fs, dir
+}
+
+func caFiles(dir string) (string, string) {
+	return filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+}
+
+func certFiles(dir, name string) (string, string) {
+	return filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+}
+
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func runCertsInit(args []string) error {
+	fs, dir := certsFlags("init", "")
+	name := fs.String("name", "DFS cluster CA", "common name of the CA")
+	validity := fs.Duration("validity", 10*365*24*time.Hour, "how long the CA is valid")
+	force := fs.Bool("force", false, "replace an existing CA; certificates it issued stop being trusted")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return &usageError{fs, "unexpected arguments"}
+	}
+	_, err := initCA(*dir, *name, *validity, *force)
+	return err
+}
+
+// initCA creates a CA in dir. Unless force is set it fails if one exists.
+func initCA(dir, name string, validity time.Duration, force bool) (*pki.CA, error) {
+	certFile, keyFile := caFiles(dir)
+	if found, err := exists(certFile); err != nil {
+		return nil, err
+	} else if found && !force {
+		return nil, fmt.Errorf("%s already exists; use -force to replace it", certFile)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ca, err := pki.NewCA(name, validity)
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.Save(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Created CA %s; keep %s secret\n", certFile, keyFile)
+	return ca, nil
+}
+
+func runCertsIssue(args []string) error {
+	fs, dir := certsFlags("issue", "")
+	name := fs.String("name", "", "common name of the certificate, which also names its files")
+	role := fs.String("role", "", "role of the certificate: "+strings.Join(pki.Roles, ", "))
+	hosts := fs.String("host", "", "comma-separated DNS names and IP addresses the holder serves on")
+	validity := fs.Duration("validity", 365*24*time.Hour, "how long the certificate is valid")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return &usageError{fs, "unexpected arguments"}
+	}
+	if *name == "" || *role == "" {
+		return &usageError{fs, "-name and -role are required"}
+	}
+	ca, err := pki.LoadCA(caFiles(*dir))
+	if err != nil {
+		return err
+	}
+	return issue(ca, *dir, pki.Request{Name: *name, Role: *role, Hosts: splitList(*hosts), Validity: *validity})
+}
+
+func issue(ca *pki.CA, dir string, req pki.Request) error {
+	certFile, keyFile := certFiles(dir, req.Name)
+	if err := ca.IssueFiles(req, certFile, keyFile); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Issued %s certificate %s\n", req.Role, certFile)
+	return nil
+}
+
+func runCertsDev(args []string) error {
+	fs, dir := certsFlags("dev", "")
+	hosts := fs.String("host", "", "comma-separated extra DNS names and IP addresses for the service certificates")
+	force := fs.Bool("force", false, "issue certificates again even if they exist")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return &usageError{fs, "unexpected arguments"}
+	}
+
+	var ca *pki.CA
+	certFile, keyFile := caFiles(*dir)
+	found, err := exists(certFile)
+	if err != nil {
+		return err
+	}
+	if found {
+		ca, err = pki.LoadCA(certFile, keyFile)
+	} else {
+		ca, err = initCA(*dir, "DFS development CA", 10*365*24*time.Hour, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Service certificates are valid for the compose service name and for
+	// the local host, so the same files serve a cluster run by hand.
+	for _, req := range devCerts {
+		if !*force {
+			certFile, _ := certFiles(*dir, req.Name)
+			if found, err := exists(certFile); err != nil {
+				return err
+			} else if found {
+				continue
+			}
+		}
+		req.Validity = 365 * 24 * time.Hour
+		if req.Role != pki.RoleClient && req.Role != pki.RoleAdmin {
+			req.Hosts = append([]string{req.Name, "localhost", "127.0.0.1", "::1"}, splitList(*hosts)...)
+		}
+		if err := issue(ca, *dir, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pbcoord "dfs/internal/pb/coordinator"
+)
+
+// uploadBlockSize is how much of the input each upload message carries.
+const uploadBlockSize = 64 * 1024
+
+func runPut(c *cli, args []string) error {
+	fs := c.newFlags("put", "<local file | -> [remote path]")
+	contentType := fs.String("type", "", "content type (detected from the contents if not given)")
+	var tags stringList
+	fs.Var(&tags, "tag", "tag to attach; may be repeated")
+	metadata := keyValues{}
+	fs.Var(metadata, "meta", "user metadata as key=value; may be repeated")
+	var mode modeFlag
+	fs.Var(&mode, "mode", "octal permission bits of a new file, such as 640 (default 644)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return &usageError{fs, "expected a local file and an optional remote path"}
+	}
+
+	local, dest := fs.Arg(0), fs.Arg(1)
+	switch {
+	case dest == "" && local == "-":
+		return &usageError{fs, "a remote path is required when reading stdin"}
+	case dest == "":
+		dest = "/" + filepath.Base(local)
+	case strings.HasSuffix(dest, "/") && local != "-":
+		dest += filepath.Base(local)
+	}
+	if !strings.HasPrefix(dest, "/") {
+		return &usageError{fs, "remote path must start with /"}
+	}
+
+	in := os.Stdin
+	if local != "-" {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	resp, err := c.upload(in, dest, &pbcoord.UploadFileRequest{
+		ContentType:  *contentType,
+		Tags:         tags,
+		UserMetadata: metadata,
+		Mode:         uint32(mode),
+	})
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(resp)
+	}
+	fmt.Println(resp.FileId)
+	return nil
+}
+
+// upload streams in to a new file, or a new version of the file, at dest.
+// The attributes of the file are taken from attrs.
+func (c *cli) upload(in io.Reader, dest string, attrs *pbcoord.UploadFileRequest) (*pbcoord.UploadFileResponse, error) {
+	// Cancelling the stream on a read error keeps a partial file from being
+	// saved.
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	stream, err := c.client.UploadFile(ctx)
+	if err != nil {
+		return nil, grpcError("failed to start upload", err)
+	}
+
+	buf := make([]byte, uploadBlockSize)
+	for first := true; ; first = false {
+		n, readErr := io.ReadFull(in, buf)
+		if n > 0 || first {
+			req := &pbcoord.UploadFileRequest{ChunkData: buf[:n]}
+			if first {
+				req.FileName = path.Base(dest)
+				req.Path = dest
+				req.ContentType = attrs.GetContentType()
+				req.Tags = attrs.GetTags()
+				req.UserMetadata = attrs.GetUserMetadata()
+				req.Mode = attrs.GetMode()
+			}
+			// A failed send means the server ended the call; its
+			// status is returned by CloseAndRecv.
+			if err := stream.Send(req); err != nil {
+				break
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read input: %w", readErr)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, grpcError("upload failed", err)
+	}
+	return resp, nil
+}
+
+func runGet(c *cli, args []string) error {
+	fs := c.newFlags("get", "<remote file> [local path | -]")
+	version := fs.String("version", "", "download this version instead of the current one")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return &usageError{fs, "expected a remote file and an optional local path"}
+	}
+
+	src, dest := fs.Arg(0), fs.Arg(1)
+	if dest == "-" {
+		return c.download(src, *version, func(string) (io.Writer, error) { return os.Stdout, nil })
+	}
+
+	_, err := c.downloadFile(src, *version, dest)
+	return err
+}
+
+// downloadFile downloads src to dest, or to a file named after src in the
+// current directory or in dest if it is a directory, and returns the path of
+// the local file. The file is written under a temporary name and only renamed
+// into place once its digest has been checked.
+func (c *cli) downloadFile(src, version, dest string) (string, error) {
+	var tmp *os.File
+	var target string
+	err := c.download(src, version, func(fileName string) (io.Writer, error) {
+		if fileName == "" {
+			fileName = path.Base(src)
+		}
+		target = dest
+		if target == "" {
+			target = fileName
+		} else if info, err := os.Stat(target); err == nil && info.IsDir() {
+			target = filepath.Join(target, fileName)
+		}
+		var err error
+		tmp, err = os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+		return tmp, err
+	})
+	if tmp != nil {
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), target)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	return target, err
+}
+
+func runCat(c *cli, args []string) error {
+	fs := c.newFlags("cat", "<remote file>...")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return &usageError{fs, "expected at least one remote file"}
+	}
+	for _, src := range fs.Args() {
+		err := c.download(src, "", func(string) (io.Writer, error) { return os.Stdout, nil })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// download streams a remote file into the writer that open returns for the
+// file's name, and checks the result against the file's SHA-256 digest.
+func (c *cli) download(src, version string, open func(fileName string) (io.Writer, error)) error {
+	fileID, filePath := remote(src)
+	stream, err := c.client.DownloadFile(c.ctx, &pbcoord.DownloadFileRequest{
+		FileId:    fileID,
+		Path:      filePath,
+		VersionId: version,
+	})
+	if err != nil {
+		return grpcError("download failed", err)
+	}
+
+	var out io.Writer
+	var want string
+	hash := sha256.New()
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return grpcError("download failed", err)
+		}
+		if out == nil {
+			w, err := open(resp.FileName)
+			if err != nil {
+				return err
+			}
+			out = io.MultiWriter(w, hash)
+			want = resp.Sha256
+		}
+		if _, err := out.Write(resp.ChunkData); err != nil {
+			return err
+		}
+	}
+	if out == nil {
+		// The file has no chunks; create it empty.
+		if _, err := open(""); err != nil {
+			return err
+		}
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); want != "" && got != want {
+		return fmt.Errorf("%s: downloaded data has SHA-256 %s, expected %s", src, got, want)
+	}
+	return nil
+}
+
+func runRm(c *cli, args []string) error {
+	fs := c.newFlags("rm", "<remote file>...")
+	permanent := fs.Bool("permanent", false, "delete right away instead of moving to the trash")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return &usageError{fs, "expected at least one remote file"}
+	}
+	for _, target := range fs.Args() {
+		fileID, filePath := remote(target)
+		_, err := c.client.DeleteFile(c.ctx, &pbcoord.DeleteFileRequest{FileId: fileID, Path: filePath, Permanent: *permanent})
+		if err != nil {
+			return grpcError(target, err)
+		}
+	}
+	return nil
+}
+
+func runLs(c *cli, args []string) error {
+	fs := c.newFlags("ls", "[path prefix]")
+	long := fs.Bool("l", false, "also show file ID, permissions, size and modification time")
+	contentType := fs.String("type", "", "only list files with this content type, or any subtype if it ends in /")
+	var tags stringList
+	fs.Var(&tags, "tag", "only list files with this tag; may be repeated")
+	metadata := keyValues{}
+	fs.Var(metadata, "meta", "only list files with this key=value user metadata; may be repeated")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return &usageError{fs, "expected at most one path prefix"}
+	}
+
+	resp, err := c.client.ListFiles(c.ctx, &pbcoord.ListFilesRequest{
+		PathPrefix:   fs.Arg(0),
+		Tags:         tags,
+		UserMetadata: metadata,
+		ContentType:  *contentType,
+	})
+	if err != nil {
+		return grpcError("list failed", err)
+	}
+	for _, f := range resp.Files {
+		switch {
+		case c.json:
+			if err := printJSON(f); err != nil {
+				return err
+			}
+		case *long:
+			fmt.Printf("%s  %s  %12d  %s  %s\n", f.FileId, permissions(f), f.FileSize, f.UpdatedAt, f.Path)
+		default:
+			fmt.Println(f.Path)
+		}
+	}
+	return nil
+}
+
+func runStat(c *cli, args []string) error {
+	fs := c.newFlags("stat", "<remote file>...")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return &usageError{fs, "expected at least one remote file"}
+	}
+	for i, target := range fs.Args() {
+		fileID, filePath := remote(target)
+		resp, err := c.client.StatFile(c.ctx, &pbcoord.StatFileRequest{FileId: fileID, Path: filePath})
+		if err != nil {
+			return grpcError(target, err)
+		}
+		if c.json {
+			if err := printJSON(resp.File); err != nil {
+				return err
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printFileInfo(resp.File)
+	}
+	return nil
+}
+
+func runMv(c *cli, args []string) error {
+	fs := c.newFlags("mv", "<remote file> <remote path>")
+	overwrite := fs.Bool("f", false, "replace a file already at the destination")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return &usageError{fs, "expected a remote file and a destination path"}
+	}
+
+	fileID, filePath := remote(fs.Arg(0))
+	dest := fs.Arg(1)
+	if !strings.HasPrefix(dest, "/") {
+		return &usageError{fs, "destination path must start with /"}
+	}
+	if strings.HasSuffix(dest, "/") {
+		resp, err := c.client.StatFile(c.ctx, &pbcoord.StatFileRequest{FileId: fileID, Path: filePath})
+		if err != nil {
+			return grpcError(fs.Arg(0), err)
+		}
+		dest += resp.File.FileName
+	}
+
+	resp, err := c.client.MoveFile(c.ctx, &pbcoord.MoveFileRequest{
+		FileId:          fileID,
+		Path:            filePath,
+		DestinationPath: dest,
+		Overwrite:       *overwrite,
+	})
+	if err != nil {
+		return grpcError("move failed", err)
+	}
+	if c.json {
+		return printJSON(resp.File)
+	}
+	return nil
+}
+
+func printFileInfo(f *pbcoord.FileInfo) {
+	fmt.Printf("File ID:      %s\n", f.FileId)
+	fmt.Printf("Path:         %s\n", f.Path)
+	fmt.Printf("Size:         %d bytes\n", f.FileSize)
+	fmt.Printf("Content type: %s\n", f.ContentType)
+	fmt.Printf("Version:      %s\n", f.VersionId)
+	fmt.Printf("SHA-256:      %s\n", f.Sha256)
+	fmt.Printf("MD5:          %s\n", f.Md5)
+	fmt.Printf("Created:      %s\n", f.CreatedAt)
+	fmt.Printf("Updated:      %s\n", f.UpdatedAt)
+	if f.Owner != "" {
+		fmt.Printf("Permissions:  %s\n", strings.TrimSpace(permissions(f)))
+	}
+	fmt.Printf("Tags:         %s\n", strings.Join(f.Tags, ", "))
+	keys := make([]string, 0, len(f.UserMetadata))
+	for k := range f.UserMetadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %s = %s\n", k, f.UserMetadata[k])
+	}
+}
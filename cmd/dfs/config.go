@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"dfs/internal/pki"
+)
+
+const defaultCoordinator = "localhost:50053"
+
+// config holds the settings that can be kept in the config file, a JSON
+// object such as {"coordinator": "dfs.example.com:50053"}.
+type config struct {
+	Coordinator string `json:"coordinator"`
+	// TLS names the certificate files for clusters that require mutual
+	// TLS, as in {"tls": {"ca": "ca.pem", "cert": "client.pem", "key":
+	// "client-key.pem"}}.
+	TLS struct {
+		CA   string `json:"ca"`
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+	} `json:"tls"`
+	// Token is the API token for clusters that require authentication.
+	Token string `json:"token"`
+}
+
+// loadConfig reads the config file at path, or at $DFS_CONFIG or the default
+// location if path is empty. Only an explicitly named file has to exist.
+func loadConfig(path string) (*config, error) {
+	explicit := true
+	if path == "" {
+		path = os.Getenv("DFS_CONFIG")
+	}
+	if path == "" {
+		explicit = false
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return &config{}, nil
+		}
+		path = filepath.Join(dir, "dfs", "config.json")
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// coordinatorAddr returns the coordinator address to use, preferring flag,
+// then $DFS_COORDINATOR, then the config file.
+func (cfg *config) coordinatorAddr(flag string) string {
+	for _, addr := range []string{flag, os.Getenv("DFS_COORDINATOR"), cfg.Coordinator} {
+		if addr != "" {
+			return addr
+		}
+	}
+	return defaultCoordinator
+}
+
+// tlsFiles returns the certificate files to use: those from $DFS_TLS_CA,
+// $DFS_TLS_CERT and $DFS_TLS_KEY if any is set, then the config file's.
+func (cfg *config) tlsFiles() (pki.Files, error) {
+	files, err := pki.FilesFromEnv()
+	if err != nil || files.Enabled() {
+		return files, err
+	}
+	files = pki.Files{CA: cfg.TLS.CA, Cert: cfg.TLS.Cert, Key: cfg.TLS.Key}
+	if files.Enabled() && (files.CA == "" || files.Cert == "" || files.Key == "") {
+		return pki.Files{}, errors.New("the config file must set tls.ca, tls.cert and tls.key together")
+	}
+	return files, nil
+}
+
+// token returns the API token to use: $DFS_TOKEN, then the config file's.
+func (cfg *config) token() string {
+	if token := os.Getenv("DFS_TOKEN"); token != "" {
+		return token
+	}
+	return cfg.Token
+}
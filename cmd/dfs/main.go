@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"strings"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	"dfs/internal/pki"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const usage = `usage: dfs [-coordinator host:port] [-config file] [-json] <command> [flags] [args]
+
+commands:
+  put    upload a local file, or stdin with "-"
+  get    download a file to a local file, or stdout with "-"
+  cat    write files to stdout
+  rm     delete files
+  ls     list files below a path prefix
+  stat   show the attributes of files
+  mv     move a file to a new path
+  cp     copy files or, with -r, directories into, out of or within the DFS
+  sync   make a directory match another, transferring only what differs
+  mount  mount the DFS as a local file system with FUSE
+  chmod  change the permission bits of files or directories
+  chown  change the owner and group of files or directories
+  certs  create a cluster CA and issue certificates for mutual TLS
+  token  issue API tokens for a cluster that requires authentication
+
+Remote files are given by path ("/dir/file") or by file ID. cp and sync take
+local paths as they are and remote ones prefixed with "dfs:", as in
+"dfs cp -r build dfs:/builds/42".
+
+The coordinator address is taken from -coordinator, then $DFS_COORDINATOR,
+then the config file, and defaults to localhost:50053. The config file is
+-config, then $DFS_CONFIG, then dfs/config.json in the user config directory.
+If the cluster requires mutual TLS, the CA certificate and the client
+certificate and key are taken from $DFS_TLS_CA, $DFS_TLS_CERT and
+$DFS_TLS_KEY, or from the config file. If it requires authentication, the
+API token is taken from $DFS_TOKEN or the config file.
+
+exit status:
+  0  success
+  1  other error
+  2  invalid usage
+  3  file not found
+  4  conflict: the file exists, is leased or changed concurrently
+  5  coordinator unavailable
+`
+
+const (
+	exitError       = 1
+	exitUsage       = 2
+	exitNotFound    = 3
+	exitConflict    = 4
+	exitUnavailable = 5
+)
+
+// usageError reports invalid arguments to a command.
+type usageError struct {
+	fs  *flag.FlagSet
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// flagsError is returned for flags the flag package has already complained
+// about.
+type flagsError struct {
+	err error
+}
+
+func (e *flagsError) Error() string { return e.err.Error() }
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return &flagsError{err}
+	}
+	return nil
+}
+
+// cli holds what every command needs.
+type cli struct {
+	ctx    context.Context
+	client pbcoord.CoordinatorClient
+	json   bool
+	// addr, tls and token are the coordinator address, certificate files
+	// and API token, for commands that make their own connection.
+	addr  string
+	tls   pki.Files
+	token string
+}
+
+var commands = map[string]func(c *cli, args []string) error{
+	"put":   runPut,
+	"get":   runGet,
+	"cat":   runCat,
+	"rm":    runRm,
+	"ls":    runLs,
+	"stat":  runStat,
+	"mv":    runMv,
+	"cp":    runCp,
+	"sync":  runSync,
+	"mount": runMount,
+	"chmod": runChmod,
+	"chown": runChown,
+}
+
+func main() {
+	global := flag.NewFlagSet("dfs", flag.ContinueOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	coordinator := global.String("coordinator", "", "coordinator address")
+	configPath := global.String("config", "", "config file")
+	jsonOutput := global.Bool("json", false, "print results as JSON, one object per line")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(report("", &flagsError{err}))
+	}
+	if global.Arg(0) == "certs" {
+		// Issuing certificates needs no coordinator, and must work before
+		// the certificate files exist.
+		if err := runCerts(global.Args()[1:]); err != nil {
+			os.Exit(report("certs", err))
+		}
+		return
+	}
+	if global.Arg(0) == "token" {
+		if err := runToken(global.Args()[1:]); err != nil {
+			os.Exit(report("token", err))
+		}
+		return
+	}
+	run, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfs: %v\n", err)
+		os.Exit(exitError)
+	}
+	addr := cfg.coordinatorAddr(*coordinator)
+	tlsFiles, err := cfg.tlsFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfs: %v\n", err)
+		os.Exit(exitError)
+	}
+	creds, err := pki.DialOption(tlsFiles, pki.RoleCoordinator)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfs: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	token := cfg.token()
+	tokenCreds := grpc.WithPerRPCCredentials(&auth.Credentials{Token: token, Insecure: !tlsFiles.Enabled()})
+
+	conn, err := grpc.NewClient(addr, creds, tokenCreds)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfs: failed to create client: %v\n", err)
+		os.Exit(exitError)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	c := &cli{ctx: ctx, client: pbcoord.NewCoordinatorClient(conn), json: *jsonOutput, addr: addr, tls: tlsFiles, token: token}
+	err = run(c, global.Args()[1:])
+	stop()
+	if err != nil {
+		conn.Close()
+		os.Exit(report(global.Arg(0), err))
+	}
+}
+
+// report prints err and returns the exit status for it.
+func report(command string, err error) int {
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintf(os.Stderr, "dfs %s: %s\n", command, usageErr.msg)
+		usageErr.fs.Usage()
+		return exitUsage
+	}
+	var flagsErr *flagsError
+	if errors.As(err, &flagsErr) {
+		if flagsErr.err == flag.ErrHelp {
+			return 0
+		}
+		return exitUsage
+	}
+
+	fmt.Fprintf(os.Stderr, "dfs %s: %v\n", command, err)
+	switch status.Code(err) {
+	case codes.NotFound:
+		return exitNotFound
+	case codes.AlreadyExists, codes.FailedPrecondition, codes.Aborted:
+		return exitConflict
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return exitUnavailable
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return exitNotFound
+	}
+	return exitError
+}
+
+// newFlags returns a flag set for a command, with the -json flag that every
+// command accepts after its name as well.
+func (c *cli) newFlags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.BoolVar(&c.json, "json", c.json, "print results as JSON, one object per line")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: dfs %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// printJSON writes m to stdout as a single line of JSON.
+func printJSON(m proto.Message) error {
+	line, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(line))
+	return err
+}
+
+// remote splits a remote file argument into a file ID or a path.
+func remote(arg string) (fileID, path string) {
+	if strings.HasPrefix(arg, "/") {
+		return "", arg
+	}
+	return arg, ""
+}
+
+// rpcError describes a failed RPC by its status message. It unwraps to the
+// RPC's error, so the status code still determines the exit status.
+type rpcError struct {
+	what string
+	err  error
+}
+
+func (e *rpcError) Error() string { return e.what + ": " + status.Convert(e.err).Message() }
+
+func (e *rpcError) Unwrap() error { return e.err }
+
+func grpcError(what string, err error) error {
+	return &rpcError{what: what, err: err}
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// keyValues is a repeatable key=value flag.
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	var pairs []string
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("%q is not key=value", v)
+	}
+	kv[k] = val
+	return nil
+}
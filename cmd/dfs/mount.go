@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"dfs/client"
+	"dfs/internal/fusefs"
+)
+
+func runMount(c *cli, args []string) error {
+	fs := c.newFlags("mount", "<mount point> [remote directory]")
+	readOnly := fs.Bool("ro", false, "mount read-only")
+	attrTTL := fs.Duration("attr-ttl", time.Second, "how long file attributes and name lookups are cached")
+	dirTTL := fs.Duration("dir-ttl", 5*time.Second, "how long directory listings are cached")
+	allowOther := fs.Bool("allow-other", false, "let other users access the mount")
+	debug := fs.Bool("debug", false, "log every FUSE request")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return &usageError{fs, "expected a mount point and an optional remote directory"}
+	}
+	mountpoint, root := fs.Arg(0), fs.Arg(1)
+	if root == "" {
+		root = "/"
+	}
+	if !strings.HasPrefix(root, "/") {
+		return &usageError{fs, "remote directory must start with /"}
+	}
+
+	var opts []client.Option
+	if c.tls.Enabled() {
+		opts = append(opts, client.WithTLS(c.tls.CA, c.tls.Cert, c.tls.Key))
+	}
+	if c.token != "" {
+		opts = append(opts, client.WithToken(c.token))
+	}
+	dfs, err := client.New(c.addr, opts...)
+	if err != nil {
+		return err
+	}
+	defer dfs.Close()
+
+	server, err := fusefs.Mount(c.ctx, dfs, mountpoint, root, fusefs.Options{
+		AttrTTL:    *attrTTL,
+		DirTTL:     *dirTTL,
+		ReadOnly:   *readOnly,
+		AllowOther: *allowOther,
+		Debug:      *debug,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mount %s: %w", mountpoint, err)
+	}
+	fmt.Fprintf(os.Stderr, "Mounted dfs:%s at %s; press Ctrl-C or run umount to unmount\n", root, mountpoint)
+
+	// The server also stops when the file system is unmounted from outside.
+	go func() {
+		<-c.ctx.Done()
+		if err := server.Unmount(); err != nil {
+			fmt.Fprintf(os.Stderr, "dfs mount: failed to unmount %s: %v; unmount it with umount\n", mountpoint, err)
+		}
+	}()
+	server.Wait()
+	return nil
+}
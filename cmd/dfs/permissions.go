@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	pbcoord "dfs/internal/pb/coordinator"
+)
+
+func runChmod(c *cli, args []string) error {
+	fs := c.newFlags("chmod", "<octal mode> <remote file | directory/>...")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return &usageError{fs, "expected a mode and at least one remote file or directory"}
+	}
+	var mode modeFlag
+	if err := mode.Set(fs.Arg(0)); err != nil {
+		return &usageError{fs, err.Error()}
+	}
+	return c.setPermissions(fs.Args()[1:], &pbcoord.SetPermissionsRequest{Mode: uint32(mode), SetMode: true})
+}
+
+func runChown(c *cli, args []string) error {
+	fs := c.newFlags("chown", "<owner[:group] | :group> <remote file | directory/>...")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return &usageError{fs, "expected an owner and at least one remote file or directory"}
+	}
+	owner, group, _ := strings.Cut(fs.Arg(0), ":")
+	if owner == "" && group == "" {
+		return &usageError{fs, "expected an owner, a group or both"}
+	}
+	return c.setPermissions(fs.Args()[1:], &pbcoord.SetPermissionsRequest{Owner: owner, Group: group})
+}
+
+// setPermissions applies req to each target. Targets ending in "/" are
+// directories, whose permissions govern the files created below them.
+func (c *cli) setPermissions(targets []string, req *pbcoord.SetPermissionsRequest) error {
+	for _, target := range targets {
+		req.FileId, req.Path, req.FilePath = "", "", ""
+		if strings.HasSuffix(target, "/") {
+			req.Path = target
+		} else {
+			req.FileId, req.FilePath = remote(target)
+		}
+		resp, err := c.client.SetPermissions(c.ctx, req)
+		if err != nil {
+			return grpcError(target, err)
+		}
+		if c.json {
+			if err := printJSON(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// permissions formats the mode, owner and group of a file like ls -l does,
+// with dashes for files that have none.
+func permissions(f *pbcoord.FileInfo) string {
+	if f.Owner == "" {
+		return fmt.Sprintf("%-10s  %-8s  %-8s", "-", "-", "-")
+	}
+	return fmt.Sprintf("%s  %-8s  %-8s", fs.FileMode(f.Mode)&fs.ModePerm, f.Owner, f.Group)
+}
+
+// modeFlag is a flag holding octal permission bits.
+type modeFlag uint32
+
+func (m *modeFlag) String() string { return fmt.Sprintf("%o", uint32(*m)) }
+
+func (m *modeFlag) Set(v string) error {
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || mode > 0o777 {
+		return fmt.Errorf("%q is not an octal mode such as 640", v)
+	}
+	*m = modeFlag(mode)
+	return nil
+}
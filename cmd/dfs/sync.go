@@ -0,0 +1,577 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// remotePrefix marks the DFS side of cp and sync arguments, as in
+// "dfs:/builds/42".
+const remotePrefix = "dfs:"
+
+// mtimeKey is the user metadata key under which cp and sync record the
+// modification time of the local files they upload.
+const mtimeKey = "mtime"
+
+// location is a source or destination of cp or sync.
+type location struct {
+	remote bool
+	// path is a local path, or a remote path or file ID.
+	path string
+}
+
+func parseLocation(arg string) location {
+	if p, ok := strings.CutPrefix(arg, remotePrefix); ok {
+		return location{remote: true, path: p}
+	}
+	return location{path: arg}
+}
+
+// treeFile is a file below the root of a recursive copy or sync.
+type treeFile struct {
+	size  int64
+	mtime time.Time
+	// local is the file's path for local files.
+	local string
+	// fileID, remotePath and sha256 are set for remote files.
+	fileID     string
+	remotePath string
+	sha256     string
+}
+
+// transferOptions are the flags shared by cp and sync.
+type transferOptions struct {
+	jobs    *int
+	include stringList
+	exclude stringList
+}
+
+func addTransferFlags(fs *flag.FlagSet) *transferOptions {
+	opts := &transferOptions{jobs: fs.Int("j", 4, "number of parallel transfers")}
+	fs.Var(&opts.include, "include", "only transfer files matching this glob; may be repeated")
+	fs.Var(&opts.exclude, "exclude", "skip files matching this glob; may be repeated")
+	return opts
+}
+
+// validate checks the options and returns the first problem found.
+func (opts *transferOptions) validate() string {
+	if *opts.jobs < 1 {
+		return "-j must be at least 1"
+	}
+	for _, pattern := range append(append([]string{}, opts.include...), opts.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Sprintf("bad glob %q", pattern)
+		}
+	}
+	return ""
+}
+
+// match reports whether the file at rel, a slash-separated path relative to
+// the root, passes the include and exclude globs. A glob without a slash is
+// matched against every element of the path, so "*.o" or "node_modules"
+// apply at any depth; other globs are matched against the whole path.
+func (opts *transferOptions) match(rel string) bool {
+	for _, pattern := range opts.exclude {
+		if globMatch(pattern, rel) {
+			return false
+		}
+	}
+	if len(opts.include) == 0 {
+		return true
+	}
+	for _, pattern := range opts.include {
+		if globMatch(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, rel string) bool {
+	if strings.Contains(pattern, "/") {
+		ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), rel)
+		return ok
+	}
+	for _, elem := range strings.Split(rel, "/") {
+		if ok, _ := path.Match(pattern, elem); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func runCp(c *cli, args []string) error {
+	fs := c.newFlags("cp", "<source> <destination>")
+	recursive := fs.Bool("r", false, "copy directories recursively")
+	opts := addTransferFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return &usageError{fs, "expected a source and a destination"}
+	}
+	if msg := opts.validate(); msg != "" {
+		return &usageError{fs, msg}
+	}
+	src, dst := parseLocation(fs.Arg(0)), parseLocation(fs.Arg(1))
+	if !src.remote && !dst.remote {
+		return &usageError{fs, "the source or the destination must be a " + remotePrefix + " path"}
+	}
+
+	if !*recursive {
+		if !src.remote {
+			if info, err := os.Stat(src.path); err == nil && info.IsDir() {
+				return fmt.Errorf("%s is a directory (use -r)", src.path)
+			}
+		}
+		return c.copyFile(src, dst)
+	}
+
+	files, err := c.tree(src, opts)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s: %w", fs.Arg(0), os.ErrNotExist)
+	}
+	var tasks []task
+	for _, rel := range sortedKeys(files) {
+		f := files[rel]
+		tasks = append(tasks, task{rel, func() (string, error) {
+			return c.transfer(src, dst, rel, f)
+		}})
+	}
+	return c.runTasks(*opts.jobs, tasks)
+}
+
+// copyFile copies a single file. A destination ending in "/", or naming a
+// local directory, gets the source's name appended.
+func (c *cli) copyFile(src, dst location) error {
+	dest := dst.path
+	if strings.HasSuffix(dest, "/") || (!dst.remote && isDir(dest)) {
+		dest = strings.TrimSuffix(dest, "/") + "/" + path.Base(src.path)
+	}
+
+	switch {
+	case !src.remote:
+		return c.putFile(src.path, dest)
+	case !dst.remote:
+		return c.getFile(src.path, dest)
+	}
+
+	fileID, filePath := remote(src.path)
+	_, err := c.client.CopyFile(c.ctx, &pbcoord.CopyFileRequest{
+		SourceFileId:    fileID,
+		SourcePath:      filePath,
+		DestinationPath: dest,
+	})
+	if err != nil {
+		return grpcError("copy failed", err)
+	}
+	return nil
+}
+
+// transfer copies the file at rel below src to the same place below dst.
+func (c *cli) transfer(src, dst location, rel string, f *treeFile) (string, error) {
+	if !src.remote {
+		return "upload", c.putFile(f.local, path.Join(dst.path, rel))
+	}
+	if !dst.remote {
+		return "download", c.getFile(f.remotePath, filepath.Join(dst.path, filepath.FromSlash(rel)))
+	}
+	return "copy", c.copyFile(location{true, f.remotePath}, location{true, path.Join(dst.path, rel)})
+}
+
+// putFile uploads a local file to dest, recording its modification time.
+// The new file keeps the tags and user metadata of a file already at dest.
+func (c *cli) putFile(local, dest string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	old, err := c.existing(dest)
+	if err != nil {
+		return err
+	}
+
+	attrs := &pbcoord.UploadFileRequest{UserMetadata: map[string]string{}}
+	if old != nil {
+		attrs.Tags = old.Tags
+		for k, v := range old.UserMetadata {
+			attrs.UserMetadata[k] = v
+		}
+	}
+	attrs.UserMetadata[mtimeKey] = info.ModTime().UTC().Format(time.RFC3339Nano)
+	_, err = c.upload(f, dest, attrs)
+	return err
+}
+
+// getFile downloads the file at remotePath to local, creating directories
+// as needed, and gives it the remote file's modification time.
+func (c *cli) getFile(remotePath, local string) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	target, err := c.downloadFile(remotePath, "", local)
+	if err != nil {
+		return err
+	}
+	fileID, filePath := remote(remotePath)
+	resp, err := c.client.StatFile(c.ctx, &pbcoord.StatFileRequest{FileId: fileID, Path: filePath})
+	if err != nil {
+		return grpcError(remotePath, err)
+	}
+	mtime := remoteMtime(resp.File)
+	return os.Chtimes(target, mtime, mtime)
+}
+
+// existing returns the file at dest, or nil if there is none.
+func (c *cli) existing(dest string) (*pbcoord.FileInfo, error) {
+	resp, err := c.client.StatFile(c.ctx, &pbcoord.StatFileRequest{Path: dest})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, grpcError(dest, err)
+	}
+	return resp.File, nil
+}
+
+func runSync(c *cli, args []string) error {
+	fs := c.newFlags("sync", "<source dir> <destination dir>")
+	opts := addTransferFlags(fs)
+	del := fs.Bool("delete", false, "delete destination files that are not in the source")
+	checksum := fs.Bool("checksum", false, "compare checksums even when size and modification time match")
+	dryRun := fs.Bool("n", false, "only show what would be transferred")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return &usageError{fs, "expected a source and a destination directory"}
+	}
+	if msg := opts.validate(); msg != "" {
+		return &usageError{fs, msg}
+	}
+	src, dst := parseLocation(fs.Arg(0)), parseLocation(fs.Arg(1))
+	if src.remote == dst.remote {
+		return &usageError{fs, "exactly one of the source and the destination must be a " + remotePrefix + " path"}
+	}
+	if !src.remote && !isDir(src.path) {
+		return fmt.Errorf("%s is not a directory", src.path)
+	}
+
+	srcFiles, err := c.tree(src, opts)
+	if err != nil {
+		return err
+	}
+	dstFiles, err := c.tree(dst, opts)
+	if err != nil {
+		return err
+	}
+
+	var tasks []task
+	for _, rel := range sortedKeys(srcFiles) {
+		s, d := srcFiles[rel], dstFiles[rel]
+		tasks = append(tasks, task{rel, func() (string, error) {
+			action, err := syncAction(s, d, *checksum)
+			switch {
+			case err != nil || action == "":
+				return action, err
+			case action == "touch" && *dryRun:
+				return "", nil
+			case action == "touch":
+				return action, c.touch(s, d)
+			case *dryRun:
+				return action, nil
+			}
+			return c.transfer(src, dst, rel, s)
+		}})
+	}
+	if *del {
+		for _, rel := range syncDeletions(srcFiles, dstFiles) {
+			d := dstFiles[rel]
+			tasks = append(tasks, task{rel, func() (string, error) {
+				if *dryRun {
+					return "delete", nil
+				}
+				if d.local != "" {
+					return "delete", os.Remove(d.local)
+				}
+				_, err := c.client.DeleteFile(c.ctx, &pbcoord.DeleteFileRequest{FileId: d.fileID})
+				if err != nil {
+					return "delete", grpcError("delete failed", err)
+				}
+				return "delete", nil
+			}})
+		}
+	}
+	return c.runTasks(*opts.jobs, tasks)
+}
+
+// syncAction decides what sync does with the source file s, given the
+// destination file d at the same place, which is nil if there is none. It
+// returns "upload" or "download" if d is missing or its contents differ,
+// "touch" if only its modification time does, and "" if it is up to date.
+func syncAction(s, d *treeFile, checksum bool) (string, error) {
+	differs := transferAction(location{remote: s.local == ""})
+	if d == nil {
+		return differs, nil
+	}
+	local, rem := s, d
+	if s.local == "" {
+		local, rem = d, s
+	}
+	if local.size != rem.size {
+		return differs, nil
+	}
+	sameTime := local.mtime.Truncate(time.Second).Equal(rem.mtime.Truncate(time.Second))
+	if sameTime && !checksum {
+		return "", nil
+	}
+	if rem.sha256 == "" {
+		// Without a digest to compare against, only the modification
+		// time can tell.
+		if sameTime {
+			return "", nil
+		}
+		return differs, nil
+	}
+	sum, err := fileSHA256(local.local)
+	if err != nil {
+		return "", err
+	}
+	if sum != rem.sha256 {
+		return differs, nil
+	}
+	if sameTime {
+		return "", nil
+	}
+	return "touch", nil
+}
+
+// syncDeletions returns the destination files that sync -delete removes:
+// those that are not in the source.
+func syncDeletions(srcFiles, dstFiles map[string]*treeFile) []string {
+	var rels []string
+	for _, rel := range sortedKeys(dstFiles) {
+		if srcFiles[rel] == nil {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// touch gives the destination file d the modification time of the source
+// file s, whose contents it already has.
+func (c *cli) touch(s, d *treeFile) error {
+	if d.local != "" {
+		return os.Chtimes(d.local, s.mtime, s.mtime)
+	}
+	_, err := c.client.UpdateFileAttributes(c.ctx, &pbcoord.UpdateFileAttributesRequest{
+		FileId:          d.fileID,
+		SetUserMetadata: map[string]string{mtimeKey: s.mtime.UTC().Format(time.RFC3339Nano)},
+	})
+	if err != nil {
+		return grpcError("failed to update modification time", err)
+	}
+	return nil
+}
+
+func transferAction(src location) string {
+	if src.remote {
+		return "download"
+	}
+	return "upload"
+}
+
+// tree returns the files below root that pass the filters, keyed by their
+// slash-separated path relative to root. A root that does not exist has no
+// files.
+func (c *cli) tree(root location, opts *transferOptions) (map[string]*treeFile, error) {
+	files := make(map[string]*treeFile)
+	if root.remote {
+		return files, c.remoteTree(root.path, opts, files)
+	}
+
+	err := filepath.WalkDir(root.path, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == root.path {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root.path, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !opts.match(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[rel] = &treeFile{size: info.Size(), mtime: info.ModTime(), local: p}
+		return nil
+	})
+	return files, err
+}
+
+func (c *cli) remoteTree(root string, opts *transferOptions, files map[string]*treeFile) error {
+	if !strings.HasPrefix(root, "/") {
+		return fmt.Errorf("%s%s: remote directories must be given by path", remotePrefix, root)
+	}
+	prefix := strings.TrimSuffix(path.Clean(root), "/") + "/"
+	resp, err := c.client.ListFiles(c.ctx, &pbcoord.ListFilesRequest{PathPrefix: prefix})
+	if err != nil {
+		return grpcError("list failed", err)
+	}
+
+	updated := make(map[string]string)
+	for _, f := range resp.Files {
+		rel := strings.TrimPrefix(f.Path, prefix)
+		if !opts.match(rel) {
+			continue
+		}
+		// Of several files at the same path, the newest is the one a
+		// lookup by path finds.
+		if _, ok := files[rel]; ok && f.UpdatedAt <= updated[rel] {
+			continue
+		}
+		updated[rel] = f.UpdatedAt
+		files[rel] = &treeFile{
+			size:       f.FileSize,
+			mtime:      remoteMtime(f),
+			fileID:     f.FileId,
+			remotePath: f.Path,
+			sha256:     f.Sha256,
+		}
+	}
+	return nil
+}
+
+// remoteMtime returns the modification time recorded when the file was
+// uploaded by cp or sync, or else the time it was last changed.
+func remoteMtime(f *pbcoord.FileInfo) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, f.UserMetadata[mtimeKey]); err == nil {
+		return t
+	}
+	t, _ := time.Parse(time.RFC3339, f.UpdatedAt)
+	return t
+}
+
+// task is one file's part of a cp or sync. run returns what it did, or ""
+// if nothing needed doing.
+type task struct {
+	rel string
+	run func() (string, error)
+}
+
+// runTasks runs tasks on up to jobs goroutines, printing what each one did
+// and reporting failures as they happen.
+func (c *cli) runTasks(jobs int, tasks []task) error {
+	var mu sync.Mutex
+	var failed int
+	queue := make(chan task)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				action, err := t.run()
+				mu.Lock()
+				switch {
+				case err != nil:
+					failed++
+					fmt.Fprintf(os.Stderr, "dfs: %s: %v\n", t.rel, err)
+				case action != "":
+					c.printAction(action, t.rel)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range tasks {
+		if c.ctx.Err() != nil {
+			break
+		}
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(tasks))
+	}
+	return nil
+}
+
+func (c *cli) printAction(action, rel string) {
+	if !c.json {
+		fmt.Printf("%-8s %s\n", action, rel)
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Action string `json:"action"`
+		Path   string `json:"path"`
+	}{action, rel})
+	fmt.Println(string(line))
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+func sortedKeys(files map[string]*treeFile) []string {
+	keys := make([]string, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
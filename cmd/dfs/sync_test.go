@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncAction(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(name, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("hello"))
+	digest := hex.EncodeToString(sum[:])
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Minute)
+	local := func(size int64, mtime time.Time) *treeFile {
+		return &treeFile{size: size, mtime: mtime, local: name}
+	}
+	remote := func(size int64, mtime time.Time, sha string) *treeFile {
+		return &treeFile{size: size, mtime: mtime, fileID: "id", remotePath: "/f", sha256: sha}
+	}
+
+	tests := []struct {
+		name     string
+		s, d     *treeFile
+		checksum bool
+		want     string
+	}{
+		{"new local file", local(5, now), nil, false, "upload"},
+		{"new remote file", remote(5, now, digest), nil, false, "download"},
+		{"size differs", local(5, now), remote(6, now, digest), false, "upload"},
+		{"size differs remotely", remote(6, now, digest), local(5, now), false, "download"},
+		{"same size and time", local(5, now), remote(5, now, digest), false, ""},
+		{"same second", local(5, now.Add(300*time.Millisecond)), remote(5, now, "other"), false, ""},
+		{"time differs, same digest", local(5, later), remote(5, now, digest), false, "touch"},
+		{"time differs, other digest", local(5, later), remote(5, now, "other"), false, "upload"},
+		{"time differs remotely, same digest", remote(5, later, digest), local(5, now), false, "touch"},
+		{"time differs without digest", local(5, later), remote(5, now, ""), false, "upload"},
+		{"checksum finds change", local(5, now), remote(5, now, "other"), true, "upload"},
+		{"checksum finds none", local(5, now), remote(5, now, digest), true, ""},
+		{"checksum without digest", local(5, now), remote(5, now, ""), true, ""},
+	}
+	for _, tt := range tests {
+		got, err := syncAction(tt.s, tt.d, tt.checksum)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: syncAction = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Reading the local file for its digest fails if it has gone.
+	gone := &treeFile{size: 5, mtime: later, local: filepath.Join(t.TempDir(), "gone")}
+	if _, err := syncAction(gone, remote(5, now, digest), false); err == nil {
+		t.Error("syncAction hashed a missing file")
+	}
+}
+
+func TestSyncDeletions(t *testing.T) {
+	f := &treeFile{}
+	tests := []struct {
+		name     string
+		src, dst map[string]*treeFile
+		want     []string
+	}{
+		{"nothing at destination", map[string]*treeFile{"a": f}, nil, nil},
+		{"all in source", map[string]*treeFile{"a": f, "b": f}, map[string]*treeFile{"a": f}, nil},
+		{"extra files", map[string]*treeFile{"b": f}, map[string]*treeFile{"c/d": f, "a": f, "b": f}, []string{"a", "c/d"}},
+		{"empty source", nil, map[string]*treeFile{"b": f, "a": f}, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := syncDeletions(tt.src, tt.dst); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: syncDeletions = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransferMatch(t *testing.T) {
+	tests := []struct {
+		include, exclude stringList
+		rel              string
+		want             bool
+	}{
+		{nil, nil, "a/b.txt", true},
+		{stringList{"*.txt"}, nil, "a/b.txt", true},
+		{stringList{"*.txt"}, nil, "a/b.go", false},
+		{stringList{"a"}, nil, "a/b.go", true},
+		{stringList{"a/*.go"}, nil, "a/b.go", true},
+		{stringList{"/a/*.go"}, nil, "a/b.go", true},
+		{stringList{"*/*.go"}, nil, "x/a/b.go", false},
+		{nil, stringList{"node_modules"}, "web/node_modules/x.js", false},
+		{nil, stringList{"*.o"}, "src/main.c", true},
+		{stringList{"*.c", "*.o"}, stringList{"*.o"}, "src/main.o", false},
+	}
+	for _, tt := range tests {
+		opts := &transferOptions{include: tt.include, exclude: tt.exclude}
+		if got := opts.match(tt.rel); got != tt.want {
+			t.Errorf("match(%q) with include %q, exclude %q = %v, want %v", tt.rel, tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"dfs/internal/auth"
+)
+
+const tokenUsage = `usage: dfs token [flags]
+
+Issues an API token, signed with the secret the coordinator reads from
+$DFS_AUTH_SECRET_FILE, and prints it. Clients send the token from $DFS_TOKEN
+or the "token" field of their config file. With -init, creates the secret
+first.
+
+flags:
+`
+
+func runToken(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, tokenUsage)
+		fs.PrintDefaults()
+	}
+	secretFile := fs.String("secret", os.Getenv("DFS_AUTH_SECRET_FILE"), "file holding the token secret")
+	create := fs.Bool("init", false, "create a new secret in the -secret file, which must not exist")
+	user := fs.String("user", "", "user the token is for")
+	groups := fs.String("group", "", "comma-separated groups of the user; the first is the group of their new files")
+	admin := fs.Bool("admin", false, "let the user access every file and change any permissions")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the token is valid; -ttl 0 issues a token that never expires")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return &usageError{fs, "unexpected arguments"}
+	}
+	if *secretFile == "" {
+		return &usageError{fs, "-secret or $DFS_AUTH_SECRET_FILE is required"}
+	}
+	if *create {
+		if err := auth.NewSecret(*secretFile); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Created token secret %s; keep it secret\n", *secretFile)
+		if *user == "" {
+			return nil
+		}
+	}
+	if *user == "" {
+		return &usageError{fs, "-user is required"}
+	}
+
+	secret, err := auth.ReadSecret(*secretFile)
+	if err != nil {
+		return err
+	}
+	token, err := auth.NewToken(secret, &auth.Identity{User: *user, Groups: splitList(*groups), Admin: *admin}, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
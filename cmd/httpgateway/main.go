@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"dfs/client"
+	"dfs/internal/httpgateway"
+	"dfs/internal/pki"
+)
+
+func main() {
+	log.Println("Starting HTTP gateway")
+
+	coordinatorAddr := os.Getenv("DFS_COORDINATOR_ADDR")
+	if coordinatorAddr == "" {
+		coordinatorAddr = "coordinator:50053"
+	}
+	port := os.Getenv("DFS_HTTP_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	webDAV := os.Getenv("DFS_HTTP_WEBDAV") != "false"
+
+	tlsFiles, err := pki.FilesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	var opts []client.Option
+	if tlsFiles.Enabled() {
+		opts = append(opts, client.WithTLS(tlsFiles.CA, tlsFiles.Cert, tlsFiles.Key))
+	}
+	if token := os.Getenv("DFS_TOKEN"); token != "" {
+		opts = append(opts, client.WithToken(token))
+	}
+	c, err := client.New(coordinatorAddr, opts...)
+	if err != nil {
+		log.Fatalf("Failed to connect to coordinator at %s: %v", coordinatorAddr, err)
+	}
+	defer c.Close()
+
+	server := httpgateway.NewServer(c, httpgateway.Config{WebDAV: webDAV})
+	log.Printf("HTTP gateway listening on port %s (coordinator %s, WebDAV %t)", port, coordinatorAddr, webDAV)
+	if err := http.ListenAndServe(":"+port, server); err != nil {
+		log.Fatalf("Failed to serve: %v", err)
+	}
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"dfs/internal/metadataservice"
 	pb "dfs/internal/pb/metadata"
+	"dfs/internal/pki"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 )
@@ -18,24 +22,53 @@ func main() {
 		baseDir = "/tmp/dfs-metadata"
 	}
 
-	store, err := metadataservice.NewDiskStore(baseDir)
+	engine := os.Getenv("DFS_METADATA_ENGINE")
+	store, err := metadataservice.OpenStore(engine, baseDir)
 	if err != nil {
 		log.Fatalf("Failed to create metadata store: %v", err)
 	}
 
-	server := metadataservice.NewServer(store)
+	tlsFiles, err := pki.FilesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	if !tlsFiles.Enabled() {
+		log.Println("DFS_TLS_* is not set; connections are not encrypted or authenticated")
+	}
 
 	port := os.Getenv("DFS_METADATA_PORT")
 	if port == "" {
 		port = "50052"
 	}
 
+	if raftAddr := os.Getenv("DFS_RAFT_ADDR"); raftAddr != "" {
+		store, err = newRaftStore(store, raftAddr, baseDir, port, tlsFiles)
+		if err != nil {
+			log.Fatalf("Failed to start raft: %v", err)
+		}
+	}
+
+	server := metadataservice.NewServer(store)
+	if v := os.Getenv("DFS_METADATA_LEASE"); v != "" {
+		lease, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid DFS_METADATA_LEASE: %v", err)
+		}
+		server.SetLeaseDuration(lease)
+	}
+
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	// The coordinator and operators' tools such as dfs-meta talk to the
+	// metadata service.
+	opts, err := pki.ServerOptions(tlsFiles, pki.RoleCoordinator, pki.RoleAdmin)
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
+	}
+	s := grpc.NewServer(opts...)
 	pb.RegisterMetadataServiceServer(s, server)
 
 	log.Printf("Metadata Service is listening on :%s", port)
@@ -43,3 +76,39 @@ func main() {
 		log.Fatalf("Failed to serve: %v", err)
 	}
 }
+
+// newRaftStore replicates store through a raft group configured from the
+// DFS_RAFT_* environment variables. The member ID defaults to this host's
+// gRPC address, which is how clients find the leader.
+func newRaftStore(store metadataservice.Store, raftAddr, baseDir, port string, tlsFiles pki.Files) (*metadataservice.RaftStore, error) {
+	id := os.Getenv("DFS_RAFT_ID")
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		id = net.JoinHostPort(hostname, port)
+	}
+
+	peers := make(map[string]string)
+	if v := os.Getenv("DFS_RAFT_PEERS"); v != "" {
+		for _, peer := range strings.Split(v, ",") {
+			peerID, peerAddr, ok := strings.Cut(peer, "=")
+			if !ok {
+				log.Fatalf("Invalid DFS_RAFT_PEERS entry %q, expected id=raftaddr", peer)
+			}
+			peers[peerID] = peerAddr
+		}
+	}
+
+	log.Printf("Starting raft member %s on %s", id, raftAddr)
+	return metadataservice.NewRaftStore(store, metadataservice.RaftConfig{
+		ID:        id,
+		BindAddr:  raftAddr,
+		Advertise: os.Getenv("DFS_RAFT_ADVERTISE"),
+		Dir:       filepath.Join(baseDir, "raft"),
+		Bootstrap: os.Getenv("DFS_RAFT_BOOTSTRAP") == "true",
+		Peers:     peers,
+		TLS:       tlsFiles,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"dfs/client"
+	"dfs/internal/pki"
+	"dfs/internal/s3gateway"
+)
+
+func main() {
+	log.Println("Starting S3 gateway")
+
+	coordinatorAddr := os.Getenv("DFS_COORDINATOR_ADDR")
+	if coordinatorAddr == "" {
+		coordinatorAddr = "coordinator:50053"
+	}
+	port := os.Getenv("DFS_S3_PORT")
+	if port == "" {
+		port = "9000"
+	}
+	region := os.Getenv("DFS_S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Credentials come from a JSON file mapping access key IDs to secret
+	// keys, from a single pair of variables, or both.
+	credentials := map[string]string{}
+	if file := os.Getenv("DFS_S3_CREDENTIALS"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Failed to read DFS_S3_CREDENTIALS: %v", err)
+		}
+		if err := json.Unmarshal(data, &credentials); err != nil {
+			log.Fatalf("Invalid DFS_S3_CREDENTIALS file %s: %v", file, err)
+		}
+	}
+	if accessKey := os.Getenv("DFS_S3_ACCESS_KEY"); accessKey != "" {
+		secretKey := os.Getenv("DFS_S3_SECRET_KEY")
+		if secretKey == "" {
+			log.Fatalf("DFS_S3_ACCESS_KEY is set but DFS_S3_SECRET_KEY is not")
+		}
+		credentials[accessKey] = secretKey
+	}
+	anonymous := os.Getenv("DFS_S3_ANONYMOUS") == "true"
+	if len(credentials) == 0 && !anonymous {
+		log.Fatalf("No credentials configured; set DFS_S3_ACCESS_KEY and DFS_S3_SECRET_KEY, or DFS_S3_ANONYMOUS=true to accept unsigned requests")
+	}
+
+	tlsFiles, err := pki.FilesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	var opts []client.Option
+	if tlsFiles.Enabled() {
+		opts = append(opts, client.WithTLS(tlsFiles.CA, tlsFiles.Cert, tlsFiles.Key))
+	}
+	if token := os.Getenv("DFS_TOKEN"); token != "" {
+		opts = append(opts, client.WithToken(token))
+	}
+	c, err := client.New(coordinatorAddr, opts...)
+	if err != nil {
+		log.Fatalf("Failed to connect to coordinator at %s: %v", coordinatorAddr, err)
+	}
+	defer c.Close()
+
+	server := s3gateway.NewServer(c, s3gateway.Config{
+		Credentials: credentials,
+		Anonymous:   anonymous,
+		StagingDir:  os.Getenv("DFS_S3_STAGING_DIR"),
+		Region:      region,
+	})
+	log.Printf("S3 gateway listening on port %s (coordinator %s, %d access keys, anonymous access %t)", port, coordinatorAddr, len(credentials), anonymous)
+	if err := http.ListenAndServe(":"+port, server); err != nil {
+		log.Fatalf("Failed to serve: %v", err)
+	}
+}
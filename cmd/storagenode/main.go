@@ -3,20 +3,36 @@ package main
 import (
 	"dfs/internal/chunk"
 	pb "dfs/internal/pb/storagenode"
+	"dfs/internal/pki"
 	"dfs/internal/storagenode"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"time"
 
 	"google.golang.org/grpc"
 )
 
 func main() {
     port := flag.Int("port", 50051, "The server port")
+    newKey := flag.Bool("new-key", false, "Add a new encryption key to DFS_STORAGE_KEY_FILE and exit")
     flag.Parse()
 
+    keyFile := os.Getenv("DFS_STORAGE_KEY_FILE")
+    if *newKey {
+        if keyFile == "" {
+            log.Fatalf("DFS_STORAGE_KEY_FILE is not set")
+        }
+        id, err := chunk.AddKey(keyFile)
+        if err != nil {
+            log.Fatalf("Failed to add key: %v", err)
+        }
+        log.Printf("Added key %s to %s; it encrypts new chunks once the node restarts", id, keyFile)
+        return
+    }
+
     log.Printf("Starting Storage Node on port %d", *port)
 
     baseDir := os.Getenv("DFS_STORAGE_DIR")
@@ -24,11 +40,61 @@ func main() {
         baseDir = fmt.Sprintf("/tmp/dfs-storage-%d", *port)
     }
 
-    store, err := chunk.NewDiskStore(baseDir)
+    engine := os.Getenv("DFS_STORAGE_ENGINE")
+    store, err := chunk.Open(engine, baseDir)
     if err != nil {
         log.Fatalf("Failed to create store: %v", err)
     }
 
+    if compactor, ok := store.(chunk.Compactor); ok {
+        interval := time.Hour
+        if v := os.Getenv("DFS_STORAGE_COMPACT_INTERVAL"); v != "" {
+            interval, err = time.ParseDuration(v)
+            if err != nil {
+                log.Fatalf("Invalid DFS_STORAGE_COMPACT_INTERVAL: %v", err)
+            }
+        }
+        go compactLoop(compactor, interval)
+    }
+
+    if v := os.Getenv("DFS_STORAGE_SCRUB_INTERVAL"); v != "" {
+        interval, err := time.ParseDuration(v)
+        if err != nil {
+            log.Fatalf("Invalid DFS_STORAGE_SCRUB_INTERVAL: %v", err)
+        }
+        // Scrubbing reads the chunks as they are on disk, so it checks the
+        // ciphertext of encrypted chunks and needs no key.
+        go scrubLoop(store, interval)
+    }
+
+    // Chunks are encrypted with keys from DFS_STORAGE_KEY_FILE. To rotate
+    // keys, add one with -new-key, restart with DFS_STORAGE_REWRAP=true to
+    // re-encrypt the existing chunks under it, and then remove the old one.
+    // Chunks stored in plaintext are refused, except while a rewrap
+    // encrypts those written before the key file was set.
+    if keyFile != "" {
+        keys, err := chunk.LoadKeyFile(keyFile)
+        if err != nil {
+            log.Fatalf("Failed to load encryption keys: %v", err)
+        }
+        encrypted := chunk.NewEncryptedStore(store, keys)
+        store = encrypted
+        log.Printf("Encrypting chunks with key %s", keys.CurrentKeyID())
+        if os.Getenv("DFS_STORAGE_REWRAP") == "true" {
+            encrypted.AllowPlaintext(true)
+            go func() {
+                n, err := encrypted.Rewrap()
+                if err != nil {
+                    log.Printf("Re-encrypting chunks failed after %d chunks: %v", n, err)
+                    return
+                }
+                log.Printf("Re-encrypted %d chunks with key %s", n, keys.CurrentKeyID())
+            }()
+        }
+    } else {
+        log.Println("DFS_STORAGE_KEY_FILE is not set; chunks are stored unencrypted")
+    }
+
     server := storagenode.NewServer(store)
 
     lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
@@ -36,7 +102,19 @@ func main() {
         log.Fatalf("Failed to listen: %v", err)
     }
 
-    s := grpc.NewServer()
+    tlsFiles, err := pki.FilesFromEnv()
+    if err != nil {
+        log.Fatalf("Invalid TLS configuration: %v", err)
+    }
+    if !tlsFiles.Enabled() {
+        log.Println("DFS_TLS_* is not set; connections are not encrypted or authenticated")
+    }
+    // Only the coordinator talks to storage nodes.
+    opts, err := pki.ServerOptions(tlsFiles, pki.RoleCoordinator)
+    if err != nil {
+        log.Fatalf("Failed to set up TLS: %v", err)
+    }
+    s := grpc.NewServer(opts...)
     pb.RegisterStorageNodeServer(s, server)
 
     log.Printf("Storage Node is listening on :%d", *port)
@@ -44,3 +122,29 @@ func main() {
         log.Fatalf("Failed to serve: %v", err)
     }
 }
+
+func compactLoop(compactor chunk.Compactor, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for range ticker.C {
+        if err := compactor.Compact(); err != nil {
+            log.Printf("Compaction failed: %v", err)
+        }
+    }
+}
+
+func scrubLoop(store chunk.Store, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for range ticker.C {
+        checked, corrupt, err := chunk.Scrub(store)
+        if err != nil {
+            log.Printf("Scrub failed: %v", err)
+            continue
+        }
+        for _, id := range corrupt {
+            log.Printf("Scrub found corrupt chunk %s", id)
+        }
+        log.Printf("Scrubbed %d chunks, %d corrupt", checked, len(corrupt))
+    }
+}
@@ -0,0 +1,200 @@
+// Package auth identifies the clients of the coordinator and decides what
+// they may do with files.
+//
+// Clients present an API token in the gRPC "authorization" metadata as
+// "Bearer <token>". Tokens are JWTs signed with HMAC-SHA256 under a secret
+// that only the coordinator and whoever issues tokens know; they name a user,
+// the user's groups and whether the user is an admin.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Identity is an authenticated user.
+type Identity struct {
+	User   string
+	Groups []string
+	// Admin users may access every file and change any permissions.
+	Admin bool
+}
+
+// Access is a set of permission bits, as they appear in the "other" part of
+// a mode.
+type Access uint32
+
+const (
+	Read  Access = 4
+	Write Access = 2
+)
+
+// DefaultMode is the mode of new files for which none was requested.
+const DefaultMode = 0o644
+
+// Allowed reports whether id may access a file or directory with the given
+// owner, group and mode. Every user belongs to the group named after them.
+func (id *Identity) Allowed(owner, group string, mode uint32, access Access) bool {
+	if id.Admin {
+		return true
+	}
+	bits := mode & 7
+	switch {
+	case id.User == owner:
+		bits = mode >> 6 & 7
+	case id.InGroup(group):
+		bits = mode >> 3 & 7
+	}
+	return Access(bits)&access == access
+}
+
+func (id *Identity) InGroup(group string) bool {
+	return group != "" && (group == id.User || slices.Contains(id.Groups, group))
+}
+
+// PrimaryGroup is the group of the files the user creates, unless the
+// directory they are created in has one.
+func (id *Identity) PrimaryGroup() string {
+	if len(id.Groups) > 0 {
+		return id.Groups[0]
+	}
+	return id.User
+}
+
+type contextKey struct{}
+
+// NewContext returns a context that carries id.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity of the caller, or nil if the request was
+// not authenticated.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// claims is the payload of a token.
+type claims struct {
+	Subject   string   `json:"sub"`
+	Groups    []string `json:"groups,omitempty"`
+	Admin     bool     `json:"admin,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// NewToken issues a token for id that expires after ttl, or never if ttl is
+// zero.
+func NewToken(secret []byte, id *Identity, ttl time.Duration) (string, error) {
+	if id.User == "" {
+		return "", errors.New("a token needs a user")
+	}
+	now := time.Now()
+	c := claims{Subject: id.User, Groups: id.Groups, Admin: id.Admin, IssuedAt: now.Unix()}
+	if ttl > 0 {
+		c.ExpiresAt = now.Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + sign(secret, signed), nil
+}
+
+func sign(secret []byte, signed string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks tokens issued with a secret.
+type Verifier struct {
+	secret []byte
+}
+
+func NewVerifier(secret []byte) *Verifier {
+	return &Verifier{secret: secret}
+}
+
+// Verify returns the identity a token was issued for.
+func (v *Verifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	var alg struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(h, &alg); err != nil || alg.Alg != "HS256" {
+		return nil, errors.New("unsupported token algorithm")
+	}
+	signed := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(sign(v.secret, signed)), []byte(parts[2])) {
+		return nil, errors.New("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token payload")
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, errors.New("malformed token payload")
+	}
+	if c.ExpiresAt != 0 && time.Now().Unix() >= c.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+	if c.Subject == "" {
+		return nil, errors.New("token names no user")
+	}
+	return &Identity{User: c.Subject, Groups: c.Groups, Admin: c.Admin}, nil
+}
+
+// NewSecret creates a secret for signing tokens and writes it, hex-encoded,
+// to a new file.
+func NewSecret(file string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(secret)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadSecret reads a secret written by NewSecret.
+func ReadSecret(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token secret: %w", err)
+	}
+	secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(secret) < 16 {
+		return nil, fmt.Errorf("%s does not hold a hex-encoded secret of at least 16 bytes", file)
+	}
+	return secret, nil
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tokenWith signs a token with the given claims, to make tokens NewToken
+// would not issue.
+func tokenWith(t *testing.T, secret []byte, c claims) string {
+	t.Helper()
+	payload, err := json.Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + sign(secret, signed)
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	id := &Identity{User: "alice", Groups: []string{"staff", "ops"}}
+	issue := func(id *Identity, ttl time.Duration) string {
+		token, err := NewToken(secret, id, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := issue(id, time.Hour)
+	parts := strings.Split(valid, ".")
+	otherAlg := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	tests := []struct {
+		name    string
+		token   string
+		secret  []byte
+		want    *Identity
+		wantErr string
+	}{
+		{name: "valid", token: valid, want: id},
+		{name: "never expires", token: issue(id, 0), want: id},
+		{name: "admin", token: issue(&Identity{User: "root", Admin: true}, time.Hour), want: &Identity{User: "root", Admin: true}},
+		{name: "wrong secret", token: valid, secret: []byte("fedcba9876543210fedcba9876543210"), wantErr: "invalid token signature"},
+		{name: "tampered payload", token: parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"root","admin":true}`)) + "." + parts[2], wantErr: "invalid token signature"},
+		{name: "other algorithm", token: otherAlg + "." + parts[1] + "." + parts[2], wantErr: "unsupported token algorithm"},
+		{name: "expired", token: tokenWith(t, secret, claims{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute).Unix()}), wantErr: "token expired"},
+		{name: "no user", token: tokenWith(t, secret, claims{}), wantErr: "token names no user"},
+		{name: "malformed", token: "not-a-token", wantErr: "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := secret
+			if tt.secret != nil {
+				s = tt.secret
+			}
+			got, err := NewVerifier(s).Verify(tt.token)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Verify = %v, %v; want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Verify = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTokenExpiry(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token, err := NewToken(secret, &Identity{User: "alice"}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.ExpiresAt - c.IssuedAt; got != int64((24 * time.Hour).Seconds()) {
+		t.Fatalf("token is valid for %ds, want 24h", got)
+	}
+	if _, err := NewToken(secret, &Identity{}, time.Hour); err == nil {
+		t.Fatal("NewToken issued a token without a user")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	alice := &Identity{User: "alice", Groups: []string{"staff"}}
+	tests := []struct {
+		name   string
+		id     *Identity
+		owner  string
+		group  string
+		mode   uint32
+		access Access
+		want   bool
+	}{
+		{"owner reads", alice, "alice", "staff", 0o400, Read, true},
+		{"owner without bit", alice, "alice", "staff", 0o044, Read, false},
+		{"owner writes", alice, "alice", "staff", 0o600, Write, true},
+		{"owner read-only", alice, "alice", "staff", 0o444, Write, false},
+		{"group reads", alice, "bob", "staff", 0o040, Read, true},
+		{"group read-only", alice, "bob", "staff", 0o646, Write, false},
+		{"group writes", alice, "bob", "staff", 0o060, Read | Write, true},
+		{"user group", alice, "bob", "alice", 0o020, Write, true},
+		{"other reads", alice, "bob", "wheel", 0o004, Read, true},
+		{"other denied", alice, "bob", "wheel", 0o660, Read, false},
+		{"admin", &Identity{User: "root", Admin: true}, "bob", "wheel", 0, Read | Write, true},
+	}
+	for _, tt := range tests {
+		if got := tt.id.Allowed(tt.owner, tt.group, tt.mode, tt.access); got != tt.want {
+			t.Errorf("%s: Allowed(%s, %s, %o, %d) = %v, want %v", tt.name, tt.owner, tt.group, tt.mode, tt.access, got, tt.want)
+		}
+	}
+}
+
+func TestSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := NewSecret(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSecret(file); err == nil {
+		t.Fatal("NewSecret overwrote an existing secret")
+	}
+	secret, err := ReadSecret(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("secret has %d bytes, want 32", len(secret))
+	}
+}
@@ -0,0 +1,235 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	checksumSuffix = ".checksum"
+
+	// layoutFile in baseDir records that its chunks are in the sharded
+	// layout. Its name cannot collide with a chunk ID.
+	layoutFile    = ".layout"
+	layoutSharded = "sharded"
+)
+
+// DiskStore keeps every chunk in its own file under baseDir/xx/yy/, where xx
+// and yy are the first two bytes of the SHA-256 of the chunk ID in hex.
+// Hashing spreads chunks evenly across 65536 directories regardless of how
+// IDs are generated. Each file holds the checksum on its first line followed
+// by the chunk data, so a chunk costs a single inode.
+type DiskStore struct {
+	baseDir string
+}
+
+func NewDiskStore(baseDir string) (*DiskStore, error) {
+	baseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve base directory: %w", err)
+	}
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base directory: %w", err)
+	}
+	d := &DiskStore{baseDir: baseDir}
+	if err := d.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to migrate chunk layout: %w", err)
+	}
+	return d, nil
+}
+
+// path returns the location of the chunk file for id. It rejects invalid IDs
+// and, as a second line of defence, any path that resolves outside baseDir.
+func (d *DiskStore) path(id string) (string, error) {
+	if err := ValidateID(id); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(id))
+	prefix := hex.EncodeToString(sum[:2])
+	path := filepath.Join(d.baseDir, prefix[:2], prefix[2:], id)
+
+	rel, err := filepath.Rel(d.baseDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return path, nil
+}
+
+func (d *DiskStore) Put(id string, data []byte, checksum string) error {
+	path, err := d.path(id)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsRune(checksum, '\n') {
+		return fmt.Errorf("checksum for chunk %s contains a newline", id)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create shard directory: %w", err)
+	}
+	return writeChunkFile(path, data, checksum)
+}
+
+func (d *DiskStore) Get(id string) ([]byte, string, error) {
+	path, err := d.path(id)
+	if err != nil {
+		return nil, "", err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	checksum, data, ok := bytes.Cut(raw, []byte("\n"))
+	if !ok {
+		return nil, "", fmt.Errorf("chunk file %s has no checksum header", path)
+	}
+	return data, string(checksum), nil
+}
+
+func (d *DiskStore) Delete(id string) error {
+	path, err := d.path(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// IDs returns the IDs of all chunks in the store.
+func (d *DiskStore) IDs() ([]string, error) {
+	var ids []string
+	err := filepath.WalkDir(d.baseDir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || ValidateID(entry.Name()) != nil {
+			return nil
+		}
+		if path, err := d.path(entry.Name()); err == nil && path == p {
+			ids = append(ids, entry.Name())
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// writeChunkFile writes the checksum header and data to a temporary file and
+// renames it into place, so readers never observe a partially written chunk.
+// The file and the rename are synced before it returns.
+func writeChunkFile(path string, data []byte, checksum string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(checksum + "\n"); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// migrate moves chunks written flat into baseDir by older versions of the
+// store, as a data file "<id>" and a "<id>.checksum" file, into the sharded
+// single-file layout, and then records in layoutFile that baseDir needs no
+// further migration. Each chunk is synced in its new place before the old
+// files are removed, and the data file before the checksum file, so a
+// checksum file whose data file is gone marks a chunk that was already moved.
+func (d *DiskStore) migrate() error {
+	marker := filepath.Join(d.baseDir, layoutFile)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	entries, err := os.ReadDir(d.baseDir)
+	if err != nil {
+		return err
+	}
+	moved := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), checksumSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), checksumSuffix)
+		newPath, err := d.path(id)
+		if err != nil {
+			continue
+		}
+		checksumPath := filepath.Join(d.baseDir, entry.Name())
+		oldPath := filepath.Join(d.baseDir, id)
+
+		data, err := os.ReadFile(oldPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if _, err := os.Stat(newPath); err != nil {
+				return fmt.Errorf("chunk %s has a checksum file but no data: %w", id, err)
+			}
+		case err != nil:
+			return err
+		default:
+			checksum, err := os.ReadFile(checksumPath)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+				return err
+			}
+			if err := writeChunkFile(newPath, data, string(checksum)); err != nil {
+				return err
+			}
+			if err := os.Remove(oldPath); err != nil {
+				return err
+			}
+			moved++
+		}
+		if err := os.Remove(checksumPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(marker, []byte(layoutSharded+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := syncDir(d.baseDir); err != nil {
+		return err
+	}
+	if moved > 0 {
+		log.Printf("Moved %d chunks in %s to the sharded layout", moved, d.baseDir)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package chunk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskStoreRejectsInvalidIDs(t *testing.T) {
+	d, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", "..", "../escape", "a/b", "a.checksum", ".layout"} {
+		if err := d.Put(id, []byte("x"), Checksum([]byte("x"))); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidID", id, err)
+		}
+	}
+}
+
+// writeFlat writes a chunk the way the flat layout did.
+func writeFlat(t *testing.T, dir, id string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, id), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+checksumSuffix), []byte(Checksum(data)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskStoreMigratesFlatLayout(t *testing.T) {
+	dir := t.TempDir()
+	writeFlat(t, dir, "chunk-1", []byte("one"))
+	writeFlat(t, dir, "chunk-2", []byte("two"))
+
+	d, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	mustGet(t, d, "chunk-1", []byte("one"))
+	mustGet(t, d, "chunk-2", []byte("two"))
+	for _, name := range []string{"chunk-1", "chunk-1" + checksumSuffix} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after migration: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, layoutFile)); err != nil {
+		t.Errorf("layout marker not written: %v", err)
+	}
+	ids, err := d.IDs()
+	if err != nil || len(ids) != 2 {
+		t.Errorf("IDs() = %v, %v; want the two migrated chunks", ids, err)
+	}
+}
+
+func TestDiskStoreMigrationResumesAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	writeFlat(t, dir, "moved", []byte("moved before the crash"))
+	writeFlat(t, dir, "pending", []byte("not moved yet"))
+
+	// Simulate a crash after "moved" was written to its new place and its
+	// data file removed, but before its checksum file was.
+	d := &DiskStore{baseDir: dir}
+	newPath, err := d.path("moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("moved before the crash")
+	if err := writeChunkFile(newPath, data, Checksum(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore after an interrupted migration: %v", err)
+	}
+	mustGet(t, d, "moved", data)
+	mustGet(t, d, "pending", []byte("not moved yet"))
+	if _, err := os.Stat(filepath.Join(dir, "moved"+checksumSuffix)); !os.IsNotExist(err) {
+		t.Errorf("orphaned checksum file left behind: %v", err)
+	}
+}
+
+func TestDiskStoreMigrationRunsOnce(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewDiskStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	// A flat chunk appearing after the marker was written is not looked
+	// for: startup does not rescan the store.
+	writeFlat(t, dir, "late", []byte("late"))
+	d, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.Get("late"); err == nil {
+		t.Fatal("migration ran again although the layout marker exists")
+	}
+}
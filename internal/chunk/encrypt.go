@@ -0,0 +1,292 @@
+package chunk
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	envelopeMagic   = "DFSE"
+	envelopeVersion = 1
+
+	// encryptedChecksumPrefix marks the checksum of an encrypted chunk. The
+	// rest of it is the Checksum of the envelope, so a scrubber can verify
+	// the stored bytes without a key.
+	encryptedChecksumPrefix = "aes-gcm:"
+
+	dataKeySize = 32
+	nonceSize   = 12
+)
+
+// ErrNotEncrypted is returned for chunks that are stored in plaintext while
+// plaintext chunks are not allowed.
+var ErrNotEncrypted = errors.New("chunk is not encrypted")
+
+// EncryptedStore encrypts chunks before they reach another store. Every
+// chunk gets a fresh AES-256 data key, which encrypts its data and checksum
+// with AES-GCM, and is kept alongside it wrapped by a KeyManager. The chunk
+// ID is authenticated too, so chunks cannot be swapped on disk.
+//
+// The inner store keeps each chunk as an envelope:
+//
+//	"DFSE" | version uint8 | keyIDLen uint8 | keyID |
+//	wrappedLen uint16 | wrapped data key | nonce [12]byte | ciphertext
+//
+// and, in place of the chunk's checksum, encryptedChecksumPrefix followed by
+// the Checksum of the envelope. The plaintext checksum is encrypted along
+// with the data, as its length in a uint16 followed by the checksum.
+//
+// Chunks stored in plaintext are rejected with ErrNotEncrypted, so that
+// nobody with access to the inner store can slip in data that is served
+// without being authenticated. Chunks stored before encryption was enabled
+// are read as they are only while AllowPlaintext is set; Rewrap encrypts
+// them and then rejects plaintext again.
+type EncryptedStore struct {
+	inner Store
+	keys  KeyManager
+
+	allowPlaintext atomic.Bool
+
+	// mu is held shared by Put and Delete and exclusively while Rewrap
+	// rewrites a chunk, so that it cannot bring back a deleted chunk.
+	mu sync.RWMutex
+}
+
+func NewEncryptedStore(inner Store, keys KeyManager) *EncryptedStore {
+	return &EncryptedStore{inner: inner, keys: keys}
+}
+
+// AllowPlaintext sets whether Get returns chunks that are stored in
+// plaintext, for while a store that held chunks before encryption was
+// enabled is being rewrapped.
+func (e *EncryptedStore) AllowPlaintext(allow bool) {
+	e.allowPlaintext.Store(allow)
+}
+
+func (e *EncryptedStore) Put(id string, data []byte, checksum string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	envelope, err := e.seal(id, data, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt chunk %s: %w", id, err)
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.inner.Put(id, envelope, encryptedChecksumPrefix+Checksum(envelope))
+}
+
+func (e *EncryptedStore) Get(id string) ([]byte, string, error) {
+	stored, storedChecksum, err := e.inner.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if !strings.HasPrefix(storedChecksum, encryptedChecksumPrefix) {
+		if !e.allowPlaintext.Load() {
+			return nil, "", fmt.Errorf("chunk %s: %w", id, ErrNotEncrypted)
+		}
+		return stored, storedChecksum, nil
+	}
+	data, checksum, err := e.open(id, stored)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt chunk %s: %w", id, err)
+	}
+	return data, checksum, nil
+}
+
+func (e *EncryptedStore) Delete(id string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.inner.Delete(id)
+}
+
+// IDs lists the chunks of the inner store, which must implement Lister.
+func (e *EncryptedStore) IDs() ([]string, error) {
+	lister, ok := e.inner.(Lister)
+	if !ok {
+		return nil, errors.New("store cannot list its chunks")
+	}
+	return lister.IDs()
+}
+
+// Rewrap encrypts every chunk that is stored in plaintext, or whose data key
+// is wrapped with a key other than the current one, again under the current
+// key and with a fresh data key. Once it has run, the keys that are no longer
+// current can be retired, and plaintext chunks are no longer allowed. It
+// returns the number of chunks it rewrote.
+func (e *EncryptedStore) Rewrap() (int, error) {
+	ids, err := e.IDs()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	current := e.keys.CurrentKeyID()
+	rewrapped := 0
+	for _, id := range ids {
+		done, err := e.rewrap(id, current)
+		if err != nil {
+			return rewrapped, err
+		}
+		if done {
+			rewrapped++
+		}
+	}
+	// Every chunk is encrypted now, and Put only stores encrypted ones.
+	e.AllowPlaintext(false)
+	return rewrapped, nil
+}
+
+func (e *EncryptedStore) rewrap(id, current string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stored, storedChecksum, err := e.inner.Get(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read chunk %s: %w", id, err)
+	}
+	data, checksum := stored, storedChecksum
+	if strings.HasPrefix(storedChecksum, encryptedChecksumPrefix) {
+		h, err := parseEnvelope(stored)
+		if err != nil {
+			return false, fmt.Errorf("chunk %s: %w", id, err)
+		}
+		if h.keyID == current {
+			return false, nil
+		}
+		data, checksum, err = e.open(id, stored)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt chunk %s: %w", id, err)
+		}
+	}
+	envelope, err := e.seal(id, data, checksum)
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt chunk %s: %w", id, err)
+	}
+	if err := e.inner.Put(id, envelope, encryptedChecksumPrefix+Checksum(envelope)); err != nil {
+		return false, err
+	}
+	log.Printf("Re-encrypted chunk %s with key %s", id, current)
+	return true, nil
+}
+
+// envelopeHeader is the part of an envelope before the ciphertext.
+type envelopeHeader struct {
+	keyID      string
+	wrappedKey []byte
+	nonce      []byte
+	// length of the header, which is authenticated with the chunk ID.
+	length int
+}
+
+func (e *EncryptedStore) seal(id string, data []byte, checksum string) ([]byte, error) {
+	if len(checksum) > math.MaxUint16 {
+		return nil, errors.New("checksum too long")
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := e.keys.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	if len(keyID) > math.MaxUint8 || len(wrapped) > math.MaxUint16 {
+		return nil, errors.New("key ID or wrapped data key too long")
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(envelopeMagic) + 2 + len(keyID) + 2 + len(wrapped) + nonceSize
+	plaintextLen := 2 + len(checksum) + len(data)
+	buf := make([]byte, 0, headerLen+plaintextLen+aead.Overhead())
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, envelopeVersion, byte(len(keyID)))
+	buf = append(buf, keyID...)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(wrapped)))
+	buf = append(buf, wrapped...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	buf = append(buf, nonce...)
+
+	plaintext := make([]byte, 0, plaintextLen)
+	plaintext = binary.LittleEndian.AppendUint16(plaintext, uint16(len(checksum)))
+	plaintext = append(plaintext, checksum...)
+	plaintext = append(plaintext, data...)
+	return aead.Seal(buf, nonce, plaintext, additionalData(id, buf)), nil
+}
+
+func (e *EncryptedStore) open(id string, envelope []byte) ([]byte, string, error) {
+	h, err := parseEnvelope(envelope)
+	if err != nil {
+		return nil, "", err
+	}
+	dataKey, err := e.keys.UnwrapKey(h.keyID, h.wrappedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err := aead.Open(nil, h.nonce, envelope[h.length:], additionalData(id, envelope[:h.length]))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(plaintext) < 2 {
+		return nil, "", errors.New("truncated plaintext")
+	}
+	checksumLen := int(binary.LittleEndian.Uint16(plaintext))
+	if len(plaintext) < 2+checksumLen {
+		return nil, "", errors.New("truncated plaintext")
+	}
+	return plaintext[2+checksumLen:], string(plaintext[2 : 2+checksumLen]), nil
+}
+
+func parseEnvelope(envelope []byte) (envelopeHeader, error) {
+	var h envelopeHeader
+	b := envelope
+	if len(b) < len(envelopeMagic)+2 || string(b[:len(envelopeMagic)]) != envelopeMagic {
+		return h, errors.New("not an encrypted chunk")
+	}
+	b = b[len(envelopeMagic):]
+	if b[0] != envelopeVersion {
+		return h, fmt.Errorf("unsupported envelope version %d", b[0])
+	}
+	keyIDLen := int(b[1])
+	b = b[2:]
+	if len(b) < keyIDLen+2 {
+		return h, errors.New("truncated envelope")
+	}
+	h.keyID = string(b[:keyIDLen])
+	wrappedLen := int(binary.LittleEndian.Uint16(b[keyIDLen:]))
+	b = b[keyIDLen+2:]
+	if len(b) < wrappedLen+nonceSize {
+		return h, errors.New("truncated envelope")
+	}
+	h.wrappedKey = b[:wrappedLen]
+	h.nonce = b[wrappedLen : wrappedLen+nonceSize]
+	h.length = len(envelope) - len(b) + wrappedLen + nonceSize
+	return h, nil
+}
+
+// additionalData binds a ciphertext to its chunk ID and envelope header.
+func additionalData(id string, header []byte) []byte {
+	ad := make([]byte, 0, 1+len(id)+len(header))
+	ad = append(ad, byte(len(id)))
+	ad = append(ad, id...)
+	return append(ad, header...)
+}
@@ -0,0 +1,162 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newEncryptedStore(t *testing.T, keyPath string) (*EncryptedStore, *DiskStore) {
+	t.Helper()
+	inner, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptedStore(inner, loadKeyFile(t, keyPath)), inner
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	e, inner := newEncryptedStore(t, newKeyFile(t))
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	mustPut(t, e, "a", data)
+	mustPut(t, e, "empty", nil)
+	mustGet(t, e, "a", data)
+	mustGet(t, e, "empty", nil)
+
+	stored, checksum, err := inner.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, data) || strings.Contains(string(stored), Checksum(data)) {
+		t.Fatal("inner store holds the plaintext or its checksum")
+	}
+	if checksum != encryptedChecksumPrefix+Checksum(stored) {
+		t.Fatalf("inner checksum = %q, want the checksum of the envelope", checksum)
+	}
+}
+
+func TestEncryptedStoreDetectsTampering(t *testing.T) {
+	e, inner := newEncryptedStore(t, newKeyFile(t))
+	mustPut(t, e, "a", []byte("first chunk"))
+	mustPut(t, e, "b", []byte("second chunk"))
+	envelope, _, err := inner.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := parseEnvelope(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// storeEnvelope writes an envelope under id with a matching checksum, so
+	// that only the decryption can notice the change.
+	storeEnvelope := func(id string, envelope []byte) {
+		t.Helper()
+		if err := inner.Put(id, envelope, encryptedChecksumPrefix+Checksum(envelope)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, offset := range map[string]int{
+		"ciphertext":  len(envelope) - 20,
+		"tag":         len(envelope) - 1,
+		"nonce":       h.length - 1,
+		"wrapped key": h.length - nonceSize - 1,
+	} {
+		tampered := bytes.Clone(envelope)
+		tampered[offset] ^= 1
+		storeEnvelope("a", tampered)
+		if _, _, err := e.Get("a"); err == nil {
+			t.Errorf("chunk with a changed %s was decrypted", name)
+		}
+	}
+
+	// An envelope moved to another chunk ID fails to authenticate.
+	storeEnvelope("b", envelope)
+	if _, _, err := e.Get("b"); err == nil {
+		t.Error("envelope of chunk a was decrypted as chunk b")
+	}
+	storeEnvelope("a", envelope)
+	mustGet(t, e, "a", []byte("first chunk"))
+}
+
+func TestEncryptedStoreRewrap(t *testing.T) {
+	keyPath := newKeyFile(t)
+	e, inner := newEncryptedStore(t, keyPath)
+	mustPut(t, inner, "plain", []byte("written before encryption"))
+	mustPut(t, e, "old", []byte("written with the first key"))
+	e.AllowPlaintext(true)
+	mustGet(t, e, "plain", []byte("written before encryption"))
+
+	// Rotate: add a key and reopen with it as the current key.
+	first := loadKeyFile(t, keyPath).CurrentKeyID()
+	if _, err := AddKey(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	e = NewEncryptedStore(inner, loadKeyFile(t, keyPath))
+	e.AllowPlaintext(true)
+	current := e.keys.CurrentKeyID()
+	mustPut(t, e, "new", []byte("written with the second key"))
+	mustGet(t, e, "old", []byte("written with the first key"))
+
+	n, err := e.Rewrap()
+	if err != nil {
+		t.Fatalf("Rewrap: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Rewrap rewrote %d chunks, want the plaintext chunk and the one under the old key", n)
+	}
+	for _, id := range []string{"plain", "old", "new"} {
+		stored, _, err := inner.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := parseEnvelope(stored)
+		if err != nil || h.keyID != current {
+			t.Errorf("chunk %s after Rewrap: key %q, %v; want key %s", id, h.keyID, err, current)
+		}
+	}
+	if n, err := e.Rewrap(); err != nil || n != 0 {
+		t.Fatalf("second Rewrap = %d, %v; want nothing left to rewrite", n, err)
+	}
+
+	// Once rewrapped, the first key can be retired.
+	contents, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(contents), "\n")
+	if !strings.HasPrefix(lines[0], first+" ") {
+		t.Fatalf("first line of the key file = %q, want key %s", lines[0], first)
+	}
+	if err := os.WriteFile(keyPath, []byte(strings.Join(lines[1:], "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e = NewEncryptedStore(inner, loadKeyFile(t, keyPath))
+	mustGet(t, e, "plain", []byte("written before encryption"))
+	mustGet(t, e, "old", []byte("written with the first key"))
+	mustGet(t, e, "new", []byte("written with the second key"))
+}
+
+func TestEncryptedStoreRejectsPlaintext(t *testing.T) {
+	e, inner := newEncryptedStore(t, newKeyFile(t))
+	mustPut(t, inner, "plain", []byte("written before encryption"))
+	if _, _, err := e.Get("plain"); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("Get of a plaintext chunk = %v, want ErrNotEncrypted", err)
+	}
+
+	e.AllowPlaintext(true)
+	mustGet(t, e, "plain", []byte("written before encryption"))
+	if _, err := e.Rewrap(); err != nil {
+		t.Fatal(err)
+	}
+	mustGet(t, e, "plain", []byte("written before encryption"))
+
+	// Once everything is encrypted, a chunk swapped for plaintext in the
+	// inner store is refused rather than served unauthenticated.
+	mustPut(t, inner, "plain", []byte("slipped in"))
+	if _, _, err := e.Get("plain"); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("Get of a chunk replaced in plaintext after Rewrap = %v, want ErrNotEncrypted", err)
+	}
+}
@@ -0,0 +1,139 @@
+package chunk
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// KeyManager protects the data keys that EncryptedStore encrypts chunks
+// with. KeyFile keeps the master keys in a local file; a client of an
+// external KMS can implement it instead, so that master keys never reach the
+// storage node.
+type KeyManager interface {
+	// CurrentKeyID names the key WrapKey uses. Chunks whose data key was
+	// wrapped with another key are re-encrypted by EncryptedStore.Rewrap.
+	CurrentKeyID() string
+	// WrapKey encrypts a data key with the current key.
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key that WrapKey wrapped with keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KeyFile is a KeyManager whose keys are kept in a local file, one per line
+// as "<id> <64 hex digits>" for a 256-bit AES key. The last key is the
+// current one; earlier keys only decrypt chunks written before a later one
+// was added. Empty lines and lines starting with # are ignored.
+type KeyFile struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// LoadKeyFile reads the keys in path.
+func LoadKeyFile(path string) (*KeyFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer f.Close()
+
+	k := &KeyFile{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, hexKey, ok := strings.Cut(line, " ")
+		if !ok || id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("%s:%d: expected a key ID and a hex-encoded key", path, n)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(hexKey))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s:%d: key %s is not 64 hex digits", path, n, id)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key ID %s", path, n, id)
+		}
+		if k.keys[id], err = newGCM(key); err != nil {
+			return nil, err
+		}
+		k.current = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if k.current == "" {
+		return nil, fmt.Errorf("key file %s holds no keys", path)
+	}
+	return k, nil
+}
+
+// AddKey appends a new random key to the key file at path, creating it if
+// needed, and returns its ID. The new key becomes the current one the next
+// time the file is loaded.
+func AddKey(path string) (string, error) {
+	// The random suffix keeps IDs unique when keys are added in quick
+	// succession.
+	key := make([]byte, 34)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	id := time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(key[32:])
+	key = key[:32]
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", id, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		return "", err
+	}
+	return id, f.Close()
+}
+
+func (k *KeyFile) CurrentKeyID() string {
+	return k.current
+}
+
+// WrapKey seals dataKey with AES-GCM under the current key, with the key ID
+// as additional data.
+func (k *KeyFile) WrapKey(dataKey []byte) (string, []byte, error) {
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.current, aead.Seal(nonce, nonce, dataKey, []byte(k.current)), nil
+}
+
+func (k *KeyFile) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %q: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,86 @@
+package chunk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadKeyFile(t *testing.T, path string) *KeyFile {
+	t.Helper()
+	k, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile: %v", err)
+	}
+	return k
+}
+
+// newKeyFile creates a key file with one key and returns its path.
+func newKeyFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if _, err := AddKey(path); err != nil {
+		t.Fatalf("AddKey: %v", err)
+	}
+	return path
+}
+
+func TestKeyFileRotation(t *testing.T) {
+	path := newKeyFile(t)
+	old := loadKeyFile(t, path)
+	dataKey := bytes.Repeat([]byte{7}, dataKeySize)
+	oldID, wrapped, err := old.WrapKey(dataKey)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	if oldID != old.CurrentKeyID() {
+		t.Fatalf("WrapKey used key %s, want the current key %s", oldID, old.CurrentKeyID())
+	}
+
+	newID, err := AddKey(path)
+	if err != nil {
+		t.Fatalf("AddKey: %v", err)
+	}
+	k := loadKeyFile(t, path)
+	if k.CurrentKeyID() != newID {
+		t.Fatalf("current key = %s, want the added key %s", k.CurrentKeyID(), newID)
+	}
+	// Earlier keys still unwrap the data keys they wrapped.
+	got, err := k.UnwrapKey(oldID, wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("UnwrapKey with the previous key = %x, %v", got, err)
+	}
+	if _, err := k.UnwrapKey(newID, wrapped); err == nil {
+		t.Fatal("data key unwrapped with a key other than the one that wrapped it")
+	}
+	if _, err := k.UnwrapKey("missing", wrapped); err == nil {
+		t.Fatal("data key unwrapped with an unknown key")
+	}
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := k.UnwrapKey(oldID, wrapped); err == nil {
+		t.Fatal("tampered wrapped key was unwrapped")
+	}
+}
+
+func TestLoadKeyFileRejectsBadFiles(t *testing.T) {
+	const key = "0000000000000000000000000000000000000000000000000000000000000000"
+	for name, contents := range map[string]string{
+		"empty":        "# no keys yet\n\n",
+		"no key":       "k1\n",
+		"short key":    "k1 " + key[:62] + "\n",
+		"not hex":      "k1 " + strings.Repeat("g", 64) + "\n",
+		"duplicate ID": "k1 " + key + "\nk1 " + key + "\n",
+		"long key ID":  strings.Repeat("k", 256) + " " + key + "\n",
+		"missing ID":   " " + key + "\n",
+	} {
+		path := filepath.Join(t.TempDir(), "keys")
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeyFile(path); err == nil {
+			t.Errorf("%s: LoadKeyFile succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultSegmentSize is the size at which LogStore seals the active segment
+// and starts a new one.
+const DefaultSegmentSize = 256 << 20
+
+const (
+	segmentSuffix = ".seg"
+
+	recordMagic     = 0x64667363 // "dfsc"
+	recordHeaderLen = 4 + 1 + 2 + 2 + 4 + 4
+
+	recordPut       byte = 1
+	recordTombstone byte = 2
+
+	// compactRatio is the fraction of dead bytes above which a sealed
+	// segment is rewritten by Compact.
+	compactRatio = 0.5
+)
+
+// LogStore packs chunks into large append-only segment files, in the style of
+// Haystack. Each record carries its own header and CRC, an in-memory index
+// maps chunk IDs to their latest record, and deletes append tombstones.
+// Compact rewrites the live records of mostly dead segments and removes them.
+//
+// Record layout (little endian):
+//
+//	magic uint32 | kind uint8 | idLen uint16 | checksumLen uint16 |
+//	dataLen uint32 | crc32 uint32 | id | checksum | data
+//
+// The CRC covers everything after the crc field.
+type LogStore struct {
+	baseDir     string
+	segmentSize int64
+
+	mu       sync.RWMutex
+	index    map[string]location
+	segments map[uint32]*segment
+	active   *segment
+	// failed is set when a failed append could not be cut off the active
+	// segment. The store refuses to write until it is reopened, which
+	// truncates the torn record.
+	failed error
+}
+
+type location struct {
+	segment uint32
+	offset  int64
+	length  int64
+}
+
+type segment struct {
+	id   uint32
+	file segmentFile
+	size int64
+	dead int64
+}
+
+// segmentFile is the part of *os.File a segment is read and written through.
+type segmentFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+type record struct {
+	kind     byte
+	id       string
+	checksum string
+	data     []byte
+}
+
+func NewLogStore(baseDir string, segmentSize int64) (*LogStore, error) {
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base directory: %w", err)
+	}
+	l := &LogStore{
+		baseDir:     baseDir,
+		segmentSize: segmentSize,
+		index:       make(map[string]location),
+		segments:    make(map[uint32]*segment),
+	}
+	if err := l.load(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogStore) Put(id string, data []byte, checksum string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	if len(checksum) > math.MaxUint16 || int64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("chunk %s is too large for a log record", id)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	loc, err := l.append(record{kind: recordPut, id: id, checksum: checksum, data: data})
+	if err != nil {
+		return err
+	}
+	if old, ok := l.index[id]; ok {
+		l.segments[old.segment].dead += old.length
+	}
+	l.index[id] = loc
+	return nil
+}
+
+func (l *LogStore) Get(id string) ([]byte, string, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, "", err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	loc, ok := l.index[id]
+	if !ok {
+		return nil, "", fmt.Errorf("chunk %s: %w", id, fs.ErrNotExist)
+	}
+	rec, err := l.segments[loc.segment].readAt(loc.offset)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read chunk %s: %w", id, err)
+	}
+	return rec.data, rec.checksum, nil
+}
+
+func (l *LogStore) Delete(id string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old, ok := l.index[id]
+	if !ok {
+		return fmt.Errorf("chunk %s: %w", id, fs.ErrNotExist)
+	}
+	loc, err := l.append(record{kind: recordTombstone, id: id})
+	if err != nil {
+		return err
+	}
+	l.segments[old.segment].dead += old.length
+	l.segments[loc.segment].dead += loc.length
+	delete(l.index, id)
+	return nil
+}
+
+// IDs returns the IDs of all chunks in the store.
+func (l *LogStore) IDs() ([]string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	ids := make([]string, 0, len(l.index))
+	for id := range l.index {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Compact rewrites every sealed segment whose dead bytes exceed half its size
+// into the active segment and removes it. Tombstones are only carried over
+// while an older segment that might still hold the deleted chunk exists.
+func (l *LogStore) Compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, id := range l.sortedSegmentIDs() {
+		seg := l.segments[id]
+		if seg == l.active || seg.size == 0 || float64(seg.dead)/float64(seg.size) < compactRatio {
+			continue
+		}
+		if err := l.compactSegment(seg); err != nil {
+			return fmt.Errorf("failed to compact segment %d: %w", seg.id, err)
+		}
+	}
+	return nil
+}
+
+func (l *LogStore) compactSegment(seg *segment) error {
+	hasOlder := false
+	for id := range l.segments {
+		if id < seg.id {
+			hasOlder = true
+			break
+		}
+	}
+
+	var offset int64
+	for offset < seg.size {
+		rec, err := seg.readAt(offset)
+		if err != nil {
+			return err
+		}
+		length := recordLength(rec)
+		switch rec.kind {
+		case recordPut:
+			if loc, ok := l.index[rec.id]; ok && loc.segment == seg.id && loc.offset == offset {
+				newLoc, err := l.append(rec)
+				if err != nil {
+					return err
+				}
+				l.index[rec.id] = newLoc
+			}
+		case recordTombstone:
+			if _, live := l.index[rec.id]; hasOlder && !live {
+				newLoc, err := l.append(rec)
+				if err != nil {
+					return err
+				}
+				l.segments[newLoc.segment].dead += newLoc.length
+			}
+		}
+		offset += length
+	}
+
+	delete(l.segments, seg.id)
+	seg.file.Close()
+	return os.Remove(l.segmentPath(seg.id))
+}
+
+func (l *LogStore) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, seg := range l.segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// load opens every segment in order and rebuilds the index. A torn record at
+// the end of the newest segment, left by a crash mid-append, is truncated.
+func (l *LogStore) load() error {
+	entries, err := os.ReadDir(l.baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 32)
+		if err != nil {
+			continue
+		}
+		file, err := os.OpenFile(filepath.Join(l.baseDir, name), os.O_RDWR, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open segment %s: %w", name, err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		l.segments[uint32(id)] = &segment{id: uint32(id), file: file, size: info.Size()}
+	}
+
+	ids := l.sortedSegmentIDs()
+	for i, id := range ids {
+		if err := l.scan(l.segments[id], i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+
+	if len(ids) == 0 {
+		return l.roll()
+	}
+	l.active = l.segments[ids[len(ids)-1]]
+	return nil
+}
+
+func (l *LogStore) scan(seg *segment, last bool) error {
+	var offset int64
+	for offset < seg.size {
+		rec, err := seg.readAt(offset)
+		if err != nil {
+			if last {
+				seg.size = offset
+				return seg.file.Truncate(offset)
+			}
+			return fmt.Errorf("corrupt record in segment %d at offset %d: %w", seg.id, offset, err)
+		}
+		length := recordLength(rec)
+		if old, ok := l.index[rec.id]; ok {
+			l.segments[old.segment].dead += old.length
+		}
+		switch rec.kind {
+		case recordPut:
+			l.index[rec.id] = location{segment: seg.id, offset: offset, length: length}
+		case recordTombstone:
+			delete(l.index, rec.id)
+			seg.dead += length
+		}
+		offset += length
+	}
+	seg.size = offset
+	return nil
+}
+
+// append writes rec to the active segment, rolling over to a new segment
+// first if the active one is full. The caller must hold l.mu.
+func (l *LogStore) append(rec record) (location, error) {
+	if l.failed != nil {
+		return location{}, l.failed
+	}
+	if l.active.size >= l.segmentSize {
+		if err := l.roll(); err != nil {
+			return location{}, err
+		}
+	}
+	seg := l.active
+	buf := encodeRecord(rec)
+	if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+		return location{}, l.discardTail(seg, fmt.Errorf("failed to append record: %w", err))
+	}
+	if err := seg.file.Sync(); err != nil {
+		return location{}, l.discardTail(seg, fmt.Errorf("failed to sync segment: %w", err))
+	}
+	loc := location{segment: seg.id, offset: seg.size, length: int64(len(buf))}
+	seg.size += int64(len(buf))
+	return loc, nil
+}
+
+// discardTail cuts whatever a failed append left after the last good record
+// off seg, so that the segment is not sealed with a torn record in it, and
+// returns err. If the segment cannot be cut, the store stops accepting
+// writes. The caller must hold l.mu.
+func (l *LogStore) discardTail(seg *segment, err error) error {
+	terr := seg.file.Truncate(seg.size)
+	if terr == nil {
+		terr = seg.file.Sync()
+	}
+	if terr != nil {
+		l.failed = fmt.Errorf("segment %d is unusable after a failed append: %w", seg.id, terr)
+	}
+	return err
+}
+
+func (l *LogStore) roll() error {
+	var next uint32 = 1
+	if l.active != nil {
+		next = l.active.id + 1
+	}
+	file, err := os.OpenFile(l.segmentPath(next), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %w", err)
+	}
+	seg := &segment{id: next, file: file}
+	l.segments[next] = seg
+	l.active = seg
+	return nil
+}
+
+func (l *LogStore) segmentPath(id uint32) string {
+	return filepath.Join(l.baseDir, fmt.Sprintf("%08d%s", id, segmentSuffix))
+}
+
+func (l *LogStore) sortedSegmentIDs() []uint32 {
+	ids := make([]uint32, 0, len(l.segments))
+	for id := range l.segments {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (s *segment) readAt(offset int64) (record, error) {
+	var header [recordHeaderLen]byte
+	if _, err := s.file.ReadAt(header[:], offset); err != nil {
+		return record{}, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != recordMagic {
+		return record{}, errors.New("bad record magic")
+	}
+	kind := header[4]
+	idLen := int(binary.LittleEndian.Uint16(header[5:7]))
+	checksumLen := int(binary.LittleEndian.Uint16(header[7:9]))
+	dataLen := int64(binary.LittleEndian.Uint32(header[9:13]))
+	crc := binary.LittleEndian.Uint32(header[13:17])
+
+	if offset+recordHeaderLen+int64(idLen+checksumLen)+dataLen > s.size {
+		return record{}, io.ErrUnexpectedEOF
+	}
+	body := make([]byte, int64(idLen+checksumLen)+dataLen)
+	if _, err := s.file.ReadAt(body, offset+recordHeaderLen); err != nil {
+		return record{}, err
+	}
+	h := crc32.NewIEEE()
+	h.Write(header[4:13])
+	h.Write(body)
+	if h.Sum32() != crc {
+		return record{}, errors.New("record checksum mismatch")
+	}
+	return record{
+		kind:     kind,
+		id:       string(body[:idLen]),
+		checksum: string(body[idLen : idLen+checksumLen]),
+		data:     body[idLen+checksumLen:],
+	}, nil
+}
+
+func encodeRecord(rec record) []byte {
+	buf := make([]byte, recordHeaderLen, recordLength(rec))
+	binary.LittleEndian.PutUint32(buf[0:4], recordMagic)
+	buf[4] = rec.kind
+	binary.LittleEndian.PutUint16(buf[5:7], uint16(len(rec.id)))
+	binary.LittleEndian.PutUint16(buf[7:9], uint16(len(rec.checksum)))
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(len(rec.data)))
+	buf = append(buf, rec.id...)
+	buf = append(buf, rec.checksum...)
+	buf = append(buf, rec.data...)
+	h := crc32.NewIEEE()
+	h.Write(buf[4:13])
+	h.Write(buf[recordHeaderLen:])
+	binary.LittleEndian.PutUint32(buf[13:17], h.Sum32())
+	return buf
+}
+
+func recordLength(rec record) int64 {
+	return int64(recordHeaderLen + len(rec.id) + len(rec.checksum) + len(rec.data))
+}
@@ -0,0 +1,253 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func openLogStore(t *testing.T, dir string, segmentSize int64) *LogStore {
+	t.Helper()
+	l, err := NewLogStore(dir, segmentSize)
+	if err != nil {
+		t.Fatalf("NewLogStore: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func mustGet(t *testing.T, s Store, id string, want []byte) {
+	t.Helper()
+	data, checksum, err := s.Get(id)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", id, err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("Get(%s) = %q, want %q", id, data, want)
+	}
+	if checksum != Checksum(want) {
+		t.Fatalf("Get(%s) checksum = %q, want %q", id, checksum, Checksum(want))
+	}
+}
+
+func mustPut(t *testing.T, s Store, id string, data []byte) {
+	t.Helper()
+	if err := s.Put(id, data, Checksum(data)); err != nil {
+		t.Fatalf("Put(%s): %v", id, err)
+	}
+}
+
+func TestLogStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openLogStore(t, dir, DefaultSegmentSize)
+	mustPut(t, l, "a", []byte("first"))
+	mustPut(t, l, "b", []byte("second"))
+	mustPut(t, l, "a", []byte("replaced"))
+	if err := l.Delete("b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	l.Close()
+
+	l = openLogStore(t, dir, DefaultSegmentSize)
+	mustGet(t, l, "a", []byte("replaced"))
+	if _, _, err := l.Get("b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get(b) after delete and reopen: %v, want ErrNotExist", err)
+	}
+}
+
+func TestLogStoreTornTail(t *testing.T) {
+	dir := t.TempDir()
+	l := openLogStore(t, dir, DefaultSegmentSize)
+	mustPut(t, l, "a", []byte("kept"))
+	mustPut(t, l, "b", []byte("torn by a crash"))
+	path := l.segmentPath(l.active.id)
+	intact := l.index["b"].offset
+	l.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLogStore(t, dir, DefaultSegmentSize)
+	mustGet(t, l, "a", []byte("kept"))
+	if _, _, err := l.Get("b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get(b) after torn write: %v, want ErrNotExist", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != intact {
+		t.Fatalf("segment size after recovery = %d, want %d (err %v)", info.Size(), intact, err)
+	}
+	// Appends continue where the intact records end.
+	mustPut(t, l, "c", []byte("after recovery"))
+	l.Close()
+	l = openLogStore(t, dir, DefaultSegmentSize)
+	mustGet(t, l, "c", []byte("after recovery"))
+}
+
+func TestLogStoreCorruptSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := openLogStore(t, dir, 1)
+	mustPut(t, l, "a", []byte("in segment 1"))
+	mustPut(t, l, "b", []byte("in segment 2"))
+	first := l.segmentPath(1)
+	l.Close()
+
+	f, err := os.OpenFile(first, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, recordHeaderLen+1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Only the newest segment may have a torn tail; damage elsewhere is
+	// reported rather than truncated away.
+	if l, err := NewLogStore(dir, 1); err == nil {
+		l.Close()
+		t.Fatal("NewLogStore succeeded with a corrupt sealed segment")
+	}
+}
+
+func TestLogStoreCompactWithTombstones(t *testing.T) {
+	dir := t.TempDir()
+	// Every record rolls over to a new segment, so each one is sealed.
+	l := openLogStore(t, dir, 1)
+	mustPut(t, l, "keep", []byte("live data"))    // segment 1
+	mustPut(t, l, "gone", []byte("deleted data")) // segment 2
+	mustPut(t, l, "other", []byte("more data"))   // segment 3
+	if err := l.Delete("gone"); err != nil {      // tombstone in segment 4
+		t.Fatal(err)
+	}
+	mustPut(t, l, "other", []byte("newer data")) // segment 5
+
+	if err := l.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	for _, id := range []uint32{2, 3} {
+		if _, ok := l.segments[id]; ok {
+			t.Errorf("dead segment %d survived compaction", id)
+		}
+		if _, err := os.Stat(l.segmentPath(id)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("file of dead segment %d survived compaction: %v", id, err)
+		}
+	}
+	if _, ok := l.segments[1]; !ok {
+		t.Error("segment 1 with a live record was compacted")
+	}
+	mustGet(t, l, "keep", []byte("live data"))
+	mustGet(t, l, "other", []byte("newer data"))
+	l.Close()
+
+	// The tombstone must keep "gone" deleted as long as a segment that held
+	// it could still be replayed.
+	l = openLogStore(t, dir, 1)
+	if _, _, err := l.Get("gone"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get(gone) after compaction and reopen: %v, want ErrNotExist", err)
+	}
+	mustGet(t, l, "keep", []byte("live data"))
+	mustGet(t, l, "other", []byte("newer data"))
+}
+
+func TestLogStoreCompactCarriesTombstoneForOlderSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := openLogStore(t, dir, 1)
+	mustPut(t, l, "gone", []byte("old copy"))  // segment 1, kept below
+	mustPut(t, l, "pin", []byte("pins seg 2")) // segment 2
+	if err := l.Delete("gone"); err != nil {   // tombstone in segment 3
+		t.Fatal(err)
+	}
+	mustPut(t, l, "pin", []byte("newer")) // segment 4
+
+	// Make segment 1 look live so that it is not compacted, leaving an older
+	// segment that still holds the deleted record.
+	l.segments[1].dead = 0
+	if err := l.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if _, ok := l.segments[3]; ok {
+		t.Fatal("segment 3 holding only a tombstone was not compacted")
+	}
+	l.Close()
+
+	l = openLogStore(t, dir, 1)
+	if _, _, err := l.Get("gone"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get(gone) = %v, want ErrNotExist: the tombstone was dropped", err)
+	}
+	mustGet(t, l, "pin", []byte("newer"))
+}
+
+// faultySegment writes half of each record and fails while fail is set, and
+// fails to truncate while stuck is set.
+type faultySegment struct {
+	segmentFile
+	fail, stuck bool
+}
+
+func (f *faultySegment) WriteAt(p []byte, off int64) (int, error) {
+	if f.fail {
+		n, _ := f.segmentFile.WriteAt(p[:len(p)/2], off)
+		return n, errors.New("no space left on device")
+	}
+	return f.segmentFile.WriteAt(p, off)
+}
+
+func (f *faultySegment) Truncate(size int64) error {
+	if f.stuck {
+		return errors.New("read-only file system")
+	}
+	return f.segmentFile.Truncate(size)
+}
+
+func TestLogStoreFailedAppendIsDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	l := openLogStore(t, dir, 100)
+	mustPut(t, l, "a", []byte("small"))
+	faulty := &faultySegment{segmentFile: l.active.file, fail: true}
+	l.active.file = faulty
+	if err := l.Put("big", bytes.Repeat([]byte("x"), 400), "sum"); err == nil {
+		t.Fatal("Put succeeded with a failing write")
+	}
+	faulty.fail = false
+
+	// The next record is shorter than what the failed one left behind and
+	// fills the segment, which is sealed before the one after it.
+	mustPut(t, l, "b", bytes.Repeat([]byte("b"), 80))
+	mustPut(t, l, "c", []byte("next segment"))
+	if len(l.segments) != 2 {
+		t.Fatalf("%d segments, want the first sealed and a second", len(l.segments))
+	}
+	l.Close()
+
+	l = openLogStore(t, dir, 100)
+	mustGet(t, l, "a", []byte("small"))
+	mustGet(t, l, "b", bytes.Repeat([]byte("b"), 80))
+	mustGet(t, l, "c", []byte("next segment"))
+	if _, _, err := l.Get("big"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get(big) = %v, want ErrNotExist", err)
+	}
+}
+
+func TestLogStoreRefusesWritesAfterUndiscardedFailure(t *testing.T) {
+	dir := t.TempDir()
+	l := openLogStore(t, dir, DefaultSegmentSize)
+	l.active.file = &faultySegment{segmentFile: l.active.file, fail: true, stuck: true}
+	if err := l.Put("a", []byte("torn"), "sum"); err == nil {
+		t.Fatal("Put succeeded with a failing write")
+	}
+	l.active.file.(*faultySegment).fail = false
+	if err := l.Put("b", []byte("after"), "sum"); err == nil {
+		t.Fatal("Put after a torn record that could not be cut off succeeded")
+	}
+	l.Close()
+
+	// Reopening cuts off the torn record.
+	l = openLogStore(t, dir, DefaultSegmentSize)
+	mustPut(t, l, "b", []byte("after"))
+	mustGet(t, l, "b", []byte("after"))
+}
@@ -0,0 +1,35 @@
+package chunk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Scrub reads every chunk in s and compares it with the checksum stored
+// along with it, returning the IDs of the chunks that do not match or cannot
+// be read. s is the store that holds the bytes on disk: given the inner store
+// of an EncryptedStore, Scrub checks the ciphertext and needs no key. s must
+// implement Lister.
+func Scrub(s Store) (checked int, corrupt []string, err error) {
+	lister, ok := s.(Lister)
+	if !ok {
+		return 0, nil, errors.New("store cannot list its chunks")
+	}
+	ids, err := lister.IDs()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	for _, id := range ids {
+		data, checksum, err := s.Get(id)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		checked++
+		if err != nil || Checksum(data) != strings.TrimPrefix(checksum, encryptedChecksumPrefix) {
+			corrupt = append(corrupt, id)
+		}
+	}
+	return checked, corrupt, nil
+}
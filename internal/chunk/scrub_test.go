@@ -0,0 +1,55 @@
+package chunk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	e, inner := newEncryptedStore(t, newKeyFile(t))
+	mustPut(t, inner, "plain", []byte("plaintext chunk"))
+	mustPut(t, inner, "plain-bad", []byte("plaintext chunk"))
+	mustPut(t, e, "sealed", []byte("encrypted chunk"))
+	mustPut(t, e, "sealed-bad", []byte("encrypted chunk"))
+
+	// Flip a bit of each bad chunk but keep its checksum.
+	for _, id := range []string{"plain-bad", "sealed-bad"} {
+		data, checksum, err := inner.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = bytes.Clone(data)
+		data[len(data)-1] ^= 1
+		if err := inner.Put(id, data, checksum); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Scrubbing the inner store needs no key.
+	checked, corrupt, err := Scrub(inner)
+	if err != nil {
+		t.Fatalf("Scrub: %v", err)
+	}
+	if checked != 4 {
+		t.Errorf("Scrub checked %d chunks, want 4", checked)
+	}
+	if !equalIDs(corrupt, "plain-bad", "sealed-bad") {
+		t.Errorf("Scrub found %v corrupt, want plain-bad and sealed-bad", corrupt)
+	}
+}
+
+func equalIDs(got []string, want ...string) bool {
+	seen := make(map[string]bool)
+	for _, id := range got {
+		seen[id] = true
+	}
+	if len(seen) != len(got) || len(got) != len(want) {
+		return false
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,64 +1,75 @@
 package chunk
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 )
 
+const maxIDLength = 128
+
+// ErrInvalidID is returned for chunk IDs that could escape the store's base
+// directory or otherwise don't look like IDs generated by the coordinator.
+var ErrInvalidID = errors.New("invalid chunk id")
+
 type Store interface {
 	Put(id string, data []byte, checksum string) error
 	Get(id string) ([]byte, string, error)
 	Delete(id string) error
 }
 
-type DiskStore struct {
-	baseDir string
+// Compactor is implemented by stores that accumulate garbage on delete and
+// need to reclaim it in the background.
+type Compactor interface {
+	Compact() error
 }
 
-func NewDiskStore(baseDir string) (*DiskStore, error) {
-	if err := os.MkdirAll(baseDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create base directory: %w", err)
-	}
-	return &DiskStore{baseDir: baseDir}, nil
+// Lister is implemented by stores that can enumerate their chunks, which
+// scrubbing and re-encrypting them need.
+type Lister interface {
+	IDs() ([]string, error)
 }
 
-func (d *DiskStore) Put(id string, data []byte, checksum string) error {
-	path := filepath.Join(d.baseDir, id)
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return err
-	}
-	checksumPath := filepath.Join(d.baseDir, id+".checksum")
+// Storage engines accepted by Open.
+const (
+	EngineDisk = "disk"
+	EngineLog  = "log"
+)
 
-	if err := os.WriteFile(checksumPath, []byte(checksum), 0644); err != nil {
-		return err
+// Open creates the store implementation named by engine in baseDir. An empty
+// engine selects EngineDisk.
+func Open(engine, baseDir string) (Store, error) {
+	switch engine {
+	case "", EngineDisk:
+		return NewDiskStore(baseDir)
+	case EngineLog:
+		return NewLogStore(baseDir, DefaultSegmentSize)
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", engine)
 	}
-	return nil
 }
 
-func (d *DiskStore) Get(id string) ([]byte, string, error) {
-	path := filepath.Join(d.baseDir, id)
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, "", err
+// ValidateID accepts only non-empty IDs of at most 128 ASCII letters, digits,
+// '-' and '_', so an ID can never contain a path separator or a dot segment.
+func ValidateID(id string) error {
+	if id == "" || len(id) > maxIDLength {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
 	}
-	checksumPath := filepath.Join(d.baseDir, id+".checksum")
-	checksum, err := os.ReadFile(checksumPath)
-	if err != nil {
-		return nil, "", err
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidID, id)
+		}
 	}
-	return data, string(checksum), nil
+	return nil
 }
 
-func (d *DiskStore) Delete(id string) error {
-	path := filepath.Join(d.baseDir, id)
-	if err := os.Remove(path); err != nil {
-		return err
-	}
-	checksumPath := filepath.Join(d.baseDir, id+".checksum")
-
-	if err := os.Remove(checksumPath); err != nil {
-		return err
-	}
-	return nil
+// Checksum returns the hex-encoded SHA-256 of data, the form of checksum the
+// coordinator stores chunks with.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,298 @@
+package coordinator
+
+import (
+	"context"
+	"log"
+	"path"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// With authentication enabled, files and directories carry an owner, a group
+// and POSIX-like mode bits. Reading a file needs its read bit and changing,
+// moving or deleting it its write bit. Creating a file needs the write bit
+// of the nearest directory with permissions, or of the file it replaces.
+// Files and directories without permissions, such as those created before
+// authentication was enabled, are open to everyone.
+
+// caller returns the identity of the client. It fails if authentication is
+// enabled and the call carries none, and returns nil if it is disabled.
+func (s *Server) caller(ctx context.Context) (*auth.Identity, error) {
+	if !s.authenticate {
+		return nil, nil
+	}
+	id := auth.FromContext(ctx)
+	if id == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "no identity for call")
+	}
+	return id, nil
+}
+
+func allowed(id *auth.Identity, perm *pbmeta.Permissions, access auth.Access) bool {
+	return id == nil || perm == nil || id.Allowed(perm.Owner, perm.Group, perm.Mode, access)
+}
+
+// checkAccess fails with codes.PermissionDenied unless the caller has access
+// to the file.
+func (s *Server) checkAccess(ctx context.Context, meta *pbmeta.FileMetadata, access auth.Access) error {
+	id, err := s.caller(ctx)
+	if err != nil {
+		return err
+	}
+	if !allowed(id, meta.Permissions, access) {
+		return status.Errorf(codes.PermissionDenied, "%s may not %s %s", id.User, accessVerb(access), meta.Path)
+	}
+	return nil
+}
+
+func accessVerb(access auth.Access) string {
+	if access&auth.Write != 0 {
+		return "write"
+	}
+	return "read"
+}
+
+// canRead reports whether the caller may read meta, for filtering listings.
+func (s *Server) canRead(ctx context.Context, meta *pbmeta.FileMetadata) bool {
+	return s.checkAccess(ctx, meta, auth.Read) == nil
+}
+
+// directoryPermissions returns the permissions that govern creating files in
+// dir: those of dir or its nearest ancestor that has them.
+func (s *Server) directoryPermissions(ctx context.Context, dir string) (*pbmeta.Permissions, error) {
+	resp, err := s.metadataClient.GetDirectory(ctx, &pbmeta.GetDirectoryRequest{Path: dir, Inherit: true})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Directory.Permissions, nil
+}
+
+// newFilePermissions checks that the caller may create a file at filePath
+// and returns the permissions the file gets: those of the file it replaces,
+// or the caller as owner with the directory's group and the requested mode.
+// existing is the file that existingFile returned for filePath, if any.
+func (s *Server) newFilePermissions(ctx context.Context, filePath string, existing *pbmeta.FileMetadata, mode uint32) (*pbmeta.Permissions, error) {
+	id, err := s.caller(ctx)
+	if err != nil || id == nil {
+		return nil, err
+	}
+	if existing != nil {
+		if err := s.checkAccess(ctx, existing, auth.Write); err != nil {
+			return nil, err
+		}
+		if existing.Permissions != nil {
+			return existing.Permissions, nil
+		}
+	}
+	dirPerm, err := s.directoryPermissions(ctx, path.Dir(filePath))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up directory permissions: %v", err)
+	}
+	if existing == nil && !allowed(id, dirPerm, auth.Write) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not create files in %s", id.User, path.Dir(filePath))
+	}
+	if mode == 0 {
+		mode = auth.DefaultMode
+	}
+	if mode > 0o777 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mode %o", mode)
+	}
+	group := id.PrimaryGroup()
+	if dirPerm.GetGroup() != "" {
+		group = dirPerm.Group
+	}
+	return &pbmeta.Permissions{Owner: id.User, Group: group, Mode: mode}, nil
+}
+
+// checkWrite fails with codes.PermissionDenied unless the caller may write
+// to the file with fileID.
+func (s *Server) checkWrite(ctx context.Context, fileID string) error {
+	if !s.authenticate {
+		return nil
+	}
+	meta, err := s.getMetadata(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	return s.checkAccess(ctx, meta, auth.Write)
+}
+
+// checkAdmin fails with codes.PermissionDenied unless the caller is an admin.
+func (s *Server) checkAdmin(ctx context.Context) error {
+	id, err := s.caller(ctx)
+	if err != nil {
+		return err
+	}
+	if id != nil && !id.Admin {
+		return status.Errorf(codes.PermissionDenied, "%s is not an admin", id.User)
+	}
+	return nil
+}
+
+// checkDirectoryOwner fails with codes.PermissionDenied unless the caller
+// may change the settings of dir: if it has permissions, the caller must own
+// it, and otherwise be allowed to create files in it.
+func (s *Server) checkDirectoryOwner(ctx context.Context, dir *pbmeta.Directory) error {
+	id, err := s.caller(ctx)
+	if err != nil || id == nil || id.Admin {
+		return err
+	}
+	if dir.Permissions != nil {
+		if dir.Permissions.Owner != id.User {
+			return status.Errorf(codes.PermissionDenied, "only the owner %s may change the settings of %s", dir.Permissions.Owner, dir.Path)
+		}
+		return nil
+	}
+	return s.checkCreate(ctx, dir.Path)
+}
+
+// checkCreate fails with codes.PermissionDenied unless the caller may create
+// files in dir.
+func (s *Server) checkCreate(ctx context.Context, dir string) error {
+	id, err := s.caller(ctx)
+	if err != nil || id == nil {
+		return err
+	}
+	perm, err := s.directoryPermissions(ctx, dir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to look up directory permissions: %v", err)
+	}
+	if !allowed(id, perm, auth.Write) {
+		return status.Errorf(codes.PermissionDenied, "%s may not create files in %s", id.User, dir)
+	}
+	return nil
+}
+
+// fileAtPath returns the live file at filePath, or nil if there is none.
+func (s *Server) fileAtPath(ctx context.Context, filePath string) (*pbmeta.FileMetadata, error) {
+	resp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{Path: filePath})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Metadata, nil
+}
+
+// changePermissions applies a SetPermissions request to current, the
+// permissions of a file or directory, and returns the result. current is
+// nil if it has none yet.
+func changePermissions(id *auth.Identity, current *pbmeta.Permissions, req *pbcoord.SetPermissionsRequest) (*pbmeta.Permissions, error) {
+	perm := &pbmeta.Permissions{Mode: auth.DefaultMode}
+	if current != nil {
+		perm.Owner, perm.Group, perm.Mode = current.Owner, current.Group, current.Mode
+	}
+	if id != nil && !id.Admin {
+		if current != nil && current.Owner != id.User {
+			return nil, status.Errorf(codes.PermissionDenied, "only the owner %s may change the permissions", current.Owner)
+		}
+		if req.GetOwner() != "" && req.GetOwner() != id.User {
+			return nil, status.Errorf(codes.PermissionDenied, "only admins may change the owner")
+		}
+		if req.GetGroup() != "" && !id.InGroup(req.GetGroup()) {
+			return nil, status.Errorf(codes.PermissionDenied, "%s is not a member of group %s", id.User, req.GetGroup())
+		}
+	}
+	if current == nil && id != nil {
+		perm.Owner, perm.Group = id.User, id.PrimaryGroup()
+	}
+	if req.GetOwner() != "" {
+		perm.Owner = req.GetOwner()
+	}
+	if req.GetGroup() != "" {
+		perm.Group = req.GetGroup()
+	}
+	if req.GetSetMode() {
+		if req.GetMode() > 0o777 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid mode %o", req.GetMode())
+		}
+		perm.Mode = req.GetMode()
+	}
+	if perm.Owner == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "permissions need an owner")
+	}
+	return perm, nil
+}
+
+func (s *Server) SetPermissions(ctx context.Context, req *pbcoord.SetPermissionsRequest) (*pbcoord.SetPermissionsResponse, error) {
+	id, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var perm *pbmeta.Permissions
+	if req.GetFileId() != "" || req.GetFilePath() != "" && req.GetPath() == "" {
+		fileID := req.GetFileId()
+		byPath := ""
+		if fileID == "" {
+			byPath = req.GetFilePath()
+			meta, err := s.lookupFile(ctx, "", byPath)
+			if err != nil {
+				return nil, status.Errorf(codes.NotFound, "file not found: %v", err)
+			}
+			fileID = meta.FileId
+		}
+		_, err = s.updateMetadata(ctx, fileID, false, func(meta *pbmeta.FileMetadata) error {
+			if err := stillAt(meta, byPath); err != nil {
+				return err
+			}
+			if meta.Permissions == nil && id != nil && !id.Admin {
+				return status.Errorf(codes.PermissionDenied, "only admins may take ownership of %s", meta.Path)
+			}
+			var err error
+			perm, err = changePermissions(id, meta.Permissions, req)
+			if err != nil {
+				return err
+			}
+			meta.Permissions = perm
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to set permissions of file %s: %v", fileID, err)
+			return nil, err
+		}
+	} else {
+		if req.GetPath() == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "no file ID or directory given")
+		}
+		dir := cleanPath(req.GetPath(), "")
+		if isSnapshotPath(dir) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is in a read-only snapshot", dir)
+		}
+		settings := &pbmeta.Directory{Path: dir}
+		resp, err := s.metadataClient.GetDirectory(ctx, &pbmeta.GetDirectoryRequest{Path: dir})
+		switch {
+		case err == nil:
+			settings = resp.Directory
+		case status.Code(err) != codes.NotFound:
+			return nil, err
+		}
+		// Claiming a directory is like creating a file in it.
+		if settings.Permissions == nil && dir == "/" {
+			err = s.checkAdmin(ctx)
+		} else if settings.Permissions == nil {
+			err = s.checkCreate(ctx, dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		perm, err = changePermissions(id, settings.Permissions, req)
+		if err != nil {
+			return nil, err
+		}
+		settings.Permissions = perm
+		log.Printf("Setting permissions of directory %s: owner %s, group %s, mode %o", dir, perm.Owner, perm.Group, perm.Mode)
+		if _, err := s.metadataClient.SetDirectory(ctx, &pbmeta.SetDirectoryRequest{Directory: settings}); err != nil {
+			return nil, err
+		}
+	}
+	return &pbcoord.SetPermissionsResponse{Owner: perm.Owner, Group: perm.Group, Mode: perm.Mode}, nil
+}
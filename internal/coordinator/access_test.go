@@ -0,0 +1,107 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckAccess(t *testing.T) {
+	s := &Server{authenticate: true}
+	alice := &auth.Identity{User: "alice", Groups: []string{"staff"}}
+	bob := &auth.Identity{User: "bob", Groups: []string{"staff"}}
+	carol := &auth.Identity{User: "carol"}
+	root := &auth.Identity{User: "root", Admin: true}
+	file := func(mode uint32) *pbmeta.FileMetadata {
+		return &pbmeta.FileMetadata{Path: "/f", Permissions: &pbmeta.Permissions{Owner: "alice", Group: "staff", Mode: mode}}
+	}
+
+	tests := []struct {
+		name   string
+		id     *auth.Identity
+		meta   *pbmeta.FileMetadata
+		access auth.Access
+		want   codes.Code
+	}{
+		{"owner reads", alice, file(0o600), auth.Read, codes.OK},
+		{"owner writes", alice, file(0o600), auth.Write, codes.OK},
+		{"owner without write bit", alice, file(0o444), auth.Write, codes.PermissionDenied},
+		{"group reads", bob, file(0o640), auth.Read, codes.OK},
+		{"group may not write", bob, file(0o640), auth.Write, codes.PermissionDenied},
+		{"group writes", bob, file(0o660), auth.Write, codes.OK},
+		{"other reads", carol, file(0o644), auth.Read, codes.OK},
+		{"other denied", carol, file(0o640), auth.Read, codes.PermissionDenied},
+		{"owner bits apply to owner only", alice, file(0o066), auth.Read, codes.PermissionDenied},
+		{"admin", root, file(0), auth.Read | auth.Write, codes.OK},
+		{"no permissions", carol, &pbmeta.FileMetadata{Path: "/old"}, auth.Write, codes.OK},
+		{"no identity", nil, file(0o777), auth.Read, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.id != nil {
+			ctx = auth.NewContext(ctx, tt.id)
+		}
+		if got := status.Code(s.checkAccess(ctx, tt.meta, tt.access)); got != tt.want {
+			t.Errorf("%s: checkAccess = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Without authentication, everything is allowed.
+	if err := (&Server{}).checkAccess(context.Background(), file(0), auth.Write); err != nil {
+		t.Errorf("checkAccess without authentication: %v", err)
+	}
+}
+
+func TestPermissionDenied(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	c := startCluster(t, Config{Authenticate: true}, auth.NewVerifier(secret).ServerOptions()...)
+	as := func(user string) context.Context {
+		token, err := auth.NewToken(secret, &auth.Identity{User: user}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+	alice, bob := as("alice"), as("bob")
+
+	resp, err := c.upload(alice, "/private.txt", []byte("secret"), &pbcoord.UploadFileRequest{Mode: 0o600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.download(alice, &pbcoord.DownloadFileRequest{FileId: resp.FileId}); err != nil {
+		t.Fatalf("owner download: %v", err)
+	}
+
+	if _, err := c.download(bob, &pbcoord.DownloadFileRequest{FileId: resp.FileId}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("download by another user = %v, want PermissionDenied", err)
+	}
+	if _, err := c.client.DeleteFile(bob, &pbcoord.DeleteFileRequest{FileId: resp.FileId}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("delete by another user = %v, want PermissionDenied", err)
+	}
+	if _, err := c.client.AcquireWriteLease(bob, &pbcoord.AcquireWriteLeaseRequest{FileId: resp.FileId}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("lease by another user = %v, want PermissionDenied", err)
+	}
+	lease, err := c.client.AcquireWriteLease(alice, &pbcoord.AcquireWriteLeaseRequest{FileId: resp.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.client.ReleaseWriteLease(bob, &pbcoord.ReleaseWriteLeaseRequest{FileId: resp.FileId, LeaseId: lease.LeaseId})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("releasing the owner's lease as another user = %v, want PermissionDenied", err)
+	}
+	if _, err := c.client.ReleaseWriteLease(alice, &pbcoord.ReleaseWriteLeaseRequest{FileId: resp.FileId, LeaseId: lease.LeaseId}); err != nil {
+		t.Errorf("releasing own lease: %v", err)
+	}
+
+	if _, err := c.client.StatFile(context.Background(), &pbcoord.StatFileRequest{FileId: resp.FileId}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call without token = %v, want Unauthenticated", err)
+	}
+}
@@ -0,0 +1,112 @@
+package coordinator
+
+import (
+	"context"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) StatFile(ctx context.Context, req *pbcoord.StatFileRequest) (*pbcoord.StatFileResponse, error) {
+	meta, err := s.lookupFile(ctx, req.GetFileId(), req.GetPath())
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "file not found: %v", err)
+	}
+	if err := s.checkAccess(ctx, meta, auth.Read); err != nil {
+		return nil, err
+	}
+	return &pbcoord.StatFileResponse{File: fileInfo(meta)}, nil
+}
+
+func (s *Server) UpdateFileAttributes(ctx context.Context, req *pbcoord.UpdateFileAttributesRequest) (*pbcoord.UpdateFileAttributesResponse, error) {
+	for k := range req.GetSetUserMetadata() {
+		if k == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "user metadata keys must not be empty")
+		}
+	}
+
+	meta, err := s.updateMetadata(ctx, req.GetFileId(), false, func(meta *pbmeta.FileMetadata) error {
+		if err := s.checkAccess(ctx, meta, auth.Write); err != nil {
+			return err
+		}
+		if req.GetContentType() != "" {
+			meta.ContentType = req.GetContentType()
+		}
+		for _, k := range req.GetDeleteUserMetadata() {
+			delete(meta.UserMetadata, k)
+		}
+		for k, v := range req.GetSetUserMetadata() {
+			if meta.UserMetadata == nil {
+				meta.UserMetadata = make(map[string]string)
+			}
+			meta.UserMetadata[k] = v
+		}
+
+		remove := make(map[string]bool)
+		for _, tag := range req.GetRemoveTags() {
+			remove[tag] = true
+		}
+		var tags []string
+		for _, tag := range append(meta.Tags, req.GetAddTags()...) {
+			if tag != "" && !remove[tag] {
+				tags = append(tags, tag)
+			}
+		}
+		sort.Strings(tags)
+		meta.Tags = slices.Compact(tags)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to update attributes of file %s: %v", req.GetFileId(), err)
+		return nil, err
+	}
+	return &pbcoord.UpdateFileAttributesResponse{File: fileInfo(meta)}, nil
+}
+
+// sniffLen is the number of leading bytes detectContentType looks at.
+const sniffLen = 512
+
+// detectContentType guesses the MIME type of a file from its first bytes,
+// falling back to its extension for contents that are not recognised.
+func detectContentType(fileName string, head []byte) string {
+	contentType := http.DetectContentType(head)
+	if contentType == "application/octet-stream" || strings.HasPrefix(contentType, "text/plain") {
+		if byExt := mime.TypeByExtension(path.Ext(fileName)); byExt != "" {
+			return byExt
+		}
+	}
+	return contentType
+}
+
+// fileInfo returns the attributes of meta that clients see.
+func fileInfo(meta *pbmeta.FileMetadata) *pbcoord.FileInfo {
+	return &pbcoord.FileInfo{
+		FileId:       meta.FileId,
+		FileName:     meta.FileName,
+		Path:         meta.Path,
+		FileSize:     meta.FileSize,
+		CreatedAt:    meta.CreatedAt,
+		UpdatedAt:    meta.UpdatedAt,
+		VersionId:    versionIDOf(meta),
+		ContentType:  meta.ContentType,
+		UserMetadata: meta.UserMetadata,
+		Tags:         meta.Tags,
+		Sha256:       meta.Sha256,
+		Md5:          meta.Md5,
+		Owner:        meta.GetPermissions().GetOwner(),
+		Group:        meta.GetPermissions().GetGroup(),
+		Mode:         meta.GetPermissions().GetMode(),
+		Generation:   meta.Generation,
+	}
+}
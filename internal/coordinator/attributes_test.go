@@ -0,0 +1,134 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"page.html", "<!DOCTYPE html><html>", "text/html; charset=utf-8"},
+		{"image.png", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"data.json", `{"a": 1}`, "application/json"},
+		{"notes.txt", "hello", "text/plain; charset=utf-8"},
+		{"notes", "hello", "text/plain; charset=utf-8"},
+		{"blob", "\x00\x01\x02", "application/octet-stream"},
+		{"doc.pdf", "\x00\x01\x02", "application/pdf"},
+		// The contents win over a misleading extension.
+		{"picture.txt", "\x89PNG\r\n\x1a\n", "image/png"},
+	}
+	for _, tt := range tests {
+		if got := detectContentType(tt.name, []byte(tt.head)); got != tt.want {
+			t.Errorf("detectContentType(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateFileAttributes(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	f, err := c.upload(ctx, "/a.txt", []byte("hello"), &pbcoord.UploadFileRequest{
+		UserMetadata: map[string]string{"owner": "alice", "team": "infra"},
+		Tags:         []string{"b", "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.client.UpdateFileAttributes(ctx, &pbcoord.UpdateFileAttributesRequest{
+		FileId:             f.FileId,
+		ContentType:        "text/markdown",
+		SetUserMetadata:    map[string]string{"owner": "bob", "reviewed": "yes"},
+		DeleteUserMetadata: []string{"team"},
+		AddTags:            []string{"c", "a", ""},
+		RemoveTags:         []string{"b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []*pbcoord.FileInfo{resp.File, stat.File} {
+		if file.ContentType != "text/markdown" {
+			t.Errorf("content type = %q", file.ContentType)
+		}
+		if got := fmt.Sprint(file.UserMetadata); got != "map[owner:bob reviewed:yes]" {
+			t.Errorf("user metadata = %s", got)
+		}
+		// Tags stay sorted and free of duplicates and empty tags.
+		if got := fmt.Sprint(file.Tags); got != "[a c]" {
+			t.Errorf("tags = %s", got)
+		}
+	}
+
+	_, err = c.client.UpdateFileAttributes(ctx, &pbcoord.UpdateFileAttributesRequest{FileId: f.FileId, SetUserMetadata: map[string]string{"": "x"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("empty user metadata key = %v, want InvalidArgument", err)
+	}
+	_, err = c.client.UpdateFileAttributes(ctx, &pbcoord.UpdateFileAttributesRequest{FileId: "missing", AddTags: []string{"x"}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("update of a missing file = %v, want NotFound", err)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	uploads := []struct {
+		path string
+		data string
+		req  *pbcoord.UploadFileRequest
+	}{
+		{"/docs/a.html", "<html><body>a</body></html>", &pbcoord.UploadFileRequest{Tags: []string{"web", "draft"}}},
+		{"/docs/b.txt", "b", &pbcoord.UploadFileRequest{UserMetadata: map[string]string{"team": "infra"}}},
+		{"/docs/c.txt", "c", &pbcoord.UploadFileRequest{Tags: []string{"draft"}, UserMetadata: map[string]string{"team": "web"}}},
+		{"/other/d.bin", "d", &pbcoord.UploadFileRequest{ContentType: "application/x-custom", Tags: []string{"draft"}}},
+	}
+	for _, u := range uploads {
+		if _, err := c.upload(ctx, u.path, []byte(u.data), u.req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		req  *pbcoord.ListFilesRequest
+		want string
+	}{
+		{"everything", &pbcoord.ListFilesRequest{}, "[/docs/a.html /docs/b.txt /docs/c.txt /other/d.bin]"},
+		{"prefix", &pbcoord.ListFilesRequest{PathPrefix: "/docs/"}, "[/docs/a.html /docs/b.txt /docs/c.txt]"},
+		{"tag", &pbcoord.ListFilesRequest{Tags: []string{"draft"}}, "[/docs/a.html /docs/c.txt /other/d.bin]"},
+		{"all tags", &pbcoord.ListFilesRequest{Tags: []string{"draft", "web"}}, "[/docs/a.html]"},
+		{"user metadata", &pbcoord.ListFilesRequest{UserMetadata: map[string]string{"team": "infra"}}, "[/docs/b.txt]"},
+		{"content type", &pbcoord.ListFilesRequest{ContentType: "application/x-custom"}, "[/other/d.bin]"},
+		{"prefix and tag", &pbcoord.ListFilesRequest{PathPrefix: "/docs/", Tags: []string{"draft"}}, "[/docs/a.html /docs/c.txt]"},
+		{"nothing matches", &pbcoord.ListFilesRequest{Tags: []string{"missing"}}, "[]"},
+	}
+	for _, tt := range tests {
+		resp, err := c.client.ListFiles(ctx, tt.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, f := range resp.Files {
+			paths = append(paths, f.Path)
+		}
+		sort.Strings(paths)
+		if got := fmt.Sprint(paths); got != tt.want {
+			t.Errorf("%s: files = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package coordinator
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultCacheSize is the number of file metadata entries the coordinator
+// caches when no size is configured.
+const DefaultCacheSize = 10000
+
+// metadataCache is a bounded LRU of file metadata. Each entry carries the
+// file's generation and the lease granted by the metadata service: until the
+// lease expires the entry is served as is, afterwards it must be revalidated.
+// A put never replaces an entry with an older generation, so a slow response
+// cannot overwrite newer data that arrived in the meantime.
+type metadataCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	meta    *pbmeta.FileMetadata
+	expires time.Time
+}
+
+func newMetadataCache(capacity int) *metadataCache {
+	return &metadataCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns a copy of the cached metadata for fileID and whether its lease
+// is still valid.
+func (c *metadataCache) get(fileID string) (meta *pbmeta.FileMetadata, fresh bool, ok bool) {
+	if c == nil {
+		return nil, false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[fileID]
+	if !ok {
+		return nil, false, false
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+	return proto.Clone(entry.meta).(*pbmeta.FileMetadata), time.Now().Before(entry.expires), true
+}
+
+// put caches meta for the given lease unless a newer generation is already
+// cached.
+func (c *metadataCache) put(meta *pbmeta.FileMetadata, lease time.Duration) {
+	if c == nil || lease <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(lease)
+	if elem, ok := c.entries[meta.FileId]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.meta.Generation > meta.Generation {
+			return
+		}
+		entry.meta = proto.Clone(meta).(*pbmeta.FileMetadata)
+		entry.expires = expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[meta.FileId] = c.lru.PushFront(&cacheEntry{
+		meta:    proto.Clone(meta).(*pbmeta.FileMetadata),
+		expires: expires,
+	})
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).meta.FileId)
+	}
+}
+
+// renew extends the lease of the cached entry for fileID if it is still at
+// generation.
+func (c *metadataCache) renew(fileID string, generation int64, lease time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[fileID]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.meta.Generation == generation {
+			entry.expires = time.Now().Add(lease)
+		}
+	}
+}
+
+func (c *metadataCache) invalidate(fileID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[fileID]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, fileID)
+	}
+}
+
+// invalidateBefore drops the entry for fileID if it is older than
+// generation.
+func (c *metadataCache) invalidateBefore(fileID string, generation int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[fileID]; ok && elem.Value.(*cacheEntry).meta.Generation < generation {
+		c.lru.Remove(elem)
+		delete(c.entries, fileID)
+	}
+}
+
+// clear drops every entry, for when invalidations may have been missed.
+func (c *metadataCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
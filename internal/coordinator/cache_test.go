@@ -0,0 +1,143 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+)
+
+func cached(c *metadataCache, fileID string) (generation int64, fresh, ok bool) {
+	meta, fresh, ok := c.get(fileID)
+	return meta.GetGeneration(), fresh, ok
+}
+
+func TestMetadataCache(t *testing.T) {
+	c := newMetadataCache(2)
+	c.put(&pbmeta.FileMetadata{FileId: "a", Generation: 2}, time.Hour)
+
+	if gen, fresh, ok := cached(c, "a"); !ok || !fresh || gen != 2 {
+		t.Fatalf("get = generation %d, fresh %v, ok %v", gen, fresh, ok)
+	}
+	if _, _, ok := c.get("b"); ok {
+		t.Fatal("get of an uncached file succeeded")
+	}
+
+	// A slow response with an older generation does not replace the entry.
+	c.put(&pbmeta.FileMetadata{FileId: "a", Generation: 1}, time.Hour)
+	if gen, _, _ := cached(c, "a"); gen != 2 {
+		t.Fatalf("generation after an older put = %d, want 2", gen)
+	}
+	c.put(&pbmeta.FileMetadata{FileId: "a", Generation: 3}, time.Hour)
+	if gen, _, _ := cached(c, "a"); gen != 3 {
+		t.Fatalf("generation after a newer put = %d, want 3", gen)
+	}
+
+	// Changing a returned copy leaves the entry alone.
+	meta, _, _ := c.get("a")
+	meta.Path = "/changed"
+	if meta, _, _ := c.get("a"); meta.Path != "" {
+		t.Fatalf("cached path = %q after changing a copy", meta.Path)
+	}
+
+	// No lease, nothing cached.
+	c.put(&pbmeta.FileMetadata{FileId: "b"}, 0)
+	if _, _, ok := c.get("b"); ok {
+		t.Fatal("put without a lease cached the file")
+	}
+}
+
+func TestMetadataCacheLease(t *testing.T) {
+	c := newMetadataCache(10)
+	c.put(&pbmeta.FileMetadata{FileId: "a", Generation: 1}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, fresh, ok := cached(c, "a"); !ok || fresh {
+		t.Fatalf("expired entry: fresh %v, ok %v; want stale but cached", fresh, ok)
+	}
+
+	// Renewing another generation keeps the entry stale.
+	c.renew("a", 2, time.Hour)
+	if _, fresh, _ := cached(c, "a"); fresh {
+		t.Fatal("renewing another generation made the entry fresh")
+	}
+	c.renew("a", 1, time.Hour)
+	if _, fresh, _ := cached(c, "a"); !fresh {
+		t.Fatal("renewed entry is stale")
+	}
+}
+
+func TestMetadataCacheEviction(t *testing.T) {
+	c := newMetadataCache(2)
+	c.put(&pbmeta.FileMetadata{FileId: "a"}, time.Hour)
+	c.put(&pbmeta.FileMetadata{FileId: "b"}, time.Hour)
+	// Using a makes b the least recently used entry.
+	c.get("a")
+	c.put(&pbmeta.FileMetadata{FileId: "c"}, time.Hour)
+
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, _, ok := c.get(id); ok != want {
+			t.Errorf("%s cached = %v, want %v", id, ok, want)
+		}
+	}
+}
+
+func TestMetadataCacheInvalidation(t *testing.T) {
+	c := newMetadataCache(10)
+	for _, id := range []string{"a", "b", "c"} {
+		c.put(&pbmeta.FileMetadata{FileId: id, Generation: 5}, time.Hour)
+	}
+
+	c.invalidate("a")
+	if _, _, ok := c.get("a"); ok {
+		t.Error("invalidated entry is still cached")
+	}
+	c.invalidateBefore("b", 5)
+	if _, _, ok := c.get("b"); !ok {
+		t.Error("invalidateBefore dropped an entry at the same generation")
+	}
+	c.invalidateBefore("b", 6)
+	if _, _, ok := c.get("b"); ok {
+		t.Error("invalidateBefore kept an older entry")
+	}
+	c.clear()
+	if _, _, ok := c.get("c"); ok {
+		t.Error("clear kept an entry")
+	}
+
+	// A disabled cache is nil and caches nothing.
+	var disabled *metadataCache
+	disabled.put(&pbmeta.FileMetadata{FileId: "a"}, time.Hour)
+	disabled.renew("a", 0, time.Hour)
+	disabled.invalidate("a")
+	disabled.invalidateBefore("a", 1)
+	disabled.clear()
+	if _, _, ok := disabled.get("a"); ok {
+		t.Error("nil cache returned an entry")
+	}
+}
+
+func TestCachedMetadataFollowsUpdates(t *testing.T) {
+	c := startCluster(t, Config{CacheSize: 100})
+	ctx := context.Background()
+	f := c.mustUpload(t, "/a.txt", []byte("one"))
+	c.checkContents(t, f.FileId, []byte("one"))
+	if _, _, ok := c.server.cache.get(f.FileId); !ok {
+		t.Fatal("downloaded file was not cached")
+	}
+
+	// Changes made through this coordinator drop its cache entry, so the
+	// next read sees them at once.
+	resp, err := c.client.UpdateFileAttributes(ctx, &pbcoord.UpdateFileAttributesRequest{FileId: f.FileId, ContentType: "text/x-one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.File.ContentType != "text/x-one" || stat.File.Generation != resp.File.Generation {
+		t.Fatalf("stat after update = %q at generation %d, want text/x-one at %d", stat.File.ContentType, stat.File.Generation, resp.File.Generation)
+	}
+}
@@ -0,0 +1,70 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckUploadConditions(t *testing.T) {
+	existing := &pbmeta.FileMetadata{FileId: "f", Generation: 3}
+	tests := []struct {
+		name     string
+		req      *pbcoord.UploadFileRequest
+		existing *pbmeta.FileMetadata
+		want     codes.Code
+	}{
+		{"no conditions", &pbcoord.UploadFileRequest{}, existing, codes.OK},
+		{"if none match, new", &pbcoord.UploadFileRequest{IfNoneMatch: true}, nil, codes.OK},
+		{"if none match, exists", &pbcoord.UploadFileRequest{IfNoneMatch: true}, existing, codes.AlreadyExists},
+		{"if match, missing", &pbcoord.UploadFileRequest{IfMatchFileId: "f"}, nil, codes.FailedPrecondition},
+		{"if match, other file", &pbcoord.UploadFileRequest{IfMatchFileId: "g"}, existing, codes.FailedPrecondition},
+		{"if match, same file", &pbcoord.UploadFileRequest{IfMatchFileId: "f"}, existing, codes.OK},
+		{"if match, same generation", &pbcoord.UploadFileRequest{IfMatchFileId: "f", IfMatch: 3}, existing, codes.OK},
+		{"if match, other generation", &pbcoord.UploadFileRequest{IfMatchFileId: "f", IfMatch: 2}, existing, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		if got := status.Code(checkUploadConditions(tt.req, "/a.txt", tt.existing)); got != tt.want {
+			t.Errorf("%s: checkUploadConditions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalUpload(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	chunks := c.chunkCount(t)
+
+	first, err := c.upload(ctx, "/a.txt", []byte("one"), &pbcoord.UploadFileRequest{IfNoneMatch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.upload(ctx, "/a.txt", []byte("two"), &pbcoord.UploadFileRequest{IfNoneMatch: true}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("upload over an existing file with if_none_match = %v, want AlreadyExists", err)
+	}
+	stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: first.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := &pbcoord.UploadFileRequest{IfMatchFileId: first.FileId, IfMatch: stat.File.Generation + 1}
+	if _, err := c.upload(ctx, "/a.txt", []byte("two"), stale); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("upload at another generation = %v, want FailedPrecondition", err)
+	}
+	// The failed uploads leave no chunks behind.
+	if got, want := c.chunkCount(t), chunks+replicationFactor; got != want {
+		t.Errorf("%d chunks stored, want %d", got, want)
+	}
+
+	current := &pbcoord.UploadFileRequest{IfMatchFileId: first.FileId, IfMatch: stat.File.Generation}
+	second, err := c.upload(ctx, "/a.txt", []byte("two"), current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkContents(t, second.FileId, []byte("two"))
+}
@@ -0,0 +1,118 @@
+package coordinator
+
+import (
+	"context"
+	"log"
+	"path"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Copies and concatenations share chunks with their sources. deleteChunks
+// only removes chunks that no metadata refers to any more, so a shared chunk
+// survives until the last file, version or snapshot using it is gone. A copy
+// whose source is deleted while it is being made fails with codes.Aborted
+// rather than refer to chunks that may be gone.
+
+func (s *Server) CopyFile(ctx context.Context, req *pbcoord.CopyFileRequest) (*pbcoord.CopyFileResponse, error) {
+	source, err := s.lookupFile(ctx, req.GetSourceFileId(), req.GetSourcePath())
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "source file not found: %v", err)
+	}
+	if err := s.checkAccess(ctx, source, auth.Read); err != nil {
+		return nil, err
+	}
+	version, ok := findVersion(source, req.GetSourceVersionId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "version %s of file %s not found", req.GetSourceVersionId(), source.FileId)
+	}
+
+	fileID, versionID, err := s.createFile(ctx, req.GetDestinationPath(), &pbmeta.FileMetadata{
+		FileSize:     version.FileSize,
+		Chunks:       version.Chunks,
+		Sha256:       version.Sha256,
+		Md5:          version.Md5,
+		ContentType:  source.ContentType,
+		UserMetadata: source.UserMetadata,
+		Tags:         source.Tags,
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Copied file %s to %s (ID: %s)", source.FileId, req.GetDestinationPath(), fileID)
+	return &pbcoord.CopyFileResponse{FileId: fileID, VersionId: versionID}, nil
+}
+
+func (s *Server) ConcatFiles(ctx context.Context, req *pbcoord.ConcatFilesRequest) (*pbcoord.ConcatFilesResponse, error) {
+	if len(req.GetSourceFileIds()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "no source files given")
+	}
+	var chunks []*pbmeta.ChunkInfo
+	var size int64
+	for _, id := range req.GetSourceFileIds() {
+		source, err := s.getMetadata(ctx, id)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "source file %s not found: %v", id, err)
+		}
+		if err := s.checkAccess(ctx, source, auth.Read); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, source.Chunks...)
+		size += source.FileSize
+	}
+
+	fileID, versionID, err := s.createFile(ctx, req.GetDestinationPath(), &pbmeta.FileMetadata{
+		FileSize:     size,
+		Chunks:       chunks,
+		ContentType:  req.GetContentType(),
+		UserMetadata: req.GetUserMetadata(),
+		Tags:         req.GetTags(),
+	}, req.GetMode())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Concatenated %d files into %s (ID: %s, Size: %d bytes)", len(req.GetSourceFileIds()), req.GetDestinationPath(), fileID, size)
+	return &pbcoord.ConcatFilesResponse{FileId: fileID, VersionId: versionID, FileSize: size}, nil
+}
+
+// createFile stores a file with the contents and attributes of meta, which
+// refers to existing chunks, at destPath. It becomes a new version of the
+// file there if versioning applies and replaces it otherwise. mode is that
+// of a new file, as for UploadFile.
+func (s *Server) createFile(ctx context.Context, destPath string, meta *pbmeta.FileMetadata, mode uint32) (string, string, error) {
+	if destPath == "" {
+		return "", "", status.Errorf(codes.InvalidArgument, "no destination path given")
+	}
+	filePath := cleanPath(destPath, "")
+	if isSnapshotPath(filePath) {
+		return "", "", status.Errorf(codes.InvalidArgument, "%s is in a read-only snapshot", filePath)
+	}
+	existing, policy, err := s.existingFile(ctx, filePath)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "failed to look up existing file: %v", err)
+	}
+	meta.Permissions, err = s.newFilePermissions(ctx, filePath, existing, mode)
+	if err != nil {
+		return "", "", err
+	}
+
+	fileName := path.Base(filePath)
+	fileID := generateFileID(fileName)
+	if existing != nil && policy.GetEnabled() {
+		fileID = existing.FileId
+	}
+	versionID := generateVersionID()
+	meta.FileId = fileID
+	meta.FileName = fileName
+	meta.Path = filePath
+	meta.VersionId = versionID
+	if err := s.saveFile(ctx, meta, existing, policy, saveOptions{shared: true}); err != nil {
+		return "", "", saveFailed(fileID, err)
+	}
+	return fileID, versionID, nil
+}
@@ -0,0 +1,163 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// purge deletes fileID for good.
+func (c *testCluster) purge(t *testing.T, fileID string) {
+	t.Helper()
+	if _, err := c.client.DeleteFile(context.Background(), &pbcoord.DeleteFileRequest{FileId: fileID, Permanent: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopySharesChunks(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	data := pattern(chunkSize+10, 0)
+	src := c.mustUpload(t, "/src.bin", data)
+	if _, err := c.client.UpdateFileAttributes(ctx, &pbcoord.UpdateFileAttributesRequest{FileId: src.FileId, AddTags: []string{"t"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := c.client.CopyFile(ctx, &pbcoord.CopyFileRequest{SourcePath: "/src.bin", DestinationPath: "/copy.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkContents(t, cp.FileId, data)
+	srcIDs, _ := c.chunkLayout(t, src.FileId)
+	cpIDs, _ := c.chunkLayout(t, cp.FileId)
+	if fmt.Sprint(srcIDs) != fmt.Sprint(cpIDs) {
+		t.Fatalf("copy has chunks %v, want the source's %v", cpIDs, srcIDs)
+	}
+	stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: cp.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.File.Sha256 != src.Sha256 || len(stat.File.Tags) != 1 {
+		t.Errorf("copy has digest %s and tags %v, want the source's", stat.File.Sha256, stat.File.Tags)
+	}
+
+	// The shared chunks outlive the source and go with the last file.
+	c.purge(t, src.FileId)
+	if n := c.chunkCount(t); n != 2*replicationFactor {
+		t.Fatalf("%d chunks stored after deleting the source, want %d", n, 2*replicationFactor)
+	}
+	c.checkContents(t, cp.FileId, data)
+	c.purge(t, cp.FileId)
+	if n := c.chunkCount(t); n != 0 {
+		t.Errorf("%d chunks stored after deleting the copy", n)
+	}
+
+	for _, req := range []*pbcoord.CopyFileRequest{
+		{SourcePath: "/src.bin", DestinationPath: "/x"},
+		{SourcePath: "/copy.bin"},
+	} {
+		if _, err := c.client.CopyFile(ctx, req); status.Code(err) == codes.OK {
+			t.Errorf("CopyFile(%v) succeeded", req)
+		}
+	}
+}
+
+func TestCopyVersion(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	if _, err := c.client.SetVersioning(ctx, &pbcoord.SetVersioningRequest{Path: "/", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	v1 := c.mustUpload(t, "/a.txt", []byte("one"))
+	c.mustUpload(t, "/a.txt", []byte("two"))
+
+	cp, err := c.client.CopyFile(ctx, &pbcoord.CopyFileRequest{SourceFileId: v1.FileId, SourceVersionId: v1.VersionId, DestinationPath: "/b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkContents(t, cp.FileId, []byte("one"))
+
+	// Deleting the source and its versions keeps the chunk the copy uses.
+	c.purge(t, v1.FileId)
+	c.checkContents(t, cp.FileId, []byte("one"))
+	if n := c.chunkCount(t); n != replicationFactor {
+		t.Errorf("%d chunks stored, want %d", n, replicationFactor)
+	}
+
+	_, err = c.client.CopyFile(ctx, &pbcoord.CopyFileRequest{SourceFileId: cp.FileId, SourceVersionId: "missing", DestinationPath: "/c.txt"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("copy of a missing version = %v, want NotFound", err)
+	}
+}
+
+func TestConcatSharesChunks(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	a := c.mustUpload(t, "/a.bin", pattern(chunkSize, 0))
+	b := c.mustUpload(t, "/b.bin", pattern(100, 1))
+
+	cat, err := c.client.ConcatFiles(ctx, &pbcoord.ConcatFilesRequest{
+		SourceFileIds:   []string{a.FileId, b.FileId, a.FileId},
+		DestinationPath: "/cat.bin",
+		Tags:            []string{"joined"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append(pattern(chunkSize, 0), pattern(100, 1)...), pattern(chunkSize, 0)...)
+	if cat.FileSize != int64(len(want)) {
+		t.Errorf("concatenation is %d bytes, want %d", cat.FileSize, len(want))
+	}
+	c.checkContents(t, cat.FileId, want)
+	// No chunks were written for it.
+	if n := c.chunkCount(t); n != 2*replicationFactor {
+		t.Fatalf("%d chunks stored, want %d", n, 2*replicationFactor)
+	}
+
+	c.purge(t, a.FileId)
+	c.purge(t, b.FileId)
+	c.checkContents(t, cat.FileId, want)
+	c.purge(t, cat.FileId)
+	if n := c.chunkCount(t); n != 0 {
+		t.Errorf("%d chunks stored after deleting every file", n)
+	}
+
+	if _, err := c.client.ConcatFiles(ctx, &pbcoord.ConcatFilesRequest{DestinationPath: "/x"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("concatenation of nothing = %v, want InvalidArgument", err)
+	}
+	_, err = c.client.ConcatFiles(ctx, &pbcoord.ConcatFilesRequest{SourceFileIds: []string{a.FileId}, DestinationPath: "/x"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("concatenation of a deleted file = %v, want NotFound", err)
+	}
+}
+
+func TestDeleteChunksKeepsReferenced(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	a := c.mustUpload(t, "/a.txt", []byte("a"))
+	c.mustUpload(t, "/b.txt", []byte("b"))
+	meta, err := c.meta.Get(a.FileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := &pbmeta.ChunkInfo{ChunkId: meta.Chunks[0].ChunkID, NodeIds: meta.Chunks[0].NodeIDs}
+
+	// The chunk still belongs to a file, so it stays.
+	c.server.deleteChunks(ctx, []*pbmeta.ChunkInfo{chunk})
+	c.checkContents(t, a.FileId, []byte("a"))
+
+	// Once the metadata is gone, the chunk goes from every replica.
+	if err := c.meta.Delete(a.FileId); err != nil {
+		t.Fatal(err)
+	}
+	c.server.deleteChunks(ctx, []*pbmeta.ChunkInfo{chunk})
+	if stored := c.storedChunks(t); stored[chunk.ChunkId] || len(stored) != 1 {
+		t.Errorf("stored chunks = %v, want only those of /b.txt", stored)
+	}
+}
@@ -0,0 +1,114 @@
+package coordinator
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	pbcoord "dfs/internal/pb/coordinator"
+)
+
+func digests(data []byte) (string, string) {
+	s, m := sha256.Sum256(data), md5.Sum(data)
+	return hex.EncodeToString(s[:]), hex.EncodeToString(m[:])
+}
+
+func TestUploadDigests(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	if _, err := c.client.SetVersioning(ctx, &pbcoord.SetVersioningRequest{Path: "/", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{nil, []byte("hello"), pattern(2*chunkSize+7, 3)} {
+		wantSHA, wantMD5 := digests(data)
+		f := c.mustUpload(t, "/a.bin", data)
+		if f.Sha256 != wantSHA || f.Md5 != wantMD5 {
+			t.Errorf("%d bytes: upload digests %s %s, want %s %s", len(data), f.Sha256, f.Md5, wantSHA, wantMD5)
+		}
+		stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.File.Sha256 != wantSHA || stat.File.Md5 != wantMD5 {
+			t.Errorf("%d bytes: stat digests %s %s", len(data), stat.File.Sha256, stat.File.Md5)
+		}
+	}
+
+	// Noncurrent versions keep their digests.
+	resp, err := c.client.ListFiles(ctx, &pbcoord.ListFilesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := c.client.ListVersions(ctx, &pbcoord.ListVersionsRequest{FileId: resp.Files[0].FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantSHA, _ := digests([]byte("hello")); len(versions.Versions) != 3 || versions.Versions[1].Sha256 != wantSHA {
+		t.Errorf("versions = %v, want the second to have digest %s", versions.Versions, wantSHA)
+	}
+}
+
+func TestDownloadDigests(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	data := pattern(2*chunkSize+7, 3)
+	f := c.mustUpload(t, "/a.bin", data)
+
+	stream, err := c.client.DownloadFile(ctx, &pbcoord.DownloadFileRequest{FileId: f.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first message carries the digests, for clients to check
+	// the whole file against.
+	for i := 0; ; i++ {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			if i != 3 {
+				t.Errorf("download took %d messages, want 3", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasDigests := msg.Sha256 != "" || msg.Md5 != ""; hasDigests != (i == 0) {
+			t.Errorf("message %d has digests %q %q", i, msg.Sha256, msg.Md5)
+		}
+		if i == 0 && (msg.Sha256 != f.Sha256 || msg.Md5 != f.Md5) {
+			t.Errorf("download digests %s %s, want %s %s", msg.Sha256, msg.Md5, f.Sha256, f.Md5)
+		}
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	tests := []struct {
+		path string
+		data string
+		set  string
+		want string
+	}{
+		{"/page.html", "<html><body>hi</body></html>", "", "text/html; charset=utf-8"},
+		{"/data.json", `{"a": 1}`, "", "application/json"},
+		{"/page2.html", "<html></html>", "text/x-mine", "text/x-mine"},
+		{"/empty.txt", "", "", ""},
+	}
+	for _, tt := range tests {
+		f, err := c.upload(ctx, tt.path, []byte(tt.data), &pbcoord.UploadFileRequest{ContentType: tt.set})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.File.ContentType != tt.want {
+			t.Errorf("%s: content type = %q, want %q", tt.path, stat.File.ContentType, tt.want)
+		}
+	}
+}
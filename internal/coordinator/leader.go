@@ -0,0 +1,163 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	leaderRetries = 5
+	leaderBackoff = 200 * time.Millisecond
+)
+
+// leaderConn is a grpc.ClientConnInterface over every member of a replicated
+// metadata service. Calls go to the member believed to be the leader; when a
+// call fails with codes.Unavailable, which followers return for writes and
+// linearizable reads, it asks the members who the leader is and retries there.
+type leaderConn struct {
+	opts []grpc.DialOption
+
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn
+	addrs  []string
+	leader string
+}
+
+var _ grpc.ClientConnInterface = (*leaderConn)(nil)
+
+func newLeaderConn(addrs []string, opts ...grpc.DialOption) (*leaderConn, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no metadata service addresses")
+	}
+	c := &leaderConn{
+		opts:   opts,
+		conns:  make(map[string]*grpc.ClientConn),
+		leader: addrs[0],
+	}
+	for _, addr := range addrs {
+		if _, err := c.conn(addr); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *leaderConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	var err error
+	for attempt := 0; attempt < leaderRetries; attempt++ {
+		addr := c.currentLeader()
+		var conn *grpc.ClientConn
+		conn, err = c.conn(addr)
+		if err != nil {
+			return err
+		}
+		err = conn.Invoke(ctx, method, args, reply, opts...)
+		if status.Code(err) != codes.Unavailable {
+			return err
+		}
+		log.Printf("Metadata member %s unavailable, looking for leader: %v", addr, err)
+		c.findLeader(ctx, addr)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(leaderBackoff * time.Duration(attempt+1)):
+		}
+	}
+	return err
+}
+
+// NewStream opens streams on the current leader without retrying; callers of
+// streaming RPCs re-establish the stream themselves.
+func (c *leaderConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, err := c.conn(c.currentLeader())
+	if err != nil {
+		return nil, err
+	}
+	return conn.NewStream(ctx, desc, method, opts...)
+}
+
+func (c *leaderConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for _, conn := range c.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *leaderConn) currentLeader() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader
+}
+
+// conn returns the connection to addr, dialling it if this is the first time
+// addr is seen, for example a member that joined after startup.
+func (c *leaderConn) conn(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, c.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to metadata service %s: %v", addr, err)
+	}
+	c.conns[addr] = conn
+	c.addrs = append(c.addrs, addr)
+	return conn, nil
+}
+
+// findLeader asks each known member for the current leader and switches to
+// it. If nobody knows the leader, it moves on to the member after failed so
+// that a member that is down is not retried forever.
+func (c *leaderConn) findLeader(ctx context.Context, failed string) {
+	c.mu.Lock()
+	addrs := append([]string(nil), c.addrs...)
+	c.mu.Unlock()
+
+	for _, addr := range addrs {
+		conn, err := c.conn(addr)
+		if err != nil {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		resp, err := pbmeta.NewMetadataServiceClient(conn).GetLeader(callCtx, &pbmeta.GetLeaderRequest{})
+		cancel()
+		if err != nil || resp.LeaderId == "" {
+			continue
+		}
+		if _, err := c.conn(resp.LeaderId); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		c.leader = resp.LeaderId
+		c.mu.Unlock()
+		log.Printf("Metadata leader is %s", resp.LeaderId)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, addr := range c.addrs {
+		if addr == failed {
+			c.leader = c.addrs[(i+1)%len(c.addrs)]
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMoveFile(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	a := c.mustUpload(t, "/a.txt", []byte("a"))
+	b := c.mustUpload(t, "/b.txt", []byte("b"))
+
+	resp, err := c.client.MoveFile(ctx, &pbcoord.MoveFileRequest{FileId: a.FileId, DestinationPath: "dir//c.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.File.FileId != a.FileId || resp.File.Path != "/dir/c.txt" || resp.File.FileName != "c.txt" {
+		t.Fatalf("moved file = %v", resp.File)
+	}
+	if _, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{Path: "/a.txt"}); status.Code(err) != codes.NotFound {
+		t.Errorf("stat of the old path = %v, want NotFound", err)
+	}
+	got, err := c.download(ctx, &pbcoord.DownloadFileRequest{Path: "/dir/c.txt"})
+	if err != nil || string(got) != "a" {
+		t.Fatalf("new path holds %q, %v", got, err)
+	}
+
+	// Moving onto another file needs overwrite, which sends that file to
+	// the trash.
+	_, err = c.client.MoveFile(ctx, &pbcoord.MoveFileRequest{Path: "/dir/c.txt", DestinationPath: "/b.txt"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("move onto an existing file = %v, want AlreadyExists", err)
+	}
+	if _, err := c.client.MoveFile(ctx, &pbcoord.MoveFileRequest{Path: "/dir/c.txt", DestinationPath: "/b.txt", Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = c.download(ctx, &pbcoord.DownloadFileRequest{Path: "/b.txt"})
+	if err != nil || string(got) != "a" {
+		t.Fatalf("overwritten path holds %q, %v", got, err)
+	}
+	if ids := c.trashIDs(t); len(ids) != 1 || ids[0] != b.FileId {
+		t.Errorf("trash = %v, want the replaced %s", ids, b.FileId)
+	}
+
+	// Moving a file to where it already is changes nothing.
+	if _, err := c.client.MoveFile(ctx, &pbcoord.MoveFileRequest{FileId: a.FileId, DestinationPath: "/b.txt"}); err != nil {
+		t.Errorf("move to the same path: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *pbcoord.MoveFileRequest
+		want codes.Code
+	}{
+		{"no destination", &pbcoord.MoveFileRequest{FileId: a.FileId}, codes.InvalidArgument},
+		{"into a snapshot", &pbcoord.MoveFileRequest{FileId: a.FileId, DestinationPath: "/.snapshots/s/a.txt"}, codes.InvalidArgument},
+		{"missing file", &pbcoord.MoveFileRequest{FileId: "missing", DestinationPath: "/x.txt"}, codes.NotFound},
+		{"missing path", &pbcoord.MoveFileRequest{Path: "/a.txt", DestinationPath: "/x.txt"}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		if _, err := c.client.MoveFile(ctx, tt.req); status.Code(err) != tt.want {
+			t.Errorf("%s: MoveFile = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -2,20 +2,23 @@ package coordinator
 
 import (
 	"context"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
+	"path"
 	"time"
 
+	"dfs/internal/auth"
 	pbcoord "dfs/internal/pb/coordinator"
 	pbmeta "dfs/internal/pb/metadata"
 	pbstorage "dfs/internal/pb/storagenode"
+	"dfs/internal/pki"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 )
 
@@ -28,6 +31,37 @@ type Server struct {
 	pbcoord.UnimplementedCoordinatorServer
 	metadataClient pbmeta.MetadataServiceClient
 	storageNodes   []StorageNode
+	cache          *metadataCache
+	trashRetention time.Duration
+	authenticate   bool
+}
+
+// Config holds the coordinator's connection and tuning settings.
+type Config struct {
+	// MetadataAddrs lists every member of the metadata service. When it is
+	// replicated, requests follow the current leader.
+	MetadataAddrs []string
+	StorageAddrs  []string
+	// CacheSize is the number of file metadata entries to cache. Zero
+	// selects DefaultCacheSize; a negative value disables the cache.
+	CacheSize int
+	// RetentionInterval is how often version retention policies and the
+	// trash retention period are applied. Zero selects
+	// DefaultRetentionInterval; a negative value disables the periodic
+	// sweep.
+	RetentionInterval time.Duration
+	// TrashRetention is how long deleted files stay in the trash. Zero
+	// selects DefaultTrashRetention; a negative value disables the trash so
+	// that deletes are permanent.
+	TrashRetention time.Duration
+	// Authenticate requires every call to carry the caller's identity, as
+	// added by the server options of an auth.Verifier, and enforces file
+	// permissions.
+	Authenticate bool
+	// TLS holds the coordinator's certificate for mutual TLS with the
+	// metadata service and the storage nodes. The connections are insecure
+	// if it is empty.
+	TLS pki.Files
 }
 
 type StorageNode struct {
@@ -35,21 +69,26 @@ type StorageNode struct {
 	nodeID string
 }
 
-func NewServer(metadataAddr string, storageAddrs []string) (*Server, error) {
-	options := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+func NewServer(cfg Config) (*Server, error) {
+	metadataCreds, err := pki.DialOption(cfg.TLS, pki.RoleMetadata)
+	if err != nil {
+		return nil, err
+	}
+	storageCreds, err := pki.DialOption(cfg.TLS, pki.RoleStorageNode)
+	if err != nil {
+		return nil, err
 	}
 
-	metadataConn, err := grpc.NewClient(metadataAddr, options...)
+	metadataConn, err := newLeaderConn(cfg.MetadataAddrs, metadataCreds)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to metadata service: %v", err)
+		return nil, err
 	}
 
 	metadataClient := pbmeta.NewMetadataServiceClient(metadataConn)
 
 	var storageNodes []StorageNode
-	for _, addr := range storageAddrs {
-		conn, err := grpc.NewClient(addr, options...)
+	for _, addr := range cfg.StorageAddrs {
+		conn, err := grpc.NewClient(addr, storageCreds)
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect to storage node %s: %v", addr, err)
 		}
@@ -64,16 +103,50 @@ func NewServer(metadataAddr string, storageAddrs []string) (*Server, error) {
 		storageNodes = append(storageNodes, storageNode)
 	}
 
-	return &Server{
+	var cache *metadataCache
+	switch {
+	case cfg.CacheSize == 0:
+		cache = newMetadataCache(DefaultCacheSize)
+	case cfg.CacheSize > 0:
+		cache = newMetadataCache(cfg.CacheSize)
+	}
+
+	trashRetention := cfg.TrashRetention
+	if trashRetention == 0 {
+		trashRetention = DefaultTrashRetention
+	}
+
+	s := &Server{
 		metadataClient: metadataClient,
 		storageNodes:   storageNodes,
-	}, nil
+		cache:          cache,
+		trashRetention: trashRetention,
+		authenticate:   cfg.Authenticate,
+	}
+	if cache != nil {
+		go s.watchMetadata(context.Background())
+	}
+	switch {
+	case cfg.RetentionInterval == 0:
+		go s.retentionLoop(context.Background(), DefaultRetentionInterval)
+	case cfg.RetentionInterval > 0:
+		go s.retentionLoop(context.Background(), cfg.RetentionInterval)
+	}
+	return s, nil
 }
 
 func (s *Server) UploadFile(stream pbcoord.Coordinator_UploadFileServer) error {
-	var fileID, fileName string
+	var fileID, fileName, filePath, versionID string
 	var fileSize int64
+	var attributes *pbcoord.UploadFileRequest
+	sha256Hash, md5Hash := sha256.New(), md5.New()
+	digest := io.MultiWriter(sha256Hash, md5Hash)
+	// head holds the first bytes of the file for content type detection.
+	var head []byte
 	var chunkInfos []*pbmeta.ChunkInfo
+	var existing *pbmeta.FileMetadata
+	var policy *pbmeta.VersioningPolicy
+	var permissions *pbmeta.Permissions
 
 	for {
 		req, err := stream.Recv()
@@ -87,120 +160,158 @@ func (s *Server) UploadFile(stream pbcoord.Coordinator_UploadFileServer) error {
 
 		if fileName == "" {
 			fileName = req.GetFileName()
-			fileID = generateFileID(fileName)
-			log.Printf("Starting upload for file: %s (ID: %s)", fileName, fileID)
-		}
-
-		chunkID := generateChunkID(fileID, len(chunkInfos))
-		chunkInfo := &pbmeta.ChunkInfo{
-			ChunkId: chunkID,
-			NodeIds: []string{},
-		}
-
-		checksum := calculateChecksum(req.GetChunkData())
-		log.Printf("Calculated checksum for chunk %s: %s", chunkID, checksum)
-
-		for i := 0; i < replicationFactor; i++ {
-			nodeIndex := (len(chunkInfos) + i) % len(s.storageNodes)
-			_, err = s.storageNodes[nodeIndex].client.PutChunk(context.Background(), &pbstorage.PutChunkRequest{
-				ChunkId:  chunkID,
-				Data:     req.GetChunkData(),
-				Checksum: checksum,
-			})
+			filePath = cleanPath(req.GetPath(), fileName)
+			attributes = req
+			if isSnapshotPath(filePath) {
+				return status.Errorf(codes.InvalidArgument, "%s is in a read-only snapshot", filePath)
+			}
+			versionID = generateVersionID()
+			existing, policy, err = s.existingFile(stream.Context(), filePath)
 			if err != nil {
-				log.Printf("Failed to store chunk %s on node %d: %v", chunkID, nodeIndex, err)
-				return status.Errorf(codes.Internal, "failed to store chunk: %v", err)
+				log.Printf("Failed to look up existing file at %s: %v", filePath, err)
+				return status.Errorf(codes.Internal, "failed to look up existing file: %v", err)
+			}
+			if err := checkUploadConditions(req, filePath, existing); err != nil {
+				return err
+			}
+			permissions, err = s.newFilePermissions(stream.Context(), filePath, existing, req.GetMode())
+			if err != nil {
+				return err
+			}
+			if existing != nil && policy.GetEnabled() {
+				fileID = existing.FileId
+				log.Printf("Starting upload of new version %s for file: %s (ID: %s)", versionID, fileName, fileID)
+			} else {
+				fileID = generateFileID(fileName)
+				log.Printf("Starting upload for file: %s (ID: %s)", fileName, fileID)
 			}
+		}
 
-			chunkInfo.NodeIds = append(chunkInfo.NodeIds, s.storageNodes[nodeIndex].nodeID)
-			log.Printf("Stored chunk %s on node %d", chunkID, nodeIndex)
+		chunkID := generateChunkID(fileID, versionID, len(chunkInfos))
+		chunkInfo, err := s.storeChunk(context.Background(), chunkID, len(chunkInfos), req.GetChunkData())
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to store chunk: %v", err)
 		}
 
 		chunkInfos = append(chunkInfos, chunkInfo)
 		fileSize += int64(len(req.GetChunkData()))
+		digest.Write(req.GetChunkData())
+		if n := sniffLen - len(head); n > 0 {
+			head = append(head, req.GetChunkData()[:min(n, len(req.GetChunkData()))]...)
+		}
 	}
 
-	_, err := s.metadataClient.SaveFileMetadata(context.Background(), &pbmeta.SaveFileMetadataRequest{
-		Metadata: &pbmeta.FileMetadata{
-			FileId:    fileID,
-			FileName:  fileName,
-			FileSize:  fileSize,
-			Chunks:    chunkInfos,
-			CreatedAt: time.Now().UTC().Format(time.RFC3339),
-			UpdatedAt: time.Now().UTC().Format(time.RFC3339),
-		},
-	})
+	contentType := attributes.GetContentType()
+	if contentType == "" && fileSize > 0 {
+		contentType = detectContentType(fileName, head)
+	}
+	sum256 := hex.EncodeToString(sha256Hash.Sum(nil))
+	sumMD5 := hex.EncodeToString(md5Hash.Sum(nil))
+
+	err := s.saveFile(context.Background(), &pbmeta.FileMetadata{
+		FileId:    fileID,
+		FileName:  fileName,
+		FileSize:  fileSize,
+		Chunks:    chunkInfos,
+		Path:      filePath,
+		VersionId: versionID,
+
+		Sha256:       sum256,
+		Md5:          sumMD5,
+		ContentType:  contentType,
+		UserMetadata: attributes.GetUserMetadata(),
+		Tags:         attributes.GetTags(),
+		Permissions:  permissions,
+	}, existing, policy, saveOptions{unchanged: attributes.GetIfMatchFileId() != ""})
 	if err != nil {
-		log.Printf("Failed to save metadata for file %s: %v", fileID, err)
-		return status.Errorf(codes.Internal, "failed to save metadata: %v", err)
+		s.deleteChunks(context.Background(), chunkInfos)
+		return saveFailed(fileID, err)
 	}
 
 	log.Printf("File uploaded successfully. File ID: %s, Size: %d bytes, Chunks: %d", fileID, fileSize, len(chunkInfos))
 	return stream.SendAndClose(&pbcoord.UploadFileResponse{
-		FileId: fileID,
+		FileId:    fileID,
+		VersionId: versionID,
+		Sha256:    sum256,
+		Md5:       sumMD5,
 	})
 }
 
 func (s *Server) DownloadFile(req *pbcoord.DownloadFileRequest, stream pbcoord.Coordinator_DownloadFileServer) error {
-	log.Printf("Starting download for file ID: %s", req.GetFileId())
+	log.Printf("Starting download for file ID: %q, path: %q", req.GetFileId(), req.GetPath())
 
-	metaResp, err := s.metadataClient.GetFileMetadata(context.Background(), &pbmeta.GetFileMetadataRequest{
-		FileId: req.GetFileId(),
-	})
+	meta, err := s.lookupFile(stream.Context(), req.GetFileId(), req.GetPath())
 	if err != nil {
-		log.Printf("Failed to retrieve metadata for file %s: %v", req.GetFileId(), err)
+		log.Printf("Failed to retrieve metadata for file ID %q, path %q: %v", req.GetFileId(), req.GetPath(), err)
 		return status.Errorf(codes.NotFound, "file not found: %v", err)
 	}
+	if err := s.checkAccess(stream.Context(), meta, auth.Read); err != nil {
+		return err
+	}
 
-	log.Printf("Retrieved metadata for file %s: %+v", req.GetFileId(), metaResp.Metadata)
-
-	for _, chunkInfo := range metaResp.Metadata.Chunks {
-		var chunkData []byte
-		var chunkErr error
+	log.Printf("Retrieved metadata for file %s: %+v", meta.GetFileId(), meta)
 
-		for _, nodeID := range chunkInfo.NodeIds {
-			var node *StorageNode
-			for _, n := range s.storageNodes {
-				if n.nodeID == nodeID {
-					node = &n
-					break
-				}
-			}
-			if node == nil {
-				log.Printf("Node %s not found for chunk %s", nodeID, chunkInfo.ChunkId)
-				continue
-			}
-
-			chunkResp, err := node.client.GetChunk(context.Background(), &pbstorage.GetChunkRequest{
-				ChunkId: chunkInfo.ChunkId,
-			})
-			if err != nil {
-				log.Printf("Failed to retrieve chunk %s from node %s: %v", chunkInfo.ChunkId, nodeID, err)
-				continue
-			}
+	version, ok := findVersion(meta, req.GetVersionId())
+	if !ok {
+		return status.Errorf(codes.NotFound, "version %s of file %s not found", req.GetVersionId(), meta.GetFileId())
+	}
 
-			calculatedChecksum := calculateChecksum(chunkResp.Data)
-			if calculatedChecksum != chunkResp.Checksum {
-				log.Printf("Checksum mismatch for chunk %s from node %s", chunkInfo.ChunkId, nodeID)
-				continue
+	if req.GetOffset() < 0 || req.GetLength() < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative offset or length")
+	}
+	chunks := version.Chunks
+	var skip, remaining int64 = 0, -1
+	if req.GetOffset() > 0 || req.GetLength() > 0 {
+		// Only the chunks that overlap the requested range are read.
+		starts, err := s.chunkOffsets(stream.Context(), chunks)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to determine chunk sizes: %v", err)
+		}
+		size := starts[len(chunks)]
+		if req.GetOffset() > size {
+			return status.Errorf(codes.OutOfRange, "offset %d is beyond the end of the file (%d bytes)", req.GetOffset(), size)
+		}
+		first := len(chunks)
+		for i := range chunks {
+			if req.GetOffset() < starts[i+1] {
+				first = i
+				break
 			}
+		}
+		skip = req.GetOffset() - starts[first]
+		if req.GetLength() > 0 {
+			remaining = req.GetLength()
+		}
+		chunks = chunks[first:]
+	}
 
-			chunkData = chunkResp.Data
+	for i, chunkInfo := range chunks {
+		if remaining == 0 {
 			break
 		}
-
-		if chunkData == nil {
-			chunkErr = fmt.Errorf("failed to retrieve chunk %s from any node", chunkInfo.ChunkId)
+		chunkData, err := s.readChunk(stream.Context(), chunkInfo)
+		if err != nil {
+			// The cached chunk list may be out of date; fetch it afresh
+			// next time.
+			s.cache.invalidate(meta.GetFileId())
+			return status.Errorf(codes.Internal, "failed to retrieve chunk: %v", err)
 		}
-
-		if chunkErr != nil {
-			return status.Errorf(codes.Internal, "failed to retrieve chunk: %v", chunkErr)
+		chunkData = chunkData[min(skip, int64(len(chunkData))):]
+		skip = 0
+		if remaining >= 0 {
+			chunkData = chunkData[:min(remaining, int64(len(chunkData)))]
+			remaining -= int64(len(chunkData))
 		}
 
-		err = stream.Send(&pbcoord.DownloadFileResponse{
-			FileName:  metaResp.Metadata.FileName,
+		resp := &pbcoord.DownloadFileResponse{
+			FileName:  meta.FileName,
 			ChunkData: chunkData,
-		})
+		}
+		if i == 0 {
+			resp.Sha256 = version.Sha256
+			resp.Md5 = version.Md5
+		}
+		err = stream.Send(resp)
 		if err != nil {
 			log.Printf("Failed to send chunk %s to client: %v", chunkInfo.ChunkId, err)
 			return status.Errorf(codes.Internal, "failed to send chunk: %v", err)
@@ -208,19 +319,240 @@ func (s *Server) DownloadFile(req *pbcoord.DownloadFileRequest, stream pbcoord.C
 		log.Printf("Sent chunk %s to client", chunkInfo.ChunkId)
 	}
 
-	log.Printf("File download completed successfully for file ID: %s", req.GetFileId())
+	log.Printf("File download completed successfully for file ID: %s", meta.GetFileId())
 	return nil
 }
 
+// DeleteFile moves a file to the trash, or deletes it and its chunks right
+// away if the request is permanent or the trash is disabled.
 func (s *Server) DeleteFile(ctx context.Context, req *pbcoord.DeleteFileRequest) (*pbcoord.DeleteFileResponse, error) {
-	metaResp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{
-		FileId: req.GetFileId(),
+	fileID, byPath := req.GetFileId(), ""
+	if fileID == "" && req.GetPath() != "" {
+		byPath = req.GetPath()
+	}
+	if req.GetPermanent() || s.trashRetention < 0 {
+		getReq := &pbmeta.GetFileMetadataRequest{FileId: fileID, IncludeDeleted: true}
+		if byPath != "" {
+			getReq = &pbmeta.GetFileMetadataRequest{Path: cleanPath(byPath, "")}
+		}
+		resp, err := s.metadataClient.GetFileMetadata(ctx, getReq)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "file not found: %v", err)
+		}
+		if err := s.checkAccess(ctx, resp.Metadata, auth.Write); err != nil {
+			return nil, err
+		}
+		// The purge is conditional on the generation read, so the file
+		// cannot have left the path in between.
+		if err := s.purgeFile(ctx, resp.Metadata); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to delete metadata: %v", err)
+		}
+		return &pbcoord.DeleteFileResponse{Success: true}, nil
+	}
+
+	if byPath != "" {
+		meta, err := s.lookupFile(ctx, "", byPath)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "file not found: %v", err)
+		}
+		fileID = meta.FileId
+	}
+	log.Printf("Moving file %s to the trash", fileID)
+	_, err := s.updateMetadata(ctx, fileID, false, func(meta *pbmeta.FileMetadata) error {
+		if err := stillAt(meta, byPath); err != nil {
+			return err
+		}
+		if err := s.checkAccess(ctx, meta, auth.Write); err != nil {
+			return err
+		}
+		meta.DeletedAt = time.Now().UTC().Format(time.RFC3339)
+		return nil
 	})
+	if err != nil {
+		log.Printf("Failed to move file %s to the trash: %v", fileID, err)
+		return nil, err
+	}
+	return &pbcoord.DeleteFileResponse{Success: true}, nil
+}
+
+// MoveFile gives a file a new path. A different file already at the
+// destination is moved to the trash in the same step if the request allows
+// it.
+func (s *Server) MoveFile(ctx context.Context, req *pbcoord.MoveFileRequest) (*pbcoord.MoveFileResponse, error) {
+	if req.GetDestinationPath() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "no destination path given")
+	}
+	dest := cleanPath(req.GetDestinationPath(), "")
+	if isSnapshotPath(dest) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is in a read-only snapshot", dest)
+	}
+	source, err := s.lookupFile(ctx, req.GetFileId(), req.GetPath())
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "file not found: %v", err)
 	}
+	byPath := ""
+	if req.GetFileId() == "" {
+		byPath = req.GetPath()
+	}
+	if err := s.checkAccess(ctx, source, auth.Write); err != nil {
+		return nil, err
+	}
+
+	var replaced *pbmeta.FileMetadata
+	resp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{Path: dest})
+	switch {
+	case err == nil && resp.Metadata.FileId != source.FileId:
+		if !req.GetOverwrite() {
+			return nil, status.Errorf(codes.AlreadyExists, "%s already exists", dest)
+		}
+		replaced = resp.Metadata
+	case err != nil && status.Code(err) != codes.NotFound:
+		return nil, err
+	}
+	if replaced != nil {
+		if err := s.checkAccess(ctx, replaced, auth.Write); err != nil {
+			return nil, err
+		}
+	} else if path.Dir(dest) != path.Dir(source.Path) {
+		if err := s.checkCreate(ctx, path.Dir(dest)); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Moving file %s from %s to %s", source.FileId, source.Path, dest)
+	// The metadata service moves replaced to the trash in the same step,
+	// provided that it has not changed since it was read.
+	meta, err := s.updateMetadataWith(ctx, source.FileId, false, &pbmeta.UpdateFileMetadataRequest{
+		ReplaceFileId:  replaced.GetFileId(),
+		ReplaceIfMatch: replaced.GetGeneration(),
+	}, func(meta *pbmeta.FileMetadata) error {
+		if err := stillAt(meta, byPath); err != nil {
+			return err
+		}
+		meta.Path = dest
+		meta.FileName = path.Base(dest)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to move file %s: %v", source.FileId, err)
+		return nil, err
+	}
+	s.dropReplaced(ctx, replaced)
+	return &pbcoord.MoveFileResponse{File: fileInfo(meta)}, nil
+}
+
+// storeChunk writes data to replicationFactor storage nodes, starting at the
+// node for the chunk's position in its file, and returns where it is stored.
+func (s *Server) storeChunk(ctx context.Context, chunkID string, index int, data []byte) (*pbmeta.ChunkInfo, error) {
+	chunkInfo := &pbmeta.ChunkInfo{
+		ChunkId: chunkID,
+		NodeIds: []string{},
+		Size:    int64(len(data)),
+	}
+
+	checksum := calculateChecksum(data)
+	log.Printf("Calculated checksum for chunk %s: %s", chunkID, checksum)
+
+	for i := 0; i < replicationFactor; i++ {
+		nodeIndex := (index + i) % len(s.storageNodes)
+		_, err := s.storageNodes[nodeIndex].client.PutChunk(ctx, &pbstorage.PutChunkRequest{
+			ChunkId:  chunkID,
+			Data:     data,
+			Checksum: checksum,
+		})
+		if err != nil {
+			log.Printf("Failed to store chunk %s on node %d: %v", chunkID, nodeIndex, err)
+			return nil, err
+		}
+
+		chunkInfo.NodeIds = append(chunkInfo.NodeIds, s.storageNodes[nodeIndex].nodeID)
+		log.Printf("Stored chunk %s on node %d", chunkID, nodeIndex)
+	}
+	return chunkInfo, nil
+}
+
+// readChunk fetches a chunk from the first replica that returns it with a
+// matching checksum.
+func (s *Server) readChunk(ctx context.Context, chunkInfo *pbmeta.ChunkInfo) ([]byte, error) {
+	for _, nodeID := range chunkInfo.NodeIds {
+		var node *StorageNode
+		for _, n := range s.storageNodes {
+			if n.nodeID == nodeID {
+				node = &n
+				break
+			}
+		}
+		if node == nil {
+			log.Printf("Node %s not found for chunk %s", nodeID, chunkInfo.ChunkId)
+			continue
+		}
+
+		chunkResp, err := node.client.GetChunk(ctx, &pbstorage.GetChunkRequest{
+			ChunkId: chunkInfo.ChunkId,
+		})
+		if err != nil {
+			log.Printf("Failed to retrieve chunk %s from node %s: %v", chunkInfo.ChunkId, nodeID, err)
+			continue
+		}
+
+		calculatedChecksum := calculateChecksum(chunkResp.Data)
+		if calculatedChecksum != chunkResp.Checksum {
+			log.Printf("Checksum mismatch for chunk %s from node %s", chunkInfo.ChunkId, nodeID)
+			continue
+		}
+
+		return chunkResp.Data, nil
+	}
+	return nil, fmt.Errorf("failed to retrieve chunk %s from any node", chunkInfo.ChunkId)
+}
 
-	for _, chunkInfo := range metaResp.Metadata.Chunks {
+// ListFiles lists the files whose path starts with the given prefix.
+func (s *Server) ListFiles(ctx context.Context, req *pbcoord.ListFilesRequest) (*pbcoord.ListFilesResponse, error) {
+	resp, err := s.metadataClient.ListFiles(ctx, &pbmeta.ListFilesRequest{
+		PathPrefix:   req.GetPathPrefix(),
+		Tags:         req.GetTags(),
+		UserMetadata: req.GetUserMetadata(),
+		ContentType:  req.GetContentType(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := &pbcoord.ListFilesResponse{}
+	for _, meta := range resp.Files {
+		if s.canRead(ctx, meta) {
+			files.Files = append(files.Files, fileInfo(meta))
+		}
+	}
+	return files, nil
+}
+
+// deleteChunks removes every replica of chunks from the storage nodes, except
+// for chunks that another file, version or snapshot still refers to. It must
+// be called after the metadata that referred to the chunks is gone. Failures
+// are logged and otherwise ignored.
+func (s *Server) deleteChunks(ctx context.Context, chunks []*pbmeta.ChunkInfo) {
+	if len(chunks) == 0 {
+		return
+	}
+	var ids []string
+	for _, chunkInfo := range chunks {
+		ids = append(ids, chunkInfo.ChunkId)
+	}
+	resp, err := s.metadataClient.UnreferencedChunks(ctx, &pbmeta.UnreferencedChunksRequest{ChunkIds: ids})
+	if err != nil {
+		log.Printf("Failed to check references to %d chunks, keeping them: %v", len(ids), err)
+		return
+	}
+	unreferenced := make(map[string]bool, len(resp.ChunkIds))
+	for _, id := range resp.ChunkIds {
+		unreferenced[id] = true
+	}
+
+	for _, chunkInfo := range chunks {
+		if !unreferenced[chunkInfo.ChunkId] {
+			log.Printf("Keeping chunk %s, which is still referenced", chunkInfo.ChunkId)
+			continue
+		}
 		for _, nodeID := range chunkInfo.NodeIds {
 			var node *StorageNode
 			for _, n := range s.storageNodes {
@@ -243,15 +575,186 @@ func (s *Server) DeleteFile(ctx context.Context, req *pbcoord.DeleteFileRequest)
 			log.Printf("Deleted chunk %s from node %s", chunkInfo.ChunkId, nodeID)
 		}
 	}
+}
 
-	_, err = s.metadataClient.DeleteFileMetadata(ctx, &pbmeta.DeleteFileMetadataRequest{
-		FileId: req.GetFileId(),
+// saveOptions qualify how saveFile saves a file.
+type saveOptions struct {
+	// shared says that the file takes its chunks from other files.
+	shared bool
+	// unchanged requires a file that gains a new version to still be at
+	// the generation it was read at, rather than add the version to
+	// whatever it has become since.
+	unchanged bool
+}
+
+// saveFile records meta as the contents of the file at meta's path.
+// existing and policy are what existingFile returned for the path. If
+// versioning applies, meta becomes the new version of existing, and
+// attributes that meta leaves unset keep the values existing has. Otherwise
+// meta is saved as a new file, and the metadata service moves existing to
+// the trash in the same step, provided that it has not changed since it was
+// read.
+func (s *Server) saveFile(ctx context.Context, meta *pbmeta.FileMetadata, existing *pbmeta.FileMetadata, policy *pbmeta.VersioningPolicy, opts saveOptions) error {
+	if existing != nil && policy.GetEnabled() {
+		var pruned []*pbmeta.FileVersion
+		req := &pbmeta.UpdateFileMetadataRequest{SharesChunks: opts.shared}
+		if opts.unchanged {
+			req.IfMatch = existing.Generation
+		}
+		_, err := s.updateMetadataWith(ctx, existing.FileId, false, req, func(current *pbmeta.FileMetadata) error {
+			archiveCurrent(current)
+			current.FileName = meta.FileName
+			current.VersionId = meta.VersionId
+			current.FileSize = meta.FileSize
+			current.Chunks = meta.Chunks
+			current.Sha256 = meta.Sha256
+			current.Md5 = meta.Md5
+			if meta.ContentType != "" {
+				current.ContentType = meta.ContentType
+			}
+			if meta.UserMetadata != nil {
+				current.UserMetadata = meta.UserMetadata
+			}
+			if meta.Tags != nil {
+				current.Tags = meta.Tags
+			}
+			pruned = pruneVersions(current, policy, time.Now())
+			return nil
+		})
+		if err == nil {
+			s.deleteVersions(ctx, pruned)
+		}
+		return err
+	}
+
+	meta.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	meta.UpdatedAt = meta.CreatedAt
+	_, err := s.metadataClient.SaveFileMetadata(ctx, &pbmeta.SaveFileMetadataRequest{
+		Metadata: meta,
+		// File IDs are freshly generated, so an existing record means a
+		// collision rather than an overwrite.
+		IfNotExists:    true,
+		ReplaceFileId:  existing.GetFileId(),
+		ReplaceIfMatch: existing.GetGeneration(),
+		SharesChunks:   opts.shared,
 	})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to delete metadata: %v", err)
+		return err
 	}
+	s.dropReplaced(ctx, existing)
+	return nil
+}
 
-	return &pbcoord.DeleteFileResponse{Success: true}, nil
+// saveFailed logs that the metadata of fileID could not be saved and returns
+// the error to report. Failed conditions keep their codes.
+func saveFailed(fileID string, err error) error {
+	log.Printf("Failed to save metadata for file %s: %v", fileID, err)
+	switch status.Code(err) {
+	case codes.AlreadyExists, codes.FailedPrecondition, codes.Aborted:
+		return err
+	}
+	return status.Errorf(codes.Internal, "failed to save metadata: %v", err)
+}
+
+// checkUploadConditions checks the if_none_match and if_match conditions of
+// an upload to filePath against existing, the file there. saveFile checks
+// again that existing is still there, unchanged, when the upload is saved.
+func checkUploadConditions(req *pbcoord.UploadFileRequest, filePath string, existing *pbmeta.FileMetadata) error {
+	switch {
+	case req.GetIfNoneMatch() && existing != nil:
+		return status.Errorf(codes.AlreadyExists, "%s already exists", filePath)
+	case req.GetIfMatchFileId() == "":
+		return nil
+	case existing == nil:
+		return status.Errorf(codes.FailedPrecondition, "there is no file at %s", filePath)
+	case existing.FileId != req.GetIfMatchFileId():
+		return status.Errorf(codes.FailedPrecondition, "%s is file %s, not %s", filePath, existing.FileId, req.GetIfMatchFileId())
+	case req.GetIfMatch() != 0 && existing.Generation != req.GetIfMatch():
+		return status.Errorf(codes.FailedPrecondition, "%s is at generation %d, not %d", filePath, existing.Generation, req.GetIfMatch())
+	}
+	return nil
+}
+
+// existingFile returns the file already at filePath and its versioning
+// policy, or nil if there is none. Uploading to filePath adds a new version
+// of the file if the policy enables versioning and replaces it otherwise.
+func (s *Server) existingFile(ctx context.Context, filePath string) (*pbmeta.FileMetadata, *pbmeta.VersioningPolicy, error) {
+	existing, err := s.fileAtPath(ctx, filePath)
+	if err != nil || existing == nil {
+		return nil, nil, err
+	}
+	policy, err := s.versioningPolicy(ctx, existing, filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return existing, policy, nil
+}
+
+// lookupFile returns the metadata of the file with fileID or, if fileID is
+// empty, of the file at filePath.
+func (s *Server) lookupFile(ctx context.Context, fileID, filePath string) (*pbmeta.FileMetadata, error) {
+	if fileID != "" || filePath == "" {
+		return s.getMetadata(ctx, fileID)
+	}
+	// Lookups by path bypass the cache, which is keyed by file ID and would
+	// mix up a snapshot's copy of a file with the live one.
+	resp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{
+		Path: cleanPath(filePath, ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Metadata, nil
+}
+
+// stillAt fails with codes.NotFound unless meta, a file that was looked up
+// at filePath, is still there. Changes to a file found by path check it in
+// their conditional update, so that they never act on a file that has moved
+// away or been replaced in the meantime. It passes if filePath is empty.
+func stillAt(meta *pbmeta.FileMetadata, filePath string) error {
+	if filePath != "" && meta.Path != cleanPath(filePath, "") {
+		return status.Errorf(codes.NotFound, "file %s is no longer at %s", meta.FileId, cleanPath(filePath, ""))
+	}
+	return nil
+}
+
+// getMetadata returns the metadata for fileID, from the cache while its lease
+// is valid. Expired entries are revalidated by generation, so an unchanged
+// file costs a round trip without a chunk list.
+func (s *Server) getMetadata(ctx context.Context, fileID string) (*pbmeta.FileMetadata, error) {
+	cached, fresh, ok := s.cache.get(fileID)
+	if ok && fresh {
+		return cached, nil
+	}
+
+	req := &pbmeta.GetFileMetadataRequest{FileId: fileID}
+	if ok {
+		req.KnownGeneration = cached.Generation
+	}
+	resp, err := s.metadataClient.GetFileMetadata(ctx, req)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			s.cache.invalidate(fileID)
+		}
+		return nil, err
+	}
+
+	lease := time.Duration(resp.LeaseMs) * time.Millisecond
+	if resp.NotModified && ok {
+		s.cache.renew(fileID, cached.Generation, lease)
+		return cached, nil
+	}
+	s.cache.put(resp.Metadata, lease)
+	return resp.Metadata, nil
+}
+
+// cleanPath returns p as an absolute, cleaned namespace path, falling back to
+// the file name in the root directory when p is empty.
+func cleanPath(p, fileName string) string {
+	if p == "" {
+		p = fileName
+	}
+	return path.Clean("/" + p)
 }
 
 func generateFileID(fileName string) string {
@@ -259,8 +762,8 @@ func generateFileID(fileName string) string {
 	return fmt.Sprintf("%x", hash[:8])
 }
 
-func generateChunkID(fileID string, chunkIndex int) string {
-	return fmt.Sprintf("%s-chunk-%d", fileID, chunkIndex)
+func generateChunkID(fileID, versionID string, chunkIndex int) string {
+	return fmt.Sprintf("%s-%s-chunk-%d", fileID, versionID, chunkIndex)
 }
 
 func calculateChecksum(data []byte) string {
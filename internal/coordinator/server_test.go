@@ -0,0 +1,233 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"dfs/internal/chunk"
+	"dfs/internal/metadataservice"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+	pbstorage "dfs/internal/pb/storagenode"
+	"dfs/internal/storagenode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// testCluster is a metadata service, three storage nodes and a coordinator
+// running in the test process.
+type testCluster struct {
+	server *Server
+	client pbcoord.CoordinatorClient
+	meta   metadataservice.Store
+	chunks []*chunk.DiskStore
+	// addrs holds the addresses of the metadata service and the storage
+	// nodes.
+	addrs Config
+}
+
+// serve starts a gRPC server with the services register adds and returns
+// its address.
+func serve(t *testing.T, register func(s *grpc.Server), opts ...grpc.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(opts...)
+	register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// startCluster runs a cluster whose coordinator has cfg, with the
+// addresses filled in, and serves the coordinator with opts. The cache and
+// the retention sweep are off unless cfg enables them.
+func startCluster(t *testing.T, cfg Config, opts ...grpc.ServerOption) *testCluster {
+	t.Helper()
+	store, err := metadataservice.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCluster{meta: store}
+	cfg.MetadataAddrs = []string{serve(t, func(s *grpc.Server) {
+		pbmeta.RegisterMetadataServiceServer(s, metadataservice.NewServer(store))
+	})}
+	for i := 0; i < 3; i++ {
+		chunks, err := chunk.NewDiskStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.chunks = append(c.chunks, chunks)
+		cfg.StorageAddrs = append(cfg.StorageAddrs, serve(t, func(s *grpc.Server) {
+			pbstorage.RegisterStorageNodeServer(s, storagenode.NewServer(chunks))
+		}))
+	}
+	c.addrs = Config{MetadataAddrs: cfg.MetadataAddrs, StorageAddrs: cfg.StorageAddrs}
+	c.server, c.client = c.coordinator(t, cfg, opts...)
+	return c
+}
+
+// coordinator starts another coordinator of the cluster with cfg, whose
+// addresses it fills in, as startCluster does.
+func (c *testCluster) coordinator(t *testing.T, cfg Config, opts ...grpc.ServerOption) (*Server, pbcoord.CoordinatorClient) {
+	t.Helper()
+	cfg.MetadataAddrs, cfg.StorageAddrs = c.addrs.MetadataAddrs, c.addrs.StorageAddrs
+	if cfg.CacheSize == 0 {
+		cfg.CacheSize = -1
+	}
+	if cfg.RetentionInterval == 0 {
+		cfg.RetentionInterval = -1
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(serve(t, func(s *grpc.Server) {
+		pbcoord.RegisterCoordinatorServer(s, server)
+	}, opts...), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, pbcoord.NewCoordinatorClient(conn)
+}
+
+// upload stores data at path, sent in chunkSize pieces as clients do. req,
+// if not nil, holds the other fields of the first message.
+func (c *testCluster) upload(ctx context.Context, path string, data []byte, req *pbcoord.UploadFileRequest) (*pbcoord.UploadFileResponse, error) {
+	stream, err := c.client.UploadFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg := &pbcoord.UploadFileRequest{}
+	if req != nil {
+		msg = req
+	}
+	msg.Path = path
+	msg.FileName = path
+	for {
+		n := min(chunkSize, len(data))
+		msg.ChunkData = data[:n]
+		data = data[n:]
+		if err := stream.Send(msg); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(data) == 0 {
+			break
+		}
+		msg = &pbcoord.UploadFileRequest{}
+	}
+	return stream.CloseAndRecv()
+}
+
+// mustUpload is upload for files the test needs to exist.
+func (c *testCluster) mustUpload(t *testing.T, path string, data []byte) *pbcoord.UploadFileResponse {
+	t.Helper()
+	resp, err := c.upload(context.Background(), path, data, nil)
+	if err != nil {
+		t.Fatalf("uploading %s: %v", path, err)
+	}
+	return resp
+}
+
+// download returns the contents of the file req names.
+func (c *testCluster) download(ctx context.Context, req *pbcoord.DownloadFileRequest) ([]byte, error) {
+	stream, err := c.client.DownloadFile(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(resp.ChunkData)
+	}
+}
+
+// checkContents fails the test unless the current contents of fileID are
+// want.
+func (c *testCluster) checkContents(t *testing.T, fileID string, want []byte) {
+	t.Helper()
+	got, err := c.download(context.Background(), &pbcoord.DownloadFileRequest{FileId: fileID})
+	if err != nil {
+		t.Fatalf("downloading %s: %v", fileID, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("file %s holds %q, want %q", fileID, truncate(got), truncate(want))
+	}
+}
+
+func truncate(data []byte) []byte {
+	if len(data) > 64 {
+		return data[:64]
+	}
+	return data
+}
+
+// chunkCount returns how many chunks the storage nodes hold in total.
+func (c *testCluster) chunkCount(t *testing.T) int {
+	t.Helper()
+	n := 0
+	for _, store := range c.chunks {
+		ids, err := store.IDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += len(ids)
+	}
+	return n
+}
+
+func TestChangesByPath(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	a := c.mustUpload(t, "/a.txt", []byte("a"))
+
+	if _, err := c.client.SetPermissions(ctx, &pbcoord.SetPermissionsRequest{FilePath: "/a.txt", Owner: "alice", Mode: 0o600, SetMode: true}); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: a.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.File.Owner != "alice" || stat.File.Mode != 0o600 {
+		t.Fatalf("permissions set by path = %s %o", stat.File.Owner, stat.File.Mode)
+	}
+
+	if _, err := c.client.DeleteFile(ctx, &pbcoord.DeleteFileRequest{Path: "/a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{Path: "/a.txt"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("stat after delete by path = %v", err)
+	}
+	if _, err := c.client.DeleteFile(ctx, &pbcoord.DeleteFileRequest{Path: "/a.txt"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("delete of a missing path = %v", err)
+	}
+}
+
+func TestStillAt(t *testing.T) {
+	meta := &pbmeta.FileMetadata{FileId: "f", Path: "/dir/a.txt"}
+	for _, p := range []string{"", "/dir/a.txt", "dir/a.txt", "/dir//a.txt"} {
+		if err := stillAt(meta, p); err != nil {
+			t.Errorf("stillAt(%q) = %v", p, err)
+		}
+	}
+	// The file moved away, or another file took its place, after it was
+	// looked up at /dir/b.txt.
+	if err := stillAt(meta, "/dir/b.txt"); status.Code(err) != codes.NotFound {
+		t.Errorf("stillAt of another path = %v, want NotFound", err)
+	}
+}
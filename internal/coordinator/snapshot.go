@@ -0,0 +1,72 @@
+package coordinator
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+)
+
+// snapshotRoot is the read-only namespace where the metadata service exposes
+// snapshot contents.
+const snapshotRoot = "/.snapshots"
+
+func (s *Server) CreateSnapshot(ctx context.Context, req *pbcoord.CreateSnapshotRequest) (*pbcoord.CreateSnapshotResponse, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Creating snapshot of %s", req.GetPath())
+	resp, err := s.metadataClient.CreateSnapshot(ctx, &pbmeta.CreateSnapshotRequest{
+		Path: cleanPath(req.GetPath(), ""),
+		Id:   req.GetId(),
+	})
+	if err != nil {
+		log.Printf("Failed to create snapshot of %s: %v", req.GetPath(), err)
+		return nil, err
+	}
+	return &pbcoord.CreateSnapshotResponse{Snapshot: snapshotToProto(resp.Snapshot)}, nil
+}
+
+func (s *Server) ListSnapshots(ctx context.Context, req *pbcoord.ListSnapshotsRequest) (*pbcoord.ListSnapshotsResponse, error) {
+	resp, err := s.metadataClient.ListSnapshots(ctx, &pbmeta.ListSnapshotsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	snaps := &pbcoord.ListSnapshotsResponse{}
+	for _, snap := range resp.Snapshots {
+		snaps.Snapshots = append(snaps.Snapshots, snapshotToProto(snap))
+	}
+	return snaps, nil
+}
+
+// DeleteSnapshot drops a snapshot and frees the chunks that only it still
+// referred to.
+func (s *Server) DeleteSnapshot(ctx context.Context, req *pbcoord.DeleteSnapshotRequest) (*pbcoord.DeleteSnapshotResponse, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Deleting snapshot %s", req.GetId())
+	resp, err := s.metadataClient.DeleteSnapshot(ctx, &pbmeta.DeleteSnapshotRequest{Id: req.GetId()})
+	if err != nil {
+		log.Printf("Failed to delete snapshot %s: %v", req.GetId(), err)
+		return nil, err
+	}
+	s.deleteChunks(ctx, resp.ReleasedChunks)
+	return &pbcoord.DeleteSnapshotResponse{Success: true}, nil
+}
+
+func isSnapshotPath(p string) bool {
+	return p == snapshotRoot || strings.HasPrefix(p, snapshotRoot+"/")
+}
+
+func snapshotToProto(snap *pbmeta.Snapshot) *pbcoord.Snapshot {
+	return &pbcoord.Snapshot{
+		Id:        snap.Id,
+		Path:      snap.Path,
+		CreatedAt: snap.CreatedAt,
+		FileCount: snap.FileCount,
+		TotalSize: snap.TotalSize,
+	}
+}
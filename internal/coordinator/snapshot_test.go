@@ -0,0 +1,81 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSnapshots(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	a := c.mustUpload(t, "/docs/a.txt", []byte("a before"))
+	b := c.mustUpload(t, "/docs/b.txt", []byte("b"))
+	c.mustUpload(t, "/other.txt", []byte("other"))
+
+	snap, err := c.client.CreateSnapshot(ctx, &pbcoord.CreateSnapshotRequest{Path: "/docs", Id: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Snapshot.FileCount != 2 || snap.Snapshot.Path != "/docs" {
+		t.Fatalf("snapshot = %v, want 2 files of /docs", snap.Snapshot)
+	}
+	if _, err := c.client.CreateSnapshot(ctx, &pbcoord.CreateSnapshotRequest{Path: "/docs", Id: "s1"}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("snapshot with a taken ID = %v, want AlreadyExists", err)
+	}
+
+	// Replacing the live files and deleting them for good leaves the
+	// snapshot's contents, and their chunks, in place.
+	c.mustUpload(t, "/docs/a.txt", []byte("a after"))
+	for _, f := range []*pbcoord.UploadFileResponse{a, b} {
+		if _, err := c.client.DeleteFile(ctx, &pbcoord.DeleteFileRequest{FileId: f.FileId, Permanent: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, want := range map[string]string{
+		"/.snapshots/s1/docs/a.txt": "a before",
+		"/.snapshots/s1/docs/b.txt": "b",
+		"/docs/a.txt":               "a after",
+	} {
+		got, err := c.download(ctx, &pbcoord.DownloadFileRequest{Path: p})
+		if err != nil || string(got) != want {
+			t.Errorf("%s holds %q, %v; want %q", p, got, err, want)
+		}
+	}
+	if _, err := c.download(ctx, &pbcoord.DownloadFileRequest{Path: "/.snapshots/s1/other.txt"}); status.Code(err) != codes.NotFound {
+		t.Errorf("download of a file outside the snapshot = %v, want NotFound", err)
+	}
+
+	// Snapshots are read-only.
+	if _, err := c.upload(ctx, "/.snapshots/s1/docs/c.txt", []byte("c"), nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("upload into a snapshot = %v, want InvalidArgument", err)
+	}
+	_, err = c.client.CopyFile(ctx, &pbcoord.CopyFileRequest{SourcePath: "/other.txt", DestinationPath: "/.snapshots/s1/x"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("copy into a snapshot = %v, want InvalidArgument", err)
+	}
+
+	list, err := c.client.ListSnapshots(ctx, &pbcoord.ListSnapshotsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Snapshots) != 1 || list.Snapshots[0].Id != "s1" {
+		t.Fatalf("snapshots = %v", list.Snapshots)
+	}
+
+	// Deleting the snapshot frees the chunks only it referred to: those
+	// of the live /docs/a.txt and /other.txt stay.
+	if _, err := c.client.DeleteSnapshot(ctx, &pbcoord.DeleteSnapshotRequest{Id: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.chunkCount(t); n != 2*replicationFactor {
+		t.Errorf("%d chunks stored after deleting the snapshot, want %d", n, 2*replicationFactor)
+	}
+	if _, err := c.download(ctx, &pbcoord.DownloadFileRequest{Path: "/.snapshots/s1/docs/b.txt"}); status.Code(err) != codes.NotFound {
+		t.Errorf("download from a deleted snapshot = %v, want NotFound", err)
+	}
+}
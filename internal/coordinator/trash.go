@@ -0,0 +1,174 @@
+package coordinator
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultTrashRetention is how long deleted files stay in the trash before
+// the purger frees their chunks.
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+func (s *Server) ListTrash(ctx context.Context, req *pbcoord.ListTrashRequest) (*pbcoord.ListTrashResponse, error) {
+	resp, err := s.metadataClient.ListFiles(ctx, &pbmeta.ListFilesRequest{
+		PathPrefix: req.GetPathPrefix(),
+		Deleted:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	trash := &pbcoord.ListTrashResponse{}
+	for _, meta := range resp.Files {
+		if !s.canRead(ctx, meta) {
+			continue
+		}
+		entry := &pbcoord.TrashEntry{
+			FileId:    meta.FileId,
+			FileName:  meta.FileName,
+			Path:      meta.Path,
+			FileSize:  meta.FileSize,
+			DeletedAt: meta.DeletedAt,
+		}
+		if deleted, err := time.Parse(time.RFC3339, meta.DeletedAt); err == nil && s.trashRetention > 0 {
+			entry.PurgeAt = deleted.Add(s.trashRetention).UTC().Format(time.RFC3339)
+		}
+		trash.Entries = append(trash.Entries, entry)
+	}
+	return trash, nil
+}
+
+// Restore moves a file out of the trash back to its original path.
+func (s *Server) Restore(ctx context.Context, req *pbcoord.RestoreRequest) (*pbcoord.RestoreResponse, error) {
+	log.Printf("Restoring file %s from the trash", req.GetFileId())
+	_, err := s.updateMetadata(ctx, req.GetFileId(), true, func(meta *pbmeta.FileMetadata) error {
+		if err := s.checkAccess(ctx, meta, auth.Write); err != nil {
+			return err
+		}
+		meta.DeletedAt = ""
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to restore file %s: %v", req.GetFileId(), err)
+		return nil, err
+	}
+	return &pbcoord.RestoreResponse{Success: true}, nil
+}
+
+// PurgeTrash deletes trashed files and their chunks immediately, without
+// waiting for the retention period.
+func (s *Server) PurgeTrash(ctx context.Context, req *pbcoord.PurgeTrashRequest) (*pbcoord.PurgeTrashResponse, error) {
+	var files []*pbmeta.FileMetadata
+	if req.GetFileId() != "" {
+		resp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{
+			FileId:         req.GetFileId(),
+			IncludeDeleted: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Metadata.DeletedAt == "" {
+			return nil, status.Errorf(codes.NotFound, "file %s is not in the trash", req.GetFileId())
+		}
+		if err := s.checkAccess(ctx, resp.Metadata, auth.Write); err != nil {
+			return nil, err
+		}
+		files = append(files, resp.Metadata)
+	} else {
+		resp, err := s.metadataClient.ListFiles(ctx, &pbmeta.ListFilesRequest{Deleted: true})
+		if err != nil {
+			return nil, err
+		}
+		// Users only empty the trash of the files they could delete.
+		for _, meta := range resp.Files {
+			if s.checkAccess(ctx, meta, auth.Write) == nil {
+				files = append(files, meta)
+			}
+		}
+	}
+
+	var purged int32
+	for _, meta := range files {
+		if err := s.purgeFile(ctx, meta); err != nil {
+			log.Printf("Failed to purge file %s: %v", meta.FileId, err)
+			continue
+		}
+		purged++
+	}
+	return &pbcoord.PurgeTrashResponse{Purged: purged}, nil
+}
+
+// purgeExpired purges every file that has been in the trash for longer than
+// the retention period.
+func (s *Server) purgeExpired(ctx context.Context) error {
+	if s.trashRetention < 0 {
+		return nil
+	}
+	resp, err := s.metadataClient.ListFiles(ctx, &pbmeta.ListFilesRequest{Deleted: true})
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-s.trashRetention)
+	for _, meta := range resp.Files {
+		deleted, err := time.Parse(time.RFC3339, meta.DeletedAt)
+		if err != nil || deleted.After(cutoff) {
+			continue
+		}
+		if err := s.purgeFile(ctx, meta); err != nil {
+			log.Printf("Failed to purge file %s: %v", meta.FileId, err)
+		}
+	}
+	return nil
+}
+
+// purgeFile deletes meta's record and then the chunks of every version. The
+// record is deleted only if it is unchanged since meta was read, so a file
+// restored in the meantime is left alone.
+func (s *Server) purgeFile(ctx context.Context, meta *pbmeta.FileMetadata) error {
+	log.Printf("Purging file %s", meta.FileId)
+	_, err := s.metadataClient.DeleteFileMetadata(ctx, &pbmeta.DeleteFileMetadataRequest{
+		FileId:  meta.FileId,
+		IfMatch: meta.Generation,
+	})
+	s.cache.invalidate(meta.FileId)
+	if err != nil {
+		return err
+	}
+
+	s.deleteChunks(ctx, meta.Chunks)
+	for _, v := range meta.Versions {
+		s.deleteChunks(ctx, v.Chunks)
+	}
+	return nil
+}
+
+// dropReplaced forgets a file that the metadata service moved to the trash
+// because another file replaced it at its path, and deletes it right away if
+// the trash is disabled. replaced may be nil.
+func (s *Server) dropReplaced(ctx context.Context, replaced *pbmeta.FileMetadata) {
+	if replaced == nil {
+		return
+	}
+	s.cache.invalidate(replaced.FileId)
+	if s.trashRetention >= 0 {
+		return
+	}
+	resp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{
+		FileId:         replaced.FileId,
+		IncludeDeleted: true,
+	})
+	if err == nil && resp.Metadata.DeletedAt != "" {
+		err = s.purgeFile(ctx, resp.Metadata)
+	}
+	if err != nil {
+		log.Printf("Failed to delete file %s replaced at %s: %v", replaced.FileId, replaced.Path, err)
+	}
+}
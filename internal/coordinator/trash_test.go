@@ -0,0 +1,155 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// trashIDs returns the IDs of the files in the trash.
+func (c *testCluster) trashIDs(t *testing.T) []string {
+	t.Helper()
+	resp, err := c.client.ListTrash(context.Background(), &pbcoord.ListTrashRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range resp.Entries {
+		ids = append(ids, e.FileId)
+	}
+	return ids
+}
+
+func TestTrash(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	f := c.mustUpload(t, "/a.txt", []byte("hello"))
+
+	if _, err := c.client.DeleteFile(ctx, &pbcoord.DeleteFileRequest{FileId: f.FileId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId}); status.Code(err) != codes.NotFound {
+		t.Fatalf("stat of a trashed file = %v, want NotFound", err)
+	}
+	resp, err := c.client.ListTrash(ctx, &pbcoord.ListTrashRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].FileId != f.FileId || resp.Entries[0].Path != "/a.txt" {
+		t.Fatalf("trash = %v", resp.Entries)
+	}
+	deleted, err := time.Parse(time.RFC3339, resp.Entries[0].DeletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purge, _ := time.Parse(time.RFC3339, resp.Entries[0].PurgeAt); !purge.Equal(deleted.Add(DefaultTrashRetention)) {
+		t.Errorf("purge at %s for a file deleted at %s", resp.Entries[0].PurgeAt, resp.Entries[0].DeletedAt)
+	}
+	// The chunks stay until the file is purged.
+	if n := c.chunkCount(t); n != replicationFactor {
+		t.Fatalf("%d chunks stored, want %d", n, replicationFactor)
+	}
+
+	if _, err := c.client.Restore(ctx, &pbcoord.RestoreRequest{FileId: f.FileId}); err != nil {
+		t.Fatal(err)
+	}
+	c.checkContents(t, f.FileId, []byte("hello"))
+	if _, err := c.client.Restore(ctx, &pbcoord.RestoreRequest{FileId: f.FileId}); status.Code(err) != codes.NotFound {
+		t.Fatalf("restore of a file not in the trash = %v, want NotFound", err)
+	}
+	if _, err := c.client.PurgeTrash(ctx, &pbcoord.PurgeTrashRequest{FileId: f.FileId}); status.Code(err) != codes.NotFound {
+		t.Fatalf("purge of a file not in the trash = %v, want NotFound", err)
+	}
+
+	// A file replaced at its path goes to the trash too.
+	g := c.mustUpload(t, "/a.txt", []byte("replacement"))
+	if ids := c.trashIDs(t); len(ids) != 1 || ids[0] != f.FileId {
+		t.Fatalf("trash after replacing = %v, want %s", ids, f.FileId)
+	}
+
+	purged, err := c.client.PurgeTrash(ctx, &pbcoord.PurgeTrashRequest{FileId: f.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged.Purged != 1 || len(c.trashIDs(t)) != 0 {
+		t.Fatalf("purged %d files, trash holds %v", purged.Purged, c.trashIDs(t))
+	}
+	if n := c.chunkCount(t); n != replicationFactor {
+		t.Errorf("%d chunks stored after purging, want %d", n, replicationFactor)
+	}
+	c.checkContents(t, g.FileId, []byte("replacement"))
+
+	// Emptying the whole trash.
+	c.mustUpload(t, "/b.txt", []byte("b"))
+	for _, p := range []string{"/a.txt", "/b.txt"} {
+		if _, err := c.client.DeleteFile(ctx, &pbcoord.DeleteFileRequest{Path: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if purged, err := c.client.PurgeTrash(ctx, &pbcoord.PurgeTrashRequest{}); err != nil || purged.Purged != 2 {
+		t.Fatalf("PurgeTrash = %v, %v; want 2 files purged", purged, err)
+	}
+	if n := c.chunkCount(t); n != 0 {
+		t.Errorf("%d chunks stored after emptying the trash", n)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	c := startCluster(t, Config{TrashRetention: time.Hour})
+	ctx := context.Background()
+	old := c.mustUpload(t, "/old.txt", []byte("old"))
+	recent := c.mustUpload(t, "/recent.txt", []byte("recent"))
+	for _, f := range []*pbcoord.UploadFileResponse{old, recent} {
+		if _, err := c.client.DeleteFile(ctx, &pbcoord.DeleteFileRequest{FileId: f.FileId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Backdate the first deletion past the retention period.
+	meta, err := c.meta.Get(old.FileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.DeletedAt = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	if err := c.meta.Save(meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.server.purgeExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ids := c.trashIDs(t); len(ids) != 1 || ids[0] != recent.FileId {
+		t.Fatalf("trash after purging expired files = %v, want only %s", ids, recent.FileId)
+	}
+	if n := c.chunkCount(t); n != replicationFactor {
+		t.Errorf("%d chunks stored, want %d", n, replicationFactor)
+	}
+}
+
+func TestTrashDisabled(t *testing.T) {
+	c := startCluster(t, Config{TrashRetention: -1})
+	ctx := context.Background()
+	f := c.mustUpload(t, "/a.txt", []byte("one"))
+
+	// Replaced and deleted files are gone at once, chunks and all.
+	g := c.mustUpload(t, "/a.txt", []byte("two"))
+	if _, err := c.meta.Get(f.FileId); err == nil {
+		t.Error("replaced file was kept")
+	}
+	if n := c.chunkCount(t); n != replicationFactor {
+		t.Errorf("%d chunks stored after replacing, want %d", n, replicationFactor)
+	}
+	if _, err := c.client.DeleteFile(ctx, &pbcoord.DeleteFileRequest{FileId: g.FileId}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := c.trashIDs(t); len(ids) != 0 {
+		t.Errorf("trash = %v, want it empty", ids)
+	}
+	if n := c.chunkCount(t); n != 0 {
+		t.Errorf("%d chunks stored after deleting", n)
+	}
+}
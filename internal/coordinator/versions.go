@@ -0,0 +1,359 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"dfs/internal/auth"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultRetentionInterval is how often the coordinator prunes noncurrent
+// versions that have outlived their policy's keep_days.
+const DefaultRetentionInterval = time.Hour
+
+// maxUpdateAttempts bounds how often updateMetadata retries after losing a
+// race with another writer.
+const maxUpdateAttempts = 5
+
+func (s *Server) ListVersions(ctx context.Context, req *pbcoord.ListVersionsRequest) (*pbcoord.ListVersionsResponse, error) {
+	meta, err := s.getMetadata(ctx, req.GetFileId())
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "file not found: %v", err)
+	}
+	if err := s.checkAccess(ctx, meta, auth.Read); err != nil {
+		return nil, err
+	}
+
+	resp := &pbcoord.ListVersionsResponse{}
+	resp.Versions = append(resp.Versions, &pbcoord.FileVersion{
+		VersionId: versionIDOf(meta),
+		FileSize:  meta.FileSize,
+		CreatedAt: meta.UpdatedAt,
+		Current:   true,
+		Sha256:    meta.Sha256,
+		Md5:       meta.Md5,
+	})
+	for i := len(meta.Versions) - 1; i >= 0; i-- {
+		v := meta.Versions[i]
+		resp.Versions = append(resp.Versions, &pbcoord.FileVersion{
+			VersionId:  v.VersionId,
+			FileSize:   v.FileSize,
+			CreatedAt:  v.CreatedAt,
+			ReplacedAt: v.ReplacedAt,
+			Sha256:     v.Sha256,
+			Md5:        v.Md5,
+		})
+	}
+	return resp, nil
+}
+
+// RestoreVersion makes a noncurrent version current again. The contents it
+// replaces become the newest noncurrent version, so a restore can itself be
+// undone.
+func (s *Server) RestoreVersion(ctx context.Context, req *pbcoord.RestoreVersionRequest) (*pbcoord.RestoreVersionResponse, error) {
+	log.Printf("Restoring version %s of file %s", req.GetVersionId(), req.GetFileId())
+	_, err := s.updateMetadata(ctx, req.GetFileId(), false, func(meta *pbmeta.FileMetadata) error {
+		if err := s.checkAccess(ctx, meta, auth.Write); err != nil {
+			return err
+		}
+		for i, v := range meta.Versions {
+			if v.VersionId != req.GetVersionId() {
+				continue
+			}
+			meta.Versions = append(meta.Versions[:i], meta.Versions[i+1:]...)
+			archiveCurrent(meta)
+			meta.VersionId = v.VersionId
+			meta.FileSize = v.FileSize
+			meta.Chunks = v.Chunks
+			meta.Sha256 = v.Sha256
+			meta.Md5 = v.Md5
+			return nil
+		}
+		if req.GetVersionId() == versionIDOf(meta) {
+			return status.Errorf(codes.FailedPrecondition, "version %s is already current", req.GetVersionId())
+		}
+		return status.Errorf(codes.NotFound, "version %s not found", req.GetVersionId())
+	})
+	if err != nil {
+		log.Printf("Failed to restore version %s of file %s: %v", req.GetVersionId(), req.GetFileId(), err)
+		return nil, err
+	}
+	return &pbcoord.RestoreVersionResponse{Success: true}, nil
+}
+
+func (s *Server) SetVersioning(ctx context.Context, req *pbcoord.SetVersioningRequest) (*pbcoord.SetVersioningResponse, error) {
+	var policy *pbmeta.VersioningPolicy
+	if !req.GetInherit() {
+		policy = &pbmeta.VersioningPolicy{
+			Enabled:      req.GetEnabled(),
+			KeepVersions: req.GetKeepVersions(),
+			KeepDays:     req.GetKeepDays(),
+		}
+	}
+
+	if req.GetFileId() != "" {
+		log.Printf("Setting versioning policy of file %s: %v", req.GetFileId(), policy)
+		var pruned []*pbmeta.FileVersion
+		_, err := s.updateMetadata(ctx, req.GetFileId(), false, func(meta *pbmeta.FileMetadata) error {
+			if err := s.checkAccess(ctx, meta, auth.Write); err != nil {
+				return err
+			}
+			meta.Versioning = policy
+			pruned = pruneVersions(meta, policy, time.Now())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.deleteVersions(ctx, pruned)
+		return &pbcoord.SetVersioningResponse{Success: true}, nil
+	}
+
+	dir := cleanPath(req.GetPath(), "")
+	// The directory's other settings are kept.
+	settings := &pbmeta.Directory{Path: dir}
+	resp, err := s.metadataClient.GetDirectory(ctx, &pbmeta.GetDirectoryRequest{Path: dir})
+	switch {
+	case err == nil:
+		settings = resp.Directory
+	case status.Code(err) != codes.NotFound:
+		return nil, err
+	}
+	if err := s.checkDirectoryOwner(ctx, settings); err != nil {
+		return nil, err
+	}
+	settings.Versioning = policy
+	log.Printf("Setting versioning policy of directory %s: %v", dir, policy)
+	_, err = s.metadataClient.SetDirectory(ctx, &pbmeta.SetDirectoryRequest{Directory: settings})
+	if err != nil {
+		return nil, err
+	}
+	return &pbcoord.SetVersioningResponse{Success: true}, nil
+}
+
+// versioningPolicy returns the policy that applies to a file at filePath:
+// the file's own policy if it has one, otherwise that of its nearest
+// directory with settings. It returns nil if versioning was never
+// configured.
+func (s *Server) versioningPolicy(ctx context.Context, meta *pbmeta.FileMetadata, filePath string) (*pbmeta.VersioningPolicy, error) {
+	if meta.GetVersioning() != nil {
+		return meta.Versioning, nil
+	}
+	resp, err := s.metadataClient.GetDirectory(ctx, &pbmeta.GetDirectoryRequest{
+		Path:    path.Dir(filePath),
+		Inherit: true,
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Directory.Versioning, nil
+}
+
+// updateMetadata applies change to the current metadata of fileID and writes
+// it back only if nobody else changed the file in the meantime, retrying
+// with fresh metadata when they did. inTrash says whether the file is
+// expected to be in the trash; if it is not where expected the update fails
+// with codes.NotFound. An error returned by change aborts the update.
+func (s *Server) updateMetadata(ctx context.Context, fileID string, inTrash bool, change func(meta *pbmeta.FileMetadata) error) (*pbmeta.FileMetadata, error) {
+	return s.updateMetadataWith(ctx, fileID, inTrash, &pbmeta.UpdateFileMetadataRequest{}, change)
+}
+
+// updateMetadataWith is updateMetadata with the further conditions that req
+// sets, such as the file the change replaces at its new path. It fills in
+// the metadata of req itself. If req sets IfMatch, the update fails with
+// codes.FailedPrecondition instead of being retried when the file is at
+// another generation.
+func (s *Server) updateMetadataWith(ctx context.Context, fileID string, inTrash bool, req *pbmeta.UpdateFileMetadataRequest, change func(meta *pbmeta.FileMetadata) error) (*pbmeta.FileMetadata, error) {
+	pinned := req.IfMatch
+	for attempt := 1; ; attempt++ {
+		resp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{
+			FileId:         fileID,
+			IncludeDeleted: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		meta := resp.Metadata
+		if (meta.DeletedAt != "") != inTrash {
+			if inTrash {
+				return nil, status.Errorf(codes.NotFound, "file %s is not in the trash", fileID)
+			}
+			return nil, status.Errorf(codes.NotFound, "file %s is in the trash", fileID)
+		}
+		generation := meta.Generation
+		if pinned != 0 && generation != pinned {
+			return nil, status.Errorf(codes.FailedPrecondition, "file %s is at generation %d, not %d", fileID, generation, pinned)
+		}
+		if err := change(meta); err != nil {
+			return nil, err
+		}
+
+		req.Metadata = meta
+		req.IfMatch = generation
+		updated, err := s.metadataClient.UpdateFileMetadata(ctx, req)
+		if status.Code(err) == codes.FailedPrecondition && pinned == 0 && attempt < maxUpdateAttempts {
+			log.Printf("File %s changed concurrently, retrying update (attempt %d)", fileID, attempt)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.cache.invalidate(fileID)
+		meta.Generation = updated.Generation
+		return meta, nil
+	}
+}
+
+// archiveCurrent moves the current contents of meta to the end of its
+// noncurrent versions.
+func archiveCurrent(meta *pbmeta.FileMetadata) {
+	meta.Versions = append(meta.Versions, &pbmeta.FileVersion{
+		VersionId:  versionIDOf(meta),
+		FileSize:   meta.FileSize,
+		Chunks:     meta.Chunks,
+		CreatedAt:  meta.UpdatedAt,
+		ReplacedAt: time.Now().UTC().Format(time.RFC3339),
+		Sha256:     meta.Sha256,
+		Md5:        meta.Md5,
+	})
+}
+
+// pruneVersions removes the noncurrent versions of meta that policy no
+// longer allows and returns them.
+func pruneVersions(meta *pbmeta.FileMetadata, policy *pbmeta.VersioningPolicy, now time.Time) []*pbmeta.FileVersion {
+	if policy == nil {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -int(policy.KeepDays))
+	var kept, pruned []*pbmeta.FileVersion
+	for i, v := range meta.Versions {
+		tooMany := policy.KeepVersions > 0 && len(meta.Versions)-i > int(policy.KeepVersions)
+		tooOld := false
+		if policy.KeepDays > 0 {
+			replaced, err := time.Parse(time.RFC3339, v.ReplacedAt)
+			tooOld = err == nil && replaced.Before(cutoff)
+		}
+		if tooMany || tooOld {
+			pruned = append(pruned, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	meta.Versions = kept
+	return pruned
+}
+
+// deleteVersions removes the chunks of pruned versions from the storage
+// nodes.
+func (s *Server) deleteVersions(ctx context.Context, versions []*pbmeta.FileVersion) {
+	for _, v := range versions {
+		log.Printf("Pruning version %s", v.VersionId)
+		s.deleteChunks(ctx, v.Chunks)
+	}
+}
+
+// retentionLoop periodically applies each file's versioning policy, so that
+// versions past keep_days are pruned even if the file is never written
+// again, and purges files that have been in the trash for longer than the
+// trash retention period.
+func (s *Server) retentionLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.applyRetention(ctx); err != nil {
+			log.Printf("Failed to apply version retention: %v", err)
+		}
+		if err := s.purgeExpired(ctx); err != nil {
+			log.Printf("Failed to purge expired trash: %v", err)
+		}
+	}
+}
+
+func (s *Server) applyRetention(ctx context.Context) error {
+	resp, err := s.metadataClient.ListFiles(ctx, &pbmeta.ListFilesRequest{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, meta := range resp.Files {
+		if len(meta.Versions) == 0 {
+			continue
+		}
+		policy, err := s.versioningPolicy(ctx, meta, meta.Path)
+		if err != nil {
+			return err
+		}
+		if len(pruneVersions(proto.Clone(meta).(*pbmeta.FileMetadata), policy, now)) == 0 {
+			continue
+		}
+
+		var pruned []*pbmeta.FileVersion
+		_, err = s.updateMetadata(ctx, meta.FileId, false, func(meta *pbmeta.FileMetadata) error {
+			pruned = pruneVersions(meta, policy, now)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to prune versions of file %s: %v", meta.FileId, err)
+			continue
+		}
+		s.deleteVersions(ctx, pruned)
+	}
+	return nil
+}
+
+// versionIDOf returns the version ID of meta's current contents. Files
+// written before versioning existed have none, so one is derived from the
+// time they were last written.
+func versionIDOf(meta *pbmeta.FileMetadata) string {
+	if meta.VersionId != "" {
+		return meta.VersionId
+	}
+	if t, err := time.Parse(time.RFC3339, meta.UpdatedAt); err == nil {
+		return fmt.Sprintf("%016x", t.UnixNano())
+	}
+	return generateVersionID()
+}
+
+// generateVersionID returns a new version ID. IDs are the hex-encoded write
+// time, so they sort in the order versions were written.
+func generateVersionID() string {
+	return fmt.Sprintf("%016x", time.Now().UnixNano())
+}
+
+// findVersion returns the given version of meta, which is its current
+// contents if versionID is empty.
+func findVersion(meta *pbmeta.FileMetadata, versionID string) (*pbmeta.FileVersion, bool) {
+	if versionID == "" || versionID == versionIDOf(meta) {
+		return &pbmeta.FileVersion{
+			VersionId: versionIDOf(meta),
+			FileSize:  meta.FileSize,
+			Chunks:    meta.Chunks,
+			CreatedAt: meta.UpdatedAt,
+			Sha256:    meta.Sha256,
+			Md5:       meta.Md5,
+		}, true
+	}
+	for _, v := range meta.Versions {
+		if v.VersionId == versionID {
+			return v, true
+		}
+	}
+	return nil, false
+}
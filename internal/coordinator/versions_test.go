@@ -0,0 +1,143 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPruneVersions(t *testing.T) {
+	now := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	// Versions v1 to v4 were replaced 4, 3, 2 and 1 days ago.
+	versions := func() *pbmeta.FileMetadata {
+		meta := &pbmeta.FileMetadata{}
+		for i := 1; i <= 4; i++ {
+			meta.Versions = append(meta.Versions, &pbmeta.FileVersion{
+				VersionId:  fmt.Sprintf("v%d", i),
+				ReplacedAt: now.AddDate(0, 0, i-5).Format(time.RFC3339),
+			})
+		}
+		return meta
+	}
+	ids := func(versions []*pbmeta.FileVersion) string {
+		s := ""
+		for _, v := range versions {
+			s += v.VersionId
+		}
+		return s
+	}
+
+	tests := []struct {
+		name               string
+		policy             *pbmeta.VersioningPolicy
+		wantKept, wantGone string
+	}{
+		{"no policy", nil, "v1v2v3v4", ""},
+		{"unlimited", &pbmeta.VersioningPolicy{Enabled: true}, "v1v2v3v4", ""},
+		{"keep versions", &pbmeta.VersioningPolicy{Enabled: true, KeepVersions: 2}, "v3v4", "v1v2"},
+		{"keep more than there are", &pbmeta.VersioningPolicy{Enabled: true, KeepVersions: 10}, "v1v2v3v4", ""},
+		{"keep days", &pbmeta.VersioningPolicy{Enabled: true, KeepDays: 3}, "v2v3v4", "v1"},
+		{"both", &pbmeta.VersioningPolicy{Enabled: true, KeepVersions: 3, KeepDays: 2}, "v3v4", "v1v2"},
+	}
+	for _, tt := range tests {
+		meta := versions()
+		pruned := pruneVersions(meta, tt.policy, now)
+		if ids(meta.Versions) != tt.wantKept || ids(pruned) != tt.wantGone {
+			t.Errorf("%s: kept %s and pruned %s, want %s and %s", tt.name, ids(meta.Versions), ids(pruned), tt.wantKept, tt.wantGone)
+		}
+	}
+}
+
+// listVersions returns the version IDs of fileID, newest first.
+func (c *testCluster) listVersions(t *testing.T, fileID string) []string {
+	t.Helper()
+	resp, err := c.client.ListVersions(context.Background(), &pbcoord.ListVersionsRequest{FileId: fileID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, v := range resp.Versions {
+		ids = append(ids, v.VersionId)
+	}
+	return ids
+}
+
+func TestVersioning(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	_, err := c.client.SetVersioning(ctx, &pbcoord.SetVersioningRequest{Path: "/docs", Enabled: true, KeepVersions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var uploads []*pbcoord.UploadFileResponse
+	for i := 1; i <= 4; i++ {
+		uploads = append(uploads, c.mustUpload(t, "/docs/a.txt", []byte(fmt.Sprintf("version %d", i))))
+	}
+	fileID := uploads[0].FileId
+	for _, u := range uploads {
+		if u.FileId != fileID {
+			t.Fatalf("upload to a versioned path created file %s, want a new version of %s", u.FileId, fileID)
+		}
+	}
+
+	// The oldest version was pruned, along with its chunks.
+	got := c.listVersions(t, fileID)
+	want := []string{uploads[3].VersionId, uploads[2].VersionId, uploads[1].VersionId}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("versions = %v, want %v", got, want)
+	}
+	if n := c.chunkCount(t); n != 3*replicationFactor {
+		t.Errorf("%d chunks stored, want %d", n, 3*replicationFactor)
+	}
+
+	old, err := c.download(ctx, &pbcoord.DownloadFileRequest{FileId: fileID, VersionId: uploads[1].VersionId})
+	if err != nil || string(old) != "version 2" {
+		t.Fatalf("download of version 2 = %q, %v", old, err)
+	}
+	if _, err := c.download(ctx, &pbcoord.DownloadFileRequest{FileId: fileID, VersionId: uploads[0].VersionId}); status.Code(err) != codes.NotFound {
+		t.Fatalf("download of a pruned version = %v, want NotFound", err)
+	}
+
+	// Restoring makes an old version current, and the replaced contents
+	// become the newest noncurrent version.
+	if _, err := c.client.RestoreVersion(ctx, &pbcoord.RestoreVersionRequest{FileId: fileID, VersionId: uploads[1].VersionId}); err != nil {
+		t.Fatal(err)
+	}
+	c.checkContents(t, fileID, []byte("version 2"))
+	got = c.listVersions(t, fileID)
+	want = []string{uploads[1].VersionId, uploads[3].VersionId, uploads[2].VersionId}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("versions after restore = %v, want %v", got, want)
+	}
+	_, err = c.client.RestoreVersion(ctx, &pbcoord.RestoreVersionRequest{FileId: fileID, VersionId: uploads[1].VersionId})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("restoring the current version = %v, want FailedPrecondition", err)
+	}
+
+	// A file's own policy overrides its directory's, and pruning to it
+	// frees the chunks of the versions dropped.
+	if _, err := c.client.SetVersioning(ctx, &pbcoord.SetVersioningRequest{FileId: fileID, Enabled: true, KeepVersions: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.listVersions(t, fileID); len(got) != 2 {
+		t.Fatalf("versions after keeping one = %v", got)
+	}
+	if n := c.chunkCount(t); n != 2*replicationFactor {
+		t.Errorf("%d chunks stored, want %d", n, 2*replicationFactor)
+	}
+
+	// Outside the directory, uploads replace files.
+	a := c.mustUpload(t, "/b.txt", []byte("one"))
+	b := c.mustUpload(t, "/b.txt", []byte("two"))
+	if a.FileId == b.FileId {
+		t.Fatal("upload to an unversioned path kept the file ID")
+	}
+}
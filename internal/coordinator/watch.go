@@ -0,0 +1,73 @@
+package coordinator
+
+import (
+	"context"
+	"log"
+	"time"
+
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const watchRetryInterval = 2 * time.Second
+
+// watchMetadata keeps the metadata cache coherent with changes made through
+// other coordinators by following the metadata service's Watch stream. When
+// the stream breaks it resumes after the last change whose events it has
+// all seen; if those events
+// are gone (for example after a restart of a metadata service that keeps
+// events only in memory) it clears the cache, since an invalidation may have
+// been missed. Leases still bound
+// staleness while the stream is down.
+func (s *Server) watchMetadata(ctx context.Context) {
+	var after uint64
+	for {
+		err := s.followWatch(ctx, &after)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case status.Code(err) == codes.Unimplemented:
+			log.Printf("Metadata service does not support Watch; relying on cache leases")
+			return
+		case status.Code(err) == codes.OutOfRange:
+			log.Printf("Missed metadata events, clearing cache: %v", err)
+			s.cache.clear()
+			after = 0
+		default:
+			log.Printf("Metadata watch interrupted: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+func (s *Server) followWatch(ctx context.Context, after *uint64) error {
+	stream, err := s.metadataClient.Watch(ctx, &pbmeta.WatchRequest{AfterSequence: *after})
+	if err != nil {
+		return err
+	}
+	// The events of one change share a sequence number, so the change at
+	// current is only complete once an event of a later one arrives.
+	// Invalidating again after resuming is harmless.
+	current := *after
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if event.Sequence != current {
+			*after, current = current, event.Sequence
+		}
+		if event.Operation == pbmeta.Operation_DELETED {
+			s.cache.invalidate(event.FileId)
+		} else {
+			s.cache.invalidateBefore(event.FileId, event.Generation)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// eventually fails the test unless cond holds within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchInvalidatesCache(t *testing.T) {
+	c := startCluster(t, Config{CacheSize: 100})
+	// Another coordinator changes the files behind the first one's back.
+	_, other := c.coordinator(t, Config{})
+	ctx := context.Background()
+
+	f := c.mustUpload(t, "/a.txt", []byte("hello"))
+	if _, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, fresh, ok := c.server.cache.get(f.FileId); !ok || !fresh {
+		t.Fatal("file is not cached")
+	}
+
+	// The cache lease lasts far longer than the test, so only the watch
+	// can make the first coordinator see the changes.
+	if _, err := other.UpdateFileAttributes(ctx, &pbcoord.UpdateFileAttributesRequest{FileId: f.FileId, AddTags: []string{"changed"}}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the tag to show", func() bool {
+		stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId})
+		return err == nil && len(stat.File.Tags) == 1
+	})
+
+	if _, err := other.DeleteFile(ctx, &pbcoord.DeleteFileRequest{FileId: f.FileId, Permanent: true}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the file to go", func() bool {
+		_, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId})
+		return status.Code(err) == codes.NotFound
+	})
+	if _, _, ok := c.server.cache.get(f.FileId); ok {
+		t.Error("deleted file is still cached")
+	}
+}
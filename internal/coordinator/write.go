@@ -0,0 +1,347 @@
+package coordinator
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeLeaseTTL is the duration of the lease the coordinator takes for an
+// Append or WriteAt call whose client did not bring its own.
+const writeLeaseTTL = 10 * time.Minute
+
+// Append adds the streamed data to the end of an existing file.
+func (s *Server) Append(stream pbcoord.Coordinator_AppendServer) error {
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return status.Errorf(codes.InvalidArgument, "no file ID given")
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to receive data: %v", err)
+	}
+	first := req.GetData()
+	resp, err := s.writeFile(stream.Context(), req.GetFileId(), req.GetLeaseId(), -1, func() ([]byte, error) {
+		if first != nil {
+			data := first
+			first = nil
+			return data, nil
+		}
+		req, err := stream.Recv()
+		return req.GetData(), err
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+// WriteAt overwrites an existing file with the streamed data starting at the
+// requested offset, extending the file if the data runs past its end.
+func (s *Server) WriteAt(stream pbcoord.Coordinator_WriteAtServer) error {
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return status.Errorf(codes.InvalidArgument, "no file ID given")
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to receive data: %v", err)
+	}
+	if req.GetOffset() < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative offset %d", req.GetOffset())
+	}
+	first := req.GetData()
+	resp, err := s.writeFile(stream.Context(), req.GetFileId(), req.GetLeaseId(), req.GetOffset(), func() ([]byte, error) {
+		if first != nil {
+			data := first
+			first = nil
+			return data, nil
+		}
+		req, err := stream.Recv()
+		return req.GetData(), err
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+func (s *Server) AcquireWriteLease(ctx context.Context, req *pbcoord.AcquireWriteLeaseRequest) (*pbcoord.AcquireWriteLeaseResponse, error) {
+	if err := s.checkWrite(ctx, req.GetFileId()); err != nil {
+		return nil, err
+	}
+	resp, err := s.metadataClient.AcquireWriteLease(ctx, &pbmeta.AcquireWriteLeaseRequest{
+		FileId:  req.GetFileId(),
+		LeaseId: req.GetLeaseId(),
+		TtlMs:   req.GetTtlMs(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pbcoord.AcquireWriteLeaseResponse{LeaseId: resp.LeaseId, TtlMs: resp.TtlMs}, nil
+}
+
+func (s *Server) ReleaseWriteLease(ctx context.Context, req *pbcoord.ReleaseWriteLeaseRequest) (*pbcoord.ReleaseWriteLeaseResponse, error) {
+	if err := s.checkWrite(ctx, req.GetFileId()); err != nil {
+		return nil, err
+	}
+	_, err := s.metadataClient.ReleaseWriteLease(ctx, &pbmeta.ReleaseWriteLeaseRequest{
+		FileId:  req.GetFileId(),
+		LeaseId: req.GetLeaseId(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pbcoord.ReleaseWriteLeaseResponse{Success: true}, nil
+}
+
+// writeFile writes the data returned by recv into fileID at offset, or at the
+// end of the file if offset is negative. recv returns io.EOF after the last
+// piece of data.
+//
+// Chunks are never modified in place: the chunks the write touches are
+// rewritten under the new version ID and the metadata is switched over to
+// them with a single conditional update, so readers see either the old or the
+// new contents. Unless leaseID is given, a write lease is held for the
+// duration of the call so that concurrent writers cannot both build on the
+// same chunk list.
+func (s *Server) writeFile(ctx context.Context, fileID, leaseID string, offset int64, recv func() ([]byte, error)) (*pbcoord.WriteResponse, error) {
+	if fileID == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "no file ID given")
+	}
+	if err := s.checkWrite(ctx, fileID); err != nil {
+		return nil, err
+	}
+	if leaseID == "" {
+		lease, err := s.metadataClient.AcquireWriteLease(ctx, &pbmeta.AcquireWriteLeaseRequest{
+			FileId: fileID,
+			TtlMs:  writeLeaseTTL.Milliseconds(),
+		})
+		if err != nil {
+			log.Printf("Failed to acquire write lease on file %s: %v", fileID, err)
+			return nil, err
+		}
+		leaseID = lease.LeaseId
+		defer func() {
+			_, err := s.metadataClient.ReleaseWriteLease(context.Background(), &pbmeta.ReleaseWriteLeaseRequest{
+				FileId:  fileID,
+				LeaseId: leaseID,
+			})
+			if err != nil {
+				log.Printf("Failed to release write lease on file %s: %v", fileID, err)
+			}
+		}()
+	}
+
+	resp, err := s.metadataClient.GetFileMetadata(ctx, &pbmeta.GetFileMetadataRequest{FileId: fileID})
+	if err != nil {
+		return nil, err
+	}
+	meta := resp.Metadata
+	if offset < 0 {
+		offset = meta.FileSize
+	}
+	if offset > meta.FileSize {
+		return nil, status.Errorf(codes.OutOfRange, "offset %d is beyond the end of file %s (%d bytes)", offset, fileID, meta.FileSize)
+	}
+
+	starts, err := s.chunkOffsets(ctx, meta.Chunks)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to determine chunk sizes: %v", err)
+	}
+
+	// first is the first chunk the write changes. Appending to a file whose
+	// last chunk is short fills that chunk up rather than leaving a short
+	// chunk in the middle of the file.
+	first := len(meta.Chunks)
+	for i := range meta.Chunks {
+		if offset < starts[i+1] {
+			first = i
+			break
+		}
+	}
+	if first == len(meta.Chunks) && first > 0 && starts[first]-starts[first-1] < chunkSize {
+		first--
+	}
+
+	versionID := generateVersionID()
+	w := &chunkWriter{s: s, ctx: ctx, fileID: fileID, versionID: versionID, index: first}
+	if first < len(meta.Chunks) && offset > starts[first] {
+		data, err := s.readChunk(ctx, meta.Chunks[first])
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read chunk: %v", err)
+		}
+		if err := w.write(data[:offset-starts[first]]); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to store chunk: %v", err)
+		}
+	}
+
+	var written int64
+	for {
+		data, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.discard()
+			return nil, status.Errorf(codes.Internal, "failed to receive data: %v", err)
+		}
+		if err := w.write(data); err != nil {
+			w.discard()
+			return nil, status.Errorf(codes.Internal, "failed to store chunk: %v", err)
+		}
+		written += int64(len(data))
+	}
+	if written == 0 {
+		w.discard()
+		return &pbcoord.WriteResponse{FileSize: meta.FileSize, VersionId: versionIDOf(meta)}, nil
+	}
+
+	// last is the first chunk after the write that is kept as it is. If the
+	// write ends inside a chunk, the rest of that chunk is rewritten too.
+	end := offset + written
+	last := len(meta.Chunks)
+	for i := first; i < len(meta.Chunks); i++ {
+		if end <= starts[i] {
+			last = i
+			break
+		}
+		if end < starts[i+1] {
+			data, err := s.readChunk(ctx, meta.Chunks[i])
+			if err != nil {
+				w.discard()
+				return nil, status.Errorf(codes.Internal, "failed to read chunk: %v", err)
+			}
+			if err := w.write(data[end-starts[i]:]); err != nil {
+				w.discard()
+				return nil, status.Errorf(codes.Internal, "failed to store chunk: %v", err)
+			}
+			last = i + 1
+			break
+		}
+	}
+	if err := w.flush(); err != nil {
+		w.discard()
+		return nil, status.Errorf(codes.Internal, "failed to store chunk: %v", err)
+	}
+
+	policy, err := s.versioningPolicy(ctx, meta, meta.Path)
+	if err != nil {
+		w.discard()
+		return nil, status.Errorf(codes.Internal, "failed to look up versioning policy: %v", err)
+	}
+	replaced := meta.Chunks[first:last]
+	chunks := append(append(append([]*pbmeta.ChunkInfo{}, meta.Chunks[:first]...), w.chunks...), meta.Chunks[last:]...)
+
+	generation := meta.Generation
+	var pruned []*pbmeta.FileVersion
+	if policy.GetEnabled() {
+		archiveCurrent(meta)
+		pruned = pruneVersions(meta, policy, time.Now())
+	}
+	meta.VersionId = versionID
+	meta.Chunks = chunks
+	// The digests cannot be updated without reading the whole file.
+	meta.Sha256 = ""
+	meta.Md5 = ""
+	if end > meta.FileSize {
+		meta.FileSize = end
+	}
+	_, err = s.metadataClient.UpdateFileMetadata(ctx, &pbmeta.UpdateFileMetadataRequest{
+		Metadata: meta,
+		IfMatch:  generation,
+		LeaseId:  leaseID,
+	})
+	if err != nil {
+		log.Printf("Failed to update metadata for file %s: %v", fileID, err)
+		w.discard()
+		return nil, err
+	}
+	s.cache.invalidate(fileID)
+
+	// Replaced chunks are kept if the archived version still refers to
+	// them.
+	s.deleteChunks(context.Background(), replaced)
+	s.deleteVersions(context.Background(), pruned)
+
+	log.Printf("Wrote %d bytes to file %s at offset %d, now %d bytes in %d chunks", written, fileID, offset, meta.FileSize, len(chunks))
+	return &pbcoord.WriteResponse{FileSize: meta.FileSize, VersionId: versionID}, nil
+}
+
+// chunkOffsets returns the offset at which each of chunks starts, followed
+// by the total size. Chunks written before sizes were recorded are read to
+// find their length.
+func (s *Server) chunkOffsets(ctx context.Context, chunks []*pbmeta.ChunkInfo) ([]int64, error) {
+	starts := make([]int64, 0, len(chunks)+1)
+	var offset int64
+	for _, chunkInfo := range chunks {
+		starts = append(starts, offset)
+		size := chunkInfo.Size
+		if size == 0 {
+			data, err := s.readChunk(ctx, chunkInfo)
+			if err != nil {
+				return nil, err
+			}
+			size = int64(len(data))
+		}
+		offset += size
+	}
+	return append(starts, offset), nil
+}
+
+// chunkWriter cuts the data written to it into chunkSize chunks and stores
+// them as new chunks of a file version.
+type chunkWriter struct {
+	s         *Server
+	ctx       context.Context
+	fileID    string
+	versionID string
+	// index is the position in the file of the next chunk, used to spread
+	// chunks over the storage nodes.
+	index  int
+	buf    []byte
+	chunks []*pbmeta.ChunkInfo
+}
+
+func (w *chunkWriter) write(data []byte) error {
+	for len(data) > 0 {
+		n := min(chunkSize-len(w.buf), len(data))
+		w.buf = append(w.buf, data[:n]...)
+		data = data[n:]
+		if len(w.buf) == chunkSize {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush stores whatever is buffered as a chunk, which is short unless the
+// buffer was full.
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	chunkID := generateChunkID(w.fileID, w.versionID, len(w.chunks))
+	chunkInfo, err := w.s.storeChunk(w.ctx, chunkID, w.index, w.buf)
+	if err != nil {
+		return err
+	}
+	w.chunks = append(w.chunks, chunkInfo)
+	w.index++
+	w.buf = nil
+	return nil
+}
+
+// discard deletes the chunks stored so far, after the write failed.
+func (w *chunkWriter) discard() {
+	w.buf = nil
+	w.s.deleteChunks(context.Background(), w.chunks)
+	w.chunks = nil
+}
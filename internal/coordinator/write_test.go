@@ -0,0 +1,271 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	pbcoord "dfs/internal/pb/coordinator"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writePiece is the size of the messages write sends, which does not line
+// up with chunks.
+const writePiece = 40000
+
+// write writes data to fileID at offset with WriteAt, or with Append if
+// offset is negative.
+func (c *testCluster) write(ctx context.Context, fileID, leaseID string, offset int64, data []byte) (*pbcoord.WriteResponse, error) {
+	var send func(data []byte) error
+	var closeAndRecv func() (*pbcoord.WriteResponse, error)
+	if offset < 0 {
+		stream, err := c.client.Append(ctx)
+		if err != nil {
+			return nil, err
+		}
+		send = func(data []byte) error {
+			return stream.Send(&pbcoord.AppendRequest{FileId: fileID, LeaseId: leaseID, Data: data})
+		}
+		closeAndRecv = stream.CloseAndRecv
+	} else {
+		stream, err := c.client.WriteAt(ctx)
+		if err != nil {
+			return nil, err
+		}
+		send = func(data []byte) error {
+			return stream.Send(&pbcoord.WriteAtRequest{FileId: fileID, LeaseId: leaseID, Offset: offset, Data: data})
+		}
+		closeAndRecv = stream.CloseAndRecv
+	}
+	for {
+		n := min(writePiece, len(data))
+		if err := send(data[:n]); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = data[n:]
+		if len(data) == 0 {
+			break
+		}
+	}
+	return closeAndRecv()
+}
+
+// chunkLayout returns the IDs and sizes of the chunks of fileID's current
+// contents.
+func (c *testCluster) chunkLayout(t *testing.T, fileID string) (ids []string, sizes []int64) {
+	t.Helper()
+	meta, err := c.meta.Get(fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range meta.Chunks {
+		ids = append(ids, chunk.ChunkID)
+		sizes = append(sizes, chunk.Size)
+	}
+	return ids, sizes
+}
+
+// storedChunks returns the IDs of the chunks on any storage node.
+func (c *testCluster) storedChunks(t *testing.T) map[string]bool {
+	t.Helper()
+	stored := make(map[string]bool)
+	for _, store := range c.chunks {
+		ids, err := store.IDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			stored[id] = true
+		}
+	}
+	return stored
+}
+
+func pattern(n int, seed byte) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = seed + byte(i%251)
+	}
+	return data
+}
+
+func TestWriteCopyOnWrite(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	// The file is two full chunks and a short one.
+	const size = 2*chunkSize + 18928
+	base := pattern(size, 0)
+
+	tests := []struct {
+		name   string
+		offset int64 // negative to append
+		n      int
+		// The chunks after the write, and which of the original three
+		// it keeps.
+		wantSizes []int64
+		wantKept  []int
+	}{
+		{"append", -1, 1000, []int64{chunkSize, chunkSize, 19928}, []int{0, 1}},
+		{"append past a chunk", -1, 100000, []int64{chunkSize, chunkSize, chunkSize, 53392}, []int{0, 1}},
+		{"inside a chunk", 70000, 1000, []int64{chunkSize, chunkSize, 18928}, []int{0, 2}},
+		{"across chunks", 60000, 10000, []int64{chunkSize, chunkSize, 18928}, []int{2}},
+		{"at a chunk start", 2 * chunkSize, 5, []int64{chunkSize, chunkSize, 18928}, []int{0, 1}},
+		{"at the end", size, 10, []int64{chunkSize, chunkSize, 18938}, []int{0, 1}},
+		{"everything and more", 0, size + 10, []int64{chunkSize, chunkSize, 18938}, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := c.mustUpload(t, fmt.Sprintf("/cow%d.bin", i), base)
+			oldIDs, _ := c.chunkLayout(t, f.FileId)
+
+			data := pattern(tt.n, 100)
+			resp, err := c.write(ctx, f.FileId, "", tt.offset, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset := tt.offset
+			if offset < 0 {
+				offset = size
+			}
+			want := append([]byte{}, base...)
+			if end := offset + int64(tt.n); end > size {
+				want = append(want, make([]byte, end-size)...)
+			}
+			copy(want[offset:], data)
+			if resp.FileSize != int64(len(want)) || resp.VersionId == f.VersionId {
+				t.Errorf("response = size %d, version %s; want size %d and a new version", resp.FileSize, resp.VersionId, len(want))
+			}
+			c.checkContents(t, f.FileId, want)
+
+			ids, sizes := c.chunkLayout(t, f.FileId)
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.wantSizes) {
+				t.Errorf("chunk sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+			// Untouched chunks are kept; the others are replaced by new
+			// ones and deleted.
+			kept := make(map[string]bool)
+			for _, k := range tt.wantKept {
+				kept[oldIDs[k]] = true
+			}
+			current := make(map[string]bool)
+			for _, id := range ids {
+				current[id] = true
+			}
+			stored := c.storedChunks(t)
+			for _, id := range oldIDs {
+				if current[id] != kept[id] {
+					t.Errorf("chunk %s kept = %v, want %v", id, current[id], kept[id])
+				}
+				if !current[id] && stored[id] {
+					t.Errorf("replaced chunk %s was not deleted", id)
+				}
+			}
+
+			// The digests no longer apply.
+			stat, err := c.client.StatFile(ctx, &pbcoord.StatFileRequest{FileId: f.FileId})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.File.Sha256 != "" || stat.File.Md5 != "" {
+				t.Errorf("digests kept after a write: %s %s", stat.File.Sha256, stat.File.Md5)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	f := c.mustUpload(t, "/a.txt", []byte("hello"))
+	chunks := c.chunkCount(t)
+
+	if _, err := c.write(ctx, f.FileId, "", 6, []byte("x")); status.Code(err) != codes.OutOfRange {
+		t.Errorf("write beyond the end = %v, want OutOfRange", err)
+	}
+	if _, err := c.write(ctx, f.FileId, "", 0, nil); err != nil {
+		t.Errorf("empty write: %v", err)
+	}
+	if _, err := c.write(ctx, "", "", -1, []byte("x")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("append without a file ID = %v, want InvalidArgument", err)
+	}
+	stream, err := c.client.WriteAt(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&pbcoord.WriteAtRequest{FileId: f.FileId, Offset: -1, Data: []byte("x")})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("write at a negative offset = %v, want InvalidArgument", err)
+	}
+	c.checkContents(t, f.FileId, []byte("hello"))
+	if n := c.chunkCount(t); n != chunks {
+		t.Errorf("%d chunks stored after failed writes, want %d", n, chunks)
+	}
+}
+
+func TestWriteLeases(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	f := c.mustUpload(t, "/a.txt", []byte("hello"))
+
+	lease, err := c.client.AcquireWriteLease(ctx, &pbcoord.AcquireWriteLeaseRequest{FileId: f.FileId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other writers are locked out while the lease is held.
+	if _, err := c.write(ctx, f.FileId, "", -1, []byte(" world")); status.Code(err) != codes.Aborted {
+		t.Fatalf("write without the lease = %v, want Aborted", err)
+	}
+	if _, err := c.client.AcquireWriteLease(ctx, &pbcoord.AcquireWriteLeaseRequest{FileId: f.FileId}); status.Code(err) != codes.Aborted {
+		t.Fatalf("second lease = %v, want Aborted", err)
+	}
+	for _, piece := range []string{" wor", "ld"} {
+		if _, err := c.write(ctx, f.FileId, lease.LeaseId, -1, []byte(piece)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.client.ReleaseWriteLease(ctx, &pbcoord.ReleaseWriteLeaseRequest{FileId: f.FileId, LeaseId: lease.LeaseId}); err != nil {
+		t.Fatal(err)
+	}
+	// Without a lease of its own, a write takes one for its duration.
+	if _, err := c.write(ctx, f.FileId, "", -1, []byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.write(ctx, f.FileId, "", -1, []byte("!")); err != nil {
+		t.Fatalf("write after another write released its lease: %v", err)
+	}
+	c.checkContents(t, f.FileId, []byte("hello world!!"))
+}
+
+func TestWriteKeepsVersions(t *testing.T) {
+	c := startCluster(t, Config{})
+	ctx := context.Background()
+	if _, err := c.client.SetVersioning(ctx, &pbcoord.SetVersioningRequest{Path: "/", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	base := pattern(chunkSize+100, 0)
+	f := c.mustUpload(t, "/a.bin", base)
+
+	resp, err := c.write(ctx, f.FileId, "", 10, []byte("changed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The replaced chunk still belongs to the old version.
+	old, err := c.download(ctx, &pbcoord.DownloadFileRequest{FileId: f.FileId, VersionId: f.VersionId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old, base) {
+		t.Fatalf("old version holds %q", truncate(old))
+	}
+	if versions := c.listVersions(t, f.FileId); len(versions) != 2 || versions[0] != resp.VersionId {
+		t.Fatalf("versions = %v, want %s and %s", versions, resp.VersionId, f.VersionId)
+	}
+	// Both versions share the second chunk, so three chunks are stored.
+	if n := c.chunkCount(t); n != 3*replicationFactor {
+		t.Errorf("%d chunks stored, want %d", n, 3*replicationFactor)
+	}
+}
@@ -0,0 +1,228 @@
+package fusefs
+
+import (
+	"context"
+	"path"
+	"strings"
+	"syscall"
+
+	"dfs/client"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// dirNode is a directory, implied by the files below it or made with mkdir.
+type dirNode struct {
+	fusefs.Inode
+	m *mount
+}
+
+var (
+	_ fusefs.NodeGetattrer = (*dirNode)(nil)
+	_ fusefs.NodeLookuper  = (*dirNode)(nil)
+	_ fusefs.NodeReaddirer = (*dirNode)(nil)
+	_ fusefs.NodeMkdirer   = (*dirNode)(nil)
+	_ fusefs.NodeRmdirer   = (*dirNode)(nil)
+	_ fusefs.NodeCreater   = (*dirNode)(nil)
+	_ fusefs.NodeUnlinker  = (*dirNode)(nil)
+	_ fusefs.NodeRenamer   = (*dirNode)(nil)
+	_ fusefs.NodeStatfser  = (*dirNode)(nil)
+)
+
+func (n *dirNode) Getattr(ctx context.Context, fh fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	n.m.dirAttr(&out.Attr)
+	return 0
+}
+
+func (m *mount) dirAttr(attr *fuse.Attr) {
+	attr.Mode = syscall.S_IFDIR | 0o755
+	attr.Nlink = 2
+	attr.SetTimes(nil, &m.started, &m.started)
+}
+
+func (n *dirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	children, err := n.m.list(ctx, n.m.dfsPath(&n.Inode))
+	if err != nil {
+		return nil, errno(err)
+	}
+	info, ok := children[name]
+	if !ok {
+		// A file being written for the first time is not listed yet.
+		if ch := n.GetChild(name); ch != nil {
+			if f, ok := ch.Operations().(*fileNode); ok && f.pending() {
+				f.getattr(&out.Attr)
+				return ch, 0
+			}
+		}
+		return nil, syscall.ENOENT
+	}
+	return n.child(ctx, name, info, &out.Attr), 0
+}
+
+// child returns the inode for a directory entry, reusing the existing one
+// if there is one for the same file.
+func (n *dirNode) child(ctx context.Context, name string, info *client.FileInfo, attr *fuse.Attr) *fusefs.Inode {
+	if info == nil {
+		childPath := path.Join(n.m.dfsPath(&n.Inode), name)
+		n.m.dirAttr(attr)
+		return n.NewInode(ctx, &dirNode{m: n.m}, fusefs.StableAttr{Mode: syscall.S_IFDIR, Ino: ino("dir:" + childPath)})
+	}
+	ch := n.NewInode(ctx, &fileNode{m: n.m}, fusefs.StableAttr{Mode: syscall.S_IFREG, Ino: ino(info.ID)})
+	f := ch.Operations().(*fileNode)
+	f.setInfo(info)
+	f.getattr(attr)
+	return ch
+}
+
+func (n *dirNode) Readdir(ctx context.Context) (fusefs.DirStream, syscall.Errno) {
+	dir := n.m.dfsPath(&n.Inode)
+	children, err := n.m.list(ctx, dir)
+	if err != nil {
+		return nil, errno(err)
+	}
+	entries := make([]fuse.DirEntry, 0, len(children))
+	for name, info := range children {
+		if info == nil {
+			entries = append(entries, fuse.DirEntry{Name: name, Mode: syscall.S_IFDIR, Ino: ino("dir:" + path.Join(dir, name))})
+		} else {
+			entries = append(entries, fuse.DirEntry{Name: name, Mode: syscall.S_IFREG, Ino: ino(info.ID)})
+		}
+	}
+	return fusefs.NewListDirStream(entries), 0
+}
+
+func (n *dirNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fusefs.Inode, syscall.Errno) {
+	dir := n.m.dfsPath(&n.Inode)
+	children, err := n.m.list(ctx, dir)
+	if err != nil {
+		return nil, errno(err)
+	}
+	if _, ok := children[name]; ok {
+		return nil, syscall.EEXIST
+	}
+	n.m.mu.Lock()
+	n.m.localDirs[path.Join(dir, name)] = true
+	n.m.mu.Unlock()
+	n.m.invalidate()
+	return n.child(ctx, name, nil, &out.Attr), 0
+}
+
+func (n *dirNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	dir := path.Join(n.m.dfsPath(&n.Inode), name)
+	children, err := n.m.list(ctx, dir)
+	if err != nil {
+		return errno(err)
+	}
+	n.m.mu.Lock()
+	defer n.m.mu.Unlock()
+	if !n.m.localDirs[dir] {
+		// A directory that is not local exists because of its files.
+		if len(children) > 0 {
+			return syscall.ENOTEMPTY
+		}
+		return syscall.ENOENT
+	}
+	if len(children) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	delete(n.m.localDirs, dir)
+	clear(n.m.listings)
+	return 0
+}
+
+func (n *dirNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fusefs.Inode, fusefs.FileHandle, uint32, syscall.Errno) {
+	f := &fileNode{m: n.m}
+	ch := n.NewInode(ctx, f, fusefs.StableAttr{Mode: syscall.S_IFREG})
+	// Create runs before the kernel links the new inode into the tree, so
+	// the path is given explicitly.
+	h, errNo := f.openBuffer(ctx, path.Join(n.m.dfsPath(&n.Inode), name), flags|syscall.O_TRUNC)
+	if errNo != 0 {
+		return nil, nil, 0, errNo
+	}
+	f.getattr(&out.Attr)
+	return ch, h, 0, 0
+}
+
+func (n *dirNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if ch := n.GetChild(name); ch != nil {
+		if f, ok := ch.Operations().(*fileNode); ok && f.unlink() {
+			// The file was never uploaded.
+			return 0
+		}
+	}
+	err := n.m.c.Remove(ctx, path.Join(n.m.dfsPath(&n.Inode), name))
+	n.m.invalidate()
+	return errno(err)
+}
+
+const (
+	renameNoReplace = 0x1
+	renameExchange  = 0x2
+)
+
+func (n *dirNode) Rename(ctx context.Context, name string, newParent fusefs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags&renameExchange != 0 {
+		return syscall.ENOTSUP
+	}
+	overwrite := flags&renameNoReplace == 0
+	from := path.Join(n.m.dfsPath(&n.Inode), name)
+	to := path.Join(n.m.dfsPath(newParent.EmbeddedInode()), newName)
+	defer n.m.invalidate()
+
+	ch := n.GetChild(name)
+	if ch != nil && ch.IsDir() {
+		return n.m.renameDir(ctx, from, to, overwrite)
+	}
+	if ch != nil {
+		if f, ok := ch.Operations().(*fileNode); ok && f.pending() {
+			// Only the inode moves; the upload goes to the new path.
+			return 0
+		}
+	}
+	_, err := n.m.c.Rename(ctx, from, to, overwrite)
+	return errno(err)
+}
+
+// renameDir moves every file below from to the same place below to. The
+// files are moved one at a time, so a failure leaves the move half done.
+func (m *mount) renameDir(ctx context.Context, from, to string, overwrite bool) syscall.Errno {
+	if existing, err := m.list(ctx, to); err != nil {
+		return errno(err)
+	} else if len(existing) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	files, err := m.c.List(ctx, from+"/", nil)
+	if err != nil {
+		return errno(err)
+	}
+	for _, info := range files {
+		if _, err := m.c.Rename(ctx, info.ID, to+strings.TrimPrefix(info.Path, from), overwrite); err != nil {
+			return errno(err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for d := range m.localDirs {
+		if d == from || strings.HasPrefix(d, from+"/") {
+			delete(m.localDirs, d)
+			m.localDirs[to+strings.TrimPrefix(d, from)] = true
+		}
+	}
+	return 0
+}
+
+func (n *dirNode) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	// The DFS has no fixed capacity; report plenty of room so that tools
+	// checking for space go ahead.
+	out.Bsize = 4096
+	out.Frsize = 4096
+	out.Blocks = 1 << 40
+	out.Bfree = 1 << 40
+	out.Bavail = 1 << 40
+	out.Files = 1 << 32
+	out.Ffree = 1 << 32
+	out.NameLen = 255
+	return 0
+}
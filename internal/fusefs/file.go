@@ -0,0 +1,353 @@
+package fusefs
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"dfs/client"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// fileNode is a file. While it is open for writing, its contents live in a
+// local buffer that all of its handles share.
+type fileNode struct {
+	fusefs.Inode
+	m *mount
+
+	mu sync.Mutex
+	// info is nil for a file that has been created but not uploaded yet.
+	info    *client.FileInfo
+	fetched time.Time
+	buf     *buffer
+	// unlinked is set once the file is deleted, so that its buffer is not
+	// uploaded again when it is closed.
+	unlinked bool
+}
+
+// buffer holds the contents of a file being written in an unlinked local
+// temporary file.
+type buffer struct {
+	f     *os.File
+	refs  int
+	dirty bool
+}
+
+var (
+	_ fusefs.NodeGetattrer = (*fileNode)(nil)
+	_ fusefs.NodeSetattrer = (*fileNode)(nil)
+	_ fusefs.NodeOpener    = (*fileNode)(nil)
+)
+
+func (n *fileNode) setInfo(info *client.FileInfo) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.info, n.fetched = info, time.Now()
+}
+
+// pending reports whether the file has been created but not uploaded yet.
+func (n *fileNode) pending() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.info == nil && n.buf != nil && !n.unlinked
+}
+
+// unlink marks the file deleted and reports whether it was still pending,
+// in which case there is nothing to delete in the DFS.
+func (n *fileNode) unlink() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	pending := n.info == nil && n.buf != nil && !n.unlinked
+	n.unlinked = true
+	return pending
+}
+
+func (n *fileNode) getattr(attr *fuse.Attr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.attrLocked(attr)
+}
+
+func (n *fileNode) attrLocked(attr *fuse.Attr) {
+	var size int64
+	mtime := n.m.started
+	if n.info != nil {
+		size, mtime = n.info.Size, n.info.UpdatedAt
+	}
+	if n.buf != nil {
+		if st, err := n.buf.f.Stat(); err == nil {
+			size = st.Size()
+			if n.buf.dirty {
+				mtime = st.ModTime()
+			}
+		}
+	}
+	attr.Mode = syscall.S_IFREG | 0o644
+	attr.Nlink = 1
+	attr.Size = uint64(size)
+	attr.Blocks = uint64(size+511) / 512
+	attr.SetTimes(nil, &mtime, &mtime)
+}
+
+func (n *fileNode) Getattr(ctx context.Context, fh fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.buf == nil && n.info != nil && !n.unlinked && time.Since(n.fetched) >= n.m.opts.AttrTTL {
+		info, err := n.m.c.Stat(ctx, n.m.dfsPath(&n.Inode))
+		if err != nil {
+			return errno(err)
+		}
+		n.info, n.fetched = info, time.Now()
+	}
+	n.attrLocked(&out.Attr)
+	return 0
+}
+
+// Setattr changes the size of the file. Modes, owners and times are not
+// kept by the DFS, so changes to them are accepted and ignored.
+func (n *fileNode) Setattr(ctx context.Context, fh fusefs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		if h, ok := fh.(*handle); ok && h.buffered {
+			if errNo := n.truncate(size); errNo != 0 {
+				return errNo
+			}
+		} else {
+			// Truncating a file that is not open for writing saves the
+			// result right away.
+			flags := uint32(syscall.O_WRONLY)
+			if size == 0 {
+				flags |= syscall.O_TRUNC
+			}
+			h, errNo := n.openBuffer(ctx, n.m.dfsPath(&n.Inode), flags)
+			if errNo != 0 {
+				return errNo
+			}
+			errNo = n.truncate(size)
+			if errNo == 0 {
+				errNo = h.Flush(ctx)
+			}
+			h.Release(ctx)
+			if errNo != 0 {
+				return errNo
+			}
+		}
+	}
+	n.getattr(&out.Attr)
+	return 0
+}
+
+func (n *fileNode) truncate(size uint64) syscall.Errno {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.buf.dirty = true
+	return errno(n.buf.f.Truncate(int64(size)))
+}
+
+func (n *fileNode) Open(ctx context.Context, flags uint32) (fusefs.FileHandle, uint32, syscall.Errno) {
+	p := n.m.dfsPath(&n.Inode)
+	n.mu.Lock()
+	buffered := n.buf != nil
+	n.mu.Unlock()
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY || buffered {
+		// Readers of a file being written see the unsaved changes.
+		h, errNo := n.openBuffer(ctx, p, flags)
+		return h, 0, errNo
+	}
+
+	// The reader outlives this request, so it gets the mount's context.
+	r, err := n.m.c.Open(n.m.ctx, p)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	n.setInfo(r.Info())
+	return &handle{n: n, r: r}, 0, 0
+}
+
+// openBuffer returns a handle on the file's buffer, filling the buffer with
+// the file's contents from p unless it exists already or flags truncate the
+// file.
+func (n *fileNode) openBuffer(ctx context.Context, p string, flags uint32) (*handle, syscall.Errno) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.buf == nil {
+		f, err := os.CreateTemp("", "dfs-mount-*")
+		if err != nil {
+			return nil, errno(err)
+		}
+		os.Remove(f.Name())
+		if flags&syscall.O_TRUNC == 0 && n.info != nil {
+			if err := n.download(ctx, p, f); err != nil {
+				f.Close()
+				return nil, errno(err)
+			}
+		}
+		n.buf = &buffer{f: f}
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		if err := n.buf.f.Truncate(0); err != nil {
+			return nil, errno(err)
+		}
+		n.buf.dirty = true
+	}
+	n.buf.refs++
+	return &handle{n: n, buffered: true, append: flags&syscall.O_APPEND != 0}, 0
+}
+
+func (n *fileNode) download(ctx context.Context, p string, f *os.File) error {
+	r, err := n.m.c.Open(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// commit uploads the buffer if it has changes, replacing the file or adding
+// a version to it. The file keeps its tags and user metadata.
+func (n *fileNode) commit(ctx context.Context) syscall.Errno {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.buf == nil || !n.buf.dirty || n.unlinked {
+		return 0
+	}
+	st, err := n.buf.f.Stat()
+	if err != nil {
+		return errno(err)
+	}
+
+	opts := &client.CreateOptions{}
+	if n.info != nil {
+		opts.Metadata, opts.Tags = n.info.Metadata, n.info.Tags
+	}
+	w, err := n.m.c.Create(ctx, n.m.dfsPath(&n.Inode), opts)
+	if err != nil {
+		return errno(err)
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(n.buf.f, 0, st.Size())); err != nil {
+		w.Abort()
+		return errno(err)
+	}
+	if err := w.Close(); err != nil {
+		return errno(err)
+	}
+	n.buf.dirty = false
+	n.m.invalidate()
+
+	info, err := n.m.c.Stat(ctx, w.Result().FileID)
+	if err != nil {
+		return errno(err)
+	}
+	n.info, n.fetched = info, time.Now()
+	return 0
+}
+
+// release drops a handle's reference to the buffer and throws the buffer
+// away with the last one.
+func (n *fileNode) release() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.buf.refs--
+	if n.buf.refs > 0 {
+		return
+	}
+	if n.buf.dirty && !n.unlinked {
+		log.Printf("dfs mount: discarding changes to %s that could not be saved", n.m.dfsPath(&n.Inode))
+	}
+	n.buf.f.Close()
+	n.buf = nil
+}
+
+// handle is an open file. Files opened only for reading while nobody writes
+// them are streamed from the DFS; all others go through the buffer.
+type handle struct {
+	n *fileNode
+
+	mu sync.Mutex
+	r  reader
+
+	buffered bool
+	append   bool
+}
+
+var (
+	_ fusefs.FileReader   = (*handle)(nil)
+	_ fusefs.FileWriter   = (*handle)(nil)
+	_ fusefs.FileFlusher  = (*handle)(nil)
+	_ fusefs.FileFsyncer  = (*handle)(nil)
+	_ fusefs.FileReleaser = (*handle)(nil)
+)
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if h.r != nil {
+		// The reader keeps its stream open between sequential reads.
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, err := h.r.Seek(off, io.SeekStart); err != nil {
+			return nil, errno(err)
+		}
+		n, err := io.ReadFull(h.r, dest)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, errno(err)
+		}
+		return fuse.ReadResultData(dest[:n]), 0
+	}
+
+	h.n.mu.Lock()
+	defer h.n.mu.Unlock()
+	n, err := h.n.buf.f.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *handle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	if !h.buffered {
+		return 0, syscall.EBADF
+	}
+	h.n.mu.Lock()
+	defer h.n.mu.Unlock()
+	if h.append {
+		st, err := h.n.buf.f.Stat()
+		if err != nil {
+			return 0, errno(err)
+		}
+		off = st.Size()
+	}
+	n, err := h.n.buf.f.WriteAt(data, off)
+	h.n.buf.dirty = true
+	if err != nil {
+		return uint32(n), errno(err)
+	}
+	return uint32(n), 0
+}
+
+// Flush uploads the changes when a descriptor of the file is closed, so that
+// close reports whether they were saved.
+func (h *handle) Flush(ctx context.Context) syscall.Errno {
+	if !h.buffered {
+		return 0
+	}
+	return h.n.commit(ctx)
+}
+
+func (h *handle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return h.Flush(ctx)
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	if h.r != nil {
+		h.r.Close()
+	}
+	if h.buffered {
+		h.n.release()
+	}
+	return 0
+}
@@ -0,0 +1,242 @@
+// Package fusefs mounts the DFS namespace as a local file system with FUSE.
+//
+// Directories are implied by the paths of the files below them, as in the
+// rest of the DFS; a directory made with mkdir only lives in the mount until
+// a file is written into it. Files are read with ranged downloads of the
+// version that was current when they were opened. Writes go to a local
+// temporary file and are uploaded as a new file, or a new version of it,
+// when the file is flushed or closed.
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"dfs/client"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Options configures a mount.
+type Options struct {
+	// AttrTTL is how long the kernel and the mount keep file attributes and
+	// name lookups before asking the coordinator again.
+	AttrTTL time.Duration
+	// DirTTL is how long a directory listing is kept.
+	DirTTL time.Duration
+	// ReadOnly mounts the file system read-only.
+	ReadOnly bool
+	// AllowOther lets users other than the one mounting access the mount.
+	AllowOther bool
+	// Debug logs every FUSE request.
+	Debug bool
+}
+
+// mount is the state shared by all nodes of a mounted file system.
+type mount struct {
+	// ctx is used for calls that outlive the FUSE request that started
+	// them, such as the reads of an open file.
+	ctx     context.Context
+	c       dfs
+	root    string
+	opts    Options
+	started time.Time
+
+	mu       sync.Mutex
+	listings map[string]*listing
+	// localDirs holds directories made with mkdir that have no files yet.
+	localDirs map[string]bool
+}
+
+// dfs is the part of the client API the mount uses, so that the nodes can
+// be tested against a fake.
+type dfs interface {
+	Stat(ctx context.Context, name string) (*client.FileInfo, error)
+	List(ctx context.Context, prefix string, opts *client.ListOptions) ([]*client.FileInfo, error)
+	Open(ctx context.Context, name string) (reader, error)
+	Create(ctx context.Context, name string, opts *client.CreateOptions) (writer, error)
+	Remove(ctx context.Context, name string) error
+	Rename(ctx context.Context, name, to string, overwrite bool) (*client.FileInfo, error)
+}
+
+// reader is a file opened with dfs.Open, like a client.Reader.
+type reader interface {
+	io.ReadSeekCloser
+	Info() *client.FileInfo
+}
+
+// writer is a file being written with dfs.Create, like a client.Writer.
+type writer interface {
+	io.WriteCloser
+	Abort()
+	Result() *client.UploadResult
+}
+
+// clientDFS is a client.Client as a dfs.
+type clientDFS struct {
+	*client.Client
+}
+
+func (c clientDFS) Open(ctx context.Context, name string) (reader, error) {
+	r, err := c.Client.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c clientDFS) Create(ctx context.Context, name string, opts *client.CreateOptions) (writer, error) {
+	w, err := c.Client.Create(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// listing is a cached directory listing. A nil FileInfo marks a
+// subdirectory.
+type listing struct {
+	at       time.Time
+	children map[string]*client.FileInfo
+}
+
+// Mount mounts the files below the DFS directory root at mountpoint. The
+// returned server is already serving; call its Unmount method to stop it
+// and Wait to wait for it to finish.
+func Mount(ctx context.Context, c *client.Client, mountpoint, root string, opts Options) (*fuse.Server, error) {
+	m := newMount(ctx, clientDFS{c}, root, opts)
+	var mountOptions []string
+	if opts.ReadOnly {
+		mountOptions = append(mountOptions, "ro")
+	}
+	return fusefs.Mount(mountpoint, &dirNode{m: m}, &fusefs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "dfs:" + m.root,
+			Name:        "dfs",
+			AllowOther:  opts.AllowOther,
+			Debug:       opts.Debug,
+			DirectMount: true,
+			Options:     mountOptions,
+		},
+		EntryTimeout:    &opts.AttrTTL,
+		AttrTimeout:     &opts.AttrTTL,
+		NegativeTimeout: &opts.AttrTTL,
+		UID:             uint32(syscall.Getuid()),
+		GID:             uint32(syscall.Getgid()),
+	})
+}
+
+func newMount(ctx context.Context, c dfs, root string, opts Options) *mount {
+	return &mount{
+		ctx:       ctx,
+		c:         c,
+		root:      "/" + strings.Trim(root, "/"),
+		opts:      opts,
+		started:   time.Now(),
+		listings:  map[string]*listing{},
+		localDirs: map[string]bool{},
+	}
+}
+
+// dfsPath returns the DFS path of an inode.
+func (m *mount) dfsPath(n *fusefs.Inode) string {
+	return path.Join(m.root, n.Path(nil))
+}
+
+// list returns the entries of the directory at the DFS path dir, listing
+// it afresh if the cached listing is older than DirTTL. If a path names a
+// file and is also a prefix of other paths, the file hides the directory.
+func (m *mount) list(ctx context.Context, dir string) (map[string]*client.FileInfo, error) {
+	m.mu.Lock()
+	l := m.listings[dir]
+	m.mu.Unlock()
+	if l != nil && time.Since(l.at) < m.opts.DirTTL {
+		return l.children, nil
+	}
+
+	prefix := dir
+	if prefix != "/" {
+		prefix += "/"
+	}
+	files, err := m.c.List(ctx, prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	l = &listing{at: time.Now(), children: map[string]*client.FileInfo{}}
+	for _, info := range files {
+		rel := strings.TrimPrefix(info.Path, prefix)
+		child, _, isDir := strings.Cut(rel, "/")
+		if !fs.ValidPath(rel) {
+			continue
+		}
+		if !isDir {
+			l.children[child] = info
+		} else if _, ok := l.children[child]; !ok {
+			l.children[child] = nil
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for d := range m.localDirs {
+		if parent, name := path.Split(d); path.Clean(parent) == dir {
+			if _, ok := l.children[name]; !ok {
+				l.children[name] = nil
+			}
+		}
+	}
+	m.listings[dir] = l
+	return l.children, nil
+}
+
+// invalidate drops all cached listings after a change to the namespace,
+// which can make directories appear or vanish all the way up to the root.
+func (m *mount) invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.listings)
+}
+
+// ino derives a stable inode number from a file ID or directory path.
+func ino(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// 1 is the root's.
+	return max(h.Sum64(), 2)
+}
+
+// errno maps the error of a DFS call to the errno the kernel sees.
+func errno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, fs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	}
+	switch status.Code(err) {
+	case codes.Aborted, codes.FailedPrecondition:
+		return syscall.EBUSY
+	case codes.InvalidArgument:
+		return syscall.EINVAL
+	}
+	log.Printf("dfs mount: %v", err)
+	return syscall.EIO
+}
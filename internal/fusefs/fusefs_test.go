@@ -0,0 +1,562 @@
+package fusefs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"dfs/client"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDFS keeps files in memory and counts the calls it gets.
+type fakeDFS struct {
+	mu     sync.Mutex
+	files  map[string]*fakeFile
+	nextID int
+	calls  map[string]int
+}
+
+type fakeFile struct {
+	info client.FileInfo
+	data []byte
+}
+
+func newFakeDFS(files map[string]string) *fakeDFS {
+	d := &fakeDFS{files: map[string]*fakeFile{}, calls: map[string]int{}}
+	for p, data := range files {
+		d.save(p, []byte(data), nil)
+	}
+	return d
+}
+
+// save stores data at p, keeping the ID of a file already there. The lock
+// must be held, or the fake not shared yet.
+func (d *fakeDFS) save(p string, data []byte, opts *client.CreateOptions) *fakeFile {
+	f := d.files[p]
+	if f == nil {
+		d.nextID++
+		f = &fakeFile{info: client.FileInfo{ID: fmt.Sprintf("id%d", d.nextID), Path: p}}
+		d.files[p] = f
+	}
+	f.data = data
+	f.info.Size = int64(len(data))
+	f.info.UpdatedAt = time.Now()
+	f.info.VersionID = fmt.Sprintf("v%d", time.Now().UnixNano())
+	if opts != nil {
+		f.info.Metadata, f.info.Tags = opts.Metadata, opts.Tags
+	}
+	return f
+}
+
+// find returns the file named by a path or ID.
+func (d *fakeDFS) find(op, name string) (*fakeFile, error) {
+	for _, f := range d.files {
+		if f.info.Path == name || f.info.ID == name {
+			return f, nil
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (d *fakeDFS) contents(p string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, ok := d.files[p]
+	if !ok {
+		return "", false
+	}
+	return string(f.data), true
+}
+
+func (d *fakeDFS) count(call string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.calls[call]
+}
+
+func (d *fakeDFS) Stat(ctx context.Context, name string) (*client.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls["Stat"]++
+	f, err := d.find("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info := f.info
+	return &info, nil
+}
+
+func (d *fakeDFS) List(ctx context.Context, prefix string, opts *client.ListOptions) ([]*client.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls["List"]++
+	var files []*client.FileInfo
+	for p, f := range d.files {
+		if strings.HasPrefix(p, prefix) {
+			info := f.info
+			files = append(files, &info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func (d *fakeDFS) Open(ctx context.Context, name string) (reader, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls["Open"]++
+	f, err := d.find("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := f.info
+	return &fakeReader{Reader: bytes.NewReader(f.data), info: &info}, nil
+}
+
+func (d *fakeDFS) Create(ctx context.Context, name string, opts *client.CreateOptions) (writer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls["Create"]++
+	return &fakeWriter{d: d, name: name, opts: opts}, nil
+}
+
+func (d *fakeDFS) Remove(ctx context.Context, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls["Remove"]++
+	f, err := d.find("remove", name)
+	if err != nil {
+		return err
+	}
+	delete(d.files, f.info.Path)
+	return nil
+}
+
+func (d *fakeDFS) Rename(ctx context.Context, name, to string, overwrite bool) (*client.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls["Rename"]++
+	f, err := d.find("rename", name)
+	if err != nil {
+		return nil, err
+	}
+	if existing, ok := d.files[to]; ok && existing != f && !overwrite {
+		return nil, &fs.PathError{Op: "rename", Path: name, Err: fs.ErrExist}
+	}
+	delete(d.files, f.info.Path)
+	f.info.Path = to
+	d.files[to] = f
+	info := f.info
+	return &info, nil
+}
+
+type fakeReader struct {
+	*bytes.Reader
+	info *client.FileInfo
+}
+
+func (r *fakeReader) Close() error           { return nil }
+func (r *fakeReader) Info() *client.FileInfo { return r.info }
+
+type fakeWriter struct {
+	d      *fakeDFS
+	name   string
+	opts   *client.CreateOptions
+	buf    bytes.Buffer
+	result *client.UploadResult
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error)  { return w.buf.Write(p) }
+func (w *fakeWriter) Abort()                       {}
+func (w *fakeWriter) Result() *client.UploadResult { return w.result }
+
+func (w *fakeWriter) Close() error {
+	w.d.mu.Lock()
+	defer w.d.mu.Unlock()
+	f := w.d.save(w.name, bytes.Clone(w.buf.Bytes()), w.opts)
+	w.result = &client.UploadResult{FileID: f.info.ID, VersionID: f.info.VersionID}
+	return nil
+}
+
+// newTestFS returns the root of a mount of d that is not attached to the
+// kernel, so that the tests call the nodes as the FUSE bridge would.
+func newTestFS(d *fakeDFS, opts Options) *dirNode {
+	root := &dirNode{m: newMount(context.Background(), d, "/", opts)}
+	fusefs.NewNodeFS(root, &fusefs.Options{})
+	return root
+}
+
+// lookup looks name up in dir and links the result into the tree, as the
+// kernel does.
+func lookup(t *testing.T, dir *fusefs.Inode, name string) (*fusefs.Inode, syscall.Errno) {
+	t.Helper()
+	var out fuse.EntryOut
+	ch, errNo := dir.Operations().(fusefs.NodeLookuper).Lookup(context.Background(), name, &out)
+	if errNo == 0 {
+		dir.AddChild(name, ch, true)
+	}
+	return ch, errNo
+}
+
+func mustLookup(t *testing.T, dir *fusefs.Inode, name string) *fusefs.Inode {
+	t.Helper()
+	ch, errNo := lookup(t, dir, name)
+	if errNo != 0 {
+		t.Fatalf("lookup %s: %v", name, errNo)
+	}
+	return ch
+}
+
+func readdir(t *testing.T, dir *fusefs.Inode) map[string]uint32 {
+	t.Helper()
+	stream, errNo := dir.Operations().(fusefs.NodeReaddirer).Readdir(context.Background())
+	if errNo != 0 {
+		t.Fatalf("readdir: %v", errNo)
+	}
+	entries := map[string]uint32{}
+	for stream.HasNext() {
+		e, errNo := stream.Next()
+		if errNo != 0 {
+			t.Fatal(errNo)
+		}
+		entries[e.Name] = e.Mode
+	}
+	return entries
+}
+
+func read(t *testing.T, h fusefs.FileHandle, size int, off int64) string {
+	t.Helper()
+	res, errNo := h.(fusefs.FileReader).Read(context.Background(), make([]byte, size), off)
+	if errNo != 0 {
+		t.Fatalf("read at %d: %v", off, errNo)
+	}
+	data, status := res.Bytes(make([]byte, size))
+	if !status.Ok() {
+		t.Fatal(status)
+	}
+	return string(data)
+}
+
+func write(t *testing.T, h fusefs.FileHandle, data string, off int64) {
+	t.Helper()
+	n, errNo := h.(fusefs.FileWriter).Write(context.Background(), []byte(data), off)
+	if errNo != 0 || int(n) != len(data) {
+		t.Fatalf("write at %d = %d, %v", off, n, errNo)
+	}
+}
+
+func open(t *testing.T, n *fusefs.Inode, flags uint32) fusefs.FileHandle {
+	t.Helper()
+	h, _, errNo := n.Operations().(fusefs.NodeOpener).Open(context.Background(), flags)
+	if errNo != 0 {
+		t.Fatalf("open: %v", errNo)
+	}
+	return h
+}
+
+func TestLookupAndReaddir(t *testing.T) {
+	d := newFakeDFS(map[string]string{
+		"/a.txt":           "alpha",
+		"/docs/b.txt":      "beta",
+		"/docs/deep/c.txt": "gamma",
+		"/x":               "file",
+		"/x/y":             "hidden",
+	})
+	root := newTestFS(d, Options{DirTTL: time.Hour, AttrTTL: time.Hour})
+
+	want := map[string]uint32{"a.txt": syscall.S_IFREG, "docs": syscall.S_IFDIR, "x": syscall.S_IFREG}
+	got := readdir(t, &root.Inode)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("root entries = %v, want %v", got, want)
+	}
+
+	var out fuse.EntryOut
+	a, errNo := root.Lookup(context.Background(), "a.txt", &out)
+	if errNo != 0 || out.Attr.Size != 5 || out.Attr.Mode != syscall.S_IFREG|0o644 || a.Operations().(*fileNode).pending() {
+		t.Errorf("lookup a.txt = %v, attr %+v", errNo, out.Attr)
+	}
+	docs := mustLookup(t, &root.Inode, "docs")
+	if !docs.IsDir() {
+		t.Fatal("docs is not a directory")
+	}
+	if got := readdir(t, docs); len(got) != 2 || got["deep"] != syscall.S_IFDIR || got["b.txt"] != syscall.S_IFREG {
+		t.Errorf("docs entries = %v", got)
+	}
+	if _, errNo := lookup(t, &root.Inode, "missing"); errNo != syscall.ENOENT {
+		t.Errorf("lookup of a missing name = %v, want ENOENT", errNo)
+	}
+	// Inode numbers follow file IDs, so they survive renames.
+	b := mustLookup(t, docs, "b.txt")
+	if b.StableAttr().Ino != ino(d.files["/docs/b.txt"].info.ID) {
+		t.Error("file inode number is not derived from its ID")
+	}
+
+	// Listings are cached for DirTTL.
+	lists := d.count("List")
+	lookup(t, &root.Inode, "a.txt")
+	readdir(t, &root.Inode)
+	if d.count("List") != lists {
+		t.Errorf("cached listing was fetched again")
+	}
+}
+
+func TestReadHandle(t *testing.T) {
+	d := newFakeDFS(map[string]string{"/f": "0123456789"})
+	root := newTestFS(d, Options{})
+	f := mustLookup(t, &root.Inode, "f")
+	h := open(t, f, syscall.O_RDONLY)
+	defer h.(fusefs.FileReleaser).Release(context.Background())
+	if h.(*handle).buffered {
+		t.Fatal("read-only handle is buffered")
+	}
+	for _, tt := range []struct {
+		off  int64
+		size int
+		want string
+	}{{0, 4, "0123"}, {4, 4, "4567"}, {2, 3, "234"}, {8, 10, "89"}, {10, 4, ""}} {
+		if got := read(t, h, tt.size, tt.off); got != tt.want {
+			t.Errorf("read %d at %d = %q, want %q", tt.size, tt.off, got, tt.want)
+		}
+	}
+	if _, errNo := h.(fusefs.FileWriter).Write(context.Background(), []byte("x"), 0); errNo != syscall.EBADF {
+		t.Errorf("write to a read-only handle = %v, want EBADF", errNo)
+	}
+}
+
+func TestCreateWriteFlush(t *testing.T) {
+	d := newFakeDFS(nil)
+	root := newTestFS(d, Options{})
+	var out fuse.EntryOut
+	ch, h, _, errNo := root.Create(context.Background(), "new.txt", syscall.O_WRONLY|syscall.O_CREAT, 0o644, &out)
+	if errNo != 0 {
+		t.Fatal(errNo)
+	}
+	root.AddChild("new.txt", ch, true)
+
+	// Until it is flushed, the file is only in the mount.
+	if _, ok := d.contents("/new.txt"); ok {
+		t.Fatal("file uploaded before flush")
+	}
+	if _, errNo := lookup(t, &root.Inode, "new.txt"); errNo != 0 {
+		t.Fatalf("lookup of a pending file: %v", errNo)
+	}
+	write(t, h, "hello", 0)
+	write(t, h, ", world", 5)
+	if got := read(t, h, 100, 0); got != "hello, world" {
+		t.Errorf("read of the buffer = %q", got)
+	}
+	var attr fuse.AttrOut
+	if ch.Operations().(*fileNode).Getattr(context.Background(), h, &attr); attr.Size != 12 {
+		t.Errorf("size of the buffer = %d", attr.Size)
+	}
+
+	if errNo := h.(fusefs.FileFlusher).Flush(context.Background()); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	if got, _ := d.contents("/new.txt"); got != "hello, world" {
+		t.Fatalf("uploaded %q", got)
+	}
+	// A flush without changes uploads nothing.
+	creates := d.count("Create")
+	h.(fusefs.FileFlusher).Flush(context.Background())
+	h.(fusefs.FileReleaser).Release(context.Background())
+	if d.count("Create") != creates {
+		t.Error("flush without changes uploaded again")
+	}
+	if ch.Operations().(*fileNode).buf != nil {
+		t.Error("buffer kept after the last release")
+	}
+}
+
+func TestOpenForWriting(t *testing.T) {
+	d := newFakeDFS(map[string]string{"/f": "0123456789"})
+	d.files["/f"].info.Tags = []string{"keep"}
+	root := newTestFS(d, Options{})
+	f := mustLookup(t, &root.Inode, "f")
+
+	tests := []struct {
+		name  string
+		flags uint32
+		data  string
+		off   int64
+		want  string
+	}{
+		{"overwrite", syscall.O_WRONLY, "ab", 2, "01ab456789"},
+		{"append", syscall.O_WRONLY | syscall.O_APPEND, "XY", 0, "01ab456789XY"},
+		{"truncate", syscall.O_RDWR | syscall.O_TRUNC, "new", 0, "new"},
+	}
+	for _, tt := range tests {
+		h := open(t, f, tt.flags)
+		write(t, h, tt.data, tt.off)
+		if errNo := h.(fusefs.FileFlusher).Flush(context.Background()); errNo != 0 {
+			t.Fatal(errNo)
+		}
+		h.(fusefs.FileReleaser).Release(context.Background())
+		if got, _ := d.contents("/f"); got != tt.want {
+			t.Errorf("%s: file holds %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if tags := d.files["/f"].info.Tags; len(tags) != 1 || tags[0] != "keep" {
+		t.Errorf("rewriting lost the tags: %v", tags)
+	}
+
+	// A reader opened while the file is being written sees the changes.
+	w := open(t, f, syscall.O_WRONLY)
+	write(t, w, "N", 0)
+	r := open(t, f, syscall.O_RDONLY)
+	if got := read(t, r, 10, 0); got != "New" {
+		t.Errorf("reader of a file being written sees %q", got)
+	}
+	r.(fusefs.FileReleaser).Release(context.Background())
+	w.(fusefs.FileReleaser).Release(context.Background())
+}
+
+func TestTruncate(t *testing.T) {
+	d := newFakeDFS(map[string]string{"/f": "0123456789"})
+	root := newTestFS(d, Options{})
+	f := mustLookup(t, &root.Inode, "f")
+	var in fuse.SetAttrIn
+	in.Valid = fuse.FATTR_SIZE
+	in.Size = 4
+	var out fuse.AttrOut
+	// Truncating a file that is not open saves it right away.
+	if errNo := f.Operations().(*fileNode).Setattr(context.Background(), nil, &in, &out); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	if got, _ := d.contents("/f"); got != "0123" || out.Size != 4 {
+		t.Errorf("after truncate the file holds %q, size %d", got, out.Size)
+	}
+	if f.Operations().(*fileNode).buf != nil {
+		t.Error("truncate kept the buffer")
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	d := newFakeDFS(map[string]string{"/old": "x"})
+	root := newTestFS(d, Options{})
+	mustLookup(t, &root.Inode, "old")
+	if errNo := root.Unlink(context.Background(), "old"); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	if _, ok := d.contents("/old"); ok {
+		t.Error("unlinked file still exists")
+	}
+	if _, errNo := lookup(t, &root.Inode, "old"); errNo != syscall.ENOENT {
+		t.Errorf("lookup after unlink = %v; the listing was not invalidated", errNo)
+	}
+
+	// A file that was never uploaded is dropped from the mount only, and
+	// closing it does not upload it after all.
+	var out fuse.EntryOut
+	ch, h, _, errNo := root.Create(context.Background(), "tmp", syscall.O_WRONLY, 0o644, &out)
+	if errNo != 0 {
+		t.Fatal(errNo)
+	}
+	root.AddChild("tmp", ch, true)
+	write(t, h, "scratch", 0)
+	removes := d.count("Remove")
+	if errNo := root.Unlink(context.Background(), "tmp"); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	h.(fusefs.FileFlusher).Flush(context.Background())
+	h.(fusefs.FileReleaser).Release(context.Background())
+	if d.count("Remove") != removes || d.count("Create") != 0 {
+		t.Errorf("unlinking a pending file made %d removes and %d uploads", d.count("Remove")-removes, d.count("Create"))
+	}
+	if errNo := root.Unlink(context.Background(), "missing"); errNo != syscall.ENOENT {
+		t.Errorf("unlink of a missing file = %v", errNo)
+	}
+}
+
+func TestMkdirRmdir(t *testing.T) {
+	d := newFakeDFS(map[string]string{"/docs/a": "a"})
+	root := newTestFS(d, Options{})
+	var out fuse.EntryOut
+	if _, errNo := root.Mkdir(context.Background(), "empty", 0o755, &out); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	if _, errNo := root.Mkdir(context.Background(), "docs", 0o755, &out); errNo != syscall.EEXIST {
+		t.Errorf("mkdir of an implied directory = %v, want EEXIST", errNo)
+	}
+	if got := readdir(t, &root.Inode); got["empty"] != syscall.S_IFDIR {
+		t.Fatalf("new directory is not listed: %v", got)
+	}
+	if errNo := root.Rmdir(context.Background(), "docs"); errNo != syscall.ENOTEMPTY {
+		t.Errorf("rmdir of a directory with files = %v, want ENOTEMPTY", errNo)
+	}
+	if errNo := root.Rmdir(context.Background(), "empty"); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	if got := readdir(t, &root.Inode); got["empty"] != 0 {
+		t.Errorf("removed directory is still listed: %v", got)
+	}
+	if errNo := root.Rmdir(context.Background(), "empty"); errNo != syscall.ENOENT {
+		t.Errorf("second rmdir = %v, want ENOENT", errNo)
+	}
+}
+
+func TestRename(t *testing.T) {
+	d := newFakeDFS(map[string]string{"/docs/a": "a", "/docs/sub/b": "b", "/c": "c", "/full/x": "x"})
+	root := newTestFS(d, Options{})
+	docs := mustLookup(t, &root.Inode, "docs")
+	mustLookup(t, &root.Inode, "c")
+	mustLookup(t, docs, "a")
+
+	if errNo := root.Rename(context.Background(), "c", docs, "a", renameNoReplace); errNo != syscall.EEXIST {
+		t.Errorf("rename onto a file with RENAME_NOREPLACE = %v, want EEXIST", errNo)
+	}
+	if errNo := root.Rename(context.Background(), "c", docs, "c2", 0); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	if got, _ := d.contents("/docs/c2"); got != "c" {
+		t.Errorf("renamed file holds %q", got)
+	}
+	if errNo := root.Rename(context.Background(), "docs", &root.Inode, "full", 0); errNo != syscall.ENOTEMPTY {
+		t.Errorf("rename of a directory onto a non-empty one = %v, want ENOTEMPTY", errNo)
+	}
+	if errNo := root.Rename(context.Background(), "docs", &root.Inode, "archive", 0); errNo != 0 {
+		t.Fatal(errNo)
+	}
+	for _, p := range []string{"/archive/a", "/archive/sub/b", "/archive/c2"} {
+		if _, ok := d.contents(p); !ok {
+			t.Errorf("%s is missing after moving the directory", p)
+		}
+	}
+	if errNo := root.Rename(context.Background(), "archive", &root.Inode, "y", renameExchange); errNo != syscall.ENOTSUP {
+		t.Errorf("RENAME_EXCHANGE = %v, want ENOTSUP", errNo)
+	}
+}
+
+func TestErrno(t *testing.T) {
+	tests := []struct {
+		err  error
+		want syscall.Errno
+	}{
+		{nil, 0},
+		{&fs.PathError{Op: "stat", Path: "/a", Err: fs.ErrNotExist}, syscall.ENOENT},
+		{&fs.PathError{Op: "rename", Path: "/a", Err: fs.ErrExist}, syscall.EEXIST},
+		{fs.ErrPermission, syscall.EACCES},
+		{context.Canceled, syscall.EINTR},
+		{status.Error(codes.FailedPrecondition, "busy"), syscall.EBUSY},
+		{status.Error(codes.InvalidArgument, "bad"), syscall.EINVAL},
+		{errors.New("other"), syscall.EIO},
+	}
+	for _, tt := range tests {
+		if got := errno(tt.err); got != tt.want {
+			t.Errorf("errno(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+// Package httpgateway serves the DFS namespace over plain HTTP for clients
+// that cannot use gRPC, and over WebDAV so that file managers can mount it.
+//
+// The REST API:
+//
+//	GET    /files/<path>    download a file; supports Range, If-None-Match and If-Modified-Since
+//	HEAD   /files/<path>    the headers of a download
+//	PUT    /files/<path>    upload the request body, which may use chunked transfer encoding
+//	DELETE /files/<path>    move a file to the trash, or delete it for good with ?permanent=true
+//	GET    /files/<dir>/    list a directory as JSON, or every file below it with ?recursive=true
+//	GET    /stat/<path>     the attributes of a file as JSON
+//
+// Uploads take the content type from Content-Type, user metadata from
+// X-Dfs-Meta-<key> headers and tags from a comma-separated X-Dfs-Tags
+// header. With If-None-Match: * an upload fails if the file exists, and with
+// If-Match it fails unless the file's ETag matches, until the upload is
+// saved.
+//
+// If the coordinator requires authentication, requests are made with the API
+// token in an "Authorization: Bearer" header, or in the password of Basic
+// authentication for WebDAV clients, and otherwise with the gateway's own.
+package httpgateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"dfs/client"
+
+	"golang.org/x/net/webdav"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const metaPrefix = "X-Dfs-Meta-"
+
+type Config struct {
+	// WebDAV serves the namespace over WebDAV below /dav/.
+	WebDAV bool
+}
+
+type Server struct {
+	c   *client.Client
+	mux *http.ServeMux
+}
+
+func NewServer(c *client.Client, cfg Config) *Server {
+	s := &Server{c: c, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /files/{path...}", s.get)
+	s.mux.HandleFunc("PUT /files/{path...}", s.put)
+	s.mux.HandleFunc("DELETE /files/{path...}", s.delete)
+	s.mux.HandleFunc("GET /stat/{path...}", s.stat)
+	if cfg.WebDAV {
+		s.mux.Handle("/dav/", &webdav.Handler{
+			Prefix:     "/dav",
+			FileSystem: newDavFS(c),
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrExist) {
+					log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		})
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", "DFS")
+	if token := requestToken(r); token != "" {
+		r = r.WithContext(client.ContextWithToken(r.Context(), token))
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// requestToken returns the API token the request carries, if any.
+func requestToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// FileInfo is the JSON form of a file's attributes.
+type FileInfo struct {
+	ID          string            `json:"id"`
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	VersionID   string            `json:"versionId"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"`
+	MD5         string            `json:"md5,omitempty"`
+	ETag        string            `json:"etag"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	Owner       string            `json:"owner,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	// Mode holds the permission bits in octal, as in "0644".
+	Mode string `json:"mode,omitempty"`
+}
+
+func fileInfo(info *client.FileInfo) *FileInfo {
+	fi := &FileInfo{
+		ID:          info.ID,
+		Path:        info.Path,
+		Size:        info.Size,
+		VersionID:   info.VersionID,
+		ContentType: info.ContentType,
+		Metadata:    info.Metadata,
+		Tags:        info.Tags,
+		SHA256:      info.SHA256,
+		MD5:         info.MD5,
+		ETag:        etag(info),
+		CreatedAt:   info.CreatedAt,
+		UpdatedAt:   info.UpdatedAt,
+		Owner:       info.Owner,
+		Group:       info.Group,
+	}
+	if info.Owner != "" {
+		fi.Mode = fmt.Sprintf("%04o", uint32(info.Mode))
+	}
+	return fi
+}
+
+// Listing is the JSON form of a directory.
+type Listing struct {
+	Path string `json:"path"`
+	// Directories holds the names of the subdirectories; it is empty for
+	// recursive listings.
+	Directories []string    `json:"directories"`
+	Files       []*FileInfo `json:"files"`
+}
+
+// etag returns a strong ETag for a file: its MD5 digest, or its version ID
+// if the digest is unknown.
+func etag(info *client.FileInfo) string {
+	if info.MD5 != "" {
+		return `"` + info.MD5 + `"`
+	}
+	return `"` + info.VersionID + `"`
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	p := "/" + r.PathValue("path")
+	if strings.HasSuffix(p, "/") {
+		s.list(w, r, p)
+		return
+	}
+	fr, err := s.c.Open(r.Context(), p)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer fr.Close()
+	info := fr.Info()
+	w.Header().Set("ETag", etag(info))
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	for k, v := range info.Metadata {
+		w.Header().Set(metaPrefix+k, v)
+	}
+	if len(info.Tags) > 0 {
+		w.Header().Set("X-Dfs-Tags", strings.Join(info.Tags, ","))
+	}
+	http.ServeContent(w, r, path.Base(p), info.UpdatedAt, fr)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, dir string) {
+	files, err := s.c.List(r.Context(), dir, nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "true"
+	listing := &Listing{Path: dir, Directories: []string{}, Files: []*FileInfo{}}
+	for _, f := range files {
+		name, _, isDir := strings.Cut(strings.TrimPrefix(f.Path, dir), "/")
+		if !isDir || recursive {
+			listing.Files = append(listing.Files, fileInfo(f))
+		} else if !slices.Contains(listing.Directories, name) {
+			listing.Directories = append(listing.Directories, name)
+		}
+	}
+	sort.Strings(listing.Directories)
+	sort.Slice(listing.Files, func(i, j int) bool { return listing.Files[i].Path < listing.Files[j].Path })
+	writeJSON(w, http.StatusOK, listing)
+}
+
+func (s *Server) stat(w http.ResponseWriter, r *http.Request) {
+	info, err := s.c.Stat(r.Context(), "/"+r.PathValue("path"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(info))
+	writeJSON(w, http.StatusOK, fileInfo(info))
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	p := "/" + r.PathValue("path")
+	if strings.HasSuffix(p, "/") {
+		writeJSONError(w, http.StatusBadRequest, "cannot upload to a directory path")
+		return
+	}
+	ctx := r.Context()
+	opts := &client.CreateOptions{ContentType: r.Header.Get("Content-Type"), Metadata: map[string]string{}}
+	if err := s.checkPreconditions(ctx, r, p, opts); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, metaPrefix) && len(name) > len(metaPrefix) {
+			opts.Metadata[strings.ToLower(strings.TrimPrefix(name, metaPrefix))] = values[0]
+		}
+	}
+	for _, tag := range strings.Split(r.Header.Get("X-Dfs-Tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			opts.Tags = append(opts.Tags, tag)
+		}
+	}
+
+	fw, err := s.c.Create(ctx, p, opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := io.Copy(fw, r.Body); err != nil {
+		fw.Abort()
+		writeError(w, r, preconditionError(opts, err))
+		return
+	}
+	if err := fw.Close(); err != nil {
+		writeError(w, r, preconditionError(opts, err))
+		return
+	}
+	info, err := s.c.Stat(ctx, fw.Result().FileID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(info))
+	w.Header().Set("Location", "/files"+info.Path)
+	writeJSON(w, http.StatusCreated, fileInfo(info))
+}
+
+var errPrecondition = errors.New("precondition failed")
+
+// checkPreconditions checks the If-Match and If-None-Match headers of an
+// upload against the file it replaces. If they hold, it sets opts so that
+// the upload only succeeds while that file, or the absence of one, stays
+// as it was.
+func (s *Server) checkPreconditions(ctx context.Context, r *http.Request, p string, opts *client.CreateOptions) error {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	info, err := s.c.Stat(ctx, p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	current := ""
+	if info != nil {
+		current = etag(info)
+	}
+	if ifMatch != "" && (current == "" || !matchETag(ifMatch, current)) {
+		return errPrecondition
+	}
+	if ifNoneMatch != "" && current != "" && matchETag(ifNoneMatch, current) {
+		return errPrecondition
+	}
+	if info == nil {
+		opts.IfNoneMatch = true
+	} else {
+		opts.IfMatch = info
+	}
+	return nil
+}
+
+// preconditionError turns the failure of an upload made with the conditions
+// in opts into errPrecondition if the conditions no longer held.
+func preconditionError(opts *client.CreateOptions, err error) error {
+	if !opts.IfNoneMatch && opts.IfMatch == nil {
+		return err
+	}
+	switch status.Code(err) {
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		return errPrecondition
+	}
+	return err
+}
+
+// matchETag reports whether a list of ETags from an If-Match or
+// If-None-Match header matches etag.
+func matchETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	p := "/" + r.PathValue("path")
+	var err error
+	if r.URL.Query().Get("permanent") == "true" {
+		err = s.c.RemovePermanently(r.Context(), p)
+	} else {
+		err = s.c.Remove(r.Context(), p)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
+
+// writeError answers with the HTTP status for an error from the DFS client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errPrecondition):
+		code = http.StatusPreconditionFailed
+	case errors.Is(err, fs.ErrNotExist):
+		code = http.StatusNotFound
+	case errors.Is(err, fs.ErrExist):
+		code = http.StatusConflict
+	case status.Code(err) == codes.Unauthenticated:
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="DFS"`)
+	case errors.Is(err, fs.ErrPermission):
+		code = http.StatusForbidden
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusServiceUnavailable
+	default:
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.OutOfRange:
+			code = http.StatusBadRequest
+		case codes.Unavailable, codes.ResourceExhausted:
+			code = http.StatusServiceUnavailable
+		}
+	}
+	if code == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(code)
+		return
+	}
+	writeJSONError(w, code, err.Error())
+}
@@ -0,0 +1,290 @@
+package httpgateway
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dfs/client"
+	"dfs/internal/chunk"
+	"dfs/internal/coordinator"
+	"dfs/internal/metadataservice"
+	pbcoord "dfs/internal/pb/coordinator"
+	pbmeta "dfs/internal/pb/metadata"
+	pbstorage "dfs/internal/pb/storagenode"
+	"dfs/internal/storagenode"
+
+	"google.golang.org/grpc"
+)
+
+// serve starts a gRPC server with the services register adds and returns
+// its address.
+func serve(t *testing.T, register func(s *grpc.Server)) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// startGateway runs a metadata service, three storage nodes, a coordinator
+// and a gateway with WebDAV in the test process and returns the gateway's
+// URL.
+func startGateway(t *testing.T) string {
+	t.Helper()
+	store, err := metadataservice.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataAddr := serve(t, func(s *grpc.Server) {
+		pbmeta.RegisterMetadataServiceServer(s, metadataservice.NewServer(store))
+	})
+	var storageAddrs []string
+	for i := 0; i < 3; i++ {
+		chunks, err := chunk.NewDiskStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		storageAddrs = append(storageAddrs, serve(t, func(s *grpc.Server) {
+			pbstorage.RegisterStorageNodeServer(s, storagenode.NewServer(chunks))
+		}))
+	}
+	coord, err := coordinator.NewServer(coordinator.Config{
+		MetadataAddrs:     []string{metadataAddr},
+		StorageAddrs:      storageAddrs,
+		CacheSize:         -1,
+		RetentionInterval: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New(serve(t, func(s *grpc.Server) {
+		pbcoord.RegisterCoordinatorServer(s, coord)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	srv := httptest.NewServer(NewServer(c, Config{WebDAV: true}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// do makes a request and returns the response with its body read.
+func do(t *testing.T, method, url, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func expectStatus(t *testing.T, what string, resp *http.Response, body string, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Fatalf("%s: status %d, want %d; body %q", what, resp.StatusCode, want, body)
+	}
+}
+
+func TestGetPut(t *testing.T) {
+	url := startGateway(t)
+
+	resp, body := do(t, "PUT", url+"/files/docs/a.txt", "hello, world", map[string]string{
+		"Content-Type":      "text/plain",
+		"X-Dfs-Meta-Author": "alice",
+		"X-Dfs-Tags":        "draft, notes",
+	})
+	expectStatus(t, "PUT", resp, body, http.StatusCreated)
+	if got := resp.Header.Get("Location"); got != "/files/docs/a.txt" {
+		t.Errorf("Location = %q", got)
+	}
+	var info FileInfo
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 12 || info.Path != "/docs/a.txt" || info.ETag != resp.Header.Get("ETag") {
+		t.Errorf("PUT returned %+v", info)
+	}
+
+	resp, body = do(t, "GET", url+"/files/docs/a.txt", "", nil)
+	expectStatus(t, "GET", resp, body, http.StatusOK)
+	if body != "hello, world" {
+		t.Errorf("GET body = %q", body)
+	}
+	for header, want := range map[string]string{
+		"ETag":              info.ETag,
+		"Content-Type":      "text/plain",
+		"X-Dfs-Meta-Author": "alice",
+		"X-Dfs-Tags":        "draft,notes",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("GET %s = %q, want %q", header, got, want)
+		}
+	}
+
+	resp, body = do(t, "GET", url+"/stat/docs/a.txt", "", nil)
+	expectStatus(t, "stat", resp, body, http.StatusOK)
+	if !strings.Contains(body, `"contentType":"text/plain"`) {
+		t.Errorf("stat = %s", body)
+	}
+
+	do(t, "PUT", url+"/files/docs/sub/b.txt", "b", nil)
+	resp, body = do(t, "GET", url+"/files/docs/", "", nil)
+	expectStatus(t, "list", resp, body, http.StatusOK)
+	var listing Listing
+	if err := json.Unmarshal([]byte(body), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Files) != 1 || listing.Files[0].Path != "/docs/a.txt" || len(listing.Directories) != 1 || listing.Directories[0] != "sub" {
+		t.Errorf("listing = %s", body)
+	}
+
+	resp, body = do(t, "GET", url+"/files/missing.txt", "", nil)
+	expectStatus(t, "GET of a missing file", resp, body, http.StatusNotFound)
+	resp, body = do(t, "PUT", url+"/files/docs/", "x", nil)
+	expectStatus(t, "PUT to a directory", resp, body, http.StatusBadRequest)
+
+	resp, body = do(t, "DELETE", url+"/files/docs/a.txt", "", nil)
+	expectStatus(t, "DELETE", resp, body, http.StatusNoContent)
+	resp, body = do(t, "GET", url+"/files/docs/a.txt", "", nil)
+	expectStatus(t, "GET after DELETE", resp, body, http.StatusNotFound)
+}
+
+func TestRange(t *testing.T) {
+	url := startGateway(t)
+	do(t, "PUT", url+"/files/r.txt", "0123456789", nil)
+
+	tests := []struct {
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, tt := range tests {
+		resp, body := do(t, "GET", url+"/files/r.txt", "", map[string]string{"Range": tt.rangeHeader})
+		if resp.StatusCode != tt.status {
+			t.Errorf("Range %s: status %d, want %d", tt.rangeHeader, resp.StatusCode, tt.status)
+			continue
+		}
+		if tt.status == http.StatusPartialContent && body != tt.body {
+			t.Errorf("Range %s: body %q, want %q", tt.rangeHeader, body, tt.body)
+		}
+		if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("Range %s: Content-Range %q, want %q", tt.rangeHeader, got, tt.contentRange)
+		}
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	url := startGateway(t)
+	resp, _ := do(t, "PUT", url+"/files/c.txt", "v1", nil)
+	v1 := resp.Header.Get("ETag")
+
+	resp, body := do(t, "GET", url+"/files/c.txt", "", map[string]string{"If-None-Match": v1})
+	expectStatus(t, "GET with matching If-None-Match", resp, body, http.StatusNotModified)
+	resp, body = do(t, "GET", url+"/files/c.txt", "", map[string]string{"If-None-Match": `"other"`})
+	expectStatus(t, "GET with other If-None-Match", resp, body, http.StatusOK)
+
+	resp, body = do(t, "PUT", url+"/files/c.txt", "v2", map[string]string{"If-None-Match": "*"})
+	expectStatus(t, "PUT If-None-Match: * over a file", resp, body, http.StatusPreconditionFailed)
+	resp, body = do(t, "PUT", url+"/files/new.txt", "new", map[string]string{"If-None-Match": "*"})
+	expectStatus(t, "PUT If-None-Match: * of a new file", resp, body, http.StatusCreated)
+
+	resp, body = do(t, "PUT", url+"/files/c.txt", "v2", map[string]string{"If-Match": `"stale"`})
+	expectStatus(t, "PUT with stale If-Match", resp, body, http.StatusPreconditionFailed)
+	resp, body = do(t, "PUT", url+"/files/missing.txt", "x", map[string]string{"If-Match": "*"})
+	expectStatus(t, "PUT If-Match: * of a missing file", resp, body, http.StatusPreconditionFailed)
+	resp, body = do(t, "PUT", url+"/files/c.txt", "v2", map[string]string{"If-Match": v1})
+	expectStatus(t, "PUT with current If-Match", resp, body, http.StatusCreated)
+	if resp.Header.Get("ETag") == v1 {
+		t.Error("ETag did not change")
+	}
+	resp, body = do(t, "PUT", url+"/files/c.txt", "v3", map[string]string{"If-Match": v1})
+	expectStatus(t, "PUT with replaced If-Match", resp, body, http.StatusPreconditionFailed)
+
+	_, body = do(t, "GET", url+"/files/c.txt", "", nil)
+	if body != "v2" {
+		t.Errorf("file holds %q, want v2", body)
+	}
+}
+
+func TestWebDAV(t *testing.T) {
+	url := startGateway(t)
+
+	resp, body := do(t, "MKCOL", url+"/dav/docs", "", nil)
+	expectStatus(t, "MKCOL", resp, body, http.StatusCreated)
+	resp, body = do(t, "PUT", url+"/dav/docs/a.txt", "alpha", nil)
+	expectStatus(t, "PUT", resp, body, http.StatusCreated)
+	do(t, "MKCOL", url+"/dav/docs/sub", "", nil)
+	resp, body = do(t, "PUT", url+"/dav/docs/sub/b.txt", "beta", nil)
+	expectStatus(t, "PUT into a subdirectory", resp, body, http.StatusCreated)
+	resp, body = do(t, "PUT", url+"/dav/nodir/c.txt", "c", nil)
+	expectStatus(t, "PUT into a missing directory", resp, body, http.StatusConflict)
+
+	resp, body = do(t, "PROPFIND", url+"/dav/docs/", "", map[string]string{"Depth": "1"})
+	expectStatus(t, "PROPFIND", resp, body, http.StatusMultiStatus)
+	for _, want := range []string{"/dav/docs/a.txt", "/dav/docs/sub/", "<D:getcontentlength>5</D:getcontentlength>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("PROPFIND response lacks %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "b.txt") {
+		t.Errorf("PROPFIND with depth 1 lists b.txt:\n%s", body)
+	}
+	resp, body = do(t, "PROPFIND", url+"/dav/missing", "", map[string]string{"Depth": "0"})
+	expectStatus(t, "PROPFIND of a missing path", resp, body, http.StatusNotFound)
+
+	resp, body = do(t, "MOVE", url+"/dav/docs/a.txt", "", map[string]string{"Destination": url + "/dav/docs/renamed.txt"})
+	expectStatus(t, "MOVE of a file", resp, body, http.StatusCreated)
+	resp, body = do(t, "GET", url+"/files/docs/renamed.txt", "", nil)
+	expectStatus(t, "GET of the moved file", resp, body, http.StatusOK)
+	if body != "alpha" {
+		t.Errorf("moved file holds %q", body)
+	}
+
+	do(t, "PUT", url+"/dav/docs/c.txt", "gamma", nil)
+	resp, body = do(t, "MOVE", url+"/dav/docs/c.txt", "", map[string]string{"Destination": url + "/dav/docs/renamed.txt", "Overwrite": "F"})
+	expectStatus(t, "MOVE onto a file without Overwrite", resp, body, http.StatusPreconditionFailed)
+	resp, body = do(t, "MOVE", url+"/dav/docs/c.txt", "", map[string]string{"Destination": url + "/dav/docs/renamed.txt", "Overwrite": "T"})
+	expectStatus(t, "MOVE onto a file with Overwrite", resp, body, http.StatusNoContent)
+	_, body = do(t, "GET", url+"/files/docs/renamed.txt", "", nil)
+	if body != "gamma" {
+		t.Errorf("overwritten file holds %q", body)
+	}
+
+	resp, body = do(t, "MOVE", url+"/dav/docs", "", map[string]string{"Destination": url + "/dav/archive"})
+	expectStatus(t, "MOVE of a directory", resp, body, http.StatusCreated)
+	for _, p := range []string{"/archive/renamed.txt", "/archive/sub/b.txt"} {
+		resp, body = do(t, "GET", url+"/files"+p, "", nil)
+		expectStatus(t, "GET "+p, resp, body, http.StatusOK)
+	}
+	resp, body = do(t, "PROPFIND", url+"/dav/docs", "", map[string]string{"Depth": "0"})
+	expectStatus(t, "PROPFIND of the moved directory", resp, body, http.StatusNotFound)
+}
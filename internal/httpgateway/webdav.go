@@ -0,0 +1,393 @@
+package httpgateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dfs/client"
+
+	"golang.org/x/net/webdav"
+)
+
+// davFS is the DFS namespace as a webdav.FileSystem. Directories are implied
+// by the files below them; those made with MKCOL are kept in memory, so an
+// empty one is lost when the gateway restarts.
+type davFS struct {
+	c *client.Client
+
+	mu        sync.Mutex
+	localDirs map[string]bool
+}
+
+func newDavFS(c *client.Client) *davFS {
+	return &davFS{c: c, localDirs: map[string]bool{}}
+}
+
+var (
+	errIsDir        = errors.New("is a directory")
+	errNotDir       = errors.New("not a directory")
+	errNotSupported = errors.New("files can only be rewritten whole")
+)
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = clean(name)
+	if name == "/" {
+		return dirInfo("/"), nil
+	}
+	info, err := d.c.Stat(ctx, name)
+	if err == nil {
+		return davInfo{info}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	isDir, err := d.isDir(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return dirInfo(path.Base(name)), nil
+}
+
+// isDir reports whether name is a directory: one with files below it or one
+// made with MKCOL.
+func (d *davFS) isDir(ctx context.Context, name string) (bool, error) {
+	if name == "/" {
+		return true, nil
+	}
+	d.mu.Lock()
+	local := d.localDirs[name]
+	d.mu.Unlock()
+	if local {
+		return true, nil
+	}
+	files, err := d.c.List(ctx, name+"/", nil)
+	if err != nil {
+		return false, err
+	}
+	return len(files) > 0, nil
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = clean(name)
+	if _, err := d.Stat(ctx, name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if isDir, err := d.isDir(ctx, path.Dir(name)); err != nil {
+		return err
+	} else if !isDir {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrNotExist}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.localDirs[name] = true
+	return nil
+}
+
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = clean(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		fi, err := d.Stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			return &davDir{d: d, ctx: ctx, name: name}, nil
+		}
+		r, err := d.c.Open(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return &davFile{r: r}, nil
+	}
+
+	existing, err := d.c.Stat(ctx, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	switch {
+	case existing != nil && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case existing != nil && flag&os.O_TRUNC == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errNotSupported}
+	case existing == nil && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if isDir, err := d.isDir(ctx, name); err != nil {
+		return nil, err
+	} else if isDir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	if isDir, err := d.isDir(ctx, path.Dir(name)); err != nil {
+		return nil, err
+	} else if !isDir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// Like the FUSE mount, rewriting a file keeps its attributes.
+	opts := &client.CreateOptions{}
+	if existing != nil {
+		opts.ContentType, opts.Metadata, opts.Tags = existing.ContentType, existing.Metadata, existing.Tags
+	}
+	w, err := d.c.Create(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{w: w, name: name, started: time.Now()}, nil
+}
+
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	name = clean(name)
+	if name == "/" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	err := d.c.Remove(ctx, name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	files, err := d.c.List(ctx, name+"/", nil)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := d.c.Remove(ctx, f.ID); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for dir := range d.localDirs {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			delete(d.localDirs, dir)
+		}
+	}
+	return nil
+}
+
+// Rename moves a file, or every file below a directory one at a time. The
+// handler has already removed the destination if it is to be overwritten.
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = clean(oldName), clean(newName)
+	if oldName == "/" || strings.HasPrefix(newName, oldName+"/") {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrInvalid}
+	}
+	_, err := d.c.Rename(ctx, oldName, newName, false)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	files, err := d.c.List(ctx, oldName+"/", nil)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	moved := false
+	for dir := range d.localDirs {
+		if dir == oldName || strings.HasPrefix(dir, oldName+"/") {
+			delete(d.localDirs, dir)
+			d.localDirs[newName+strings.TrimPrefix(dir, oldName)] = true
+			moved = true
+		}
+	}
+	d.mu.Unlock()
+	if len(files) == 0 && !moved {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	for _, f := range files {
+		if _, err := d.c.Rename(ctx, f.ID, newName+strings.TrimPrefix(f.Path, oldName), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// davFile is a file opened either for reading or for writing it anew.
+type davFile struct {
+	r *client.Reader
+
+	w       *client.Writer
+	name    string
+	written int64
+	started time.Time
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, fs.ErrInvalid
+	}
+	return f.r.Read(p)
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if f.r == nil {
+		if offset == 0 && whence == io.SeekCurrent {
+			return f.written, nil
+		}
+		return 0, fs.ErrInvalid
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	if f.w == nil {
+		return 0, fs.ErrInvalid
+	}
+	n, err := f.w.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, errNotDir
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) {
+	if f.r != nil {
+		return davInfo{f.r.Info()}, nil
+	}
+	return uploadInfo{f}, nil
+}
+
+func (f *davFile) Close() error {
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return f.w.Close()
+}
+
+// davDir is an open directory.
+type davDir struct {
+	d    *davFS
+	ctx  context.Context
+	name string
+
+	entries []fs.FileInfo
+	loaded  bool
+}
+
+func (dd *davDir) Read([]byte) (int, error)       { return 0, errIsDir }
+func (dd *davDir) Write([]byte) (int, error)      { return 0, errIsDir }
+func (dd *davDir) Seek(int64, int) (int64, error) { return 0, nil }
+func (dd *davDir) Close() error                   { return nil }
+func (dd *davDir) Stat() (fs.FileInfo, error)     { return dirInfo(path.Base(dd.name)), nil }
+
+func (dd *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !dd.loaded {
+		if err := dd.load(); err != nil {
+			return nil, err
+		}
+		dd.loaded = true
+	}
+	if count <= 0 {
+		entries := dd.entries
+		dd.entries = nil
+		return entries, nil
+	}
+	if len(dd.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(dd.entries))
+	entries := dd.entries[:n]
+	dd.entries = dd.entries[n:]
+	return entries, nil
+}
+
+// load lists the children of the directory. A file hides a directory with
+// the same name.
+func (dd *davDir) load() error {
+	prefix := dd.name + "/"
+	if dd.name == "/" {
+		prefix = "/"
+	}
+	files, err := dd.d.c.List(dd.ctx, prefix, nil)
+	if err != nil {
+		return err
+	}
+	children := map[string]fs.FileInfo{}
+	for _, f := range files {
+		name, _, isDir := strings.Cut(strings.TrimPrefix(f.Path, prefix), "/")
+		if !isDir {
+			children[name] = davInfo{f}
+		} else if _, ok := children[name]; !ok {
+			children[name] = dirInfo(name)
+		}
+	}
+	dd.d.mu.Lock()
+	for dir := range dd.d.localDirs {
+		if rest, ok := strings.CutPrefix(dir, prefix); ok {
+			name, _, _ := strings.Cut(rest, "/")
+			if _, ok := children[name]; !ok {
+				children[name] = dirInfo(name)
+			}
+		}
+	}
+	dd.d.mu.Unlock()
+
+	dd.entries = make([]fs.FileInfo, 0, len(children))
+	for _, fi := range children {
+		dd.entries = append(dd.entries, fi)
+	}
+	sort.Slice(dd.entries, func(i, j int) bool { return dd.entries[i].Name() < dd.entries[j].Name() })
+	return nil
+}
+
+// davInfo describes a file, with the ETag and content type the WebDAV
+// handler reports for it.
+type davInfo struct {
+	info *client.FileInfo
+}
+
+func (fi davInfo) Name() string       { return path.Base(fi.info.Path) }
+func (fi davInfo) Size() int64        { return fi.info.Size }
+func (fi davInfo) Mode() fs.FileMode  { return 0o644 }
+func (fi davInfo) ModTime() time.Time { return fi.info.UpdatedAt }
+func (fi davInfo) IsDir() bool        { return false }
+func (fi davInfo) Sys() any           { return fi.info }
+
+func (fi davInfo) ETag(ctx context.Context) (string, error) {
+	return etag(fi.info), nil
+}
+
+func (fi davInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.info.ContentType == "" {
+		// Let the handler guess from the name.
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.info.ContentType, nil
+}
+
+// uploadInfo describes a file being written.
+type uploadInfo struct {
+	f *davFile
+}
+
+func (fi uploadInfo) Name() string       { return path.Base(fi.f.name) }
+func (fi uploadInfo) Size() int64        { return fi.f.written }
+func (fi uploadInfo) Mode() fs.FileMode  { return 0o644 }
+func (fi uploadInfo) ModTime() time.Time { return fi.f.started }
+func (fi uploadInfo) IsDir() bool        { return false }
+func (fi uploadInfo) Sys() any           { return nil }
+
+type dirInfo string
+
+func (di dirInfo) Name() string       { return string(di) }
+func (di dirInfo) Size() int64        { return 0 }
+func (di dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o755 }
+func (di dirInfo) ModTime() time.Time { return time.Time{} }
+func (di dirInfo) IsDir() bool        { return true }
+func (di dirInfo) Sys() any           { return nil }
@@ -0,0 +1,61 @@
+package metadataservice
+
+import (
+	"sort"
+	"strings"
+
+	pb "dfs/internal/pb/metadata"
+)
+
+// matchesFilter reports whether meta has every tag, user metadata pair and
+// the content type that req asks for.
+func matchesFilter(meta *FileMetadata, req *pb.ListFilesRequest) bool {
+	for _, tag := range req.Tags {
+		i := sort.SearchStrings(meta.Tags, tag)
+		if i == len(meta.Tags) || meta.Tags[i] != tag {
+			return false
+		}
+	}
+	for k, v := range req.UserMetadata {
+		if got, ok := meta.UserMetadata[k]; !ok || got != v {
+			return false
+		}
+	}
+	if req.ContentType != "" && !matchesContentType(meta.ContentType, req.ContentType) {
+		return false
+	}
+	return true
+}
+
+// matchesContentType reports whether contentType is want or, if want ends in
+// "/", one of its subtypes. Parameters such as "; charset=utf-8" are ignored.
+func matchesContentType(contentType, want string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	want = strings.ToLower(want)
+	if strings.HasSuffix(want, "/") {
+		return strings.HasPrefix(contentType, want)
+	}
+	return contentType == want
+}
+
+// normalizeTags returns tags sorted, without duplicates or empty tags.
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	result := sorted[:0]
+	for _, tag := range sorted {
+		if tag != "" && (len(result) == 0 || result[len(result)-1] != tag) {
+			result = append(result, tag)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
@@ -0,0 +1,496 @@
+package metadataservice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltFileName is the name of the database file OpenStore creates for
+// EngineBolt inside the metadata directory.
+const BoltFileName = "metadata.db"
+
+var (
+	filesBucket  = []byte("files")
+	byNameBucket = []byte("by_name")
+	byPathBucket = []byte("by_path")
+	byNodeBucket = []byte("by_node")
+	dirsBucket   = []byte("directories")
+	snapsBucket  = []byte("snapshots")
+	refsBucket   = []byte("chunk_refs")
+)
+
+// BoltStore keeps metadata records in a bbolt database. Records are stored as
+// JSON in the files bucket, keyed by file ID. Each secondary index bucket maps
+// "<value>\x00<file ID>" to an empty value, so lookups are prefix scans and
+// every index update happens in the same transaction as the record itself.
+// The chunk_refs bucket maps each chunk ID to its reference count, as a
+// big-endian int64, and is kept up to date the same way.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{filesBucket, byNameBucket, byPathBucket, byNodeBucket, dirsBucket, snapsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(refsBucket) != nil {
+			return nil
+		}
+		// Databases written before reference counts were kept get them
+		// counted once.
+		if _, err := tx.CreateBucket(refsBucket); err != nil {
+			return err
+		}
+		return countRefsTx(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStore) Save(metadata *FileMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return saveRecord(tx, metadata, data)
+	})
+}
+
+func (b *BoltStore) SaveAll(records []*FileMetadata) error {
+	encoded := make([][]byte, len(records))
+	for i, metadata := range records {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		encoded[i] = data
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for i, metadata := range records {
+			if err := saveRecord(tx, metadata, encoded[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// saveRecord stores metadata, encoded as data, with its index entries and
+// chunk references.
+func saveRecord(tx *bolt.Tx, metadata *FileMetadata, data []byte) error {
+	refs := make(chunkRefs)
+	old, err := getRecord(tx, metadata.FileID)
+	switch {
+	case err == nil:
+		if err := updateIndexes(tx, old, false); err != nil {
+			return err
+		}
+		refs.addFile(old, -1)
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if err := tx.Bucket(filesBucket).Put([]byte(metadata.FileID), data); err != nil {
+		return err
+	}
+	if err := updateIndexes(tx, metadata, true); err != nil {
+		return err
+	}
+	refs.addFile(metadata, 1)
+	return updateRefs(tx, refs)
+}
+
+func (b *BoltStore) Get(fileID string) (*FileMetadata, error) {
+	var metadata *FileMetadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		metadata, err = getRecord(tx, fileID)
+		return err
+	})
+	return metadata, err
+}
+
+func (b *BoltStore) Delete(fileID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		old, err := getRecord(tx, fileID)
+		if err != nil {
+			return err
+		}
+		if err := updateIndexes(tx, old, false); err != nil {
+			return err
+		}
+		if err := tx.Bucket(filesBucket).Delete([]byte(fileID)); err != nil {
+			return err
+		}
+		refs := make(chunkRefs)
+		refs.addFile(old, -1)
+		return updateRefs(tx, refs)
+	})
+}
+
+func (b *BoltStore) List() ([]*FileMetadata, error) {
+	var metadataList []*FileMetadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).ForEach(func(k, v []byte) error {
+			var metadata FileMetadata
+			if err := json.Unmarshal(v, &metadata); err != nil {
+				return fmt.Errorf("failed to unmarshal metadata for file %s: %w", k, err)
+			}
+			metadataList = append(metadataList, &metadata)
+			return nil
+		})
+	})
+	return metadataList, err
+}
+
+func (b *BoltStore) FindByName(name string) ([]*FileMetadata, error) {
+	return b.find(byNameBucket, indexKey(name, ""))
+}
+
+// FindByPathPrefix returns every file whose path starts with prefix, in path
+// order.
+func (b *BoltStore) FindByPathPrefix(prefix string) ([]*FileMetadata, error) {
+	return b.find(byPathBucket, []byte(prefix))
+}
+
+func (b *BoltStore) FindByNode(nodeID string) ([]*FileMetadata, error) {
+	return b.find(byNodeBucket, indexKey(nodeID, ""))
+}
+
+func (b *BoltStore) find(bucket, prefix []byte) ([]*FileMetadata, error) {
+	var metadataList []*FileMetadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			i := bytes.LastIndexByte(k, 0)
+			if i < 0 {
+				continue
+			}
+			metadata, err := getRecord(tx, string(k[i+1:]))
+			if err != nil {
+				return fmt.Errorf("index %s points at missing file %s: %w", bucket, k[i+1:], err)
+			}
+			metadataList = append(metadataList, metadata)
+		}
+		return nil
+	})
+	return metadataList, err
+}
+
+func getRecord(tx *bolt.Tx, fileID string) (*FileMetadata, error) {
+	data := tx.Bucket(filesBucket).Get([]byte(fileID))
+	if data == nil {
+		return nil, fmt.Errorf("file %s: %w", fileID, fs.ErrNotExist)
+	}
+	var metadata FileMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+	return &metadata, nil
+}
+
+type indexEntry struct {
+	bucket []byte
+	value  string
+}
+
+func (b *BoltStore) SaveDirectory(dir *Directory) error {
+	data, err := json.Marshal(dir)
+	if err != nil {
+		return fmt.Errorf("failed to marshal directory: %w", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(dirsBucket).Put([]byte(dir.Path), data)
+	})
+}
+
+func (b *BoltStore) GetDirectory(path string) (*Directory, error) {
+	var dir Directory
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(dirsBucket).Get([]byte(path))
+		if data == nil {
+			return fmt.Errorf("directory %s: %w", path, fs.ErrNotExist)
+		}
+		return json.Unmarshal(data, &dir)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dir, nil
+}
+
+func (b *BoltStore) DeleteDirectory(path string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dirsBucket)
+		if bucket.Get([]byte(path)) == nil {
+			return fmt.Errorf("directory %s: %w", path, fs.ErrNotExist)
+		}
+		return bucket.Delete([]byte(path))
+	})
+}
+
+func (b *BoltStore) ListDirectories() ([]*Directory, error) {
+	var dirs []*Directory
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(dirsBucket).ForEach(func(k, v []byte) error {
+			var dir Directory
+			if err := json.Unmarshal(v, &dir); err != nil {
+				return fmt.Errorf("failed to unmarshal directory %s: %w", k, err)
+			}
+			dirs = append(dirs, &dir)
+			return nil
+		})
+	})
+	return dirs, err
+}
+
+func (b *BoltStore) SaveSnapshot(snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		refs := make(chunkRefs)
+		old, err := getSnapshot(tx, snap.ID)
+		switch {
+		case err == nil:
+			refs.addSnapshot(old, -1)
+		case !errors.Is(err, fs.ErrNotExist):
+			return err
+		}
+		if err := tx.Bucket(snapsBucket).Put([]byte(snap.ID), data); err != nil {
+			return err
+		}
+		refs.addSnapshot(snap, 1)
+		return updateRefs(tx, refs)
+	})
+}
+
+func (b *BoltStore) GetSnapshot(id string) (*Snapshot, error) {
+	var snap *Snapshot
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		snap, err = getSnapshot(tx, id)
+		return err
+	})
+	return snap, err
+}
+
+func getSnapshot(tx *bolt.Tx, id string) (*Snapshot, error) {
+	data := tx.Bucket(snapsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, fmt.Errorf("snapshot %s: %w", id, fs.ErrNotExist)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+func (b *BoltStore) DeleteSnapshot(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		old, err := getSnapshot(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(snapsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		refs := make(chunkRefs)
+		refs.addSnapshot(old, -1)
+		return updateRefs(tx, refs)
+	})
+}
+
+func (b *BoltStore) ListSnapshots() ([]*Snapshot, error) {
+	var snaps []*Snapshot
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapsBucket).ForEach(func(k, v []byte) error {
+			var snap Snapshot
+			if err := json.Unmarshal(v, &snap); err != nil {
+				return fmt.Errorf("failed to unmarshal snapshot %s: %w", k, err)
+			}
+			snaps = append(snaps, &snap)
+			return nil
+		})
+	})
+	return snaps, err
+}
+
+// Replace swaps the contents of the database for records, dirs and snaps in
+// a single transaction.
+func (b *BoltStore) Replace(records []*FileMetadata, dirs []*Directory, snaps []*Snapshot) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{filesBucket, byNameBucket, byPathBucket, byNodeBucket, dirsBucket, snapsBucket, refsBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		for _, metadata := range records {
+			data, err := json.Marshal(metadata)
+			if err != nil {
+				return fmt.Errorf("failed to marshal metadata: %w", err)
+			}
+			if err := tx.Bucket(filesBucket).Put([]byte(metadata.FileID), data); err != nil {
+				return err
+			}
+			if err := updateIndexes(tx, metadata, true); err != nil {
+				return err
+			}
+		}
+		for _, dir := range dirs {
+			data, err := json.Marshal(dir)
+			if err != nil {
+				return fmt.Errorf("failed to marshal directory: %w", err)
+			}
+			if err := tx.Bucket(dirsBucket).Put([]byte(dir.Path), data); err != nil {
+				return err
+			}
+		}
+		for _, snap := range snaps {
+			data, err := json.Marshal(snap)
+			if err != nil {
+				return fmt.Errorf("failed to marshal snapshot: %w", err)
+			}
+			if err := tx.Bucket(snapsBucket).Put([]byte(snap.ID), data); err != nil {
+				return err
+			}
+		}
+		return countRefsTx(tx)
+	})
+}
+
+func (b *BoltStore) ChunkRefs(chunkIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(chunkIDs))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for _, id := range chunkIDs {
+			counts[id] = getRef(tx, id)
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// countRefsTx fills the empty chunk_refs bucket from the files and
+// snapshots buckets.
+func countRefsTx(tx *bolt.Tx) error {
+	refs := make(chunkRefs)
+	err := tx.Bucket(filesBucket).ForEach(func(k, v []byte) error {
+		var metadata FileMetadata
+		if err := json.Unmarshal(v, &metadata); err != nil {
+			return fmt.Errorf("failed to unmarshal metadata for file %s: %w", k, err)
+		}
+		refs.addFile(&metadata, 1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(snapsBucket).ForEach(func(k, v []byte) error {
+		var snap Snapshot
+		if err := json.Unmarshal(v, &snap); err != nil {
+			return fmt.Errorf("failed to unmarshal snapshot %s: %w", k, err)
+		}
+		refs.addSnapshot(&snap, 1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return updateRefs(tx, refs)
+}
+
+func getRef(tx *bolt.Tx, chunkID string) int64 {
+	v := tx.Bucket(refsBucket).Get([]byte(chunkID))
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+// updateRefs adds the counts in delta to the chunk_refs bucket, dropping
+// chunks whose count reaches zero.
+func updateRefs(tx *bolt.Tx, delta chunkRefs) error {
+	bucket := tx.Bucket(refsBucket)
+	for id, d := range delta {
+		n := getRef(tx, id) + d
+		var err error
+		if n > 0 {
+			err = bucket.Put([]byte(id), binary.BigEndian.AppendUint64(nil, uint64(n)))
+		} else {
+			err = bucket.Delete([]byte(id))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexEntries returns the value metadata is indexed under in each index.
+func indexEntries(metadata *FileMetadata) []indexEntry {
+	entries := []indexEntry{
+		{byNameBucket, metadata.FileName},
+		{byPathBucket, metadata.Path},
+	}
+	seen := make(map[string]bool)
+	for _, chunk := range allChunks(metadata) {
+		for _, nodeID := range chunk.NodeIDs {
+			if !seen[nodeID] {
+				seen[nodeID] = true
+				entries = append(entries, indexEntry{byNodeBucket, nodeID})
+			}
+		}
+	}
+	return entries
+}
+
+// updateIndexes adds (or, with add false, removes) every index entry for
+// metadata.
+func updateIndexes(tx *bolt.Tx, metadata *FileMetadata, add bool) error {
+	for _, e := range indexEntries(metadata) {
+		key := indexKey(e.value, metadata.FileID)
+		var err error
+		if add {
+			err = tx.Bucket(e.bucket).Put(key, []byte{})
+		} else {
+			err = tx.Bucket(e.bucket).Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexKey(value, fileID string) []byte {
+	key := make([]byte, 0, len(value)+1+len(fileID))
+	key = append(key, value...)
+	key = append(key, 0)
+	return append(key, fileID...)
+}
@@ -0,0 +1,124 @@
+package metadataservice
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"path"
+
+	pb "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) SetDirectory(ctx context.Context, req *pb.SetDirectoryRequest) (*pb.SetDirectoryResponse, error) {
+	dir := directoryFromProto(req.Directory)
+	if dir.Path == "" || dir.Path[0] != '/' || path.Clean(dir.Path) != dir.Path {
+		return nil, status.Errorf(codes.InvalidArgument, "directory path %q is not a clean absolute path", dir.Path)
+	}
+
+	var err error
+	if dir.Versioning == nil && dir.Permissions == nil {
+		log.Printf("Removing settings for directory: %s", dir.Path)
+		err = s.store.DeleteDirectory(dir.Path)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		log.Printf("Saving settings for directory: %s", dir.Path)
+		err = s.store.SaveDirectory(dir)
+	}
+	if err != nil {
+		log.Printf("Failed to save settings for directory %s: %v", dir.Path, err)
+		return nil, storeError(err, codes.Internal, "failed to save directory")
+	}
+	return &pb.SetDirectoryResponse{Success: true}, nil
+}
+
+func (s *Server) GetDirectory(ctx context.Context, req *pb.GetDirectoryRequest) (*pb.GetDirectoryResponse, error) {
+	p := path.Clean("/" + req.Path)
+	// found collects the settings of the directory and, when inheriting,
+	// those it lacks from its ancestors.
+	var found *Directory
+	for {
+		dir, err := s.store.GetDirectory(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to read settings for directory %s: %v", p, err)
+			return nil, storeError(err, codes.Internal, "failed to read directory")
+		}
+		if err == nil {
+			if found == nil {
+				found = &Directory{Path: dir.Path}
+			}
+			if found.Versioning == nil {
+				found.Versioning = dir.Versioning
+			}
+			if found.Permissions == nil {
+				found.Permissions = dir.Permissions
+			}
+		}
+		complete := found != nil && found.Versioning != nil && found.Permissions != nil
+		if !req.Inherit || complete || p == "/" {
+			break
+		}
+		p = path.Dir(p)
+	}
+	if found == nil {
+		return nil, status.Errorf(codes.NotFound, "no settings for directory %s", req.Path)
+	}
+	return &pb.GetDirectoryResponse{Directory: directoryToProto(found)}, nil
+}
+
+func directoryFromProto(d *pb.Directory) *Directory {
+	return &Directory{
+		Path:        d.GetPath(),
+		Versioning:  policyFromProto(d.GetVersioning()),
+		Permissions: permissionsFromProto(d.GetPermissions()),
+	}
+}
+
+func directoryToProto(dir *Directory) *pb.Directory {
+	return &pb.Directory{
+		Path:        dir.Path,
+		Versioning:  policyToProto(dir.Versioning),
+		Permissions: permissionsToProto(dir.Permissions),
+	}
+}
+
+func policyFromProto(p *pb.VersioningPolicy) *VersioningPolicy {
+	if p == nil {
+		return nil
+	}
+	return &VersioningPolicy{
+		Enabled:      p.Enabled,
+		KeepVersions: int(p.KeepVersions),
+		KeepDays:     int(p.KeepDays),
+	}
+}
+
+func policyToProto(p *VersioningPolicy) *pb.VersioningPolicy {
+	if p == nil {
+		return nil
+	}
+	return &pb.VersioningPolicy{
+		Enabled:      p.Enabled,
+		KeepVersions: int32(p.KeepVersions),
+		KeepDays:     int32(p.KeepDays),
+	}
+}
+
+func permissionsFromProto(p *pb.Permissions) *Permissions {
+	if p == nil {
+		return nil
+	}
+	return &Permissions{Owner: p.Owner, Group: p.Group, Mode: p.Mode}
+}
+
+func permissionsToProto(p *Permissions) *pb.Permissions {
+	if p == nil {
+		return nil
+	}
+	return &pb.Permissions{Owner: p.Owner, Group: p.Group, Mode: p.Mode}
+}
@@ -0,0 +1,224 @@
+package metadataservice
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultEventRetention is the number of recent change events kept for
+// watchers that resume from an earlier sequence number.
+const DefaultEventRetention = 10000
+
+// ErrEventsCompacted is returned when a watcher asks to resume from a
+// sequence number whose successors are no longer retained.
+var ErrEventsCompacted = errors.New("requested events are no longer retained")
+
+// eventLog is a bounded, ordered log of namespace changes.
+//
+// Stores with a durable log of their own (WALStore and RaftStore) record an
+// event as they apply each change, numbered with the change's position in
+// their log, and keep the retained events in their snapshots. Their sequence
+// numbers survive restarts and, for RaftStore, are the same on every member,
+// so watchers can resume after a restart or leader change.
+//
+// For other stores the server publishes events itself into a log that is
+// only kept in memory. Its sequence numbers start at the service's start
+// time in nanoseconds, so they keep increasing across restarts and a watcher
+// resuming from before a restart gets ErrEventsCompacted instead of silently
+// missing events.
+type eventLog struct {
+	retention int
+
+	mu     sync.Mutex
+	events []*pb.WatchEvent
+	// Every event after first and up to last is retained. Sequence numbers
+	// from a store's log may skip changes that are not file events.
+	first  uint64
+	last   uint64
+	notify chan struct{}
+}
+
+func newEventLog(retention int) *eventLog {
+	now := uint64(time.Now().UnixNano())
+	return &eventLog{
+		retention: retention,
+		first:     now,
+		last:      now,
+		notify:    make(chan struct{}),
+	}
+}
+
+// newStoreEventLog returns an empty log for a store whose log is at seq.
+func newStoreEventLog(retention int, seq uint64) *eventLog {
+	return &eventLog{
+		retention: retention,
+		first:     seq,
+		last:      seq,
+		notify:    make(chan struct{}),
+	}
+}
+
+// publish appends event with the next sequence number.
+func (l *eventLog) publish(event *pb.WatchEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.append(l.last+1, event)
+}
+
+// record notes that a store applied the change at seq, which produced
+// events; nil events stand for changes that were not to a file. Changes a
+// store replays at or before the last recorded one are ignored.
+func (l *eventLog) record(seq uint64, events ...*pb.WatchEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq <= l.last {
+		return
+	}
+	l.last = seq
+	for _, event := range events {
+		if event != nil {
+			l.append(seq, event)
+		}
+	}
+}
+
+// append adds event at seq and wakes watchers. The caller must hold l.mu.
+func (l *eventLog) append(seq uint64, event *pb.WatchEvent) {
+	event.Sequence = seq
+	l.last = seq
+	l.events = append(l.events, event)
+	if len(l.events) > l.retention {
+		drop := len(l.events) - l.retention
+		l.first = l.events[drop-1].Sequence
+		l.events = append([]*pb.WatchEvent(nil), l.events[drop:]...)
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+func newEvent(op pb.Operation, meta *FileMetadata, at time.Time) *pb.WatchEvent {
+	return &pb.WatchEvent{
+		FileId:     meta.FileID,
+		Operation:  op,
+		Generation: meta.Generation,
+		Timestamp:  at.UTC().Format(time.RFC3339Nano),
+		Path:       meta.Path,
+	}
+}
+
+// parseEventTime parses the time a store logged a change at, which is empty
+// for changes logged before events were kept.
+func parseEventTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// changeEvent returns the event for replacing prev, which is nil for a new
+// file, with meta. Moving a file into or out of the trash looks like a
+// delete or create to watchers.
+func changeEvent(prev, meta *FileMetadata, at time.Time) *pb.WatchEvent {
+	switch {
+	case meta.DeletedAt != "":
+		return newEvent(pb.Operation_DELETED, meta, at)
+	case prev == nil || prev.DeletedAt != "":
+		return newEvent(pb.Operation_CREATED, meta, at)
+	default:
+		return newEvent(pb.Operation_UPDATED, meta, at)
+	}
+}
+
+// lastSequence returns the sequence number of the most recent event.
+func (l *eventLog) lastSequence() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// since returns the retained events after seq, and a channel that is closed
+// when the next event is published.
+func (l *eventLog) since(seq uint64) ([]*pb.WatchEvent, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq > l.last || seq < l.first {
+		return nil, nil, ErrEventsCompacted
+	}
+	i := sort.Search(len(l.events), func(i int) bool { return l.events[i].Sequence > seq })
+	return l.events[i:], l.notify, nil
+}
+
+// eventSnapshot is the part of an event log a store keeps in its snapshots.
+type eventSnapshot struct {
+	// After is the sequence number after which every event is retained.
+	After  uint64           `json:"after"`
+	Events []*pb.WatchEvent `json:"events,omitempty"`
+}
+
+func (l *eventLog) snapshot() eventSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return eventSnapshot{After: l.first, Events: append([]*pb.WatchEvent(nil), l.events...)}
+}
+
+// restore replaces the log with the events of a snapshot taken when the
+// store's log was at seq.
+func (l *eventLog) restore(snap eventSnapshot, seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.first = min(snap.After, seq)
+	l.last = seq
+	l.events = nil
+	for _, event := range snap.Events {
+		if event.Sequence > l.first && event.Sequence <= seq {
+			l.events = append(l.events, event)
+		}
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// eventSource is implemented by stores that record events in their own
+// eventLog as they apply changes.
+type eventSource interface {
+	watchEvents() *eventLog
+}
+
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.MetadataService_WatchServer) error {
+	cursor := req.AfterSequence
+	if cursor == 0 {
+		cursor = s.events.lastSequence()
+	}
+	log.Printf("Starting watch after sequence %d with prefix %q", cursor, req.PathPrefix)
+
+	for {
+		events, wait, err := s.events.since(cursor)
+		if err != nil {
+			return status.Errorf(codes.OutOfRange, "cannot resume after sequence %d: %v", cursor, err)
+		}
+		for _, event := range events {
+			if strings.HasPrefix(event.Path, req.PathPrefix) {
+				if err := stream.Send(event); err != nil {
+					return err
+				}
+			}
+			cursor = event.Sequence
+		}
+
+		select {
+		case <-wait:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
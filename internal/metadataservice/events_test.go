@@ -0,0 +1,118 @@
+package metadataservice
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+)
+
+func wantEvents(t *testing.T, l *eventLog, after uint64, want ...pb.Operation) []*pb.WatchEvent {
+	t.Helper()
+	events, _, err := l.since(after)
+	if err != nil {
+		t.Fatalf("since(%d): %v", after, err)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("since(%d) returned %d events, want %d", after, len(events), len(want))
+	}
+	for i, event := range events {
+		if event.Operation != want[i] {
+			t.Errorf("event %d is %v, want %v", i, event.Operation, want[i])
+		}
+		if event.Sequence <= after || (i > 0 && event.Sequence < events[i-1].Sequence) {
+			t.Errorf("event %d has sequence %d out of order", i, event.Sequence)
+		}
+	}
+	return events
+}
+
+func TestEventLogRetention(t *testing.T) {
+	l := newStoreEventLog(2, 0)
+	l.record(1, newEvent(pb.Operation_CREATED, &FileMetadata{FileID: "a"}, time.Now()))
+	l.record(2, nil)
+	l.record(3, newEvent(pb.Operation_UPDATED, &FileMetadata{FileID: "a"}, time.Now()))
+	l.record(4, newEvent(pb.Operation_DELETED, &FileMetadata{FileID: "a"}, time.Now()))
+	l.record(3, newEvent(pb.Operation_CREATED, &FileMetadata{FileID: "b"}, time.Now()))
+
+	wantEvents(t, l, 1, pb.Operation_UPDATED, pb.Operation_DELETED)
+	wantEvents(t, l, 2, pb.Operation_UPDATED, pb.Operation_DELETED)
+	wantEvents(t, l, 4)
+	if _, _, err := l.since(0); !errors.Is(err, ErrEventsCompacted) {
+		t.Errorf("since(0) = %v, want ErrEventsCompacted", err)
+	}
+	if _, _, err := l.since(5); !errors.Is(err, ErrEventsCompacted) {
+		t.Errorf("since(5) = %v, want ErrEventsCompacted", err)
+	}
+}
+
+func TestWALStoreEventsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 3)
+	s := NewServer(w)
+	mustSave(t, w, "a", "/a")
+	if err := w.SaveDirectory(&Directory{Path: "/dir"}); err != nil {
+		t.Fatal(err)
+	}
+	after := s.events.lastSequence()
+	mustSave(t, w, "a", "/b") // the snapshot is written here
+	mustSave(t, w, "c", "/c")
+	if err := w.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w = openWALStore(t, dir, 3)
+	s = NewServer(w)
+	events := wantEvents(t, s.events, after, pb.Operation_UPDATED, pb.Operation_CREATED, pb.Operation_DELETED)
+	if events[0].Path != "/b" || events[2].FileId != "a" {
+		t.Errorf("unexpected events after restart: %v", events)
+	}
+	wantEvents(t, s.events, 0, pb.Operation_CREATED, pb.Operation_UPDATED, pb.Operation_CREATED, pb.Operation_DELETED)
+
+	// Sequence numbers continue from the log.
+	mustSave(t, w, "d", "/d")
+	if got := wantEvents(t, s.events, events[2].Sequence, pb.Operation_CREATED); got[0].Sequence != w.seq {
+		t.Errorf("event sequence %d, want WAL sequence %d", got[0].Sequence, w.seq)
+	}
+}
+
+func TestRaftFSMEventsUseLogIndex(t *testing.T) {
+	newFSM := func() *raftFSM {
+		store, err := NewDiskStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &raftFSM{store: store, events: newStoreEventLog(DefaultEventRetention, 0)}
+	}
+	leader := newFSM()
+	applyCommand(t, leader, 3, raftCommand{Op: opSave, Metadata: &FileMetadata{FileID: "a", Path: "/a", Generation: 1}})
+	applyCommand(t, leader, 4, raftCommand{Op: opSaveDirectory, Directory: &Directory{Path: "/d"}})
+	applyCommand(t, leader, 6, raftCommand{Op: opSave, Metadata: &FileMetadata{FileID: "a", Path: "/a", Generation: 2}})
+
+	events := wantEvents(t, leader.events, 0, pb.Operation_CREATED, pb.Operation_UPDATED)
+	if events[0].Sequence != 3 || events[1].Sequence != 6 {
+		t.Fatalf("event sequences %d and %d, want the raft indexes 3 and 6", events[0].Sequence, events[1].Sequence)
+	}
+
+	// A member restored from the leader's snapshot serves the same events.
+	snap, err := leader.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink memorySink
+	if err := snap.Persist(&sink); err != nil {
+		t.Fatal(err)
+	}
+	follower := newFSM()
+	if err := follower.Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	applyCommand(t, follower, 7, raftCommand{Op: opDelete, FileID: "a"})
+	events = wantEvents(t, follower.events, 3, pb.Operation_UPDATED, pb.Operation_DELETED)
+	if events[1].Sequence != 7 || events[1].Generation != 2 {
+		t.Errorf("delete event = %v, want sequence 7 at generation 2", events[1])
+	}
+}
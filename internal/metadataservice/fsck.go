@@ -0,0 +1,281 @@
+package metadataservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dfs/internal/chunk"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FsckReport is the result of an offline consistency check.
+type FsckReport struct {
+	Records  int
+	Problems []string
+}
+
+func (r *FsckReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// Fsck checks the on-disk state of a metadata store without modifying it.
+// It verifies the engine's own structures (JSON files, bolt indexes, or WAL
+// checksums and snapshot) and then every record: required fields, clean
+// absolute paths, parseable timestamps, chunks with at least one replica,
+// valid chunk IDs, and chunks shared between files (by copies and
+// concatenations) agreeing on their size. The store must not be in use by a
+// running metadata service.
+func Fsck(engine, dir string) (*FsckReport, error) {
+	report := &FsckReport{}
+	var records []*FileMetadata
+	var err error
+
+	switch engine {
+	case "", EngineJSON:
+		records, err = fsckJSON(dir, report)
+	case EngineBolt:
+		records, err = fsckBolt(filepath.Join(dir, BoltFileName), report)
+	case EngineWAL:
+		records, err = fsckWAL(dir, report)
+	default:
+		return nil, fmt.Errorf("unknown metadata engine %q", engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].FileID < records[j].FileID })
+	owners := make(map[string]chunkOwner)
+	for _, metadata := range records {
+		fsckRecord(metadata, owners, report)
+	}
+	report.Records = len(records)
+	return report, nil
+}
+
+// chunkOwner records the first file fsck saw referring to a chunk.
+type chunkOwner struct {
+	fileID string
+	size   int64
+}
+
+func fsckRecord(m *FileMetadata, owners map[string]chunkOwner, report *FsckReport) {
+	if m.FileID == "" {
+		report.problem("record for %q has no file ID", m.FileName)
+		return
+	}
+	if m.Path != "" && (!strings.HasPrefix(m.Path, "/") || path.Clean(m.Path) != m.Path) {
+		report.problem("file %s: path %q is not a clean absolute path", m.FileID, m.Path)
+	}
+	if m.FileSize < 0 {
+		report.problem("file %s: negative size %d", m.FileID, m.FileSize)
+	}
+	if m.FileSize > 0 && len(m.Chunks) == 0 {
+		report.problem("file %s: size %d but no chunks", m.FileID, m.FileSize)
+	}
+	var sized int64
+	for _, c := range m.Chunks {
+		if c.Size <= 0 {
+			sized = -1
+			break
+		}
+		sized += c.Size
+	}
+	if sized >= 0 && len(m.Chunks) > 0 && sized != m.FileSize {
+		report.problem("file %s: size %d but chunks add up to %d bytes", m.FileID, m.FileSize, sized)
+	}
+	for _, ts := range []string{m.CreatedAt, m.UpdatedAt} {
+		if _, err := time.Parse(time.RFC3339, ts); err != nil {
+			report.problem("file %s: bad timestamp %q", m.FileID, ts)
+		}
+	}
+	for i, c := range allChunks(m) {
+		if err := chunk.ValidateID(c.ChunkID); err != nil {
+			report.problem("file %s: chunk %d: %v", m.FileID, i, err)
+		}
+		if len(c.NodeIDs) == 0 {
+			report.problem("file %s: chunk %s has no replicas", m.FileID, c.ChunkID)
+		}
+		owner, ok := owners[c.ChunkID]
+		switch {
+		case !ok:
+			owners[c.ChunkID] = chunkOwner{fileID: m.FileID, size: c.Size}
+		case c.Size > 0 && owner.size > 0 && c.Size != owner.size:
+			report.problem("file %s: chunk %s has size %d but file %s records %d", m.FileID, c.ChunkID, c.Size, owner.fileID, owner.size)
+		}
+	}
+}
+
+func fsckJSON(dir string, report *FsckReport) ([]*FileMetadata, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+	var records []*FileMetadata
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == replaceDirName {
+			report.problem("%s: interrupted snapshot restore (it will be finished on next start)", name)
+			continue
+		}
+		if strings.HasSuffix(name, ".json.tmp") {
+			report.problem("%s: leftover temporary file from an interrupted write", name)
+			continue
+		}
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			report.problem("%s: %v", name, err)
+			continue
+		}
+		var metadata FileMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			report.problem("%s: %v", name, err)
+			continue
+		}
+		if want := strings.TrimSuffix(name, ".json"); metadata.FileID != want {
+			report.problem("%s: contains file ID %q", name, metadata.FileID)
+		}
+		records = append(records, &metadata)
+	}
+	return records, nil
+}
+
+func fsckBolt(dbPath string, report *FsckReport) ([]*FileMetadata, error) {
+	db, err := bolt.Open(dbPath, 0644, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+	defer db.Close()
+
+	var records []*FileMetadata
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{filesBucket, byNameBucket, byPathBucket, byNodeBucket, dirsBucket, snapsBucket} {
+			if tx.Bucket(name) == nil {
+				return fmt.Errorf("bucket %s is missing", name)
+			}
+		}
+
+		expected := make(map[string]bool)
+		err := tx.Bucket(filesBucket).ForEach(func(k, v []byte) error {
+			var metadata FileMetadata
+			if err := json.Unmarshal(v, &metadata); err != nil {
+				report.problem("record %s: %v", k, err)
+				return nil
+			}
+			if metadata.FileID != string(k) {
+				report.problem("record %s: contains file ID %q", k, metadata.FileID)
+			}
+			records = append(records, &metadata)
+			for _, e := range indexEntries(&metadata) {
+				key := indexKey(e.value, metadata.FileID)
+				expected[string(e.bucket)+"/"+string(key)] = true
+				if tx.Bucket(e.bucket).Get(key) == nil {
+					report.problem("record %s: missing %s index entry %q", k, e.bucket, e.value)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range [][]byte{byNameBucket, byPathBucket, byNodeBucket} {
+			err := tx.Bucket(name).ForEach(func(k, _ []byte) error {
+				if !expected[string(name)+"/"+string(k)] {
+					i := bytes.LastIndexByte(k, 0)
+					report.problem("stale %s index entry %q", name, k[i+1:])
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func fsckWAL(dir string, report *FsckReport) ([]*FileMetadata, error) {
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		report.problem("%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName+".tmp")); err == nil {
+		report.problem("%s.tmp: leftover temporary file from an interrupted snapshot", snapshotFileName)
+	}
+
+	files := make(map[string]*FileMetadata)
+	for _, metadata := range snap.Records {
+		files[metadata.FileID] = metadata
+	}
+
+	f, err := os.Open(filepath.Join(dir, walFileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open WAL: %w", err)
+	}
+	if f != nil {
+		defer f.Close()
+		seq := snap.Seq
+		err := scanWAL(f, func(rec walRecord, end int64) error {
+			if rec.Seq <= snap.Seq {
+				return nil
+			}
+			if rec.Seq != seq+1 {
+				report.problem("WAL record %d follows record %d", rec.Seq, seq)
+			}
+			seq = rec.Seq
+			switch rec.Op {
+			case opSave:
+				if rec.Metadata == nil {
+					report.problem("WAL record %d: save without metadata", rec.Seq)
+					return nil
+				}
+				files[rec.Metadata.FileID] = rec.Metadata
+			case opSaveAll:
+				for _, metadata := range rec.Records {
+					if metadata == nil {
+						report.problem("WAL record %d: save without metadata", rec.Seq)
+						continue
+					}
+					files[metadata.FileID] = metadata
+				}
+			case opDelete:
+				delete(files, rec.FileID)
+			case opSaveLease:
+				if rec.Lease == nil || rec.FileID == "" {
+					report.problem("WAL record %d: lease without a file ID or lease", rec.Seq)
+				}
+			case opSaveDirectory, opDeleteDirectory, opSaveSnapshot, opDeleteSnapshot, opDeleteLease:
+			default:
+				report.problem("WAL record %d: unknown operation %q", rec.Seq, rec.Op)
+			}
+			return nil
+		})
+		var corrupt *walCorruptError
+		if errors.As(err, &corrupt) {
+			report.problem("%v (the tail will be discarded on next start)", err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	records := make([]*FileMetadata, 0, len(files))
+	for _, metadata := range files {
+		records = append(records, metadata)
+	}
+	return records, nil
+}
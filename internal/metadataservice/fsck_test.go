@@ -0,0 +1,39 @@
+package metadataservice
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFsckWALWithLeases(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := w.Save(&FileMetadata{FileID: "a", FileName: "a", Path: "/a", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.saveLease("a", writeLease{ID: "1", Expires: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.deleteLease("a"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	report, err := Fsck(EngineWAL, dir)
+	if err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+	if len(report.Problems) != 0 || report.Records != 1 {
+		t.Fatalf("Fsck found %d records and problems %q, want 1 record and none", report.Records, report.Problems)
+	}
+
+	w = openWALStore(t, dir, 0)
+	if err := w.commit(walRecord{Op: opSaveLease, FileID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if report, err := Fsck(EngineWAL, dir); err != nil || len(report.Problems) != 1 {
+		t.Fatalf("Fsck of a lease record without a lease = %v, %v; want one problem", report, err)
+	}
+}
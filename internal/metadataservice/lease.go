@@ -0,0 +1,149 @@
+package metadataservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultWriteLeaseTTL is how long a write lease lasts when the caller does
+// not ask for a specific duration.
+const DefaultWriteLeaseTTL = time.Minute
+
+// maxWriteLeaseTTL caps requested lease durations, so a writer that dies
+// cannot block a file for long.
+const maxWriteLeaseTTL = 10 * time.Minute
+
+type writeLease struct {
+	ID      string    `json:"id"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l writeLease) expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+// leaseStore keeps write leases. WALStore and RaftStore implement it by
+// logging leases along with metadata, so that leases survive a restart and,
+// for RaftStore, hold on a new leader. Expired leases may be kept until the
+// store next writes a snapshot.
+type leaseStore interface {
+	getLease(fileID string) (writeLease, bool, error)
+	saveLease(fileID string, lease writeLease) error
+	deleteLease(fileID string) error
+}
+
+// memoryLeases keeps leases for stores that cannot, so they are lost when
+// the service restarts. The server's mu guards it.
+type memoryLeases map[string]writeLease
+
+func (m memoryLeases) getLease(fileID string) (writeLease, bool, error) {
+	lease, ok := m[fileID]
+	if ok && lease.expired() {
+		delete(m, fileID)
+		return writeLease{}, false, nil
+	}
+	return lease, ok, nil
+}
+
+func (m memoryLeases) saveLease(fileID string, lease writeLease) error {
+	m[fileID] = lease
+	return nil
+}
+
+func (m memoryLeases) deleteLease(fileID string) error {
+	delete(m, fileID)
+	return nil
+}
+
+func (s *Server) AcquireWriteLease(ctx context.Context, req *pb.AcquireWriteLeaseRequest) (*pb.AcquireWriteLeaseResponse, error) {
+	ttl := time.Duration(req.TtlMs) * time.Millisecond
+	if ttl <= 0 {
+		ttl = DefaultWriteLeaseTTL
+	}
+	if ttl > maxWriteLeaseTTL {
+		ttl = maxWriteLeaseTTL
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.store.Get(req.FileId); err != nil {
+		return nil, storeError(err, codes.NotFound, "metadata not found")
+	}
+	lease, ok, err := s.activeLease(req.FileId)
+	if err != nil {
+		return nil, storeError(err, codes.Internal, "failed to read lease")
+	}
+	if ok && lease.ID != req.LeaseId {
+		return nil, status.Errorf(codes.Aborted, "file %s is leased by another writer for %v", req.FileId, time.Until(lease.Expires).Round(time.Second))
+	}
+
+	id := req.LeaseId
+	if id == "" {
+		id = generateLeaseID()
+	}
+	if err := s.leases.saveLease(req.FileId, writeLease{ID: id, Expires: time.Now().Add(ttl)}); err != nil {
+		log.Printf("Failed to save write lease on file %s: %v", req.FileId, err)
+		return nil, storeError(err, codes.Internal, "failed to save lease")
+	}
+	if req.LeaseId == "" {
+		log.Printf("Granted write lease on file %s for %v", req.FileId, ttl)
+	}
+	return &pb.AcquireWriteLeaseResponse{LeaseId: id, TtlMs: ttl.Milliseconds()}, nil
+}
+
+func (s *Server) ReleaseWriteLease(ctx context.Context, req *pb.ReleaseWriteLeaseRequest) (*pb.ReleaseWriteLeaseResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease, ok, err := s.leases.getLease(req.FileId)
+	if err != nil {
+		return nil, storeError(err, codes.Internal, "failed to read lease")
+	}
+	if ok && lease.ID == req.LeaseId {
+		if err := s.leases.deleteLease(req.FileId); err != nil {
+			log.Printf("Failed to release write lease on file %s: %v", req.FileId, err)
+			return nil, storeError(err, codes.Internal, "failed to release lease")
+		}
+		log.Printf("Released write lease on file %s", req.FileId)
+	}
+	return &pb.ReleaseWriteLeaseResponse{Success: true}, nil
+}
+
+// checkLease fails with codes.Aborted if someone other than the holder of
+// leaseID holds a write lease on fileID. The caller must hold s.mu.
+func (s *Server) checkLease(fileID, leaseID string) error {
+	lease, ok, err := s.activeLease(fileID)
+	if err != nil {
+		return storeError(err, codes.Internal, "failed to read lease")
+	}
+	if ok && lease.ID != leaseID {
+		log.Printf("Rejecting write to file %s leased by another writer", fileID)
+		return status.Errorf(codes.Aborted, "file %s is leased by another writer", fileID)
+	}
+	return nil
+}
+
+// activeLease returns the unexpired lease on fileID. The caller must hold
+// s.mu.
+func (s *Server) activeLease(fileID string) (writeLease, bool, error) {
+	lease, ok, err := s.leases.getLease(fileID)
+	if err != nil || !ok || lease.expired() {
+		return writeLease{}, false, err
+	}
+	return lease, true, nil
+}
+
+func generateLeaseID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,102 @@
+package metadataservice
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteLeaseSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	mustSave(t, w, "a", "/a")
+	lease, err := NewServer(w).AcquireWriteLease(ctx, &pb.AcquireWriteLeaseRequest{FileId: "a"})
+	if err != nil {
+		t.Fatalf("AcquireWriteLease: %v", err)
+	}
+	w.Close()
+
+	w = openWALStore(t, dir, 0)
+	s := NewServer(w)
+	_, err = s.AcquireWriteLease(ctx, &pb.AcquireWriteLeaseRequest{FileId: "a"})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("second writer after restart: %v, want Aborted", err)
+	}
+	_, err = s.SaveFileMetadata(ctx, &pb.SaveFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "a", Path: "/a"}})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("write without the lease after restart: %v, want Aborted", err)
+	}
+
+	if _, err := s.ReleaseWriteLease(ctx, &pb.ReleaseWriteLeaseRequest{FileId: "a", LeaseId: lease.LeaseId}); err != nil {
+		t.Fatalf("ReleaseWriteLease: %v", err)
+	}
+	w.Close()
+	w = openWALStore(t, dir, 0)
+	if _, err := NewServer(w).AcquireWriteLease(ctx, &pb.AcquireWriteLeaseRequest{FileId: "a"}); err != nil {
+		t.Fatalf("AcquireWriteLease after release and restart: %v", err)
+	}
+}
+
+func TestWALStoreSnapshotDropsExpiredLeases(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	if err := w.saveLease("live", writeLease{ID: "1", Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.saveLease("expired", writeLease{ID: "2", Expires: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w = openWALStore(t, dir, 0)
+	if lease, ok, _ := w.getLease("live"); !ok || lease.ID != "1" {
+		t.Errorf("live lease = %v, %v after snapshot and reopen", lease, ok)
+	}
+	if _, ok, _ := w.getLease("expired"); ok {
+		t.Error("expired lease kept in the snapshot")
+	}
+}
+
+func TestRaftFSMRestoresLeases(t *testing.T) {
+	newFSM := func() *raftFSM {
+		store, err := NewDiskStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &raftFSM{store: store, events: newStoreEventLog(DefaultEventRetention, 0), leases: make(map[string]writeLease)}
+	}
+	leader := newFSM()
+	expires := time.Now().Add(time.Hour).Round(0)
+	applyCommand(t, leader, 1, raftCommand{Op: opSaveLease, FileID: "a", Lease: &writeLease{ID: "held", Expires: expires}})
+	applyCommand(t, leader, 2, raftCommand{Op: opSaveLease, FileID: "b", Lease: &writeLease{ID: "released", Expires: expires}})
+	applyCommand(t, leader, 3, raftCommand{Op: opDeleteLease, FileID: "b"})
+
+	snap, err := leader.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink memorySink
+	if err := snap.Persist(&sink); err != nil {
+		t.Fatal(err)
+	}
+	follower := newFSM()
+	if err := follower.Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if lease, ok, _ := follower.getLease("a"); !ok || lease.ID != "held" || !lease.Expires.Equal(expires) {
+		t.Errorf("lease on a = %v, %v after restore", lease, ok)
+	}
+	if _, ok, _ := follower.getLease("b"); ok {
+		t.Error("released lease on b came back after restore")
+	}
+}
@@ -0,0 +1,558 @@
+package metadataservice
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+	"dfs/internal/pki"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	raftTimeout       = 10 * time.Second
+	raftSnapshotCount = 3
+)
+
+// ErrNotLeader is returned for writes and linearizable reads sent to a member
+// that is not the raft leader.
+var ErrNotLeader = errors.New("not the raft leader")
+
+// RaftConfig describes the local member of a replicated metadata group.
+type RaftConfig struct {
+	// ID identifies the member in the group. By convention it is the
+	// member's gRPC address, so clients can reach the leader by its ID.
+	ID string
+	// BindAddr is the TCP address raft listens on; Advertise is the address
+	// peers use to reach it and defaults to BindAddr.
+	BindAddr  string
+	Advertise string
+	// Dir holds the raft log and snapshots.
+	Dir string
+	// Bootstrap forms a new group from Peers (member ID to raft address)
+	// when Dir holds no existing raft state. Only one member should set it.
+	Bootstrap bool
+	Peers     map[string]string
+	// TLS holds the member's certificate for mutual TLS between members.
+	// Raft traffic is unencrypted if it is empty.
+	TLS pki.Files
+}
+
+// Member is one server in the raft configuration.
+type Member struct {
+	ID      string
+	Address string
+	Voter   bool
+	Leader  bool
+}
+
+// RaftStore replicates a local Store across a raft group. Mutations are
+// appended to the raft log and applied to every member's local store in log
+// order. Reads through the Store interface are linearizable and only succeed
+// on the leader; GetStale serves possibly outdated data from any member.
+type RaftStore struct {
+	local     Store
+	fsm       *raftFSM
+	raft      *raft.Raft
+	logs      *raftLogStore
+	transport *raft.NetworkTransport
+}
+
+type raftCommand struct {
+	Op       string        `json:"op"`
+	Metadata *FileMetadata `json:"metadata,omitempty"`
+	// Records are saved together by opSaveAll.
+	Records    []*FileMetadata `json:"records,omitempty"`
+	FileID     string          `json:"file_id,omitempty"`
+	Directory  *Directory      `json:"directory,omitempty"`
+	Path       string          `json:"path,omitempty"`
+	Snapshot   *Snapshot       `json:"snapshot,omitempty"`
+	SnapshotID string          `json:"snapshot_id,omitempty"`
+	Lease      *writeLease     `json:"lease,omitempty"`
+}
+
+const (
+	opSave            = "save"
+	opSaveAll         = "save_all"
+	opDelete          = "delete"
+	opSaveDirectory   = "save_directory"
+	opDeleteDirectory = "delete_directory"
+	opSaveSnapshot    = "save_snapshot"
+	opDeleteSnapshot  = "delete_snapshot"
+	opSaveLease       = "save_lease"
+	opDeleteLease     = "delete_lease"
+)
+
+func newRaftTransport(cfg RaftConfig, advertise *net.TCPAddr) (*raft.NetworkTransport, error) {
+	if !cfg.TLS.Enabled() {
+		return raft.NewTCPTransport(cfg.BindAddr, advertise, 3, raftTimeout, os.Stderr)
+	}
+	if advertise.IP.IsUnspecified() {
+		return nil, errors.New("raft advertise address must not be unspecified")
+	}
+	serverConfig, err := pki.ServerConfig(cfg.TLS, pki.RoleMetadata)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig, err := pki.ClientConfig(cfg.TLS, pki.RoleMetadata)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", cfg.BindAddr, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	stream := &tlsStreamLayer{Listener: listener, advertise: advertise, config: clientConfig}
+	return raft.NewNetworkTransport(stream, 3, raftTimeout, os.Stderr), nil
+}
+
+// tlsStreamLayer carries raft traffic over mutual TLS. Members verify that
+// their peers hold metadata service certificates.
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	config    *tls.Config
+}
+
+func (l *tlsStreamLayer) Addr() net.Addr {
+	return l.advertise
+}
+
+func (l *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), l.config)
+}
+
+func NewRaftStore(local Store, cfg RaftConfig) (*RaftStore, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create raft directory: %w", err)
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(cfg.ID)
+
+	advertise := cfg.Advertise
+	if advertise == "" {
+		advertise = cfg.BindAddr
+	}
+	advertiseAddr, err := net.ResolveTCPAddr("tcp", advertise)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve raft advertise address: %w", err)
+	}
+	transport, err := newRaftTransport(cfg, advertiseAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raft transport: %w", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(cfg.Dir, raftSnapshotCount, os.Stderr)
+	if err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("failed to create snapshot store: %w", err)
+	}
+
+	logs, err := newRaftLogStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	if cfg.Bootstrap {
+		existing, err := raft.HasExistingState(logs, logs, snapshots)
+		if err != nil {
+			logs.Close()
+			transport.Close()
+			return nil, fmt.Errorf("failed to check raft state: %w", err)
+		}
+		if !existing {
+			servers := []raft.Server{{ID: conf.LocalID, Address: transport.LocalAddr()}}
+			for id, addr := range cfg.Peers {
+				if id == cfg.ID {
+					continue
+				}
+				servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+			}
+			if err := raft.BootstrapCluster(conf, logs, logs, snapshots, transport, raft.Configuration{Servers: servers}); err != nil {
+				logs.Close()
+				transport.Close()
+				return nil, fmt.Errorf("failed to bootstrap raft group: %w", err)
+			}
+		}
+	}
+
+	fsm := &raftFSM{
+		store:  local,
+		events: newStoreEventLog(DefaultEventRetention, 0),
+		leases: make(map[string]writeLease),
+	}
+	r, err := raft.NewRaft(conf, fsm, logs, logs, snapshots, transport)
+	if err != nil {
+		logs.Close()
+		transport.Close()
+		return nil, fmt.Errorf("failed to start raft: %w", err)
+	}
+
+	return &RaftStore{local: local, fsm: fsm, raft: r, logs: logs, transport: transport}, nil
+}
+
+func (r *RaftStore) Save(metadata *FileMetadata) error {
+	return r.apply(raftCommand{Op: opSave, Metadata: metadata})
+}
+
+func (r *RaftStore) SaveAll(records []*FileMetadata) error {
+	return r.apply(raftCommand{Op: opSaveAll, Records: records})
+}
+
+func (r *RaftStore) Get(fileID string) (*FileMetadata, error) {
+	if err := r.readBarrier(); err != nil {
+		return nil, err
+	}
+	return r.local.Get(fileID)
+}
+
+// GetStale reads from the local replica without contacting the leader.
+func (r *RaftStore) GetStale(fileID string) (*FileMetadata, error) {
+	return r.local.Get(fileID)
+}
+
+func (r *RaftStore) Delete(fileID string) error {
+	return r.apply(raftCommand{Op: opDelete, FileID: fileID})
+}
+
+func (r *RaftStore) List() ([]*FileMetadata, error) {
+	if err := r.readBarrier(); err != nil {
+		return nil, err
+	}
+	return r.local.List()
+}
+
+func (r *RaftStore) SaveDirectory(dir *Directory) error {
+	return r.apply(raftCommand{Op: opSaveDirectory, Directory: dir})
+}
+
+func (r *RaftStore) GetDirectory(path string) (*Directory, error) {
+	if err := r.readBarrier(); err != nil {
+		return nil, err
+	}
+	return r.local.GetDirectory(path)
+}
+
+func (r *RaftStore) DeleteDirectory(path string) error {
+	return r.apply(raftCommand{Op: opDeleteDirectory, Path: path})
+}
+
+func (r *RaftStore) ListDirectories() ([]*Directory, error) {
+	if err := r.readBarrier(); err != nil {
+		return nil, err
+	}
+	return r.local.ListDirectories()
+}
+
+func (r *RaftStore) SaveSnapshot(snap *Snapshot) error {
+	return r.apply(raftCommand{Op: opSaveSnapshot, Snapshot: snap})
+}
+
+func (r *RaftStore) GetSnapshot(id string) (*Snapshot, error) {
+	if err := r.readBarrier(); err != nil {
+		return nil, err
+	}
+	return r.local.GetSnapshot(id)
+}
+
+func (r *RaftStore) DeleteSnapshot(id string) error {
+	return r.apply(raftCommand{Op: opDeleteSnapshot, SnapshotID: id})
+}
+
+func (r *RaftStore) ListSnapshots() ([]*Snapshot, error) {
+	if err := r.readBarrier(); err != nil {
+		return nil, err
+	}
+	return r.local.ListSnapshots()
+}
+
+func (r *RaftStore) ChunkRefs(chunkIDs []string) (map[string]int64, error) {
+	if err := r.readBarrier(); err != nil {
+		return nil, err
+	}
+	return r.local.ChunkRefs(chunkIDs)
+}
+
+func (r *RaftStore) getLease(fileID string) (writeLease, bool, error) {
+	if err := r.readBarrier(); err != nil {
+		return writeLease{}, false, err
+	}
+	return r.fsm.getLease(fileID)
+}
+
+func (r *RaftStore) saveLease(fileID string, lease writeLease) error {
+	return r.apply(raftCommand{Op: opSaveLease, FileID: fileID, Lease: &lease})
+}
+
+func (r *RaftStore) deleteLease(fileID string) error {
+	return r.apply(raftCommand{Op: opDeleteLease, FileID: fileID})
+}
+
+// watchEvents returns the events applied on this member, numbered by their
+// raft log index.
+func (r *RaftStore) watchEvents() *eventLog {
+	return r.fsm.events
+}
+
+// Leader returns the ID of the current leader, or "" if none is known.
+func (r *RaftStore) Leader() string {
+	_, id := r.raft.LeaderWithID()
+	return string(id)
+}
+
+func (r *RaftStore) Members() ([]Member, error) {
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	leader := r.Leader()
+	var members []Member
+	for _, server := range future.Configuration().Servers {
+		members = append(members, Member{
+			ID:      string(server.ID),
+			Address: string(server.Address),
+			Voter:   server.Suffrage == raft.Voter,
+			Leader:  string(server.ID) == leader,
+		})
+	}
+	return members, nil
+}
+
+func (r *RaftStore) AddMember(id, raftAddr string) error {
+	err := r.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(raftAddr), 0, raftTimeout).Error()
+	return raftError(err)
+}
+
+func (r *RaftStore) RemoveMember(id string) error {
+	err := r.raft.RemoveServer(raft.ServerID(id), 0, raftTimeout).Error()
+	return raftError(err)
+}
+
+func (r *RaftStore) Close() error {
+	err := r.raft.Shutdown().Error()
+	r.transport.Close()
+	if closeErr := r.logs.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *RaftStore) apply(cmd raftCommand) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to marshal raft command: %w", err)
+	}
+	future := r.raft.Apply(data, raftTimeout)
+	if err := future.Error(); err != nil {
+		return raftError(err)
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// readBarrier makes a subsequent local read linearizable: it records the
+// commit index, confirms with a quorum that this member is still the leader,
+// and waits until the local store has applied everything up to that index.
+func (r *RaftStore) readBarrier() error {
+	commitIndex := r.raft.CommitIndex()
+	if err := r.raft.VerifyLeader().Error(); err != nil {
+		return raftError(err)
+	}
+	deadline := time.Now().Add(raftTimeout)
+	for r.raft.AppliedIndex() < commitIndex {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for local store to catch up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func raftError(err error) error {
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		return fmt.Errorf("%w: %v", ErrNotLeader, err)
+	}
+	return err
+}
+
+// raftFSM applies committed raft commands to the local store, records the
+// resulting events and keeps the write leases. Errors from the store are returned as the command's
+// response; raft ignores them when replaying the log after a restart, so
+// replay is idempotent.
+type raftFSM struct {
+	store  Store
+	events *eventLog
+
+	mu     sync.Mutex
+	leases map[string]writeLease
+}
+
+func (f *raftFSM) Apply(log *raft.Log) interface{} {
+	var cmd raftCommand
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		f.events.record(log.Index, nil)
+		return fmt.Errorf("failed to unmarshal raft command: %w", err)
+	}
+	at := log.AppendedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	var event *pb.WatchEvent
+	var err error
+	switch cmd.Op {
+	case opSave:
+		prev := f.current(cmd.Metadata.FileID)
+		if err = f.store.Save(cmd.Metadata); err == nil {
+			event = changeEvent(prev, cmd.Metadata, at)
+		}
+	case opSaveAll:
+		events := make([]*pb.WatchEvent, len(cmd.Records))
+		for i, metadata := range cmd.Records {
+			events[i] = changeEvent(f.current(metadata.FileID), metadata, at)
+		}
+		if err = f.store.SaveAll(cmd.Records); err != nil {
+			events = nil
+		}
+		f.events.record(log.Index, events...)
+		return err
+	case opDelete:
+		prev := f.current(cmd.FileID)
+		if err = f.store.Delete(cmd.FileID); err == nil && prev != nil {
+			event = newEvent(pb.Operation_DELETED, prev, at)
+		}
+	case opSaveDirectory:
+		err = f.store.SaveDirectory(cmd.Directory)
+	case opDeleteDirectory:
+		err = f.store.DeleteDirectory(cmd.Path)
+	case opSaveSnapshot:
+		err = f.store.SaveSnapshot(cmd.Snapshot)
+	case opDeleteSnapshot:
+		err = f.store.DeleteSnapshot(cmd.SnapshotID)
+	case opSaveLease:
+		f.mu.Lock()
+		if cmd.Lease != nil {
+			f.leases[cmd.FileID] = *cmd.Lease
+		}
+		f.mu.Unlock()
+	case opDeleteLease:
+		f.mu.Lock()
+		delete(f.leases, cmd.FileID)
+		f.mu.Unlock()
+	default:
+		err = fmt.Errorf("unknown raft command %q", cmd.Op)
+	}
+	f.events.record(log.Index, event)
+	return err
+}
+
+func (f *raftFSM) getLease(fileID string) (writeLease, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lease, ok := f.leases[fileID]
+	return lease, ok, nil
+}
+
+// current returns the local record of fileID, or nil if there is none.
+func (f *raftFSM) current(fileID string) *FileMetadata {
+	meta, err := f.store.Get(fileID)
+	if err != nil {
+		return nil
+	}
+	return meta
+}
+
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	records, err := f.store.List()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := f.store.ListDirectories()
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := f.store.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	return &raftSnapshot{
+		Records:     records,
+		Directories: dirs,
+		Snapshots:   snaps,
+		Sequence:    f.events.lastSequence(),
+		Events:      f.events.snapshot(),
+		Leases:      f.activeLeases(),
+	}, nil
+}
+
+// Restore replaces the local store's contents with a snapshot in one step.
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	replacer, ok := f.store.(Replacer)
+	if !ok {
+		return errors.New("local store cannot restore snapshots")
+	}
+	var snap raftSnapshot
+	if err := json.NewDecoder(rc).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if err := replacer.Replace(snap.Records, snap.Directories, snap.Snapshots); err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	f.events.restore(snap.Events, snap.Sequence)
+	f.mu.Lock()
+	f.leases = make(map[string]writeLease, len(snap.Leases))
+	for fileID, lease := range snap.Leases {
+		f.leases[fileID] = lease
+	}
+	f.mu.Unlock()
+	return nil
+}
+
+// activeLeases returns a copy of the leases that have not expired.
+func (f *raftFSM) activeLeases() map[string]writeLease {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	leases := make(map[string]writeLease, len(f.leases))
+	for fileID, lease := range f.leases {
+		if !lease.expired() {
+			leases[fileID] = lease
+		}
+	}
+	return leases
+}
+
+type raftSnapshot struct {
+	Records     []*FileMetadata `json:"records"`
+	Directories []*Directory    `json:"directories"`
+	Snapshots   []*Snapshot     `json:"snapshots"`
+	// Sequence is the index of the last command applied before the
+	// snapshot, and Events the events retained then.
+	Sequence uint64                `json:"sequence"`
+	Events   eventSnapshot         `json:"events"`
+	Leases   map[string]writeLease `json:"leases,omitempty"`
+}
+
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *raftSnapshot) Release() {}
@@ -0,0 +1,190 @@
+package metadataservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+
+	"github.com/hashicorp/raft"
+)
+
+// memorySink is a raft.SnapshotSink that keeps the snapshot in memory.
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "test" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+func applyCommand(t *testing.T, f *raftFSM, index uint64, cmd raftCommand) {
+	t.Helper()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := f.Apply(&raft.Log{Index: index, Data: data, AppendedAt: time.Now()}).(error); ok {
+		t.Fatalf("Apply(%d): %v", index, err)
+	}
+}
+
+func TestRaftFSMRestoreReplacesStore(t *testing.T) {
+	for engine, open := range openStores(t) {
+		t.Run(engine, func(t *testing.T) {
+			store := open(t.TempDir())
+			mustSave(t, store, "stale", "/stale")
+			mustSave(t, store, "kept", "/old")
+			if err := store.SaveDirectory(&Directory{Path: "/gone"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SaveSnapshot(&Snapshot{ID: "old"}); err != nil {
+				t.Fatal(err)
+			}
+
+			snap := raftSnapshot{
+				Records:     []*FileMetadata{{FileID: "kept", Path: "/new"}, {FileID: "added", Path: "/added"}},
+				Directories: []*Directory{{Path: "/dir"}},
+				Snapshots:   []*Snapshot{{ID: "new"}},
+			}
+			data, err := json.Marshal(snap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &raftFSM{store: store, events: newStoreEventLog(DefaultEventRetention, 0)}
+			if err := f.Restore(io.NopCloser(bytes.NewReader(data))); err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+
+			records, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, metadata := range records {
+				paths = append(paths, metadata.FileID+"="+metadata.Path)
+			}
+			sort.Strings(paths)
+			if got, want := paths, []string{"added=/added", "kept=/new"}; !equalStrings(got, want) {
+				t.Errorf("records after restore = %v, want %v", got, want)
+			}
+			if dirs, err := store.ListDirectories(); err != nil || len(dirs) != 1 || dirs[0].Path != "/dir" {
+				t.Errorf("directories after restore = %v, %v", dirs, err)
+			}
+			if snaps, err := store.ListSnapshots(); err != nil || len(snaps) != 1 || snaps[0].ID != "new" {
+				t.Errorf("snapshots after restore = %v, %v", snaps, err)
+			}
+			if indexed, ok := store.(IndexedStore); ok {
+				if files, err := indexed.FindByPathPrefix("/old"); err != nil || len(files) != 0 {
+					t.Errorf("path index still finds the old path: %v, %v", files, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRaftFSMSaveAllIsOneEntry(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &raftFSM{store: store, events: newStoreEventLog(DefaultEventRetention, 0)}
+	applyCommand(t, f, 1, raftCommand{Op: opSave, Metadata: &FileMetadata{FileID: "a", Path: "/f", Generation: 1}})
+	applyCommand(t, f, 2, raftCommand{Op: opSaveAll, Records: []*FileMetadata{
+		{FileID: "a", Path: "/f", Generation: 2, DeletedAt: "2026-01-01T00:00:00Z"},
+		{FileID: "b", Path: "/f", Generation: 1},
+	}})
+
+	events := wantEvents(t, f.events, 1, pb.Operation_DELETED, pb.Operation_CREATED)
+	if events[0].Sequence != 2 || events[1].Sequence != 2 {
+		t.Errorf("event sequences %d and %d, want both at raft index 2", events[0].Sequence, events[1].Sequence)
+	}
+	if old, err := store.Get("a"); err != nil || old.DeletedAt == "" {
+		t.Errorf("replaced file = %v, %v, want it in the trash", old, err)
+	}
+	wantFile(t, store, "b", "/f")
+}
+
+// copyTree copies the regular files under src into dst.
+func copyTree(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.WalkDir(src, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskStoreFinishesInterruptedReplace(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, "stale", "/stale")
+	mustSave(t, store, "kept", "/old")
+	if err := store.SaveSnapshot(&Snapshot{ID: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stage the new contents as Replace does and crash after committing
+	// them and copying one record.
+	staged := t.TempDir()
+	replacement, err := NewDiskStore(staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, replacement, "kept", "/new")
+	mustSave(t, replacement, "added", "/added")
+	if err := replacement.SaveSnapshot(&Snapshot{ID: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	copyTree(t, staged, filepath.Join(dir, replaceDirName))
+	copyTree(t, filepath.Join(staged, "added.json"), filepath.Join(dir, "added.json"))
+
+	store, err = NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	wantFile(t, store, "kept", "/new")
+	wantFile(t, store, "added", "/added")
+	wantNoFile(t, store, "stale")
+	if snaps, err := store.ListSnapshots(); err != nil || len(snaps) != 1 || snaps[0].ID != "new" {
+		t.Errorf("snapshots after finishing the restore = %v, %v", snaps, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, replaceDirName)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("staging directory left behind: %v", err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package metadataservice
+
+// chunkRefs counts the references to each chunk from file records, their
+// noncurrent versions included, and from snapshots. A chunk whose count is
+// zero can be deleted from the storage nodes; chunks without references have
+// no entry.
+type chunkRefs map[string]int64
+
+// countRefs returns the references that files and snaps hold.
+func countRefs(files map[string]*FileMetadata, snaps map[string]*Snapshot) chunkRefs {
+	refs := make(chunkRefs)
+	for _, metadata := range files {
+		refs.addFile(metadata, 1)
+	}
+	for _, snap := range snaps {
+		refs.addSnapshot(snap, 1)
+	}
+	return refs
+}
+
+// addFile adds delta to the count of every chunk metadata refers to. A nil
+// metadata changes nothing.
+func (r chunkRefs) addFile(metadata *FileMetadata, delta int64) {
+	if metadata == nil {
+		return
+	}
+	for _, c := range allChunks(metadata) {
+		r.add(c.ChunkID, delta)
+	}
+}
+
+// addSnapshot adds delta to the count of every chunk the files of snap refer
+// to. A nil snap changes nothing.
+func (r chunkRefs) addSnapshot(snap *Snapshot, delta int64) {
+	if snap == nil {
+		return
+	}
+	for _, metadata := range snap.Files {
+		r.addFile(metadata, delta)
+	}
+}
+
+func (r chunkRefs) add(chunkID string, delta int64) {
+	if n := r[chunkID] + delta; n != 0 {
+		r[chunkID] = n
+	} else {
+		delete(r, chunkID)
+	}
+}
+
+// lookup returns the counts of chunkIDs.
+func (r chunkRefs) lookup(chunkIDs []string) map[string]int64 {
+	counts := make(map[string]int64, len(chunkIDs))
+	for _, id := range chunkIDs {
+		counts[id] = r[id]
+	}
+	return counts
+}
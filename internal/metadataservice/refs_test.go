@@ -0,0 +1,183 @@
+package metadataservice
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	pb "dfs/internal/pb/metadata"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func openStores(t *testing.T) map[string]func(dir string) Store {
+	return map[string]func(dir string) Store{
+		EngineJSON: func(dir string) Store {
+			s, err := NewDiskStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		EngineBolt: func(dir string) Store {
+			s, err := NewBoltStore(filepath.Join(dir, BoltFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		EngineWAL: func(dir string) Store {
+			return openWALStore(t, dir, 0)
+		},
+	}
+}
+
+func withChunks(fileID string, chunkIDs ...string) *FileMetadata {
+	metadata := &FileMetadata{FileID: fileID, FileName: fileID, Path: "/" + fileID}
+	for _, id := range chunkIDs {
+		metadata.Chunks = append(metadata.Chunks, ChunkInfo{ChunkID: id})
+	}
+	return metadata
+}
+
+func wantRefs(t *testing.T, s Store, want map[string]int64) {
+	t.Helper()
+	var ids []string
+	for id := range want {
+		ids = append(ids, id)
+	}
+	got, err := s.ChunkRefs(ids)
+	if err != nil {
+		t.Fatalf("ChunkRefs: %v", err)
+	}
+	for id, n := range want {
+		if got[id] != n {
+			t.Errorf("chunk %s has %d references, want %d", id, got[id], n)
+		}
+	}
+}
+
+func TestStoreChunkRefs(t *testing.T) {
+	for engine, open := range openStores(t) {
+		t.Run(engine, func(t *testing.T) {
+			dir := t.TempDir()
+			s := open(dir)
+			if err := s.Save(withChunks("a", "c1", "c2")); err != nil {
+				t.Fatal(err)
+			}
+			copied := withChunks("b", "c2")
+			copied.Versions = []FileVersion{{VersionID: "v1", Chunks: []ChunkInfo{{ChunkID: "c3"}}}}
+			if err := s.Save(copied); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SaveSnapshot(&Snapshot{ID: "snap", Files: []*FileMetadata{withChunks("a", "c1")}}); err != nil {
+				t.Fatal(err)
+			}
+			wantRefs(t, s, map[string]int64{"c1": 2, "c2": 2, "c3": 1, "c4": 0})
+
+			// Overwriting a record releases the chunks it no longer has.
+			if err := s.Save(withChunks("a", "c4")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete("b"); err != nil {
+				t.Fatal(err)
+			}
+			wantRefs(t, s, map[string]int64{"c1": 1, "c2": 0, "c3": 0, "c4": 1})
+			if err := s.DeleteSnapshot("snap"); err != nil {
+				t.Fatal(err)
+			}
+			wantRefs(t, s, map[string]int64{"c1": 0, "c4": 1})
+
+			// The counts survive reopening the store.
+			if c, ok := s.(interface{ Close() error }); ok {
+				c.Close()
+			}
+			wantRefs(t, open(dir), map[string]int64{"c1": 0, "c4": 1})
+		})
+	}
+}
+
+func TestBoltStoreCountsRefsOfOlderDatabases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), BoltFileName)
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(withChunks("a", "c1", "c2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveSnapshot(&Snapshot{ID: "snap", Files: []*FileMetadata{withChunks("a", "c1")}}); err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(refsBucket)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	wantRefs(t, s, map[string]int64{"c1": 2, "c2": 1})
+}
+
+func TestSharedChunksRefusedOnceReleased(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store)
+	if err := store.Save(withChunks("src", "c1")); err != nil {
+		t.Fatal(err)
+	}
+	chunks := []*pb.ChunkInfo{{ChunkId: "c1"}}
+
+	// A copy made while the source exists keeps its chunk alive.
+	_, err = s.SaveFileMetadata(ctx, &pb.SaveFileMetadataRequest{
+		Metadata:     &pb.FileMetadata{FileId: "copy1", Path: "/copy1", Chunks: chunks},
+		SharesChunks: true,
+	})
+	if err != nil {
+		t.Fatalf("copy of a live source: %v", err)
+	}
+	if _, err := s.DeleteFileMetadata(ctx, &pb.DeleteFileMetadataRequest{FileId: "src"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.UnreferencedChunks(ctx, &pb.UnreferencedChunksRequest{ChunkIds: []string{"c1"}})
+	if err != nil || len(resp.ChunkIds) != 0 {
+		t.Fatalf("UnreferencedChunks with a copy left = %v, %v, want none", resp, err)
+	}
+
+	// Once the last reference is gone and the chunk is reported free, a copy
+	// that read the chunk list earlier must not take it up.
+	if _, err := s.DeleteFileMetadata(ctx, &pb.DeleteFileMetadataRequest{FileId: "copy1"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s.UnreferencedChunks(ctx, &pb.UnreferencedChunksRequest{ChunkIds: []string{"c1"}})
+	if err != nil || len(resp.ChunkIds) != 1 {
+		t.Fatalf("UnreferencedChunks after the last delete = %v, %v, want c1", resp, err)
+	}
+	_, err = s.SaveFileMetadata(ctx, &pb.SaveFileMetadataRequest{
+		Metadata:     &pb.FileMetadata{FileId: "copy2", Path: "/copy2", Chunks: chunks},
+		SharesChunks: true,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("copy of released chunks: %v, want Aborted", err)
+	}
+
+	// New uploads do not share chunks, so unreferenced ones are expected.
+	_, err = s.SaveFileMetadata(ctx, &pb.SaveFileMetadataRequest{
+		Metadata: &pb.FileMetadata{FileId: "upload", Path: "/upload", Chunks: []*pb.ChunkInfo{{ChunkId: "c2"}}},
+	})
+	if err != nil {
+		t.Fatalf("upload of new chunks: %v", err)
+	}
+}
@@ -3,85 +3,591 @@ package metadataservice
 import (
 	"context"
 	pb "dfs/internal/pb/metadata"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// DefaultLeaseDuration is how long clients may cache metadata returned by
+// GetFileMetadata before revalidating it.
+const DefaultLeaseDuration = 30 * time.Second
+
 type Server struct {
 	pb.UnimplementedMetadataServiceServer
-	store Store
+	store         Store
+	leaseDuration time.Duration
+	events        *eventLog
+	// publish is set when the store does not record events itself.
+	publish bool
+
+	// mu serializes read-modify-write sequences such as assigning the next
+	// generation on save, and guards leases.
+	mu     sync.Mutex
+	leases leaseStore
 }
 
 func NewServer(store Store) *Server {
-	return &Server{store: store}
+	s := &Server{
+		store:         store,
+		leaseDuration: DefaultLeaseDuration,
+		leases:        make(memoryLeases),
+	}
+	if leases, ok := store.(leaseStore); ok {
+		s.leases = leases
+	}
+	if source, ok := store.(eventSource); ok {
+		s.events = source.watchEvents()
+	} else {
+		s.events = newEventLog(DefaultEventRetention)
+		s.publish = true
+	}
+	return s
+}
+
+// SetLeaseDuration changes the cache lease granted by GetFileMetadata.
+func (s *Server) SetLeaseDuration(d time.Duration) {
+	s.leaseDuration = d
 }
 
 func (s *Server) SaveFileMetadata(ctx context.Context, req *pb.SaveFileMetadataRequest) (*pb.SaveFileMetadataResponse, error) {
 	log.Printf("Saving metadata for file: %s", req.Metadata.FileId)
-	meta := &FileMetadata{
-		FileID:    req.Metadata.FileId,
-		FileName:  req.Metadata.FileName,
-		FileSize:  req.Metadata.FileSize,
-		Chunks:    make([]ChunkInfo, len(req.Metadata.Chunks)),
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
-		UpdatedAt: time.Now().UTC().Format(time.RFC3339),
+	if err := checkWritable(req.Metadata); err != nil {
+		return nil, err
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	for i, chunk := range req.Metadata.Chunks {
-		meta.Chunks[i] = ChunkInfo{
-			ChunkID: chunk.ChunkId,
-			NodeIDs: chunk.NodeIds,
+	if err := s.checkLease(req.Metadata.FileId, req.LeaseId); err != nil {
+		return nil, err
+	}
+	prev, err := s.store.Get(req.Metadata.FileId)
+	switch {
+	case err == nil:
+		if req.IfNotExists {
+			log.Printf("Refusing to overwrite existing file %s", req.Metadata.FileId)
+			return nil, status.Errorf(codes.FailedPrecondition, "file %s already exists", req.Metadata.FileId)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		prev = nil
+	default:
+		log.Printf("Failed to read current metadata for file %s: %v", req.Metadata.FileId, err)
+		return nil, storeError(err, codes.Internal, "failed to save metadata")
+	}
+	if req.SharesChunks {
+		if err := s.checkSharedChunks(req.Metadata, prev); err != nil {
+			return nil, err
 		}
 	}
+	replaced, err := s.claimPath(req.Metadata, prev, req.ReplaceFileId, req.ReplaceIfMatch)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := s.store.Save(meta); err != nil {
+	meta, err := s.write(req.Metadata, prev, replaced...)
+	if err != nil {
 		log.Printf("Failed to save metadata for file %s: %v", req.Metadata.FileId, err)
-		return nil, status.Errorf(codes.Internal, "failed to save metadata: %v", err)
+		return nil, storeError(err, codes.Internal, "failed to save metadata")
 	}
 
-	log.Printf("Metadata saved successfully for file: %s", req.Metadata.FileId)
-	return &pb.SaveFileMetadataResponse{Success: true}, nil
+	log.Printf("Metadata saved successfully for file: %s (generation %d)", meta.FileID, meta.Generation)
+	return &pb.SaveFileMetadataResponse{Success: true, Generation: meta.Generation}, nil
 }
 
-func (s *Server) GetFileMetadata(ctx context.Context, req *pb.GetFileMetadataRequest) (*pb.GetFileMetadataResponse, error) {
-	log.Printf("Retrieving metadata for file: %s", req.FileId)
-	meta, err := s.store.Get(req.FileId)
+func (s *Server) UpdateFileMetadata(ctx context.Context, req *pb.UpdateFileMetadataRequest) (*pb.UpdateFileMetadataResponse, error) {
+	log.Printf("Updating metadata for file: %s", req.Metadata.FileId)
+	if err := checkWritable(req.Metadata); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, err := s.store.Get(req.Metadata.FileId)
 	if err != nil {
-		log.Printf("Failed to retrieve metadata for file %s: %v", req.FileId, err)
-		return nil, status.Errorf(codes.NotFound, "metadata not found: %v", err)
+		log.Printf("Failed to read current metadata for file %s: %v", req.Metadata.FileId, err)
+		return nil, storeError(err, codes.NotFound, "metadata not found")
+	}
+	if err := checkGeneration(prev, req.IfMatch); err != nil {
+		return nil, err
+	}
+	if err := s.checkLease(prev.FileID, req.LeaseId); err != nil {
+		return nil, err
+	}
+	if req.SharesChunks {
+		if err := s.checkSharedChunks(req.Metadata, prev); err != nil {
+			return nil, err
+		}
+	}
+	replaced, err := s.claimPath(req.Metadata, prev, req.ReplaceFileId, req.ReplaceIfMatch)
+	if err != nil {
+		return nil, err
 	}
 
-	pbMeta := &pb.FileMetadata{
-		FileId:    meta.FileID,
-		FileName:  meta.FileName,
-		FileSize:  meta.FileSize,
-		Chunks:    make([]*pb.ChunkInfo, len(meta.Chunks)),
-		CreatedAt: meta.CreatedAt,
-		UpdatedAt: meta.UpdatedAt,
+	meta, err := s.write(req.Metadata, prev, replaced...)
+	if err != nil {
+		log.Printf("Failed to update metadata for file %s: %v", req.Metadata.FileId, err)
+		return nil, storeError(err, codes.Internal, "failed to update metadata")
 	}
 
-	for i, chunk := range meta.Chunks {
-		pbMeta.Chunks[i] = &pb.ChunkInfo{
-			ChunkId: chunk.ChunkID,
-			NodeIds: chunk.NodeIDs,
+	log.Printf("Metadata updated successfully for file: %s (generation %d)", meta.FileID, meta.Generation)
+	return &pb.UpdateFileMetadataResponse{Success: true, Generation: meta.Generation}, nil
+}
+
+func (s *Server) GetFileMetadata(ctx context.Context, req *pb.GetFileMetadataRequest) (*pb.GetFileMetadataResponse, error) {
+	var meta *FileMetadata
+	var err error
+	switch {
+	case req.FileId == "" && req.Path != "":
+		log.Printf("Retrieving metadata for path: %s", req.Path)
+		meta, err = s.findByPath(req.Path)
+	case req.Consistency == pb.ReadConsistency_STALE:
+		log.Printf("Retrieving metadata for file: %s", req.FileId)
+		if stale, ok := s.store.(staleReader); ok {
+			meta, err = stale.GetStale(req.FileId)
+		} else {
+			meta, err = s.store.Get(req.FileId)
 		}
+	default:
+		log.Printf("Retrieving metadata for file: %s", req.FileId)
+		meta, err = s.store.Get(req.FileId)
+	}
+	if err == nil && meta.DeletedAt != "" && !req.IncludeDeleted {
+		err = fmt.Errorf("file %s is in the trash: %w", meta.FileID, fs.ErrNotExist)
+	}
+	if err != nil {
+		log.Printf("Failed to retrieve metadata for file %s: %v", req.FileId+req.Path, err)
+		return nil, storeError(err, codes.NotFound, "metadata not found")
+	}
+
+	leaseMs := s.leaseDuration.Milliseconds()
+	if req.KnownGeneration != 0 && req.KnownGeneration == meta.Generation {
+		log.Printf("Metadata for file %s not modified since generation %d", req.FileId, meta.Generation)
+		return &pb.GetFileMetadataResponse{NotModified: true, LeaseMs: leaseMs}, nil
 	}
 
-	log.Printf("Metadata retrieved successfully for file: %s", req.FileId)
-	return &pb.GetFileMetadataResponse{Metadata: pbMeta}, nil
+	log.Printf("Metadata retrieved successfully for file: %s", meta.FileID)
+	return &pb.GetFileMetadataResponse{Metadata: metadataToProto(meta), LeaseMs: leaseMs}, nil
+}
+
+func (s *Server) ListFiles(ctx context.Context, req *pb.ListFilesRequest) (*pb.ListFilesResponse, error) {
+	var files []*FileMetadata
+	var err error
+	if isSnapshotPath(req.PathPrefix) {
+		files, err = s.snapshotFiles(req.PathPrefix)
+	} else {
+		files, err = s.listByPrefix(req.PathPrefix)
+	}
+	if err != nil {
+		log.Printf("Failed to list files under %q: %v", req.PathPrefix, err)
+		return nil, storeError(err, codes.Internal, "failed to list files")
+	}
+	resp := &pb.ListFilesResponse{}
+	for _, meta := range files {
+		if (meta.DeletedAt != "") == req.Deleted && matchesFilter(meta, req) {
+			resp.Files = append(resp.Files, metadataToProto(meta))
+		}
+	}
+	return resp, nil
 }
 
 func (s *Server) DeleteFileMetadata(ctx context.Context, req *pb.DeleteFileMetadataRequest) (*pb.DeleteFileMetadataResponse, error) {
 	log.Printf("Deleting metadata for file: %s", req.FileId)
-	err := s.store.Delete(req.FileId)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.store.Get(req.FileId)
+	if err == nil {
+		if err := checkGeneration(meta, req.IfMatch); err != nil {
+			return nil, err
+		}
+		if err := s.checkLease(req.FileId, req.LeaseId); err != nil {
+			return nil, err
+		}
+		err = s.store.Delete(req.FileId)
+	}
 	if err != nil {
 		log.Printf("Failed to delete metadata for file %s: %v", req.FileId, err)
-		return nil, status.Errorf(codes.Internal, "failed to delete metadata: %v", err)
+		return nil, storeError(err, codes.Internal, "failed to delete metadata")
+	}
+	if s.publish {
+		s.events.publish(newEvent(pb.Operation_DELETED, meta, time.Now()))
+	}
+	if _, ok, _ := s.leases.getLease(req.FileId); ok {
+		if err := s.leases.deleteLease(req.FileId); err != nil {
+			log.Printf("Failed to drop the write lease on deleted file %s: %v", req.FileId, err)
+		}
 	}
 
 	log.Printf("Metadata deleted successfully for file: %s", req.FileId)
 	return &pb.DeleteFileMetadataResponse{Success: true}, nil
 }
+
+func (s *Server) GetLeader(ctx context.Context, req *pb.GetLeaderRequest) (*pb.GetLeaderResponse, error) {
+	rs, ok := s.store.(*RaftStore)
+	if !ok {
+		return &pb.GetLeaderResponse{}, nil
+	}
+	return &pb.GetLeaderResponse{LeaderId: rs.Leader()}, nil
+}
+
+func (s *Server) ListMembers(ctx context.Context, req *pb.ListMembersRequest) (*pb.ListMembersResponse, error) {
+	rs, ok := s.store.(*RaftStore)
+	if !ok {
+		return &pb.ListMembersResponse{}, nil
+	}
+	members, err := rs.Members()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list members: %v", err)
+	}
+	resp := &pb.ListMembersResponse{}
+	for _, m := range members {
+		resp.Members = append(resp.Members, &pb.Member{
+			Id:       m.ID,
+			RaftAddr: m.Address,
+			Voter:    m.Voter,
+			Leader:   m.Leader,
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) AddMember(ctx context.Context, req *pb.AddMemberRequest) (*pb.AddMemberResponse, error) {
+	rs, ok := s.store.(*RaftStore)
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "metadata service is not replicated")
+	}
+	log.Printf("Adding member %s at %s", req.Id, req.RaftAddr)
+	if err := rs.AddMember(req.Id, req.RaftAddr); err != nil {
+		log.Printf("Failed to add member %s: %v", req.Id, err)
+		return nil, storeError(err, codes.Internal, "failed to add member")
+	}
+	return &pb.AddMemberResponse{Success: true}, nil
+}
+
+func (s *Server) RemoveMember(ctx context.Context, req *pb.RemoveMemberRequest) (*pb.RemoveMemberResponse, error) {
+	rs, ok := s.store.(*RaftStore)
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "metadata service is not replicated")
+	}
+	log.Printf("Removing member %s", req.Id)
+	if err := rs.RemoveMember(req.Id); err != nil {
+		log.Printf("Failed to remove member %s: %v", req.Id, err)
+		return nil, storeError(err, codes.Internal, "failed to remove member")
+	}
+	return &pb.RemoveMemberResponse{Success: true}, nil
+}
+
+// listByPrefix returns every file whose path starts with prefix, sorted by
+// path, using the store's path index when it has one.
+func (s *Server) listByPrefix(prefix string) ([]*FileMetadata, error) {
+	if indexed, ok := s.store.(IndexedStore); ok {
+		return indexed.FindByPathPrefix(prefix)
+	}
+	all, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var files []*FileMetadata
+	for _, meta := range all {
+		if strings.HasPrefix(meta.Path, prefix) {
+			files = append(files, meta)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Path != files[j].Path {
+			return files[i].Path < files[j].Path
+		}
+		return files[i].FileID < files[j].FileID
+	})
+	return files, nil
+}
+
+// findByPath returns the file at exactly path that is not in the trash, or
+// the most recently updated one if files saved before paths were kept unique
+// share it. Paths under SnapshotRoot are looked up in snapshots.
+func (s *Server) findByPath(p string) (*FileMetadata, error) {
+	var files []*FileMetadata
+	var err error
+	if isSnapshotPath(p) {
+		files, err = s.snapshotFiles(p)
+	} else {
+		files, err = s.listByPrefix(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var found *FileMetadata
+	for _, meta := range files {
+		if meta.Path == p && meta.DeletedAt == "" && (found == nil || meta.UpdatedAt > found.UpdatedAt) {
+			found = meta
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("path %s: %w", p, fs.ErrNotExist)
+	}
+	return found, nil
+}
+
+// write stores m as the next generation after prev, which is nil for a new
+// file, and publishes the change unless the store records it. Files m
+// replaces at its path are moved to the trash in the same step. The caller
+// must hold s.mu.
+func (s *Server) write(m *pb.FileMetadata, prev *FileMetadata, replaced ...*FileMetadata) (*FileMetadata, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	meta := nextGeneration(metadataFromProto(m), prev, now)
+	if len(replaced) == 0 {
+		if err := s.store.Save(meta); err != nil {
+			return nil, err
+		}
+		if s.publish {
+			s.events.publish(changeEvent(prev, meta, time.Now()))
+		}
+		return meta, nil
+	}
+
+	records := make([]*FileMetadata, 0, len(replaced)+1)
+	for _, old := range replaced {
+		trashed := *old
+		trashed.DeletedAt = now
+		records = append(records, nextGeneration(&trashed, old, now))
+	}
+	records = append(records, meta)
+	if err := s.store.SaveAll(records); err != nil {
+		return nil, err
+	}
+	for i, old := range replaced {
+		log.Printf("Moved file %s at %s to the trash, replaced by file %s", old.FileID, old.Path, meta.FileID)
+		if s.publish {
+			s.events.publish(changeEvent(old, records[i], time.Now()))
+		}
+	}
+	if s.publish {
+		s.events.publish(changeEvent(prev, meta, time.Now()))
+	}
+	return meta, nil
+}
+
+// nextGeneration makes meta the generation after prev, which is nil for a
+// new file, written at now.
+func nextGeneration(meta, prev *FileMetadata, now string) *FileMetadata {
+	meta.CreatedAt = now
+	meta.UpdatedAt = now
+	meta.Generation = 1
+	if prev != nil {
+		meta.Generation = prev.Generation + 1
+		meta.CreatedAt = prev.CreatedAt
+	}
+	return meta
+}
+
+// claimPath keeps paths unique when m, which replaces prev, puts a file at a
+// path: any other file there must be replaceID, at generation replaceIfMatch
+// unless that is zero. It returns the files for write to move to the trash
+// along with saving m: the replaced file and any that shared the path before
+// paths were kept unique. The caller must hold s.mu.
+func (s *Server) claimPath(m *pb.FileMetadata, prev *FileMetadata, replaceID string, replaceIfMatch int64) ([]*FileMetadata, error) {
+	if m.Path == "" || m.DeletedAt != "" || isSnapshotPath(m.Path) {
+		return nil, nil
+	}
+	if prev != nil && prev.DeletedAt == "" && prev.Path == m.Path {
+		return nil, nil
+	}
+	files, err := s.listByPrefix(m.Path)
+	if err != nil {
+		log.Printf("Failed to look up files at %s: %v", m.Path, err)
+		return nil, storeError(err, codes.Internal, "failed to look up path")
+	}
+	var others []*FileMetadata
+	var replaced *FileMetadata
+	for _, meta := range files {
+		if meta.Path != m.Path || meta.DeletedAt != "" || meta.FileID == m.FileId {
+			continue
+		}
+		others = append(others, meta)
+		if meta.FileID == replaceID {
+			replaced = meta
+		}
+	}
+	switch {
+	case len(others) == 0:
+		return nil, nil
+	case replaceID == "":
+		log.Printf("Refusing to save file %s at %s, where file %s is", m.FileId, m.Path, others[0].FileID)
+		return nil, status.Errorf(codes.AlreadyExists, "%s already exists", m.Path)
+	case replaced == nil:
+		log.Printf("Refusing to save file %s at %s: file %s is no longer there", m.FileId, m.Path, replaceID)
+		return nil, status.Errorf(codes.FailedPrecondition, "file %s is no longer at %s", replaceID, m.Path)
+	}
+	if err := checkGeneration(replaced, replaceIfMatch); err != nil {
+		return nil, err
+	}
+	for _, meta := range others {
+		if err := s.checkLease(meta.FileID, ""); err != nil {
+			return nil, err
+		}
+	}
+	return others, nil
+}
+
+// checkSharedChunks makes sure that every chunk m refers to and prev did not
+// is still referenced, so that a copy cannot take up a chunk that
+// UnreferencedChunks already reported as free to delete. The caller must
+// hold s.mu.
+func (s *Server) checkSharedChunks(m *pb.FileMetadata, prev *FileMetadata) error {
+	had := make(map[string]bool)
+	if prev != nil {
+		for _, c := range allChunks(prev) {
+			had[c.ChunkID] = true
+		}
+	}
+	var ids []string
+	for _, c := range allChunks(metadataFromProto(m)) {
+		if !had[c.ChunkID] {
+			had[c.ChunkID] = true
+			ids = append(ids, c.ChunkID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	refs, err := s.store.ChunkRefs(ids)
+	if err != nil {
+		log.Printf("Failed to count references to the chunks of file %s: %v", m.FileId, err)
+		return storeError(err, codes.Internal, "failed to check chunk references")
+	}
+	for _, id := range ids {
+		if refs[id] == 0 {
+			log.Printf("Refusing to save file %s: chunk %s is no longer referenced", m.FileId, id)
+			return status.Errorf(codes.Aborted, "chunk %s was released while file %s was written", id, m.FileId)
+		}
+	}
+	return nil
+}
+
+// checkGeneration enforces an if_match precondition; zero matches any
+// generation.
+func checkGeneration(meta *FileMetadata, ifMatch int64) error {
+	if ifMatch != 0 && meta.Generation != ifMatch {
+		log.Printf("Generation mismatch for file %s: have %d, want %d", meta.FileID, meta.Generation, ifMatch)
+		return status.Errorf(codes.FailedPrecondition, "file %s is at generation %d, not %d", meta.FileID, meta.Generation, ifMatch)
+	}
+	return nil
+}
+
+// staleReader is implemented by replicated stores that can serve reads from
+// the local replica without consulting the leader.
+type staleReader interface {
+	GetStale(fileID string) (*FileMetadata, error)
+}
+
+// storeError converts a store error into a gRPC status. Requests that reached
+// a follower get codes.Unavailable so clients know to retry on the leader.
+func storeError(err error, code codes.Code, msg string) error {
+	if errors.Is(err, ErrNotLeader) {
+		return status.Errorf(codes.Unavailable, "%s: %v", msg, err)
+	}
+	return status.Errorf(code, "%s: %v", msg, err)
+}
+
+func metadataFromProto(m *pb.FileMetadata) *FileMetadata {
+	meta := &FileMetadata{
+		FileID:     m.FileId,
+		FileName:   m.FileName,
+		FileSize:   m.FileSize,
+		Chunks:     chunksFromProto(m.Chunks),
+		CreatedAt:  m.CreatedAt,
+		UpdatedAt:  m.UpdatedAt,
+		Path:       m.Path,
+		Generation: m.Generation,
+		VersionID:  m.VersionId,
+		Versioning: policyFromProto(m.Versioning),
+		DeletedAt:  m.DeletedAt,
+
+		ContentType:  m.ContentType,
+		UserMetadata: m.UserMetadata,
+		Tags:         normalizeTags(m.Tags),
+		SHA256:       m.Sha256,
+		MD5:          m.Md5,
+		Permissions:  permissionsFromProto(m.Permissions),
+	}
+
+	for _, v := range m.Versions {
+		meta.Versions = append(meta.Versions, FileVersion{
+			VersionID:  v.VersionId,
+			FileSize:   v.FileSize,
+			Chunks:     chunksFromProto(v.Chunks),
+			CreatedAt:  v.CreatedAt,
+			ReplacedAt: v.ReplacedAt,
+			SHA256:     v.Sha256,
+			MD5:        v.Md5,
+		})
+	}
+	return meta
+}
+
+func metadataToProto(meta *FileMetadata) *pb.FileMetadata {
+	pbMeta := &pb.FileMetadata{
+		FileId:     meta.FileID,
+		FileName:   meta.FileName,
+		FileSize:   meta.FileSize,
+		Chunks:     chunksToProto(meta.Chunks),
+		CreatedAt:  meta.CreatedAt,
+		UpdatedAt:  meta.UpdatedAt,
+		Path:       meta.Path,
+		Generation: meta.Generation,
+		VersionId:  meta.VersionID,
+		Versioning: policyToProto(meta.Versioning),
+		DeletedAt:  meta.DeletedAt,
+
+		ContentType:  meta.ContentType,
+		UserMetadata: meta.UserMetadata,
+		Tags:         meta.Tags,
+		Sha256:       meta.SHA256,
+		Md5:          meta.MD5,
+		Permissions:  permissionsToProto(meta.Permissions),
+	}
+
+	for _, v := range meta.Versions {
+		pbMeta.Versions = append(pbMeta.Versions, &pb.FileVersion{
+			VersionId:  v.VersionID,
+			FileSize:   v.FileSize,
+			Chunks:     chunksToProto(v.Chunks),
+			CreatedAt:  v.CreatedAt,
+			ReplacedAt: v.ReplacedAt,
+			Sha256:     v.SHA256,
+			Md5:        v.MD5,
+		})
+	}
+	return pbMeta
+}
+
+func chunksFromProto(chunks []*pb.ChunkInfo) []ChunkInfo {
+	result := make([]ChunkInfo, len(chunks))
+	for i, chunk := range chunks {
+		result[i] = ChunkInfo{
+			ChunkID: chunk.ChunkId,
+			NodeIDs: chunk.NodeIds,
+			Size:    chunk.Size,
+		}
+	}
+	return result
+}
+
+func chunksToProto(chunks []ChunkInfo) []*pb.ChunkInfo {
+	result := make([]*pb.ChunkInfo, len(chunks))
+	for i, chunk := range chunks {
+		result[i] = &pb.ChunkInfo{
+			ChunkId: chunk.ChunkID,
+			NodeIds: chunk.NodeIDs,
+			Size:    chunk.Size,
+		}
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package metadataservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func liveFilesAt(t *testing.T, s *Server, path string) []string {
+	t.Helper()
+	files, err := s.listByPrefix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, meta := range files {
+		if meta.Path == path && meta.DeletedAt == "" {
+			ids = append(ids, meta.FileID)
+		}
+	}
+	return ids
+}
+
+func TestSaveFileMetadataKeepsPathsUnique(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store)
+	save := func(req *pb.SaveFileMetadataRequest) error {
+		_, err := s.SaveFileMetadata(ctx, req)
+		return err
+	}
+	if err := save(&pb.SaveFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "a", Path: "/f"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = save(&pb.SaveFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "b", Path: "/f"}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("second file at /f: %v, want AlreadyExists", err)
+	}
+	err = save(&pb.SaveFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "b", Path: "/f"}, ReplaceFileId: "a", ReplaceIfMatch: 5})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("replace at the wrong generation: %v, want FailedPrecondition", err)
+	}
+	err = save(&pb.SaveFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "b", Path: "/f"}, ReplaceFileId: "x"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("replace of a file not at the path: %v, want FailedPrecondition", err)
+	}
+	if ids := liveFilesAt(t, s, "/f"); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("files at /f after refused saves = %v, want [a]", ids)
+	}
+
+	err = save(&pb.SaveFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "b", Path: "/f"}, ReplaceFileId: "a", ReplaceIfMatch: 1})
+	if err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if ids := liveFilesAt(t, s, "/f"); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("files at /f after replace = %v, want [b]", ids)
+	}
+	if old, err := store.Get("a"); err != nil || old.DeletedAt == "" {
+		t.Fatalf("replaced file = %v, %v, want it in the trash", old, err)
+	}
+
+	// Restoring the replaced file would put a second file at /f.
+	_, err = s.UpdateFileMetadata(ctx, &pb.UpdateFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "a", Path: "/f"}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("restore onto an occupied path: %v, want AlreadyExists", err)
+	}
+}
+
+func TestUpdateFileMetadataMoveOntoOccupiedPath(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store)
+	mustSave(t, store, "a", "/a")
+	mustSave(t, store, "b", "/b")
+
+	move := &pb.UpdateFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "a", FileName: "b", Path: "/b"}}
+	if _, err := s.UpdateFileMetadata(ctx, move); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("move onto /b: %v, want AlreadyExists", err)
+	}
+	move.ReplaceFileId = "b"
+	if _, err := s.UpdateFileMetadata(ctx, move); err != nil {
+		t.Fatalf("move replacing b: %v", err)
+	}
+	if ids := liveFilesAt(t, s, "/b"); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("files at /b after move = %v, want [a]", ids)
+	}
+	if ids := liveFilesAt(t, s, "/a"); len(ids) != 0 {
+		t.Fatalf("files at /a after move = %v, want none", ids)
+	}
+}
+
+// failingStore fails every SaveAll.
+type failingStore struct {
+	Store
+}
+
+func (failingStore) SaveAll([]*FileMetadata) error {
+	return errors.New("disk full")
+}
+
+func TestReplaceIsOneStoreChange(t *testing.T) {
+	ctx := context.Background()
+	for engine, open := range openStores(t) {
+		t.Run(engine, func(t *testing.T) {
+			store := open(t.TempDir())
+			mustSave(t, store, "a", "/f")
+
+			// A failed save leaves the replaced file where it was.
+			s := NewServer(failingStore{store})
+			replace := &pb.SaveFileMetadataRequest{Metadata: &pb.FileMetadata{FileId: "b", Path: "/f"}, ReplaceFileId: "a"}
+			if _, err := s.SaveFileMetadata(ctx, replace); status.Code(err) != codes.Internal {
+				t.Fatalf("replace with a failing store: %v, want Internal", err)
+			}
+			if old, err := store.Get("a"); err != nil || old.DeletedAt != "" {
+				t.Fatalf("replaced file after a failed save = %v, %v, want it live", old, err)
+			}
+			wantNoFile(t, store, "b")
+
+			s = NewServer(store)
+			after := s.events.lastSequence()
+			if _, err := s.SaveFileMetadata(ctx, replace); err != nil {
+				t.Fatalf("replace: %v", err)
+			}
+			events := wantEvents(t, s.events, after, pb.Operation_DELETED, pb.Operation_CREATED)
+			if events[0].FileId != "a" || events[1].FileId != "b" {
+				t.Fatalf("events of the replace = %v", events)
+			}
+			if _, ok := store.(eventSource); ok && events[0].Sequence != events[1].Sequence {
+				t.Errorf("events of one logged change have sequences %d and %d", events[0].Sequence, events[1].Sequence)
+			}
+		})
+	}
+}
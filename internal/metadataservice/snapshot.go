@@ -0,0 +1,203 @@
+package metadataservice
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SnapshotRoot is the read-only namespace under which the files of each
+// snapshot appear, as SnapshotRoot/<snapshot id>/<original path>.
+const SnapshotRoot = "/.snapshots"
+
+func (s *Server) CreateSnapshot(ctx context.Context, req *pb.CreateSnapshotRequest) (*pb.CreateSnapshotResponse, error) {
+	dir := path.Clean("/" + req.Path)
+	if isSnapshotPath(dir) {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot snapshot %s", dir)
+	}
+	id := req.Id
+	if id == "" {
+		id = time.Now().UTC().Format("20060102T150405.000Z")
+	}
+	if len(id) > 128 || id == "." || id == ".." || strings.Contains(id, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid snapshot ID %q", id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.store.GetSnapshot(id)
+	if err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists", id)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, storeError(err, codes.Internal, "failed to create snapshot")
+	}
+
+	files, err := s.listByPrefix(dir)
+	if err != nil {
+		log.Printf("Failed to list files for snapshot of %s: %v", dir, err)
+		return nil, storeError(err, codes.Internal, "failed to create snapshot")
+	}
+	snap := &Snapshot{
+		ID:        id,
+		Path:      dir,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, meta := range files {
+		if meta.DeletedAt != "" || !inDirectory(meta.Path, dir) {
+			continue
+		}
+		frozen := *meta
+		frozen.Versions = nil
+		frozen.Versioning = nil
+		snap.Files = append(snap.Files, &frozen)
+	}
+
+	if err := s.store.SaveSnapshot(snap); err != nil {
+		log.Printf("Failed to save snapshot %s: %v", id, err)
+		return nil, storeError(err, codes.Internal, "failed to create snapshot")
+	}
+	log.Printf("Created snapshot %s of %s with %d files", id, dir, len(snap.Files))
+	return &pb.CreateSnapshotResponse{Snapshot: snapshotToProto(snap)}, nil
+}
+
+func (s *Server) ListSnapshots(ctx context.Context, req *pb.ListSnapshotsRequest) (*pb.ListSnapshotsResponse, error) {
+	snaps, err := s.store.ListSnapshots()
+	if err != nil {
+		return nil, storeError(err, codes.Internal, "failed to list snapshots")
+	}
+	resp := &pb.ListSnapshotsResponse{}
+	for _, snap := range snaps {
+		resp.Snapshots = append(resp.Snapshots, snapshotToProto(snap))
+	}
+	return resp, nil
+}
+
+// DeleteSnapshot drops a snapshot and reports which of its chunks nothing
+// else refers to any more, so the caller can free them.
+func (s *Server) DeleteSnapshot(ctx context.Context, req *pb.DeleteSnapshotRequest) (*pb.DeleteSnapshotResponse, error) {
+	log.Printf("Deleting snapshot: %s", req.Id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, err := s.store.GetSnapshot(req.Id)
+	if err == nil {
+		err = s.store.DeleteSnapshot(req.Id)
+	}
+	if err != nil {
+		log.Printf("Failed to delete snapshot %s: %v", req.Id, err)
+		return nil, storeError(err, codes.NotFound, "failed to delete snapshot")
+	}
+
+	var chunks []ChunkInfo
+	var ids []string
+	for _, meta := range snap.Files {
+		for _, c := range meta.Chunks {
+			chunks = append(chunks, c)
+			ids = append(ids, c.ChunkID)
+		}
+	}
+	refs, err := s.store.ChunkRefs(ids)
+	if err != nil {
+		// The snapshot is gone; its chunks just stay around until the
+		// next garbage collection.
+		log.Printf("Failed to find chunks released by snapshot %s: %v", req.Id, err)
+		return &pb.DeleteSnapshotResponse{}, nil
+	}
+	resp := &pb.DeleteSnapshotResponse{}
+	released := make(map[string]bool)
+	for _, c := range chunks {
+		if refs[c.ChunkID] == 0 && !released[c.ChunkID] {
+			released[c.ChunkID] = true
+			resp.ReleasedChunks = append(resp.ReleasedChunks, chunksToProto([]ChunkInfo{c})...)
+		}
+	}
+	return resp, nil
+}
+
+// UnreferencedChunks reports which of the requested chunks have no
+// references left. It holds s.mu so that the answer stays true: saves that
+// share chunks check under the same lock that they are still referenced.
+func (s *Server) UnreferencedChunks(ctx context.Context, req *pb.UnreferencedChunksRequest) (*pb.UnreferencedChunksResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refs, err := s.store.ChunkRefs(req.ChunkIds)
+	if err != nil {
+		return nil, storeError(err, codes.Internal, "failed to check chunk references")
+	}
+	resp := &pb.UnreferencedChunksResponse{}
+	for _, id := range req.ChunkIds {
+		if refs[id] == 0 {
+			resp.ChunkIds = append(resp.ChunkIds, id)
+		}
+	}
+	return resp, nil
+}
+
+// snapshotFiles returns the snapshot files whose path under SnapshotRoot
+// starts with prefix, with their paths rewritten accordingly.
+func (s *Server) snapshotFiles(prefix string) ([]*FileMetadata, error) {
+	snaps, err := s.store.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	var files []*FileMetadata
+	for _, snap := range snaps {
+		root := SnapshotRoot + "/" + snap.ID
+		if !strings.HasPrefix(root+"/", prefix) && !strings.HasPrefix(prefix, root+"/") {
+			continue
+		}
+		for _, meta := range snap.Files {
+			if p := root + meta.Path; strings.HasPrefix(p, prefix) {
+				copied := *meta
+				copied.Path = p
+				files = append(files, &copied)
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func isSnapshotPath(p string) bool {
+	return p == SnapshotRoot || strings.HasPrefix(p, SnapshotRoot+"/")
+}
+
+// inDirectory reports whether p is dir or below it.
+func inDirectory(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+func snapshotToProto(snap *Snapshot) *pb.Snapshot {
+	var size int64
+	for _, meta := range snap.Files {
+		size += meta.FileSize
+	}
+	return &pb.Snapshot{
+		Id:        snap.ID,
+		Path:      snap.Path,
+		CreatedAt: snap.CreatedAt,
+		FileCount: int32(len(snap.Files)),
+		TotalSize: size,
+	}
+}
+
+// checkWritable rejects writes to the read-only snapshot namespace.
+func checkWritable(m *pb.FileMetadata) error {
+	if isSnapshotPath(m.Path) {
+		return status.Errorf(codes.InvalidArgument, "%s is in a read-only snapshot", m.Path)
+	}
+	return nil
+}
@@ -2,42 +2,226 @@ package metadataservice
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 )
 
 type ChunkInfo struct {
 	ChunkID string
 	NodeIDs []string
+	Size    int64
 }
 
 type FileMetadata struct {
-	FileID    string
-	FileName  string
-	FileSize  int64
-	Chunks    []ChunkInfo
+	FileID     string
+	FileName   string
+	FileSize   int64
+	Chunks     []ChunkInfo
+	CreatedAt  string
+	UpdatedAt  string
+	Path       string
+	Generation int64
+	// VersionID identifies the current contents. Versions holds earlier
+	// contents, oldest first, and Versioning overrides the policy inherited
+	// from the file's directories.
+	VersionID  string
+	Versions   []FileVersion
+	Versioning *VersioningPolicy
+	// DeletedAt is set while the file is in the trash.
+	DeletedAt string
+	// ContentType, UserMetadata and Tags are attributes set by users. Tags
+	// are kept sorted and free of duplicates.
+	ContentType  string
+	UserMetadata map[string]string
+	Tags         []string
+	// SHA256 and MD5 are hex-encoded digests of the current contents.
+	SHA256 string
+	MD5    string
+	// Permissions is nil for files created without authentication.
+	Permissions *Permissions
+}
+
+// FileVersion is a noncurrent version of a file's contents.
+type FileVersion struct {
+	VersionID  string
+	FileSize   int64
+	Chunks     []ChunkInfo
+	CreatedAt  string
+	ReplacedAt string
+	SHA256     string
+	MD5        string
+}
+
+// VersioningPolicy controls whether overwriting a file keeps its previous
+// contents, and for how long. Zero limits keep versions indefinitely.
+type VersioningPolicy struct {
+	Enabled      bool
+	KeepVersions int
+	KeepDays     int
+}
+
+// Directory holds settings attached to a namespace directory. They apply to
+// every file below Path unless a deeper directory or the file itself
+// overrides them.
+type Directory struct {
+	Path        string
+	Versioning  *VersioningPolicy
+	Permissions *Permissions
+}
+
+// Permissions name the owner and group of a file or directory and hold
+// POSIX-like mode bits for them and everyone else.
+type Permissions struct {
+	Owner string
+	Group string
+	Mode  uint32
+}
+
+// allChunks returns the chunks of the current contents followed by those of
+// every noncurrent version.
+func allChunks(metadata *FileMetadata) []ChunkInfo {
+	chunks := metadata.Chunks
+	for _, version := range metadata.Versions {
+		chunks = append(chunks[:len(chunks):len(chunks)], version.Chunks...)
+	}
+	return chunks
+}
+
+// Snapshot is a frozen copy of the files below Path at CreatedAt. Files hold
+// the current contents only, under their original paths.
+type Snapshot struct {
+	ID        string
+	Path      string
 	CreatedAt string
-	UpdatedAt string
+	Files     []*FileMetadata
 }
 
 type Store interface {
 	Save(metadata *FileMetadata) error
+	// SaveAll saves records in one step: BoltStore, WALStore and RaftStore
+	// store all of them or none. DiskStore saves them in order.
+	SaveAll(records []*FileMetadata) error
 	Get(fileID string) (*FileMetadata, error)
 	Delete(fileID string) error
+	List() ([]*FileMetadata, error)
+
+	SaveDirectory(dir *Directory) error
+	GetDirectory(path string) (*Directory, error)
+	DeleteDirectory(path string) error
+	ListDirectories() ([]*Directory, error)
+
+	SaveSnapshot(snap *Snapshot) error
+	GetSnapshot(id string) (*Snapshot, error)
+	DeleteSnapshot(id string) error
+	ListSnapshots() ([]*Snapshot, error)
+
+	// ChunkRefs returns how many file records and snapshots refer to each
+	// of chunkIDs. The counts change in the same step as the records.
+	ChunkRefs(chunkIDs []string) (map[string]int64, error)
+}
+
+// IndexedStore is implemented by stores that maintain secondary indexes and
+// can answer lookups without scanning every record.
+type IndexedStore interface {
+	Store
+	FindByName(name string) ([]*FileMetadata, error)
+	FindByPathPrefix(prefix string) ([]*FileMetadata, error)
+	FindByNode(nodeID string) ([]*FileMetadata, error)
+}
+
+// Replacer is implemented by stores that can replace everything they hold in
+// one step. RaftStore uses it to restore raft snapshots.
+type Replacer interface {
+	Replace(records []*FileMetadata, dirs []*Directory, snaps []*Snapshot) error
 }
 
+// Metadata engines accepted by OpenStore.
+const (
+	EngineJSON = "json"
+	EngineBolt = "bolt"
+	EngineWAL  = "wal"
+)
+
+// OpenStore creates the store implementation named by engine in baseDir. An
+// empty engine selects EngineJSON.
+func OpenStore(engine, baseDir string) (Store, error) {
+	switch engine {
+	case "", EngineJSON:
+		return NewDiskStore(baseDir)
+	case EngineBolt:
+		if err := os.MkdirAll(baseDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create base directory: %w", err)
+		}
+		return NewBoltStore(filepath.Join(baseDir, BoltFileName))
+	case EngineWAL:
+		return NewWALStore(baseDir, DefaultSnapshotInterval)
+	default:
+		return nil, fmt.Errorf("unknown metadata engine %q", engine)
+	}
+}
+
+// directoriesFileName holds every directory record for DiskStore. It has no
+// .json extension so List does not mistake it for file metadata.
+const directoriesFileName = "directories"
+
+// snapshotsDirName is the subdirectory where DiskStore keeps one JSON file
+// per snapshot.
+const snapshotsDirName = "snapshots"
+
+// DiskStore keeps each record in its own JSON file. Chunk reference counts
+// are kept in memory, counted when the store is opened and updated under mu
+// along with the files.
 type DiskStore struct {
 	baseDir string
 	mu      sync.RWMutex
+	refs    chunkRefs
 }
 
 func NewDiskStore(baseDir string) (*DiskStore, error) {
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create base directory: %w", err)
 	}
-	return &DiskStore{baseDir: baseDir}, nil
+	d := &DiskStore{baseDir: baseDir}
+	if err := d.finishReplace(); err != nil {
+		return nil, fmt.Errorf("failed to finish restoring a snapshot: %w", err)
+	}
+	if err := d.countRefs(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// countRefs counts the chunk references of every record and snapshot.
+// Records that cannot be read refer to nothing.
+func (d *DiskStore) countRefs() error {
+	d.refs = make(chunkRefs)
+	entries, err := os.ReadDir(d.baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".json" {
+			metadata, _ := d.get(strings.TrimSuffix(entry.Name(), ".json"))
+			d.refs.addFile(metadata, 1)
+		}
+	}
+	entries, err = os.ReadDir(filepath.Join(d.baseDir, snapshotsDirName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".json" {
+			snap, _ := d.getSnapshot(strings.TrimSuffix(entry.Name(), ".json"))
+			d.refs.addSnapshot(snap, 1)
+		}
+	}
+	return nil
 }
 
 func (d *DiskStore) Save(metadata *FileMetadata) error {
@@ -49,11 +233,23 @@ func (d *DiskStore) Save(metadata *FileMetadata) error {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
+	old, _ := d.get(metadata.FileID)
 	path := filepath.Join(d.baseDir, metadata.FileID+".json")
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := writeFileSync(path, data); err != nil {
 		return fmt.Errorf("failed to write metadata to file: %w", err)
 	}
+	d.refs.addFile(old, -1)
+	d.refs.addFile(metadata, 1)
+
+	return nil
+}
 
+func (d *DiskStore) SaveAll(records []*FileMetadata) error {
+	for _, metadata := range records {
+		if err := d.Save(metadata); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -61,6 +257,10 @@ func (d *DiskStore) Get(fileID string) (*FileMetadata, error) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
+	return d.get(fileID)
+}
+
+func (d *DiskStore) get(fileID string) (*FileMetadata, error) {
 	path := filepath.Join(d.baseDir, fileID+".json")
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -79,10 +279,12 @@ func (d *DiskStore) Delete(fileID string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	old, _ := d.get(fileID)
 	path := filepath.Join(d.baseDir, fileID+".json")
 	if err := os.Remove(path); err != nil {
 		return err
 	}
+	d.refs.addFile(old, -1)
 	return nil
 }
 
@@ -101,7 +303,7 @@ func (d *DiskStore) List() ([]*FileMetadata, error) {
 			continue
 		}
 
-		metadata, err := d.Get(filepath.Base(file.Name()[:len(file.Name())-5]))
+		metadata, err := d.get(filepath.Base(file.Name()[:len(file.Name())-5]))
 		if err != nil {
 			return nil, fmt.Errorf("failed to get metadata for file %s: %w", file.Name(), err)
 		}
@@ -111,3 +313,307 @@ func (d *DiskStore) List() ([]*FileMetadata, error) {
 
 	return metadataList, nil
 }
+
+func (d *DiskStore) SaveDirectory(dir *Directory) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dirs, err := d.readDirectories()
+	if err != nil {
+		return err
+	}
+	dirs[dir.Path] = dir
+	return d.writeDirectories(dirs)
+}
+
+func (d *DiskStore) GetDirectory(path string) (*Directory, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	dirs, err := d.readDirectories()
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := dirs[path]
+	if !ok {
+		return nil, fmt.Errorf("directory %s: %w", path, fs.ErrNotExist)
+	}
+	return dir, nil
+}
+
+func (d *DiskStore) DeleteDirectory(path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dirs, err := d.readDirectories()
+	if err != nil {
+		return err
+	}
+	if _, ok := dirs[path]; !ok {
+		return fmt.Errorf("directory %s: %w", path, fs.ErrNotExist)
+	}
+	delete(dirs, path)
+	return d.writeDirectories(dirs)
+}
+
+func (d *DiskStore) ListDirectories() ([]*Directory, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	dirs, err := d.readDirectories()
+	if err != nil {
+		return nil, err
+	}
+	return sortedDirectories(dirs), nil
+}
+
+func (d *DiskStore) readDirectories() (map[string]*Directory, error) {
+	dirs := make(map[string]*Directory)
+	data, err := os.ReadFile(filepath.Join(d.baseDir, directoriesFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return dirs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directories: %w", err)
+	}
+	if err := json.Unmarshal(data, &dirs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal directories: %w", err)
+	}
+	return dirs, nil
+}
+
+func (d *DiskStore) writeDirectories(dirs map[string]*Directory) error {
+	data, err := json.Marshal(dirs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal directories: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(d.baseDir, directoriesFileName), data); err != nil {
+		return fmt.Errorf("failed to write directories: %w", err)
+	}
+	return nil
+}
+
+func sortedDirectories(dirs map[string]*Directory) []*Directory {
+	list := make([]*Directory, 0, len(dirs))
+	for _, dir := range dirs {
+		list = append(list, dir)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// replaceDirName is where DiskStore.Replace stages the contents that replace
+// the store's. Once the directory has been renamed into place the replacement
+// is committed and is finished on the next start if it was interrupted.
+const replaceDirName = ".replace"
+
+// Replace writes the new records, directories and snapshots into a staging
+// directory, renames it into place and then moves its contents into the
+// store, so that a crash leaves either the old or the new contents.
+func (d *DiskStore) Replace(records []*FileMetadata, dirs []*Directory, snaps []*Snapshot) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Recount whatever is on disk afterwards, even after a failure.
+	defer d.countRefs()
+
+	tmp := filepath.Join(d.baseDir, replaceDirName+".tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("failed to clear staging directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmp, snapshotsDirName), 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	for _, metadata := range records {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		if err := writeFileSync(filepath.Join(tmp, metadata.FileID+".json"), data); err != nil {
+			return fmt.Errorf("failed to write metadata to file: %w", err)
+		}
+	}
+	dirMap := make(map[string]*Directory, len(dirs))
+	for _, dir := range dirs {
+		dirMap[dir.Path] = dir
+	}
+	data, err := json.Marshal(dirMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal directories: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(tmp, directoriesFileName), data); err != nil {
+		return fmt.Errorf("failed to write directories: %w", err)
+	}
+	for _, snap := range snaps {
+		data, err := json.Marshal(snap)
+		if err != nil {
+			return fmt.Errorf("failed to marshal snapshot: %w", err)
+		}
+		if err := writeFileSync(filepath.Join(tmp, snapshotsDirName, snap.ID+".json"), data); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmp, filepath.Join(d.baseDir, replaceDirName)); err != nil {
+		return fmt.Errorf("failed to commit replacement: %w", err)
+	}
+	if err := syncDir(d.baseDir); err != nil {
+		return fmt.Errorf("failed to commit replacement: %w", err)
+	}
+	return d.finishReplace()
+}
+
+// finishReplace moves the contents of a committed replacement into the store
+// and removes the records and snapshots that are not among them. Every step
+// can be repeated, so it is safe to run again after a crash. The caller must
+// hold d.mu or be opening the store.
+func (d *DiskStore) finishReplace() error {
+	staged := filepath.Join(d.baseDir, replaceDirName)
+	if _, err := os.Stat(staged); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	keep, err := copyStaged(staged, d.baseDir)
+	if err != nil {
+		return err
+	}
+	keepSnaps, err := copyStaged(filepath.Join(staged, snapshotsDirName), filepath.Join(d.baseDir, snapshotsDirName))
+	if err != nil {
+		return err
+	}
+	if err := removeJSONExcept(d.baseDir, keep); err != nil {
+		return err
+	}
+	if err := removeJSONExcept(filepath.Join(d.baseDir, snapshotsDirName), keepSnaps); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(staged); err != nil {
+		return fmt.Errorf("failed to remove staging directory: %w", err)
+	}
+	return nil
+}
+
+// copyStaged copies the files in the staging directory src into dst and
+// returns their names.
+func copyStaged(src, dst string) (map[string]bool, error) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging directory: %w", err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read staged file: %w", err)
+		}
+		if err := writeFileSync(filepath.Join(dst, entry.Name()), data); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", entry.Name(), err)
+		}
+		names[entry.Name()] = true
+	}
+	return names, nil
+}
+
+// removeJSONExcept removes the .json files in dir whose names keep lacks.
+func removeJSONExcept(dir string, keep map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".json" && !keep[entry.Name()] {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DiskStore) SaveSnapshot(snap *Snapshot) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dir := filepath.Join(d.baseDir, snapshotsDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	old, _ := d.getSnapshot(snap.ID)
+	if err := writeFileSync(filepath.Join(dir, snap.ID+".json"), data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	d.refs.addSnapshot(old, -1)
+	d.refs.addSnapshot(snap, 1)
+	return nil
+}
+
+func (d *DiskStore) GetSnapshot(id string) (*Snapshot, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.getSnapshot(id)
+}
+
+func (d *DiskStore) getSnapshot(id string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(d.baseDir, snapshotsDirName, id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+func (d *DiskStore) DeleteSnapshot(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old, _ := d.getSnapshot(id)
+	if err := os.Remove(filepath.Join(d.baseDir, snapshotsDirName, id+".json")); err != nil {
+		return err
+	}
+	d.refs.addSnapshot(old, -1)
+	return nil
+}
+
+func (d *DiskStore) ListSnapshots() ([]*Snapshot, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(d.baseDir, snapshotsDirName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+	var snaps []*Snapshot
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		snap, err := d.getSnapshot(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+func (d *DiskStore) ChunkRefs(chunkIDs []string) (map[string]int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.refs.lookup(chunkIDs), nil
+}
@@ -0,0 +1,644 @@
+package metadataservice
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	pb "dfs/internal/pb/metadata"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+
+	// DefaultSnapshotInterval is the number of WAL records after which
+	// WALStore writes a snapshot and truncates the log.
+	DefaultSnapshotInterval = 1000
+
+	walHeaderLen = 8
+	// maxWALRecord bounds the payload length read from a record header, so
+	// a corrupt length cannot trigger a huge allocation.
+	maxWALRecord = 64 << 20
+)
+
+// WALStore keeps all metadata in memory and makes it durable with a
+// write-ahead log plus periodic snapshots. Every mutation is appended to the
+// log and fsynced before it is applied. After snapshotInterval records the
+// whole state is written to a snapshot file, which is fsynced and atomically
+// renamed into place, and the log is truncated. On startup the snapshot is
+// loaded and the log replayed on top of it.
+//
+// Each log record is a little-endian uint32 payload length, a uint32 CRC-32
+// of the payload, and a JSON-encoded walRecord.
+type WALStore struct {
+	dir              string
+	snapshotInterval int
+
+	mu    sync.RWMutex
+	files map[string]*FileMetadata
+	dirs  map[string]*Directory
+	snaps map[string]*Snapshot
+	refs  chunkRefs
+	wal   walFile
+	// size is where the next record goes: the end of the last record that
+	// was written and synced.
+	size     int64
+	seq      uint64
+	unsynced int
+	events   *eventLog
+	leases   map[string]writeLease
+	// failed is set when a failed append could not be cut off the log. The
+	// store refuses to write until a snapshot replaces the log.
+	failed error
+}
+
+// walFile is the part of *os.File the WAL is written through.
+type walFile interface {
+	io.ReadSeeker
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+type walRecord struct {
+	Seq      uint64        `json:"seq"`
+	Op       string        `json:"op"`
+	Time     string        `json:"time,omitempty"`
+	Metadata *FileMetadata `json:"metadata,omitempty"`
+	// Records are saved together by opSaveAll.
+	Records    []*FileMetadata `json:"records,omitempty"`
+	FileID     string          `json:"file_id,omitempty"`
+	Directory  *Directory      `json:"directory,omitempty"`
+	Path       string          `json:"path,omitempty"`
+	Snapshot   *Snapshot       `json:"snapshot,omitempty"`
+	SnapshotID string          `json:"snapshot_id,omitempty"`
+	Lease      *writeLease     `json:"lease,omitempty"`
+}
+
+type walSnapshot struct {
+	Seq         uint64          `json:"seq"`
+	Records     []*FileMetadata `json:"records"`
+	Directories []*Directory    `json:"directories,omitempty"`
+	Snapshots   []*Snapshot     `json:"snapshots,omitempty"`
+	// Events is nil in snapshots written before events were kept.
+	Events *eventSnapshot        `json:"events,omitempty"`
+	Leases map[string]writeLease `json:"leases,omitempty"`
+}
+
+func NewWALStore(dir string, snapshotInterval int) (*WALStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base directory: %w", err)
+	}
+	w := &WALStore{
+		dir:              dir,
+		snapshotInterval: snapshotInterval,
+		files:            make(map[string]*FileMetadata),
+		dirs:             make(map[string]*Directory),
+		snaps:            make(map[string]*Snapshot),
+		leases:           make(map[string]writeLease),
+	}
+
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		return nil, err
+	}
+	for _, metadata := range snap.Records {
+		w.files[metadata.FileID] = metadata
+	}
+	for _, dir := range snap.Directories {
+		w.dirs[dir.Path] = dir
+	}
+	for _, s := range snap.Snapshots {
+		w.snaps[s.ID] = s
+	}
+	for fileID, lease := range snap.Leases {
+		w.leases[fileID] = lease
+	}
+	w.refs = countRefs(w.files, w.snaps)
+	w.seq = snap.Seq
+	w.events = newStoreEventLog(DefaultEventRetention, snap.Seq)
+	if snap.Events != nil {
+		w.events.restore(*snap.Events, snap.Seq)
+	}
+
+	w.wal, err = os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL: %w", err)
+	}
+	if err := w.replay(); err != nil {
+		w.wal.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *WALStore) Save(metadata *FileMetadata) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	copied := *metadata
+	return w.commit(walRecord{Op: opSave, Metadata: &copied})
+}
+
+func (w *WALStore) SaveAll(records []*FileMetadata) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	copied := make([]*FileMetadata, len(records))
+	for i, metadata := range records {
+		c := *metadata
+		copied[i] = &c
+	}
+	return w.commit(walRecord{Op: opSaveAll, Records: copied})
+}
+
+func (w *WALStore) Get(fileID string) (*FileMetadata, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	metadata, ok := w.files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("file %s: %w", fileID, fs.ErrNotExist)
+	}
+	copied := *metadata
+	return &copied, nil
+}
+
+func (w *WALStore) Delete(fileID string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.files[fileID]; !ok {
+		return fmt.Errorf("file %s: %w", fileID, fs.ErrNotExist)
+	}
+	return w.commit(walRecord{Op: opDelete, FileID: fileID})
+}
+
+func (w *WALStore) List() ([]*FileMetadata, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	metadataList := make([]*FileMetadata, 0, len(w.files))
+	for _, metadata := range w.files {
+		copied := *metadata
+		metadataList = append(metadataList, &copied)
+	}
+	sort.Slice(metadataList, func(i, j int) bool { return metadataList[i].FileID < metadataList[j].FileID })
+	return metadataList, nil
+}
+
+func (w *WALStore) SaveDirectory(dir *Directory) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	copied := *dir
+	return w.commit(walRecord{Op: opSaveDirectory, Directory: &copied})
+}
+
+func (w *WALStore) GetDirectory(path string) (*Directory, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	dir, ok := w.dirs[path]
+	if !ok {
+		return nil, fmt.Errorf("directory %s: %w", path, fs.ErrNotExist)
+	}
+	copied := *dir
+	return &copied, nil
+}
+
+func (w *WALStore) DeleteDirectory(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.dirs[path]; !ok {
+		return fmt.Errorf("directory %s: %w", path, fs.ErrNotExist)
+	}
+	return w.commit(walRecord{Op: opDeleteDirectory, Path: path})
+}
+
+func (w *WALStore) ListDirectories() ([]*Directory, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	dirs := make(map[string]*Directory, len(w.dirs))
+	for path, dir := range w.dirs {
+		copied := *dir
+		dirs[path] = &copied
+	}
+	return sortedDirectories(dirs), nil
+}
+
+func (w *WALStore) SaveSnapshot(snap *Snapshot) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	copied := *snap
+	return w.commit(walRecord{Op: opSaveSnapshot, Snapshot: &copied})
+}
+
+func (w *WALStore) GetSnapshot(id string) (*Snapshot, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	snap, ok := w.snaps[id]
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s: %w", id, fs.ErrNotExist)
+	}
+	copied := *snap
+	return &copied, nil
+}
+
+func (w *WALStore) DeleteSnapshot(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.snaps[id]; !ok {
+		return fmt.Errorf("snapshot %s: %w", id, fs.ErrNotExist)
+	}
+	return w.commit(walRecord{Op: opDeleteSnapshot, SnapshotID: id})
+}
+
+func (w *WALStore) ListSnapshots() ([]*Snapshot, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	snaps := make([]*Snapshot, 0, len(w.snaps))
+	for _, snap := range w.snaps {
+		copied := *snap
+		snaps = append(snaps, &copied)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID < snaps[j].ID })
+	return snaps, nil
+}
+
+func (w *WALStore) ChunkRefs(chunkIDs []string) (map[string]int64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.refs.lookup(chunkIDs), nil
+}
+
+func (w *WALStore) getLease(fileID string) (writeLease, bool, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	lease, ok := w.leases[fileID]
+	return lease, ok, nil
+}
+
+func (w *WALStore) saveLease(fileID string, lease writeLease) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.commit(walRecord{Op: opSaveLease, FileID: fileID, Lease: &lease})
+}
+
+func (w *WALStore) deleteLease(fileID string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.commit(walRecord{Op: opDeleteLease, FileID: fileID})
+}
+
+// Replace swaps the in-memory state for records, dirs and snaps and writes
+// it as a new snapshot, which replaces the old state on disk in one rename.
+func (w *WALStore) Replace(records []*FileMetadata, dirs []*Directory, snaps []*Snapshot) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	oldFiles, oldDirs, oldSnaps, oldRefs := w.files, w.dirs, w.snaps, w.refs
+	w.files = make(map[string]*FileMetadata, len(records))
+	for _, metadata := range records {
+		w.files[metadata.FileID] = metadata
+	}
+	w.dirs = make(map[string]*Directory, len(dirs))
+	for _, dir := range dirs {
+		w.dirs[dir.Path] = dir
+	}
+	w.snaps = make(map[string]*Snapshot, len(snaps))
+	for _, s := range snaps {
+		w.snaps[s.ID] = s
+	}
+	w.refs = countRefs(w.files, w.snaps)
+	w.seq++
+	if err := w.snapshot(); err != nil {
+		w.files, w.dirs, w.snaps, w.refs = oldFiles, oldDirs, oldSnaps, oldRefs
+		w.seq--
+		return err
+	}
+	w.events.record(w.seq, nil)
+	return nil
+}
+
+// Snapshot writes the current state to the snapshot file and truncates the
+// WAL.
+func (w *WALStore) Snapshot() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snapshot()
+}
+
+func (w *WALStore) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.wal.Close()
+}
+
+// commit logs rec durably, applies it, and snapshots if the log has grown
+// past the snapshot interval. The caller must hold w.mu.
+func (w *WALStore) commit(rec walRecord) error {
+	rec.Seq = w.seq + 1
+	rec.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal WAL record: %w", err)
+	}
+	buf := make([]byte, walHeaderLen, walHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	buf = append(buf, payload...)
+
+	if w.failed != nil {
+		return w.failed
+	}
+	if _, err := w.wal.WriteAt(buf, w.size); err != nil {
+		return w.discardTail(fmt.Errorf("failed to append to WAL: %w", err))
+	}
+	if err := w.wal.Sync(); err != nil {
+		return w.discardTail(fmt.Errorf("failed to sync WAL: %w", err))
+	}
+	w.size += int64(len(buf))
+	w.seq = rec.Seq
+	w.apply(rec)
+
+	w.unsynced++
+	if w.snapshotInterval > 0 && w.unsynced >= w.snapshotInterval {
+		if err := w.snapshot(); err != nil {
+			// The mutation is already durable in the WAL, so a failed
+			// snapshot only means a longer replay next time.
+			log.Printf("Failed to snapshot metadata: %v", err)
+		}
+	}
+	return nil
+}
+
+// discardTail cuts whatever a failed append left after the last good record
+// off the log, so that later records do not follow a torn one and the failed
+// record cannot come back after a restart, and returns err. If the log
+// cannot be cut, the store stops accepting writes. The caller must hold w.mu.
+func (w *WALStore) discardTail(err error) error {
+	terr := w.wal.Truncate(w.size)
+	if terr == nil {
+		terr = w.wal.Sync()
+	}
+	if terr != nil {
+		log.Printf("Failed to discard a failed WAL append, refusing further writes: %v", terr)
+		w.failed = fmt.Errorf("WAL is unusable after a failed append: %w", terr)
+	}
+	return err
+}
+
+// apply applies rec to the in-memory state and records its event.
+func (w *WALStore) apply(rec walRecord) {
+	var event *pb.WatchEvent
+	switch rec.Op {
+	case opSave:
+		if rec.Metadata != nil {
+			event = w.save(rec.Metadata, rec.Time)
+		}
+	case opSaveAll:
+		events := make([]*pb.WatchEvent, 0, len(rec.Records))
+		for _, metadata := range rec.Records {
+			if metadata != nil {
+				events = append(events, w.save(metadata, rec.Time))
+			}
+		}
+		w.events.record(rec.Seq, events...)
+		return
+	case opDelete:
+		if prev, ok := w.files[rec.FileID]; ok {
+			event = newEvent(pb.Operation_DELETED, prev, parseEventTime(rec.Time))
+			w.refs.addFile(prev, -1)
+		}
+		delete(w.files, rec.FileID)
+	case opSaveDirectory:
+		if rec.Directory != nil {
+			w.dirs[rec.Directory.Path] = rec.Directory
+		}
+	case opDeleteDirectory:
+		delete(w.dirs, rec.Path)
+	case opSaveSnapshot:
+		if rec.Snapshot != nil {
+			w.refs.addSnapshot(w.snaps[rec.Snapshot.ID], -1)
+			w.refs.addSnapshot(rec.Snapshot, 1)
+			w.snaps[rec.Snapshot.ID] = rec.Snapshot
+		}
+	case opDeleteSnapshot:
+		w.refs.addSnapshot(w.snaps[rec.SnapshotID], -1)
+		delete(w.snaps, rec.SnapshotID)
+	case opSaveLease:
+		if rec.Lease != nil {
+			w.leases[rec.FileID] = *rec.Lease
+		}
+	case opDeleteLease:
+		delete(w.leases, rec.FileID)
+	}
+	w.events.record(rec.Seq, event)
+}
+
+// save applies the save of metadata logged at the given time and returns its
+// event. The caller must hold w.mu.
+func (w *WALStore) save(metadata *FileMetadata, at string) *pb.WatchEvent {
+	event := changeEvent(w.files[metadata.FileID], metadata, parseEventTime(at))
+	w.refs.addFile(w.files[metadata.FileID], -1)
+	w.refs.addFile(metadata, 1)
+	w.files[metadata.FileID] = metadata
+	return event
+}
+
+func (w *WALStore) watchEvents() *eventLog {
+	return w.events
+}
+
+func (w *WALStore) snapshot() error {
+	snap := walSnapshot{Seq: w.seq, Records: make([]*FileMetadata, 0, len(w.files))}
+	for _, metadata := range w.files {
+		snap.Records = append(snap.Records, metadata)
+	}
+	sort.Slice(snap.Records, func(i, j int) bool { return snap.Records[i].FileID < snap.Records[j].FileID })
+	snap.Directories = sortedDirectories(w.dirs)
+	for _, s := range w.snaps {
+		snap.Snapshots = append(snap.Snapshots, s)
+	}
+	sort.Slice(snap.Snapshots, func(i, j int) bool { return snap.Snapshots[i].ID < snap.Snapshots[j].ID })
+	events := w.events.snapshot()
+	snap.Events = &events
+	for fileID, lease := range w.leases {
+		if !lease.expired() {
+			if snap.Leases == nil {
+				snap.Leases = make(map[string]writeLease)
+			}
+			snap.Leases[fileID] = lease
+		}
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(w.dir, snapshotFileName), data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := w.wal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+	w.size = 0
+	if err := w.wal.Sync(); err != nil {
+		return err
+	}
+	w.unsynced = 0
+	w.failed = nil
+	return nil
+}
+
+// replay applies every WAL record newer than the loaded snapshot. A torn or
+// corrupt record at the end of the log, left by a crash mid-append, is cut
+// off so that new records are appended after the last good one.
+func (w *WALStore) replay() error {
+	var good int64
+	err := scanWAL(w.wal, func(rec walRecord, end int64) error {
+		if rec.Seq > w.seq {
+			w.apply(rec)
+			w.seq = rec.Seq
+			w.unsynced++
+		}
+		good = end
+		return nil
+	})
+	var corrupt *walCorruptError
+	if errors.As(err, &corrupt) {
+		log.Printf("Truncating WAL at offset %d: %v", good, err)
+		if err := w.wal.Truncate(good); err != nil {
+			return fmt.Errorf("failed to truncate WAL: %w", err)
+		}
+	} else if err != nil {
+		return err
+	}
+	w.size = good
+	return nil
+}
+
+type walCorruptError struct {
+	offset int64
+	reason string
+}
+
+func (e *walCorruptError) Error() string {
+	return fmt.Sprintf("corrupt WAL record at offset %d: %s", e.offset, e.reason)
+}
+
+// scanWAL calls fn for each record in the log from the start, passing the
+// offset just past the record. It stops with a *walCorruptError at the first
+// record that is truncated or fails its CRC.
+func scanWAL(f io.ReadSeeker, fn func(rec walRecord, end int64) error) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		var header [walHeaderLen]byte
+		n, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &walCorruptError{offset, fmt.Sprintf("short header (%d bytes)", n)}
+		}
+		length := binary.LittleEndian.Uint32(header[0:4])
+		if length > maxWALRecord {
+			return &walCorruptError{offset, fmt.Sprintf("record length %d too large", length)}
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return &walCorruptError{offset, "short payload"}
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+			return &walCorruptError{offset, "checksum mismatch"}
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return &walCorruptError{offset, err.Error()}
+		}
+		offset += walHeaderLen + int64(length)
+		if err := fn(rec, offset); err != nil {
+			return err
+		}
+	}
+}
+
+func readSnapshot(path string) (walSnapshot, error) {
+	var snap walSnapshot
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// writeFileSync replaces path with data so that after a crash path holds
+// either the old or the new contents in full: the data is written to a
+// temporary file and fsynced, renamed over path, and the directory fsynced.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes renames and removals in dir durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
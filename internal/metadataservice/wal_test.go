@@ -0,0 +1,263 @@
+package metadataservice
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openWALStore(t *testing.T, dir string, snapshotInterval int) *WALStore {
+	t.Helper()
+	w, err := NewWALStore(dir, snapshotInterval)
+	if err != nil {
+		t.Fatalf("NewWALStore: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func mustSave(t *testing.T, s Store, fileID, path string) {
+	t.Helper()
+	if err := s.Save(&FileMetadata{FileID: fileID, FileName: filepath.Base(path), Path: path}); err != nil {
+		t.Fatalf("Save(%s): %v", fileID, err)
+	}
+}
+
+func wantFile(t *testing.T, s Store, fileID, path string) {
+	t.Helper()
+	metadata, err := s.Get(fileID)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", fileID, err)
+	}
+	if metadata.Path != path {
+		t.Fatalf("Get(%s).Path = %q, want %q", fileID, metadata.Path, path)
+	}
+}
+
+func wantNoFile(t *testing.T, s Store, fileID string) {
+	t.Helper()
+	if _, err := s.Get(fileID); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get(%s) = %v, want ErrNotExist", fileID, err)
+	}
+}
+
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestWALStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	mustSave(t, w, "a", "/a")
+	mustSave(t, w, "b", "/b")
+	mustSave(t, w, "a", "/renamed")
+	if err := w.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SaveDirectory(&Directory{Path: "/dir"}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w = openWALStore(t, dir, 0)
+	wantFile(t, w, "a", "/renamed")
+	wantNoFile(t, w, "b")
+	if _, err := w.GetDirectory("/dir"); err != nil {
+		t.Fatalf("GetDirectory after replay: %v", err)
+	}
+	if w.seq != 5 {
+		t.Fatalf("seq after replay = %d, want 5", w.seq)
+	}
+}
+
+func TestWALStoreTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	mustSave(t, w, "a", "/a")
+	intact := walSize(t, dir)
+	mustSave(t, w, "b", "/b")
+	w.Close()
+
+	path := filepath.Join(dir, walFileName)
+	if err := os.Truncate(path, walSize(t, dir)-2); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openWALStore(t, dir, 0)
+	wantFile(t, w, "a", "/a")
+	wantNoFile(t, w, "b")
+	if size := walSize(t, dir); size != intact {
+		t.Fatalf("WAL size after recovery = %d, want %d", size, intact)
+	}
+	// New records follow the last intact one and survive another restart.
+	mustSave(t, w, "c", "/c")
+	w.Close()
+	w = openWALStore(t, dir, 0)
+	wantFile(t, w, "a", "/a")
+	wantFile(t, w, "c", "/c")
+}
+
+func TestWALStoreCorruptRecordTruncatesRest(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	mustSave(t, w, "a", "/a")
+	intact := walSize(t, dir)
+	mustSave(t, w, "b", "/b")
+	mustSave(t, w, "c", "/c")
+	w.Close()
+
+	// Flip a payload byte of the second record: it and everything after it
+	// are dropped, since a later record may depend on it.
+	f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{'#'}, intact+walHeaderLen+1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w = openWALStore(t, dir, 0)
+	wantFile(t, w, "a", "/a")
+	wantNoFile(t, w, "b")
+	wantNoFile(t, w, "c")
+	if size := walSize(t, dir); size != intact {
+		t.Fatalf("WAL size after recovery = %d, want %d", size, intact)
+	}
+}
+
+func TestWALStoreSnapshotTruncatesLog(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 2)
+	mustSave(t, w, "a", "/a")
+	mustSave(t, w, "b", "/b")
+	if size := walSize(t, dir); size != 0 {
+		t.Fatalf("WAL size after the snapshot interval = %d, want 0", size)
+	}
+	mustSave(t, w, "c", "/c")
+	w.Close()
+
+	w = openWALStore(t, dir, 2)
+	for _, id := range []string{"a", "b", "c"} {
+		wantFile(t, w, id, "/"+id)
+	}
+	if w.seq != 3 {
+		t.Fatalf("seq after reopen = %d, want 3", w.seq)
+	}
+}
+
+func TestWALStoreSkipsRecordsInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	mustSave(t, w, "a", "/a")
+	mustSave(t, w, "a", "/b")
+	logged, err := os.ReadFile(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// Simulate a crash after the snapshot was written but before the log
+	// was truncated: replaying the stale records must not undo the delete.
+	if err := os.WriteFile(filepath.Join(dir, walFileName), logged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = openWALStore(t, dir, 0)
+	wantNoFile(t, w, "a")
+}
+
+// faultyWAL writes half of each record and fails while fail is set, fails
+// the next sync if failSync is set, and fails to truncate while stuck is set.
+type faultyWAL struct {
+	walFile
+	fail, failSync, stuck bool
+}
+
+func (f *faultyWAL) WriteAt(p []byte, off int64) (int, error) {
+	if f.fail {
+		n, _ := f.walFile.WriteAt(p[:len(p)/2], off)
+		return n, errors.New("no space left on device")
+	}
+	return f.walFile.WriteAt(p, off)
+}
+
+func (f *faultyWAL) Sync() error {
+	if f.failSync {
+		f.failSync = false
+		return errors.New("I/O error")
+	}
+	return f.walFile.Sync()
+}
+
+func (f *faultyWAL) Truncate(size int64) error {
+	if f.stuck {
+		return errors.New("read-only file system")
+	}
+	return f.walFile.Truncate(size)
+}
+
+func TestWALStoreFailedAppendIsDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	mustSave(t, w, "a", "/a")
+	faulty := &faultyWAL{walFile: w.wal}
+	w.wal = faulty
+
+	faulty.fail = true
+	if err := w.Save(&FileMetadata{FileID: "torn", Path: "/torn"}); err == nil {
+		t.Fatal("Save succeeded with a failing write")
+	}
+	faulty.fail, faulty.failSync = false, true
+	if err := w.Save(&FileMetadata{FileID: "unsynced", Path: "/unsynced"}); err == nil {
+		t.Fatal("Save succeeded with a failing sync")
+	}
+	wantNoFile(t, w, "torn")
+	wantNoFile(t, w, "unsynced")
+
+	// Records acknowledged after the failures survive a restart, and the
+	// failed ones do not come back.
+	mustSave(t, w, "b", "/b")
+	w.Close()
+	w = openWALStore(t, dir, 0)
+	wantFile(t, w, "a", "/a")
+	wantFile(t, w, "b", "/b")
+	wantNoFile(t, w, "torn")
+	wantNoFile(t, w, "unsynced")
+}
+
+func TestWALStoreRefusesWritesAfterUndiscardedFailure(t *testing.T) {
+	dir := t.TempDir()
+	w := openWALStore(t, dir, 0)
+	faulty := &faultyWAL{walFile: w.wal, fail: true, stuck: true}
+	w.wal = faulty
+	if err := w.Save(&FileMetadata{FileID: "torn", Path: "/torn"}); err == nil {
+		t.Fatal("Save succeeded with a failing write")
+	}
+	faulty.fail, faulty.stuck = false, false
+	if err := w.Save(&FileMetadata{FileID: "a", Path: "/a"}); err == nil {
+		t.Fatal("Save after a torn record that could not be cut off succeeded")
+	}
+
+	// A snapshot replaces the log and makes the store writable again.
+	if err := w.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, w, "a", "/a")
+	w.Close()
+	w = openWALStore(t, dir, 0)
+	wantFile(t, w, "a", "/a")
+	wantNoFile(t, w, "torn")
+}
@@ -0,0 +1,206 @@
+// Package pki sets up mutual TLS between the DFS services and issues the
+// certificates for it.
+//
+// Every service and client holds a certificate signed by the cluster CA. The
+// certificate's organizational unit names its role, and each side of a
+// connection checks the other's role as well as its chain, so that a
+// client certificate cannot be used to pose as a storage node or to talk to
+// one directly.
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Roles of the certificates.
+const (
+	RoleCoordinator = "coordinator"
+	RoleStorageNode = "storagenode"
+	RoleMetadata    = "metadata"
+	// RoleClient is for the CLI, the SDK and the gateways, which talk to
+	// the coordinator.
+	RoleClient = "client"
+	// RoleAdmin is for operators, who may also talk to the metadata
+	// service directly.
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{RoleCoordinator, RoleStorageNode, RoleMetadata, RoleClient, RoleAdmin}
+
+// Files names the PEM files of the cluster CA certificate and of a
+// certificate and its key. TLS is disabled when all three are empty.
+type Files struct {
+	CA   string
+	Cert string
+	Key  string
+}
+
+// FilesFromEnv reads the files from DFS_TLS_CA, DFS_TLS_CERT and
+// DFS_TLS_KEY.
+func FilesFromEnv() (Files, error) {
+	f := Files{
+		CA:   os.Getenv("DFS_TLS_CA"),
+		Cert: os.Getenv("DFS_TLS_CERT"),
+		Key:  os.Getenv("DFS_TLS_KEY"),
+	}
+	if f.Enabled() && (f.CA == "" || f.Cert == "" || f.Key == "") {
+		return Files{}, errors.New("DFS_TLS_CA, DFS_TLS_CERT and DFS_TLS_KEY must be set together")
+	}
+	return f, nil
+}
+
+func (f Files) Enabled() bool {
+	return f.CA != "" || f.Cert != "" || f.Key != ""
+}
+
+// ServerConfig returns the TLS configuration for a server that accepts
+// clients with one of the allowed roles.
+func ServerConfig(f Files, allowed ...string) (*tls.Config, error) {
+	pool, kp, err := load(f)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS13,
+		GetCertificate: kp.getCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      pool,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return checkRole(cs, "client", allowed)
+		},
+	}, nil
+}
+
+// ClientConfig returns the TLS configuration for connecting to a server with
+// the given role. The server's certificate must be valid for the host it is
+// dialled by.
+func ClientConfig(f Files, serverRole string) (*tls.Config, error) {
+	pool, kp, err := load(f)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS13,
+		GetClientCertificate: kp.getClientCertificate,
+		RootCAs:              pool,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return checkRole(cs, "server", []string{serverRole})
+		},
+	}, nil
+}
+
+// ServerOptions returns the options for a gRPC server that accepts clients
+// with one of the allowed roles. It returns no options if TLS is disabled.
+func ServerOptions(f Files, allowed ...string) ([]grpc.ServerOption, error) {
+	if !f.Enabled() {
+		return nil, nil
+	}
+	cfg, err := ServerConfig(f, allowed...)
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(cfg))}, nil
+}
+
+// DialOption returns the credentials for connecting to a gRPC server with
+// the given role, which are insecure if TLS is disabled.
+func DialOption(f Files, serverRole string) (grpc.DialOption, error) {
+	if !f.Enabled() {
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), nil
+	}
+	cfg, err := ClientConfig(f, serverRole)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(cfg)), nil
+}
+
+// Role returns the role a certificate was issued for.
+func Role(cert *x509.Certificate) string {
+	if len(cert.Subject.OrganizationalUnit) == 0 {
+		return ""
+	}
+	return cert.Subject.OrganizationalUnit[0]
+}
+
+func checkRole(cs tls.ConnectionState, side string, allowed []string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("%s presented no certificate", side)
+	}
+	cert := cs.PeerCertificates[0]
+	if role := Role(cert); !slices.Contains(allowed, role) {
+		return fmt.Errorf("%s certificate %q has role %q, expected one of %v", side, cert.Subject.CommonName, role, allowed)
+	}
+	return nil
+}
+
+func load(f Files) (*x509.CertPool, *keyPair, error) {
+	caPEM, err := os.ReadFile(f.CA)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, nil, fmt.Errorf("no certificates found in %s", f.CA)
+	}
+	kp := &keyPair{certFile: f.Cert, keyFile: f.Key}
+	if _, err := kp.get(); err != nil {
+		return nil, nil, err
+	}
+	return pool, kp, nil
+}
+
+// keyPair loads a certificate and its key, and loads them again when the
+// certificate file changes so that renewed certificates are picked up
+// without a restart.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (kp *keyPair) get() (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	st, err := os.Stat(kp.certFile)
+	if err != nil {
+		if kp.cert != nil {
+			// Keep using the old certificate while the file is replaced.
+			return kp.cert, nil
+		}
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	if kp.cert != nil && st.ModTime().Equal(kp.modTime) {
+		return kp.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		if kp.cert != nil {
+			return kp.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	kp.cert, kp.modTime = &cert, st.ModTime()
+	return kp.cert, nil
+}
+
+func (kp *keyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return kp.get()
+}
+
+func (kp *keyPair) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return kp.get()
+}
@@ -0,0 +1,154 @@
+package pki
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA creates a CA and saves it in dir.
+func writeCA(t *testing.T, dir, name string) *CA {
+	t.Helper()
+	ca, err := NewCA(name, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Save(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")); err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// issueFiles issues a certificate for 127.0.0.1 with role and returns the
+// files of it and of the CA caName in dir that it is checked against.
+func issueFiles(t *testing.T, dir string, ca *CA, caName, name, role string) Files {
+	t.Helper()
+	f := Files{
+		CA:   filepath.Join(dir, caName+".crt"),
+		Cert: filepath.Join(dir, name+".crt"),
+		Key:  filepath.Join(dir, name+".key"),
+	}
+	req := Request{Name: name, Role: role, Hosts: []string{"127.0.0.1"}, Validity: time.Hour}
+	if err := ca.IssueFiles(req, f.Cert, f.Key); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// handshake connects a client with clientFiles to a storage node with
+// serverFiles that accepts coordinators, and returns the errors of both
+// sides.
+func handshake(t *testing.T, serverFiles, clientFiles Files) (serverErr, clientErr error) {
+	t.Helper()
+	serverCfg, err := ServerConfig(serverFiles, RoleCoordinator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCfg, err := ClientConfig(clientFiles, RoleStorageNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCfg.ServerName = "127.0.0.1"
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		// Reading completes the handshake and gets the client's byte if
+		// both sides accepted each other.
+		_, err = conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := tls.Client(conn, clientCfg)
+	clientErr = client.Handshake()
+	if clientErr == nil {
+		_, clientErr = client.Write([]byte{1})
+	}
+	client.Close()
+	return <-done, clientErr
+}
+
+func TestHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := writeCA(t, dir, "ca")
+	other := writeCA(t, dir, "other")
+	node := issueFiles(t, dir, ca, "ca", "storagenode1", RoleStorageNode)
+	coord := issueFiles(t, dir, ca, "ca", "coordinator", RoleCoordinator)
+	client := issueFiles(t, dir, ca, "ca", "cli", RoleClient)
+	// Certificates of another cluster, which trust only their own CA.
+	otherNode := issueFiles(t, dir, other, "other", "othernode", RoleStorageNode)
+	otherCoord := issueFiles(t, dir, other, "other", "othercoord", RoleCoordinator)
+	// A certificate of another cluster presented to this one.
+	foreignCoord := otherCoord
+	foreignCoord.CA = coord.CA
+
+	tests := []struct {
+		name           string
+		server, client Files
+		// Whether the server accepts the client and the client the
+		// server.
+		wantServer bool
+		wantClient bool
+	}{
+		{"same CA", node, coord, true, true},
+		{"client signed by another CA", node, foreignCoord, false, true},
+		{"server signed by another CA", otherNode, coord, false, false},
+		{"client with wrong role", node, client, false, true},
+		{"server with wrong role", coord, coord, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverErr, clientErr := handshake(t, tt.server, tt.client)
+			if (serverErr == nil) != tt.wantServer {
+				t.Errorf("server side: %v, want success %v", serverErr, tt.wantServer)
+			}
+			if !tt.wantClient && clientErr == nil {
+				t.Errorf("client accepted the server")
+			}
+			if tt.wantServer && tt.wantClient && clientErr != nil {
+				t.Errorf("client side: %v", clientErr)
+			}
+		})
+	}
+}
+
+func TestLoadCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := writeCA(t, dir, "ca")
+	loaded, err := LoadCA(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(ca.Cert) {
+		t.Fatal("loaded CA certificate differs")
+	}
+	// A certificate issued by the loaded CA verifies against the original.
+	leaf, _, err := loaded.Issue(Request{Name: "n", Role: RoleMetadata, Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.CheckSignatureFrom(ca.Cert); err != nil {
+		t.Fatal(err)
+	}
+	if Role(leaf) != RoleMetadata {
+		t.Fatalf("Role = %q, want %q", Role(leaf), RoleMetadata)
+	}
+	if _, err := LoadCA(filepath.Join(dir, "n.crt"), filepath.Join(dir, "ca.key")); err == nil {
+		t.Fatal("LoadCA accepted a missing certificate")
+	}
+}
@@ -0,0 +1,273 @@
+package s3gateway
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dfs/client"
+)
+
+// timeFormat is how S3 formats times in XML responses.
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+type bucketEntry struct {
+	Name         string
+	CreationDate string
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	files, err := s.c.List(r.Context(), "/", nil)
+	if err != nil {
+		return err
+	}
+	// A bucket was created when its oldest file was.
+	created := map[string]time.Time{}
+	for _, f := range files {
+		name, _, ok := strings.Cut(strings.TrimPrefix(f.Path, "/"), "/")
+		if !ok || !validBucket(name) {
+			continue
+		}
+		if t, seen := created[name]; !seen || f.CreatedAt.Before(t) {
+			created[name] = f.CreatedAt
+		}
+	}
+	var buckets []bucketEntry
+	for name, t := range created {
+		buckets = append(buckets, bucketEntry{name, t.UTC().Format(timeFormat)})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+		NS      string        `xml:"xmlns,attr"`
+		Owner   owner         `xml:"Owner"`
+		Buckets []bucketEntry `xml:"Buckets>Bucket"`
+	}{NS: s3Namespace, Owner: dfsOwner, Buckets: buckets})
+	return nil
+}
+
+type owner struct {
+	ID          string
+	DisplayName string
+}
+
+var dfsOwner = owner{"dfs", "dfs"}
+
+type object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+// listing is one page of a bucket's contents, in key order.
+type listing struct {
+	objects   []object
+	prefixes  []commonPrefix
+	truncated bool
+	// last is the last key or common prefix on the page.
+	last string
+}
+
+// list returns the objects in bucket whose keys start with prefix and come
+// after the key after. With a delimiter, keys that contain it after the
+// prefix are rolled up into common prefixes, each counting as one entry.
+func (s *Server) list(r *http.Request, bucket, prefix, delimiter, after string, maxKeys int) (*listing, error) {
+	dir := "/" + bucket + "/"
+	files, err := s.c.List(r.Context(), dir+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*client.FileInfo, len(files))
+	keys := make([]string, 0, len(files))
+	for _, f := range files {
+		key := strings.TrimPrefix(f.Path, dir)
+		if _, dup := byKey[key]; !dup {
+			keys = append(keys, key)
+		}
+		byKey[key] = f
+	}
+	sort.Strings(keys)
+
+	l := &listing{}
+	for _, key := range keys {
+		if key <= after {
+			continue
+		}
+		entry, isPrefix := key, false
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry, isPrefix = key[:len(prefix)+i+len(delimiter)], true
+				if entry <= after || entry == l.last {
+					continue
+				}
+			}
+		}
+		if len(l.objects)+len(l.prefixes) == maxKeys {
+			l.truncated = true
+			break
+		}
+		if isPrefix {
+			l.prefixes = append(l.prefixes, commonPrefix{entry})
+		} else {
+			f := byKey[key]
+			l.objects = append(l.objects, object{
+				Key:          key,
+				LastModified: f.UpdatedAt.UTC().Format(timeFormat),
+				ETag:         etag(f),
+				Size:         f.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+		l.last = entry
+	}
+	return l, nil
+}
+
+// listParams reads the parameters that both versions of ListObjects share.
+func listParams(r *http.Request) (prefix, delimiter string, maxKeys int, encode func(string) string, err error) {
+	q := r.URL.Query()
+	maxKeys = 1000
+	if v := q.Get("max-keys"); v != "" {
+		maxKeys, err = strconv.Atoi(v)
+		if err != nil || maxKeys < 0 {
+			return "", "", 0, nil, &s3Error{http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer."}
+		}
+		maxKeys = min(maxKeys, 1000)
+	}
+	encode = func(s string) string { return s }
+	switch q.Get("encoding-type") {
+	case "":
+	case "url":
+		encode = func(s string) string { return uriEncode(s, false) }
+	default:
+		return "", "", 0, nil, &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid Encoding Method specified in Request."}
+	}
+	return q.Get("prefix"), q.Get("delimiter"), maxKeys, encode, nil
+}
+
+// encodeListing applies the encoding-type to the keys and prefixes.
+func encodeListing(l *listing, encode func(string) string) {
+	for i := range l.objects {
+		l.objects[i].Key = encode(l.objects[i].Key)
+	}
+	for i := range l.prefixes {
+		l.prefixes[i].Prefix = encode(l.prefixes[i].Prefix)
+	}
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) error {
+	prefix, delimiter, maxKeys, encode, err := listParams(r)
+	if err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	token, startAfter := q.Get("continuation-token"), q.Get("start-after")
+	after := startAfter
+	if token != "" {
+		// Continuation tokens are the last key or prefix of the previous
+		// page.
+		last, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return &s3Error{http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect."}
+		}
+		after = max(after, string(last))
+	}
+	l, err := s.list(r, bucket, prefix, delimiter, after, maxKeys)
+	if err != nil {
+		return err
+	}
+	var next string
+	if l.truncated {
+		next = base64.RawURLEncoding.EncodeToString([]byte(l.last))
+	}
+	encodeListing(l, encode)
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		NS                    string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		MaxKeys               int
+		KeyCount              int
+		IsTruncated           bool
+		ContinuationToken     string         `xml:",omitempty"`
+		NextContinuationToken string         `xml:",omitempty"`
+		StartAfter            string         `xml:",omitempty"`
+		EncodingType          string         `xml:",omitempty"`
+		Contents              []object       `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{
+		NS:                    s3Namespace,
+		Name:                  bucket,
+		Prefix:                encode(prefix),
+		Delimiter:             encode(delimiter),
+		MaxKeys:               maxKeys,
+		KeyCount:              len(l.objects) + len(l.prefixes),
+		IsTruncated:           l.truncated,
+		ContinuationToken:     token,
+		NextContinuationToken: next,
+		StartAfter:            encode(startAfter),
+		EncodingType:          q.Get("encoding-type"),
+		Contents:              l.objects,
+		CommonPrefixes:        l.prefixes,
+	})
+	return nil
+}
+
+func (s *Server) listObjectsV1(w http.ResponseWriter, r *http.Request, bucket string) error {
+	prefix, delimiter, maxKeys, encode, err := listParams(r)
+	if err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	marker := q.Get("marker")
+	l, err := s.list(r, bucket, prefix, delimiter, marker, maxKeys)
+	if err != nil {
+		return err
+	}
+	var next string
+	if l.truncated {
+		next = encode(l.last)
+	}
+	encodeListing(l, encode)
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		NS             string   `xml:"xmlns,attr"`
+		Name           string
+		Prefix         string
+		Marker         string
+		NextMarker     string `xml:",omitempty"`
+		Delimiter      string `xml:",omitempty"`
+		MaxKeys        int
+		IsTruncated    bool
+		EncodingType   string         `xml:",omitempty"`
+		Contents       []object       `xml:"Contents"`
+		CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+	}{
+		NS:             s3Namespace,
+		Name:           bucket,
+		Prefix:         encode(prefix),
+		Marker:         encode(marker),
+		NextMarker:     next,
+		Delimiter:      encode(delimiter),
+		MaxKeys:        maxKeys,
+		IsTruncated:    l.truncated,
+		EncodingType:   q.Get("encoding-type"),
+		Contents:       l.objects,
+		CommonPrefixes: l.prefixes,
+	})
+	return nil
+}
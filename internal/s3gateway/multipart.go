@@ -0,0 +1,380 @@
+package s3gateway
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dfs/client"
+)
+
+// Multipart uploads are staged in the DFS, so any gateway of the cluster can
+// take the parts of an upload. Upload <id> keeps a record of the object it
+// makes in <staging dir>/<id>/upload and its parts in part-00001 and so on
+// next to it. Completing the upload concatenates the parts into the object
+// without copying their data.
+
+const maxParts = 10000
+
+// upload is the record of a multipart upload.
+type upload struct {
+	Bucket      string            `json:"bucket"`
+	Key         string            `json:"key"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func (s *Server) uploadDir(uploadID string) string {
+	return path.Join(s.staging, uploadID)
+}
+
+func (s *Server) partPath(uploadID string, part int) string {
+	return path.Join(s.uploadDir(uploadID), fmt.Sprintf("part-%05d", part))
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if _, err := objectPath(bucket, key); err != nil {
+		return err
+	}
+	record, err := json.Marshal(&upload{
+		Bucket:      bucket,
+		Key:         key,
+		ContentType: r.Header.Get("Content-Type"),
+		Metadata:    userMetadata(r.Header),
+	})
+	if err != nil {
+		return err
+	}
+	uploadID := newID(16)
+	// The bucket and key are also kept as attributes of the record, so that
+	// uploads can be listed without reading every record.
+	fw, err := s.c.Create(r.Context(), path.Join(s.uploadDir(uploadID), "upload"), &client.CreateOptions{
+		ContentType: "application/json",
+		Metadata:    map[string]string{"bucket": bucket, "key": key},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(record); err != nil {
+		fw.Abort()
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		NS       string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}{NS: s3Namespace, Bucket: bucket, Key: key, UploadID: uploadID})
+	return nil
+}
+
+// loadUpload reads the record of an upload, which must be for the given
+// object.
+func (s *Server) loadUpload(r *http.Request, bucket, key, uploadID string) (*upload, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || len(uploadID) != 32 {
+		return nil, errNoSuchUpload
+	}
+	fr, err := s.c.Open(r.Context(), path.Join(s.uploadDir(uploadID), "upload"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNoSuchUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+	var u upload
+	if err := json.NewDecoder(fr).Decode(&u); err != nil {
+		return nil, fmt.Errorf("failed to read multipart upload %s: %w", uploadID, err)
+	}
+	if u.Bucket != bucket || u.Key != key {
+		return nil, errNoSuchUpload
+	}
+	return &u, nil
+}
+
+func partNumber(r *http.Request) (int, error) {
+	part, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || part < 1 || part > maxParts {
+		return 0, &s3Error{http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive."}
+	}
+	return part, nil
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) error {
+	part, err := partNumber(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.loadUpload(r, bucket, key, uploadID); err != nil {
+		return err
+	}
+	result, err := s.write(r, s.partPath(uploadID, part), nil)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+result.MD5+`"`)
+	return nil
+}
+
+// parts returns the uploaded parts of an upload by part number.
+func (s *Server) parts(r *http.Request, uploadID string) (map[int]*client.FileInfo, error) {
+	files, err := s.c.List(r.Context(), s.uploadDir(uploadID)+"/part-", nil)
+	if err != nil {
+		return nil, err
+	}
+	parts := map[int]*client.FileInfo{}
+	for _, f := range files {
+		if n, err := strconv.Atoi(strings.TrimPrefix(f.Name, "part-")); err == nil {
+			parts[n] = f
+		}
+	}
+	return parts, nil
+}
+
+type part struct {
+	PartNumber   int
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) error {
+	if _, err := s.loadUpload(r, bucket, key, uploadID); err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	marker, _ := strconv.Atoi(q.Get("part-number-marker"))
+	limit := 1000
+	if v := q.Get("max-parts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &s3Error{http.StatusBadRequest, "InvalidArgument", "max-parts must be a non-negative integer."}
+		}
+		limit = min(n, 1000)
+	}
+	parts, err := s.parts(r, uploadID)
+	if err != nil {
+		return err
+	}
+	numbers := make([]int, 0, len(parts))
+	for n := range parts {
+		if n > marker {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	truncated := len(numbers) > limit
+	if truncated {
+		numbers = numbers[:limit]
+	}
+	result := make([]part, len(numbers))
+	next := marker
+	for i, n := range numbers {
+		f := parts[n]
+		result[i] = part{n, f.UpdatedAt.UTC().Format(timeFormat), etag(f), f.Size}
+		next = n
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName              xml.Name `xml:"ListPartsResult"`
+		NS                   string   `xml:"xmlns,attr"`
+		Bucket               string
+		Key                  string
+		UploadID             string `xml:"UploadId"`
+		Initiator            owner
+		Owner                owner
+		StorageClass         string
+		PartNumberMarker     int
+		NextPartNumberMarker int
+		MaxParts             int
+		IsTruncated          bool
+		Parts                []part `xml:"Part"`
+	}{
+		NS:                   s3Namespace,
+		Bucket:               bucket,
+		Key:                  key,
+		UploadID:             uploadID,
+		Initiator:            dfsOwner,
+		Owner:                dfsOwner,
+		StorageClass:         "STANDARD",
+		PartNumberMarker:     marker,
+		NextPartNumberMarker: next,
+		MaxParts:             limit,
+		IsTruncated:          truncated,
+		Parts:                result,
+	})
+	return nil
+}
+
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) error {
+	u, err := s.loadUpload(r, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	var req completeRequest
+	if err := readXML(r, &req); err != nil {
+		return err
+	}
+	if len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+	parts, err := s.parts(r, uploadID)
+	if err != nil {
+		return err
+	}
+
+	// The ETag of a multipart object is the MD5 digest of the parts' MD5
+	// digests, followed by the number of parts.
+	digests := md5.New()
+	sources := make([]string, len(req.Parts))
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			return &s3Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order."}
+		}
+		f, ok := parts[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != f.MD5 {
+			return &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found or did not match its ETag."}
+		}
+		sum, err := hex.DecodeString(f.MD5)
+		if err != nil {
+			return fmt.Errorf("part %d of upload %s has no MD5 digest", p.PartNumber, uploadID)
+		}
+		digests.Write(sum)
+		sources[i] = f.ID
+	}
+	tag := fmt.Sprintf(`"%x-%d"`, digests.Sum(nil), len(req.Parts))
+
+	metadata := map[string]string{etagKey: tag}
+	for k, v := range u.Metadata {
+		metadata[k] = v
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.c.Concat(r.Context(), p, sources, &client.CreateOptions{
+		ContentType: u.ContentType,
+		Metadata:    metadata,
+	}); err != nil {
+		return err
+	}
+	// The object shares its data with the parts, which stays until the
+	// object is deleted too.
+	if err := s.removeUpload(r, uploadID); err != nil {
+		log.Printf("Failed to clean up multipart upload %s: %v", uploadID, err)
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		NS       string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{NS: s3Namespace, Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key, ETag: tag})
+	return nil
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) error {
+	if _, err := s.loadUpload(r, bucket, key, uploadID); err != nil {
+		return err
+	}
+	if err := s.removeUpload(r, uploadID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeUpload deletes the record and parts of an upload for good; they are
+// of no use in the trash.
+func (s *Server) removeUpload(r *http.Request, uploadID string) error {
+	files, err := s.c.List(r.Context(), s.uploadDir(uploadID)+"/", nil)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := s.c.RemovePermanently(r.Context(), f.ID); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+type uploadEntry struct {
+	Key          string
+	UploadID     string `xml:"UploadId"`
+	Initiator    owner
+	Owner        owner
+	StorageClass string
+	Initiated    string
+}
+
+// listMultipartUploads lists the uploads in progress in a bucket. All of
+// them are returned at once.
+func (s *Server) listMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) error {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	records, err := s.c.List(r.Context(), s.staging+"/", &client.ListOptions{
+		Metadata: map[string]string{"bucket": bucket},
+	})
+	if err != nil {
+		return err
+	}
+	var uploads []uploadEntry
+	for _, f := range records {
+		key := f.Metadata["key"]
+		if f.Name != "upload" || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		uploads = append(uploads, uploadEntry{
+			Key:          key,
+			UploadID:     path.Base(path.Dir(f.Path)),
+			Initiator:    dfsOwner,
+			Owner:        dfsOwner,
+			StorageClass: "STANDARD",
+			Initiated:    f.CreatedAt.UTC().Format(timeFormat),
+		})
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated < uploads[j].Initiated
+	})
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName        xml.Name `xml:"ListMultipartUploadsResult"`
+		NS             string   `xml:"xmlns,attr"`
+		Bucket         string
+		KeyMarker      string
+		UploadIDMarker string `xml:"UploadIdMarker"`
+		Prefix         string
+		MaxUploads     int
+		IsTruncated    bool
+		Uploads        []uploadEntry `xml:"Upload"`
+	}{NS: s3Namespace, Bucket: bucket, Prefix: prefix, MaxUploads: 1000, Uploads: uploads})
+	return nil
+}
@@ -0,0 +1,249 @@
+package s3gateway
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"dfs/client"
+)
+
+// etagKey is the user metadata key that holds the ETag of an object made by
+// a multipart upload, whose contents have no MD5 digest. Header names cannot
+// contain ':', so no x-amz-meta-* header can set it.
+const etagKey = "s3:etag"
+
+const metaPrefix = "x-amz-meta-"
+
+// emptyETag is the ETag of an empty object.
+const emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+
+// etag returns the ETag of an object: the MD5 digest of its contents if it
+// was uploaded whole, the ETag of the multipart upload that made it, or its
+// version ID if it was changed in place since.
+func etag(info *client.FileInfo) string {
+	switch {
+	case info.MD5 != "":
+		return `"` + info.MD5 + `"`
+	case info.Metadata[etagKey] != "":
+		return info.Metadata[etagKey]
+	}
+	return `"` + info.VersionID + `"`
+}
+
+// userMetadata returns the x-amz-meta-* headers of a request, which become
+// the file's user metadata.
+func userMetadata(h http.Header) map[string]string {
+	meta := map[string]string{}
+	for name, values := range h {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, metaPrefix) && len(name) > len(metaPrefix) {
+			meta[strings.TrimPrefix(name, metaPrefix)] = strings.Join(values, ",")
+		}
+	}
+	return meta
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if strings.HasSuffix(key, "/") {
+		// Tools make empty "folder/" objects to show a directory in a
+		// listing. Directories are implied in the DFS, so there is nothing
+		// to store.
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return &s3Error{http.StatusBadRequest, "InvalidArgument", "Objects with keys ending in \"/\" must be empty."}
+		}
+		w.Header().Set("ETag", emptyETag)
+		return nil
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	result, err := s.write(r, p, &client.CreateOptions{
+		ContentType: r.Header.Get("Content-Type"),
+		Metadata:    userMetadata(r.Header),
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+result.MD5+`"`)
+	return nil
+}
+
+// write saves the body of r as the file at p. The upload is aborted if the
+// body fails its signature or checksum checks.
+func (s *Server) write(r *http.Request, p string, opts *client.CreateOptions) (*client.UploadResult, error) {
+	fw, err := s.c.Create(r.Context(), p, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(fw, r.Body); err != nil {
+		fw.Abort()
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return fw.Result(), nil
+}
+
+// getObject serves GetObject and HeadObject, including ranged and
+// conditional requests.
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if strings.HasSuffix(key, "/") {
+		return errNoSuchKey
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	fr, err := s.c.Open(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	info := fr.Info()
+	h := w.Header()
+	h.Set("ETag", etag(info))
+	h.Set("Last-Modified", info.UpdatedAt.UTC().Format(http.TimeFormat))
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	for k, v := range info.Metadata {
+		if k != etagKey {
+			h.Set(metaPrefix+k, v)
+		}
+	}
+	// Presigned URLs may override response headers.
+	q := r.URL.Query()
+	for _, name := range []string{"Content-Type", "Content-Language", "Expires", "Cache-Control", "Content-Disposition", "Content-Encoding"} {
+		if v := q.Get("response-" + strings.ToLower(name)); v != "" {
+			h.Set(name, v)
+		}
+	}
+	http.ServeContent(w, r, "", info.UpdatedAt, fr)
+	return nil
+}
+
+// deleteObject moves the object's file to the trash. Deleting an object that
+// does not exist succeeds, as in S3.
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if err := s.delete(r, bucket, key); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) delete(r *http.Request, bucket, key string) error {
+	if strings.HasSuffix(key, "/") {
+		return nil
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := s.c.Remove(r.Context(), p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type deleteRequest struct {
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string
+}
+
+type deleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	NS      string          `xml:"xmlns,attr"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	var req deleteRequest
+	if err := readXML(r, &req); err != nil {
+		return err
+	}
+	if len(req.Objects) > 1000 {
+		return errMalformedXML
+	}
+	result := deleteResult{NS: s3Namespace}
+	for _, obj := range req.Objects {
+		if err := s.delete(r, bucket, obj.Key); err != nil {
+			var e *s3Error
+			if !errors.As(err, &e) {
+				e = s3ErrorFor(err)
+			}
+			result.Errors = append(result.Errors, deleteError{obj.Key, e.code, e.message})
+		} else if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{obj.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+// copyObject copies an object within the gateway. The copy shares the
+// source's data. Copies keep the source's metadata; replacing it is not
+// supported.
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		return errNotImplemented
+	}
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return &s3Error{http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey."}
+	}
+	source, version, _ := strings.Cut(source, "?versionId=")
+	if version != "" {
+		return errNotImplemented
+	}
+	srcBucket, srcKey, ok := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !ok || !validBucket(srcBucket) || srcKey == "" {
+		return &s3Error{http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey."}
+	}
+	from, err := objectPath(srcBucket, srcKey)
+	if err != nil {
+		return err
+	}
+	to, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	info, err := s.c.Copy(r.Context(), from, to)
+	if err != nil {
+		return err
+	}
+	writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		NS           string   `xml:"xmlns,attr"`
+		LastModified string
+		ETag         string
+	}{NS: s3Namespace, LastModified: info.UpdatedAt.UTC().Format(timeFormat), ETag: etag(info)})
+	return nil
+}
@@ -0,0 +1,314 @@
+// Package s3gateway serves the DFS namespace over the Amazon S3 REST API, so
+// that S3 tools and SDKs can use the cluster.
+//
+// Bucket b holds the files below the directory /b and the object with key k
+// is the file /b/k. Only path-style requests (http://host/bucket/key) are
+// supported. Like directories, buckets are implied by the files in them: any
+// bucket with a valid name exists, and ListBuckets lists the top-level
+// directories that hold files.
+package s3gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"dfs/client"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// DefaultStagingDir is where multipart uploads keep their parts by default.
+const DefaultStagingDir = "/.s3-uploads"
+
+type Config struct {
+	// Credentials maps access key IDs to secret keys. Requests must be
+	// signed with one of them.
+	Credentials map[string]string
+	// Anonymous also accepts requests that are not signed.
+	Anonymous bool
+	// StagingDir is the DFS directory where multipart uploads keep their
+	// parts until they are completed. Defaults to DefaultStagingDir.
+	StagingDir string
+	// Region is reported by GetBucketLocation. Signatures for any region
+	// are accepted.
+	Region string
+}
+
+type Server struct {
+	c           *client.Client
+	credentials map[string]string
+	anonymous   bool
+	staging     string
+	region      string
+}
+
+func NewServer(c *client.Client, cfg Config) *Server {
+	if cfg.StagingDir == "" {
+		cfg.StagingDir = DefaultStagingDir
+	}
+	return &Server{
+		c:           c,
+		credentials: cfg.Credentials,
+		anonymous:   cfg.Anonymous,
+		staging:     path.Clean(cfg.StagingDir),
+		region:      cfg.Region,
+	}
+}
+
+// s3Error is an error response in the form S3 clients expect.
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *s3Error) Error() string { return e.code + ": " + e.message }
+
+var (
+	errAccessDenied     = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied."}
+	errNoSuchKey        = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errNoSuchUpload     = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist."}
+	errInvalidBucket    = &s3Error{http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid."}
+	errNotImplemented   = &s3Error{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented."}
+	errMalformedXML     = &s3Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema."}
+	errMethodNotAllowed = &s3Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource."}
+)
+
+// unsupported lists subresources that are not implemented. Requests for
+// them fail rather than being mistaken for plain object or bucket requests.
+var unsupported = []string{
+	"acl", "accelerate", "analytics", "cors", "encryption", "intelligent-tiering",
+	"inventory", "legal-hold", "lifecycle", "logging", "metrics", "notification",
+	"object-lock", "ownershipControls", "policy", "policyStatus", "publicAccessBlock",
+	"replication", "requestPayment", "restore", "retention", "select", "tagging",
+	"torrent", "versioning", "versionId", "versions", "website",
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := newID(8)
+	w.Header().Set("X-Amz-Request-Id", requestID)
+	w.Header().Set("Server", "DFS")
+
+	body, err := s.authenticate(r)
+	if err == nil {
+		r.Body = io.NopCloser(body)
+		err = s.route(w, r)
+	}
+	if err != nil {
+		s.writeError(w, r, requestID, err)
+	}
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	for _, sub := range unsupported {
+		if q.Has(sub) {
+			return errNotImplemented
+		}
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			return errMethodNotAllowed
+		}
+		return s.listBuckets(w, r)
+	}
+	if !validBucket(bucket) {
+		return errInvalidBucket
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodGet:
+			switch {
+			case q.Has("location"):
+				return s.getBucketLocation(w, r)
+			case q.Has("uploads"):
+				return s.listMultipartUploads(w, r, bucket)
+			case q.Get("list-type") == "2":
+				return s.listObjectsV2(w, r, bucket)
+			default:
+				return s.listObjectsV1(w, r, bucket)
+			}
+		case http.MethodHead, http.MethodPut:
+			// Buckets are implied, so every valid one exists.
+			return nil
+		case http.MethodDelete:
+			return s.deleteBucket(w, r, bucket)
+		case http.MethodPost:
+			if q.Has("delete") {
+				return s.deleteObjects(w, r, bucket)
+			}
+		}
+		return errMethodNotAllowed
+	}
+
+	uploadID := q.Get("uploadId")
+	switch r.Method {
+	case http.MethodGet:
+		if q.Has("uploadId") {
+			return s.listParts(w, r, bucket, key, uploadID)
+		}
+		return s.getObject(w, r, bucket, key)
+	case http.MethodHead:
+		return s.getObject(w, r, bucket, key)
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			if q.Has("uploadId") {
+				// UploadPartCopy.
+				return errNotImplemented
+			}
+			return s.copyObject(w, r, bucket, key)
+		}
+		if q.Has("uploadId") {
+			return s.uploadPart(w, r, bucket, key, uploadID)
+		}
+		return s.putObject(w, r, bucket, key)
+	case http.MethodDelete:
+		if q.Has("uploadId") {
+			return s.abortMultipartUpload(w, r, bucket, key, uploadID)
+		}
+		return s.deleteObject(w, r, bucket, key)
+	case http.MethodPost:
+		switch {
+		case q.Has("uploads"):
+			return s.createMultipartUpload(w, r, bucket, key)
+		case q.Has("uploadId"):
+			return s.completeMultipartUpload(w, r, bucket, key, uploadID)
+		}
+	}
+	return errMethodNotAllowed
+}
+
+// validBucket reports whether name follows the S3 bucket naming rules. Names
+// of hidden directories such as /.snapshots are never valid.
+func validBucket(name string) bool {
+	if len(name) < 3 || len(name) > 63 || strings.Contains(name, "..") {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		alnum := 'a' <= c && c <= 'z' || '0' <= c && c <= '9'
+		if !alnum && (c != '.' && c != '-' || i == 0 || i == len(name)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// objectPath returns the DFS path of an object. Keys that cannot be a path,
+// because they have empty, "." or ".." segments, are rejected.
+func objectPath(bucket, key string) (string, error) {
+	p := "/" + bucket + "/" + key
+	if len(key) > 1024 || path.Clean(p) != p {
+		return "", &s3Error{http.StatusBadRequest, "InvalidArgument", "Object keys with empty, \".\" or \"..\" segments are not supported."}
+	}
+	return p, nil
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, requestID string, err error) {
+	var e *s3Error
+	if !errors.As(err, &e) {
+		e = s3ErrorFor(err)
+		if e.status == http.StatusInternalServerError {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestID string `xml:"RequestId"`
+	}{Code: e.code, Message: e.message, Resource: r.URL.Path, RequestID: requestID})
+}
+
+// s3ErrorFor translates errors from the DFS client.
+func s3ErrorFor(err error) *s3Error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return errNoSuchKey
+	case errors.Is(err, fs.ErrPermission):
+		return errAccessDenied
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return &s3Error{http.StatusServiceUnavailable, "RequestTimeout", "The request was canceled or timed out."}
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return &s3Error{http.StatusServiceUnavailable, "ServiceUnavailable", "Please reduce your request rate."}
+	case codes.InvalidArgument:
+		return &s3Error{http.StatusBadRequest, "InvalidArgument", err.Error()}
+	}
+	return &s3Error{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+}
+
+func writeXML(w http.ResponseWriter, statusCode int, v any) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	io.WriteString(w, xml.Header)
+	w.Write(out)
+}
+
+func readXML(r *http.Request, v any) error {
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(v); err != nil {
+		var e *s3Error
+		if errors.As(err, &e) {
+			return e
+		}
+		return errMalformedXML
+	}
+	// Drain the body so that its checks run.
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		return err
+	}
+	return nil
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) getBucketLocation(w http.ResponseWriter, r *http.Request) error {
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"LocationConstraint"`
+		NS      string   `xml:"xmlns,attr"`
+		Region  string   `xml:",chardata"`
+	}{NS: s3Namespace, Region: s.region})
+	return nil
+}
+
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	files, err := s.c.List(r.Context(), "/"+bucket+"/", nil)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return &s3Error{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty."}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,553 @@
+package s3gateway
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as S3 uses it:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	maxClockSkew     = 15 * time.Minute
+	maxPresignExpiry = 7 * 24 * time.Hour
+
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// signature holds the parts of a request's signature.
+type signature struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	// expires is set for presigned URLs.
+	expires time.Duration
+	presign bool
+}
+
+func (sig *signature) scope() string {
+	return sig.date + "/" + sig.region + "/" + sig.service + "/aws4_request"
+}
+
+// authenticate checks the signature of r and returns the body to read the
+// payload from. The body decodes aws-chunked payloads and fails at the end
+// if the payload does not match its signed hash, chunk signatures or
+// checksums, so that a bad upload is thrown away rather than saved.
+func (s *Server) authenticate(r *http.Request) (io.Reader, error) {
+	contentSHA256 := r.Header.Get("X-Amz-Content-Sha256")
+	if s.anonymous && r.Header.Get("Authorization") == "" && r.URL.Query().Get("X-Amz-Algorithm") == "" {
+		return payloadReader(r, contentSHA256, nil, nil)
+	}
+
+	var sig *signature
+	var err error
+	switch {
+	case r.Header.Get("Authorization") != "":
+		sig, err = parseAuthorization(r)
+	case r.URL.Query().Get("X-Amz-Algorithm") != "":
+		sig, err = parsePresigned(r)
+		if contentSHA256 == "" {
+			contentSHA256 = unsignedPayload
+		}
+	default:
+		return nil, errAccessDenied
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := s.credentials[sig.accessKey]
+	if !ok {
+		return nil, &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist."}
+	}
+	t, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil || t.Format("20060102") != sig.date || sig.service != "s3" {
+		return nil, &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The credential scope does not match the request date or service."}
+	}
+	now := time.Now()
+	if sig.presign {
+		if now.After(t.Add(sig.expires)) {
+			return nil, &s3Error{http.StatusForbidden, "AccessDenied", "Request has expired."}
+		}
+	} else if now.Sub(t) > maxClockSkew || t.Sub(now) > maxClockSkew {
+		return nil, &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large."}
+	}
+	if contentSHA256 == "" {
+		// Some clients only leave it out when there is no payload.
+		if r.ContentLength != 0 {
+			return nil, &s3Error{http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256."}
+		}
+		contentSHA256 = emptySHA256
+	}
+
+	key, err := verifySignature(r, sig, secret, contentSHA256)
+	if err != nil {
+		return nil, err
+	}
+	return payloadReader(r, contentSHA256, sig, key)
+}
+
+// verifySignature checks sig against the signature of r computed with
+// secret and returns the signing key, which also signs aws-chunked payloads.
+func verifySignature(r *http.Request, sig *signature, secret, contentSHA256 string) ([]byte, error) {
+	key := signingKey(secret, sig.date, sig.region, sig.service)
+	canonical := canonicalRequest(r, sig, contentSHA256)
+	stringToSign := signingAlgorithm + "\n" + sig.amzDate + "\n" + sig.scope() + "\n" + sha256Hex([]byte(canonical))
+	if !hmac.Equal([]byte(hmacHex(key, stringToSign)), []byte(sig.signature)) {
+		return nil, &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+	}
+	return key, nil
+}
+
+// parseAuthorization reads the signature from an Authorization header such
+// as "AWS4-HMAC-SHA256 Credential=AKID/20240101/us-east-1/s3/aws4_request,
+// SignedHeaders=host;x-amz-date, Signature=...".
+func parseAuthorization(r *http.Request) (*signature, error) {
+	malformed := &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed."}
+	algorithm, params, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || algorithm != signingAlgorithm {
+		return nil, &s3Error{http.StatusBadRequest, "InvalidArgument", "Only AWS Signature Version 4 (AWS4-HMAC-SHA256) is supported."}
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[k] = v
+	}
+	sig := &signature{amzDate: r.Header.Get("X-Amz-Date")}
+	if sig.amzDate == "" {
+		if t, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+			sig.amzDate = t.UTC().Format(amzDateFormat)
+		}
+	}
+	if !sig.parseCredential(fields["Credential"]) || fields["SignedHeaders"] == "" || fields["Signature"] == "" {
+		return nil, malformed
+	}
+	sig.signedHeaders = strings.Split(fields["SignedHeaders"], ";")
+	sig.signature = fields["Signature"]
+	return sig, nil
+}
+
+// parsePresigned reads the signature from the query of a presigned URL.
+func parsePresigned(r *http.Request) (*signature, error) {
+	q := r.URL.Query()
+	if q.Get("X-Amz-Algorithm") != signingAlgorithm {
+		return nil, &s3Error{http.StatusBadRequest, "InvalidArgument", "Only AWS Signature Version 4 (AWS4-HMAC-SHA256) is supported."}
+	}
+	sig := &signature{
+		amzDate:   q.Get("X-Amz-Date"),
+		signature: q.Get("X-Amz-Signature"),
+		presign:   true,
+	}
+	seconds, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	sig.expires = time.Duration(seconds) * time.Second
+	if err != nil || seconds < 0 || sig.expires > maxPresignExpiry {
+		return nil, &s3Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires must be between 0 and 604800 seconds."}
+	}
+	if !sig.parseCredential(q.Get("X-Amz-Credential")) || q.Get("X-Amz-SignedHeaders") == "" || sig.signature == "" {
+		return nil, &s3Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "The presigned URL is missing signature parameters."}
+	}
+	sig.signedHeaders = strings.Split(q.Get("X-Amz-SignedHeaders"), ";")
+	return sig, nil
+}
+
+// parseCredential splits a credential of the form
+// "<access key>/<date>/<region>/<service>/aws4_request".
+func (sig *signature) parseCredential(credential string) bool {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return false
+	}
+	sig.accessKey, sig.date, sig.region, sig.service = parts[0], parts[1], parts[2], parts[3]
+	return true
+}
+
+func canonicalRequest(r *http.Request, sig *signature, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(uriEncode(r.URL.Path, false) + "\n")
+
+	query := r.URL.Query()
+	var params []string
+	for k, vs := range query {
+		if sig.presign && k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vs {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	b.WriteString(strings.Join(params, "&") + "\n")
+
+	for _, name := range sig.signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" && r.ContentLength >= 0 {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		case "transfer-encoding":
+			value = strings.Join(r.TransferEncoding, ",")
+		default:
+			values := r.Header.Values(name)
+			for i, v := range values {
+				values[i] = strings.Join(strings.Fields(v), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		b.WriteString(name + ":" + value + "\n")
+	}
+	b.WriteString("\n" + strings.Join(sig.signedHeaders, ";") + "\n")
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+// uriEncode percent-encodes s the way SigV4 does: everything but unreserved
+// characters, and slashes as well if encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hmacHex(key []byte, data string) string {
+	return hex.EncodeToString(hmacSHA256(key, data))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadReader returns the body of r, decoded if it is aws-chunked, with
+// the checks that apply to it. sig and key are nil for anonymous requests,
+// whose chunk signatures are not checked.
+func payloadReader(r *http.Request, contentSHA256 string, sig *signature, key []byte) (io.Reader, error) {
+	var body io.Reader = r.Body
+	var checks []digestCheck
+	switch contentSHA256 {
+	case "", unsignedPayload:
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedTrailer:
+		cr := &chunkedReader{
+			r:       bufio.NewReader(r.Body),
+			signed:  contentSHA256 != streamingUnsignedTrailer && sig != nil,
+			trailer: contentSHA256 != streamingPayload,
+			key:     key,
+			hash:    sha256.New(),
+		}
+		if sig != nil {
+			cr.amzDate, cr.scope, cr.prevSignature = sig.amzDate, sig.scope(), sig.signature
+		}
+		if name := r.Header.Get("X-Amz-Trailer"); name != "" {
+			h := checksumHash(name)
+			if h == nil {
+				return nil, &s3Error{http.StatusBadRequest, "InvalidRequest", "Unsupported trailing checksum " + name + "."}
+			}
+			cr.checksumName, cr.checksum = name, h
+		}
+		if n := r.Header.Get("X-Amz-Decoded-Content-Length"); n != "" {
+			cr.decodedLength, _ = strconv.ParseInt(n, 10, 64)
+		} else {
+			cr.decodedLength = -1
+		}
+		body = cr
+	default:
+		if sig != nil {
+			checks = append(checks, digestCheck{sha256.New(), contentSHA256, hex.EncodeToString,
+				&s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}})
+		}
+	}
+
+	if want := r.Header.Get("Content-Md5"); want != "" {
+		checks = append(checks, digestCheck{md5.New(), want, base64.StdEncoding.EncodeToString,
+			&s3Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received."}})
+	}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, "x-amz-checksum-") || name == "x-amz-checksum-algorithm" || name == "x-amz-checksum-type" {
+			continue
+		}
+		h := checksumHash(name)
+		if h == nil {
+			return nil, &s3Error{http.StatusBadRequest, "InvalidRequest", "Unsupported checksum " + name + "."}
+		}
+		checks = append(checks, digestCheck{h, r.Header.Get(name), base64.StdEncoding.EncodeToString,
+			&s3Error{http.StatusBadRequest, "BadDigest", "The " + name + " you specified did not match the calculated checksum."}})
+	}
+	if len(checks) > 0 {
+		body = &verifyingReader{r: body, checks: checks}
+	}
+	return body, nil
+}
+
+// checksumHash returns the hash for an x-amz-checksum-* header, or nil if
+// the algorithm is not supported.
+func checksumHash(name string) hash.Hash {
+	switch strings.TrimPrefix(strings.ToLower(name), "x-amz-checksum-") {
+	case "crc32":
+		return crc32.NewIEEE()
+	case "crc32c":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "crc64nvme":
+		return crc64.New(crc64.MakeTable(0x9a6c9329ac4bc9b5))
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// digestCheck compares a digest of the payload with the expected value.
+type digestCheck struct {
+	h      hash.Hash
+	want   string
+	encode func([]byte) string
+	err    *s3Error
+}
+
+// verifyingReader passes the payload through and fails at its end if any of
+// the checks does not match.
+type verifyingReader struct {
+	r      io.Reader
+	checks []digestCheck
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	for _, c := range v.checks {
+		c.h.Write(p[:n])
+	}
+	if err == io.EOF {
+		for _, c := range v.checks {
+			if c.encode(c.h.Sum(nil)) != c.want {
+				return n, c.err
+			}
+		}
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked payload: chunks of the form
+// "<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n", ending with an
+// empty chunk and, with the trailer variants, trailing headers such as a
+// checksum of the whole payload and its signature.
+type chunkedReader struct {
+	r       *bufio.Reader
+	signed  bool
+	trailer bool
+
+	key           []byte
+	amzDate       string
+	scope         string
+	prevSignature string
+
+	// The current chunk: its signature, how much of it is left to read and
+	// the hash of what has been read.
+	chunkSignature string
+	remaining      int64
+	inChunk        bool
+	hash           hash.Hash
+
+	checksumName  string
+	checksum      hash.Hash
+	decodedLength int64
+	decoded       int64
+	err           error
+}
+
+var errMalformedChunk = &s3Error{http.StatusBadRequest, "IncompleteBody", "The aws-chunked payload is malformed."}
+
+var errChunkSignature = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The chunk signature we calculated does not match the signature you provided."}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for c.remaining == 0 {
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+	}
+	n, err := c.r.Read(p[:min(int64(len(p)), c.remaining)])
+	c.remaining -= int64(n)
+	c.decoded += int64(n)
+	c.hash.Write(p[:n])
+	if c.checksum != nil {
+		c.checksum.Write(p[:n])
+	}
+	if err == io.EOF {
+		err = errMalformedChunk
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// nextChunk finishes the current chunk and starts the next one. It returns
+// io.EOF after the last chunk and the trailer have been checked.
+func (c *chunkedReader) nextChunk() error {
+	if c.inChunk {
+		if err := c.verifyChunk(); err != nil {
+			return err
+		}
+		if line, err := c.readLine(); err != nil || line != "" {
+			return errMalformedChunk
+		}
+		c.inChunk = false
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return errMalformedChunk
+	}
+	sizeField, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 {
+		return errMalformedChunk
+	}
+	c.chunkSignature = strings.TrimPrefix(ext, "chunk-signature=")
+	c.hash.Reset()
+	c.remaining = size
+	c.inChunk = true
+	if size > 0 {
+		return nil
+	}
+
+	// The final, empty chunk is signed like the others.
+	if err := c.verifyChunk(); err != nil {
+		return err
+	}
+	if c.trailer {
+		if err := c.readTrailer(); err != nil {
+			return err
+		}
+	} else if line, err := c.readLine(); err != nil || line != "" {
+		return errMalformedChunk
+	}
+	if c.decodedLength >= 0 && c.decoded != c.decodedLength {
+		return &s3Error{http.StatusBadRequest, "IncompleteBody", "The decoded payload does not have the length given by x-amz-decoded-content-length."}
+	}
+	return io.EOF
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if !c.signed {
+		return nil
+	}
+	stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + c.amzDate + "\n" + c.scope + "\n" + c.prevSignature + "\n" +
+		emptySHA256 + "\n" + hex.EncodeToString(c.hash.Sum(nil))
+	expected := hmacHex(c.key, stringToSign)
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSignature)) {
+		return errChunkSignature
+	}
+	c.prevSignature = expected
+	return nil
+}
+
+// readTrailer reads the trailing headers up to the empty line that ends the
+// payload, checking the trailing checksum and, if signed, the trailer
+// signature.
+func (c *chunkedReader) readTrailer() error {
+	var trailer strings.Builder
+	var trailerSignature string
+	checksums := map[string]string{}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return errMalformedChunk
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return errMalformedChunk
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if name == "x-amz-trailer-signature" {
+			trailerSignature = value
+			continue
+		}
+		trailer.WriteString(name + ":" + value + "\n")
+		checksums[name] = value
+	}
+
+	if c.signed {
+		stringToSign := "AWS4-HMAC-SHA256-TRAILER\n" + c.amzDate + "\n" + c.scope + "\n" + c.prevSignature + "\n" +
+			sha256Hex([]byte(trailer.String()))
+		if !hmac.Equal([]byte(hmacHex(c.key, stringToSign)), []byte(trailerSignature)) {
+			return errChunkSignature
+		}
+	}
+	if c.checksum != nil {
+		want, ok := checksums[strings.ToLower(c.checksumName)]
+		if !ok || base64.StdEncoding.EncodeToString(c.checksum.Sum(nil)) != want {
+			return &s3Error{http.StatusBadRequest, "BadDigest", "The " + c.checksumName + " you specified did not match the calculated checksum."}
+		}
+	}
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"dfs/internal/chunk"
 	pb "dfs/internal/pb/storagenode"
+	"errors"
+	"io/fs"
 	"log"
 
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Server struct {
@@ -27,34 +31,58 @@ func (s *Server) GetNodeID(ctx context.Context, req *pb.GetNodeIDRequest) (*pb.G
 }
 
 func (s *Server) PutChunk(ctx context.Context, req *pb.PutChunkRequest) (*pb.PutChunkResponse, error) {
+	if err := chunk.ValidateID(req.ChunkId); err != nil {
+		log.Printf("Rejected PutChunk: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	log.Printf("Storing chunk: %s with checksum: %s", req.ChunkId, req.Checksum)
 	err := s.store.Put(req.ChunkId, req.Data, req.Checksum)
 	if err != nil {
 		log.Printf("Failed to store chunk %s: %v", req.ChunkId, err)
-		return nil, err
+		return nil, storeError(err)
 	}
 	log.Printf("Chunk stored successfully: %s", req.ChunkId)
 	return &pb.PutChunkResponse{Success: true}, nil
 }
 
 func (s *Server) GetChunk(ctx context.Context, req *pb.GetChunkRequest) (*pb.GetChunkResponse, error) {
+	if err := chunk.ValidateID(req.ChunkId); err != nil {
+		log.Printf("Rejected GetChunk: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	log.Printf("Retrieving chunk: %s", req.ChunkId)
 	data, checksum, err := s.store.Get(req.ChunkId)
 	if err != nil {
 		log.Printf("Failed to retrieve chunk %s: %v", req.ChunkId, err)
-		return nil, err
+		return nil, storeError(err)
 	}
 	log.Printf("Chunk retrieved successfully: %s with checksum: %s", req.ChunkId, checksum)
 	return &pb.GetChunkResponse{Data: data, Checksum: checksum}, nil
 }
 
 func (s *Server) DeleteChunk(ctx context.Context, req *pb.DeleteChunkRequest) (*pb.DeleteChunkResponse, error) {
+	if err := chunk.ValidateID(req.ChunkId); err != nil {
+		log.Printf("Rejected DeleteChunk: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	log.Printf("Deleting chunk: %s", req.ChunkId)
 	err := s.store.Delete(req.ChunkId)
 	if err != nil {
 		log.Printf("Failed to delete chunk %s: %v", req.ChunkId, err)
-		return nil, err
+		return nil, storeError(err)
 	}
 	log.Printf("Chunk deleted successfully: %s", req.ChunkId)
 	return &pb.DeleteChunkResponse{Success: true}, nil
 }
+
+// storeError maps a chunk.Store error onto the matching gRPC status.
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, chunk.ErrInvalidID):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Errorf(codes.NotFound, "chunk not found: %v", err)
+	default:
+		return status.Errorf(codes.Internal, "chunk store error: %v", err)
+	}
+}